@@ -0,0 +1,45 @@
+package accounts
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//breachedPrefixLength is length of hash prefix which names range file, like in Have I Been Pwned range api
+const breachedPrefixLength = 5
+
+//isBreached looks for SHA-1 of password in range file named by first 5 hex characters of hash (e.g. 5BAA6.txt),
+//file lists remaining 35 characters of breached hashes, one SUFFIX:COUNT per line, so only small part of list is read
+func isBreached(dir string, pass Password) (bool, error) {
+
+	hash := strings.ToUpper(fmt.Sprintf("%x", sha1.Sum([]byte(pass))))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if end := strings.Index(line, ":"); end >= 0 {
+			line = line[:end]
+		}
+
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
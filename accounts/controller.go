@@ -9,12 +9,17 @@ import (
 )
 
 type Controller struct {
-	Create               func(c echo.Context) error
-	GetByID              func(c echo.Context) error
-	ResetPassword        func(c echo.Context) error
-	ConfirmAccount       func(c echo.Context) error
-	ConfirmResetPassword func(c echo.Context) error
-	Update               func(c echo.Context) error
+	Create                 func(c echo.Context) error
+	GetByID                func(c echo.Context) error
+	ResetPassword          func(c echo.Context) error
+	ConfirmAccount         func(c echo.Context) error
+	ConfirmResetPassword   func(c echo.Context) error
+	Update                 func(c echo.Context) error
+	UpdateRoles            func(c echo.Context) error
+	GenerateRecoveryCodes  func(c echo.Context) error
+	GetRecoveryCodesStatus func(c echo.Context) error
+	Delete                 func(c echo.Context) error
+	HashingStats           func(c echo.Context) error
 }
 
 func Create(service Service) Controller {
@@ -29,24 +34,31 @@ func Create(service Service) Controller {
 			return web.BadRequestResponse(c, "Invalid payload")
 		}
 
+		//roles are granted only by admins, identities are linked only by social login after provider verified them
+		account.Roles, account.Identities = nil, nil
+
 		secAccount := new(SecuredAccount)
 		secAccount.Account = *account
 
-		validationErrors := secAccount.validate()
+		validationErrors := append(secAccount.validate(), service.ValidatePassword(account.Password, account.PasswordlessAccount)...)
 
 		if len(validationErrors) != 0 {
 			log.Debugf("validation errors while creating acccount %+v", validationErrors)
 			return web.BadRequestResponseWithDetails(c, "Niektóre pola są błędnie wypełnione", validationErrors)
 		}
 
-		accID, err := service.StartSignupAccount(account.Email, *secAccount)
-		if err != nil {
+		if _, err := service.StartSignupAccount(c.Request().Context(), account.Email, *secAccount); err != nil {
+
+			if overloaded, ok := err.(OverloadedError); ok {
+				return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+			}
+
 			return web.LogAndReturnInternalError(c, "Could not create account ", err)
 		}
 
-		account.Id = accID
-		log.Debugf("Recived ", account)
-		return web.CreatedResponse(c, account)
+		//id is not returned, response for registered email has to look the same
+		log.Debugf("Recived ", account.PasswordlessAccount)
+		return c.JSON(http.StatusAccepted, account.PasswordlessAccount)
 	}
 
 	getById := func(c echo.Context) error {
@@ -70,11 +82,6 @@ func Create(service Service) Controller {
 
 		email := c.Param("id")
 		if err := service.StartResetPassword(email); err != nil {
-
-			if err == ErrAccountNotFound {
-				return web.NotFoundResponse(c)
-			}
-
 			return web.LogAndReturnInternalError(c, "Could not start reset password process.", err)
 		}
 
@@ -91,21 +98,20 @@ func Create(service Service) Controller {
 			return web.BadRequestResponse(c, "Unable to parse request body")
 		}
 
-		if !passwordChangeDto.NewPassword.IsValid() {
-			details := []web.ErrorDetails{{
-				Field:   "password",
-				Type:    web.InvalidField,
-				Message: "Password is to weak",
-			}}
-			return web.BadRequestResponseWithDetails(c, "Password is to weak", details)
-		}
-
-		if err := service.ConfirmResetPassword(email, passwordChangeDto.Code, passwordChangeDto.NewPassword); err != nil {
+		if err := service.ConfirmResetPassword(c.Request().Context(), email, passwordChangeDto.Code, passwordChangeDto.NewPassword); err != nil {
 
 			if err == ErrInvalidResetCode {
 				return web.BadRequestResponse(c, "Invalid reset code")
 			}
 
+			if policyErr, ok := err.(PolicyError); ok {
+				return web.BadRequestResponseWithDetails(c, "Password does not meet password policy", policyErr.Details)
+			}
+
+			if overloaded, ok := err.(OverloadedError); ok {
+				return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+			}
+
 			return web.LogAndReturnInternalError(c, "Could not reset password account.", err)
 		}
 
@@ -144,9 +150,18 @@ func Create(service Service) Controller {
 			return web.BadRequestResponse(c, "Unable to parse request body")
 		}
 
-		email, _ := url.QueryUnescape(c.Param("id"))
+		//id is username, like in other secured routes
+		account, err := service.GetByUsername(c.Param("id"))
+		if err != nil {
+
+			if err == ErrAccountNotFound {
+				return web.NotFoundResponse(c)
+			}
+
+			return web.LogAndReturnInternalError(c, "unable to fetch account for update", err)
+		}
 
-		if err := service.UpdateByEmail(email, acc); err != nil {
+		if err := service.UpdateByEmail(account.Email, acc); err != nil {
 
 			return web.LogAndReturnInternalError(c, "unable to update account", err)
 		}
@@ -154,12 +169,92 @@ func Create(service Service) Controller {
 		return c.JSON(http.StatusOK, "")
 	}
 
+	updateRoles := func(c echo.Context) error {
+
+		dto := UpdateRolesDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if validationErrors := dto.validate(); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid roles", validationErrors)
+		}
+
+		if err := service.UpdateRoles(c.Param("id"), dto.Roles); err != nil {
+
+			if err == ErrAccountNotFound {
+				return web.NotFoundResponse(c)
+			}
+
+			return web.LogAndReturnInternalError(c, "Could not update roles", err)
+		}
+
+		return c.JSON(http.StatusOK, dto)
+	}
+
+	generateRecoveryCodes := func(c echo.Context) error {
+
+		codes, err := service.GenerateRecoveryCodes(c.Param("id"))
+		if err != nil {
+
+			if err == ErrAccountNotFound {
+				return web.NotFoundResponse(c)
+			}
+
+			if overloaded, ok := err.(OverloadedError); ok {
+				return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+			}
+
+			return web.LogAndReturnInternalError(c, "Could not generate recovery codes", err)
+		}
+
+		return web.CreatedResponse(c, RecoveryCodesDto{Codes: codes})
+	}
+
+	getRecoveryCodesStatus := func(c echo.Context) error {
+
+		status, err := service.GetRecoveryCodesStatus(c.Param("id"))
+		if err != nil {
+
+			if err == ErrAccountNotFound {
+				return web.NotFoundResponse(c)
+			}
+
+			return web.LogAndReturnInternalError(c, "Could not fetch recovery codes", err)
+		}
+
+		return c.JSON(http.StatusOK, status)
+	}
+
+	deleteAccount := func(c echo.Context) error {
+
+		if err := service.Delete(c.Param("id")); err != nil {
+
+			if err == ErrAccountNotFound {
+				return web.NotFoundResponse(c)
+			}
+
+			return web.LogAndReturnInternalError(c, "Could not delete account", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	hashingStats := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, service.HashingStats())
+	}
+
 	return Controller{
-		Create:               create,
-		GetByID:              getById,
-		ConfirmResetPassword: confirmResetPassword,
-		ConfirmAccount:       confirmAccount,
-		ResetPassword:        resetPassword,
-		Update:               update,
+		Create:                 create,
+		GetByID:                getById,
+		ConfirmResetPassword:   confirmResetPassword,
+		ConfirmAccount:         confirmAccount,
+		ResetPassword:          resetPassword,
+		Update:                 update,
+		UpdateRoles:            updateRoles,
+		GenerateRecoveryCodes:  generateRecoveryCodes,
+		GetRecoveryCodesStatus: getRecoveryCodesStatus,
+		Delete:                 deleteAccount,
+		HashingStats:           hashingStats,
 	}
 }
@@ -1,6 +1,7 @@
 package accounts
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/labstack/echo"
 	"github.com/piotrjaromin/go-login-backend/test"
@@ -10,6 +11,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestController(t *testing.T) {
@@ -34,7 +36,7 @@ func TestController(t *testing.T) {
 
 	accountsService := func() Service {
 		return Service{
-			StartSignupAccount: func(email string, secAccount SecuredAccount) (string, error) {
+			StartSignupAccount: func(ctx context.Context, email string, secAccount SecuredAccount) (string, error) {
 
 				return "testID", nil
 			},
@@ -46,13 +48,23 @@ func TestController(t *testing.T) {
 
 				return PasswordlessAccount{}, ErrAccountNotFound
 			},
-			StartResetPassword: func(email string) error {
-				if email == validAccount.Email {
+			UpdateRoles: func(username string, roles []string) error {
+				if username == validAccount.Username {
 					return nil
 				}
 
 				return ErrAccountNotFound
 			},
+			GenerateRecoveryCodes: func(username string) ([]string, error) {
+				return []string{"aaaa-bbbb-cccc-dddd", "eeee-ffff-gggg-hhhh"}, nil
+			},
+			StartResetPassword: func(email string) error {
+				return nil
+			},
+			ValidatePassword: func(pass Password, account PasswordlessAccount) []web.ErrorDetails {
+				details, _ := PasswordPolicy{}.withDefaults().violations("password", pass, userInputs(account))
+				return details
+			},
 		}
 	}
 
@@ -60,7 +72,10 @@ func TestController(t *testing.T) {
 		rec := httptest.NewRecorder()
 
 		e := echo.New()
-		InitRoutes(e, controller, test.CreateSecurity("valid"))
+		InitRoutes(e, controller, test.CreateSecurityWithClaims("valid", map[string]interface{}{
+			"roles":     []interface{}{RoleUser},
+			"auth_time": float64(time.Now().Unix()),
+		}))
 
 		res := echo.NewResponse(rec, e)
 		e.ServeHTTP(res, req)
@@ -77,12 +92,38 @@ func TestController(t *testing.T) {
 
 			resp := createContextAndRecorder(Create(accountsService()), req)
 
-			So(resp.Code, ShouldEqual, http.StatusCreated)
+			So(resp.Code, ShouldEqual, http.StatusAccepted)
 
 			createdResp := Account{}
 
 			json.Unmarshal(resp.Body.Bytes(), &createdResp)
 			So(createdResp.Email, ShouldEqual, validAccount.Email)
+			So(createdResp.Id, ShouldBeBlank)
+			So(string(createdResp.Password), ShouldBeBlank)
+		})
+
+		Convey("should drop roles and identities sent with account", func() {
+
+			var signedUp SecuredAccount
+			service := accountsService()
+			service.StartSignupAccount = func(ctx context.Context, email string, secAccount SecuredAccount) (string, error) {
+				signedUp = secAccount
+				return "testID", nil
+			}
+
+			withRoles := validAccount
+			withRoles.Roles = []string{RoleAdmin}
+			withRoles.Identities = []Identity{{Provider: "google", Subject: "victim-subject"}}
+			accountJson, _ := json.Marshal(withRoles)
+			req, _ := http.NewRequest(echo.POST, "/accounts", strings.NewReader(string(accountJson)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			resp := createContextAndRecorder(Create(service), req)
+
+			So(resp.Code, ShouldEqual, http.StatusAccepted)
+			So(signedUp.Email, ShouldEqual, validAccount.Email)
+			So(signedUp.Roles, ShouldBeEmpty)
+			So(signedUp.Identities, ShouldBeEmpty)
 		})
 
 		Convey("should return bad request when invalid account data is sent", func() {
@@ -101,6 +142,23 @@ func TestController(t *testing.T) {
 
 			So(errorDto.ErrorDetails, ShouldHaveLength, 3)
 		})
+
+		Convey("should ask to retry later when password hashing is overloaded", func() {
+
+			service := accountsService()
+			service.StartSignupAccount = func(ctx context.Context, email string, secAccount SecuredAccount) (string, error) {
+				return "", OverloadedError{RetryAfter: 2 * time.Second}
+			}
+
+			accountJson, _ := json.Marshal(validAccount)
+			req, _ := http.NewRequest(echo.POST, "/accounts", strings.NewReader(string(accountJson)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			resp := createContextAndRecorder(Create(service), req)
+
+			So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(resp.Header().Get("Retry-After"), ShouldEqual, "2")
+		})
 	})
 
 	Convey("for get on single account should", t, func() {
@@ -145,6 +203,91 @@ func TestController(t *testing.T) {
 		})
 	})
 
+	Convey("for put on accounts/:id/roles should", t, func() {
+
+		createAdminContextAndRecorder := func(req *http.Request) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+
+			e := echo.New()
+			InitRoutes(e, Create(accountsService()), test.CreateSecurityWithClaims("admin", map[string]interface{}{
+				"roles": []interface{}{RoleUser, RoleAdmin},
+			}))
+
+			res := echo.NewResponse(rec, e)
+			e.ServeHTTP(res, req)
+			return rec
+		}
+
+		Convey("update roles when requested by admin", func() {
+
+			req, _ := http.NewRequest(echo.PUT, "/accounts/"+validAccount.Username+"/roles", strings.NewReader(`{"roles":["user","admin"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer admin")
+
+			resp := createAdminContextAndRecorder(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("return bad request for unknown role", func() {
+
+			req, _ := http.NewRequest(echo.PUT, "/accounts/"+validAccount.Username+"/roles", strings.NewReader(`{"roles":["superuser"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer admin")
+
+			resp := createAdminContextAndRecorder(req)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("return forbidden for user without admin role", func() {
+
+			req, _ := http.NewRequest(echo.PUT, "/accounts/"+validAccount.Username+"/roles", strings.NewReader(`{"roles":["admin"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer valid")
+
+			resp := createContextAndRecorder(Create(accountsService()), req)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+
+	Convey("for post on accounts/:id/recovery-codes should", t, func() {
+
+		Convey("return generated codes", func() {
+
+			req, _ := http.NewRequest(echo.POST, "/accounts/"+validAccount.Email+"/recovery-codes", strings.NewReader(""))
+			req.Header.Set("Authorization", "Bearer valid")
+
+			resp := createContextAndRecorder(Create(accountsService()), req)
+			So(resp.Code, ShouldEqual, http.StatusCreated)
+
+			codes := RecoveryCodesDto{}
+			json.Unmarshal(resp.Body.Bytes(), &codes)
+			So(codes.Codes, ShouldHaveLength, 2)
+		})
+
+		Convey("return unauthorized for invalid token", func() {
+
+			req, _ := http.NewRequest(echo.POST, "/accounts/"+validAccount.Email+"/recovery-codes", strings.NewReader(""))
+			req.Header.Set("Authorization", "Bearer invalidToken")
+
+			resp := createContextAndRecorder(Create(accountsService()), req)
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("ask for reauthentication when user logged in long ago", func() {
+
+			req, _ := http.NewRequest(echo.POST, "/accounts/"+validAccount.Email+"/recovery-codes", strings.NewReader(""))
+			req.Header.Set("Authorization", "Bearer valid")
+
+			rec := httptest.NewRecorder()
+			e := echo.New()
+			InitRoutes(e, Create(accountsService()), test.CreateSecurity("valid"))
+			e.ServeHTTP(echo.NewResponse(rec, e), req)
+
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rec.Body.String(), ShouldContainSubstring, "Recent authentication is required")
+		})
+	})
+
 	Convey("for post on accounts/:id/reset should", t, func() {
 
 		Convey("start reset password flow", func() {
@@ -156,12 +299,12 @@ func TestController(t *testing.T) {
 
 		})
 
-		Convey("return not found for not existing account", func() {
+		Convey("respond the same for not existing account", func() {
 
 			req, _ := http.NewRequest(echo.POST, "/accounts/random@mail.com/reset", strings.NewReader(""))
 
 			resp := createContextAndRecorder(Create(accountsService()), req)
-			So(resp.Code, ShouldEqual, http.StatusNotFound)
+			So(resp.Code, ShouldEqual, http.StatusOK)
 
 		})
 	})
@@ -170,10 +313,14 @@ func TestController(t *testing.T) {
 
 		validCode := "validCode12"
 		accService := Service{
-			ConfirmResetPassword: func(email string, code string, newPassword Password) error {
+			ConfirmResetPassword: func(ctx context.Context, email string, code string, newPassword Password) error {
 				if email != validAccount.Email || code != validCode {
 					return ErrInvalidResetCode
 				}
+
+				if details, _ := (PasswordPolicy{}).withDefaults().violations("newPassword", newPassword, nil); len(details) > 0 {
+					return PolicyError{Details: details}
+				}
 				return nil
 			},
 		}
@@ -194,7 +341,7 @@ func TestController(t *testing.T) {
 
 		})
 
-		Convey("return bad request for too weak password", func() {
+		Convey("return bad request with policy violations for too weak password", func() {
 
 			passDto := PasswordChangeDto{
 				Code:        validCode,
@@ -208,6 +355,11 @@ func TestController(t *testing.T) {
 			resp := createContextAndRecorder(Create(accService), req)
 			So(resp.Code, ShouldEqual, http.StatusBadRequest)
 
+			errorDto := web.Error{}
+			json.Unmarshal(resp.Body.Bytes(), &errorDto)
+			So(errorDto.ErrorDetails, ShouldHaveLength, 1)
+			So(errorDto.ErrorDetails[0].Type, ShouldEqual, PasswordTooShort)
+			So(errorDto.ErrorDetails[0].Field, ShouldEqual, "newPassword")
 		})
 
 		Convey("return bad request for invalid reset code", func() {
@@ -9,15 +9,20 @@ import (
 )
 
 type Dal struct {
-	GetById                func(id string) (PasswordlessAccount, error)
-	GetByEmail             func(email string) (PasswordlessAccount, error)
-	GetWithPasswordById    func(id string) (SecuredAccount, error)
-	GetWithPasswordByEmail func(email string) (SecuredAccount, error)
-	getByFbID              func(fbId string) (PasswordlessAccount, error)
-	UpdateByEmail          func(email string, handleUpdateFunc func(*SecuredAccount) error) error
-	updateByID             func(id string, handleUpdateFunc func(*SecuredAccount) error) error
-	CreateAccount          func(secAccount SecuredAccount) (string, error)
-	GetByUsername          func(username string) (PasswordlessAccount, error)
+	GetById                   func(id string) (PasswordlessAccount, error)
+	GetByEmail                func(email string) (PasswordlessAccount, error)
+	GetWithPasswordById       func(id string) (SecuredAccount, error)
+	GetWithPasswordByEmail    func(email string) (SecuredAccount, error)
+	//GetByIdentity finds account linked with account of social login provider
+	GetByIdentity             func(provider string, subject string) (PasswordlessAccount, error)
+	UpdateByEmail             func(email string, handleUpdateFunc func(*SecuredAccount) error) error
+	updateByID                func(id string, handleUpdateFunc func(*SecuredAccount) error) error
+	updateByUsername          func(username string, handleUpdateFunc func(*SecuredAccount) error) error
+	updateWithRecoveryCode    func(email string, codeHash string, handleUpdateFunc func(*SecuredAccount) error) error
+	CreateAccount             func(secAccount SecuredAccount) (string, error)
+	GetByUsername             func(username string) (PasswordlessAccount, error)
+	getWithPasswordByUsername func(username string) (SecuredAccount, error)
+	deleteById                func(id string) error
 }
 
 func CreateDal(accountsRepo dal.Dal) Dal {
@@ -78,20 +83,14 @@ func CreateDal(accountsRepo dal.Dal) Dal {
 		return acc.PasswordlessAccount, err
 	}
 
-	getByFbID := func(fbId string) (PasswordlessAccount, error) {
+	getByIdentity := func(provider string, subject string) (PasswordlessAccount, error) {
 
-		query := dal.NewQueryBuilder().WithField("providers.fb", fbId).Build()
-		accs, err := getByQuery(query, web.DefaultPagination())
-
-		if err != nil {
-			return PasswordlessAccount{}, err
-		}
+		query := dal.NewQueryBuilder().WithField("identities", map[string]interface{}{
+			"$elemMatch": map[string]interface{}{"provider": provider, "subject": subject},
+		}).Build()
 
-		if len(accs) == 0 {
-			return PasswordlessAccount{}, ErrAccountNotFound
-		}
-
-		return accs[0].PasswordlessAccount, nil
+		acc, err := getSingleByQuery(query)
+		return acc.PasswordlessAccount, err
 	}
 
 	getByEmail := func(email string) (PasswordlessAccount, error) {
@@ -106,7 +105,10 @@ func CreateDal(accountsRepo dal.Dal) Dal {
 			return err
 		}
 
-		updateHandle(&acc)
+		if err := updateHandle(&acc); err != nil {
+			return err
+		}
+
 		return accountsRepo.UpdateByQuery(dal.NewQueryBuilder().WithField("email", email).Build(), acc)
 	}
 
@@ -117,12 +119,59 @@ func CreateDal(accountsRepo dal.Dal) Dal {
 			return err
 		}
 
-		updateHandle(&acc)
+		if err := updateHandle(&acc); err != nil {
+			return err
+		}
+
 		return accountsRepo.Update(id, acc)
 	}
 
+	updateByUsername := func(username string, updateHandle func(*SecuredAccount) error) error {
+
+		acc, err := getWithPasswordByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		if err := updateHandle(&acc); err != nil {
+			return err
+		}
+
+		return accountsRepo.Update(acc.Id, acc)
+	}
+
+	//updateWithRecoveryCode saves account only if recovery code with given hash is still unused
+	updateWithRecoveryCode := func(email string, codeHash string, updateHandle func(*SecuredAccount) error) error {
+
+		acc, err := getWithPasswordByEmail(email)
+		if err != nil {
+			return err
+		}
+
+		if err := updateHandle(&acc); err != nil {
+			return err
+		}
+
+		//code could be used by concurrent request after account was read
+		query := dal.NewQueryBuilder().WithField("email", email).WithField("recoveryCodes", map[string]interface{}{
+			"$elemMatch": map[string]interface{}{"hash": codeHash, "usedAt": nil},
+		}).Build()
+
+		if err := accountsRepo.UpdateByQuery(query, acc); err != nil {
+			if err == dal.ErrNotFound {
+				return ErrInvalidRecoveryCode
+			}
+			return err
+		}
+
+		return nil
+	}
+
 	createAccount := func(secAccount SecuredAccount) (string, error) {
 
+		//new accounts are always plain users, roles are granted only with UpdateRoles
+		secAccount.Roles = []string{RoleUser}
+
 		secAccount.CreatedAt = time.Now()
 		secAccount.Id = uuid.NewV4().String()
 
@@ -132,15 +181,19 @@ func CreateDal(accountsRepo dal.Dal) Dal {
 	}
 
 	return Dal{
-		GetById:                getById,
-		GetByEmail:             getByEmail,
-		GetWithPasswordById:    getWithPasswordById,
-		GetWithPasswordByEmail: getWithPasswordByEmail,
-		UpdateByEmail:          updateByEmail,
-		updateByID:             updateByID,
-		getByFbID:              getByFbID,
-		CreateAccount:          createAccount,
-		GetByUsername:          getByUsername,
+		GetById:                   getById,
+		GetByEmail:                getByEmail,
+		GetWithPasswordById:       getWithPasswordById,
+		GetWithPasswordByEmail:    getWithPasswordByEmail,
+		UpdateByEmail:             updateByEmail,
+		updateByID:                updateByID,
+		updateByUsername:          updateByUsername,
+		updateWithRecoveryCode:    updateWithRecoveryCode,
+		getWithPasswordByUsername: getWithPasswordByUsername,
+		GetByIdentity:             getByIdentity,
+		CreateAccount:             createAccount,
+		GetByUsername:             getByUsername,
+		deleteById:                accountsRepo.DeleteById,
 	}
 
 }
@@ -1,67 +1,472 @@
 package accounts
 
 import (
-	"github.com/op/go-logging"
-	"io"
-	"golang.org/x/crypto/scrypt"
+	"context"
+	"crypto/hmac"
 	cryptoRand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/op/go-logging"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+//Password hashing algorithms, new hashes are made with algorithm of HashingPolicy
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+	HashScrypt   = "scrypt"
 )
 
+//legacy hashes are hex encoded scrypt with N=2^14, salt is kept in separate field
 const (
-	PW_SALT_BYTES = 32
 	PW_HASH_BYTES = 64
+	legacyCost    = 14
 )
 
+const (
+	saltBytes = 16
+	hashBytes = 32
+	//keyIdParam marks hashes of peppered passwords
+	keyIdParam = "keyid"
+	pepperId   = "pepper"
+	//bcrypt ignores everything after 72 bytes, longer passwords are hashed with sha256 first
+	bcryptMaxInput = 72
+)
+
+//ErrUnknownHashAlgorithm is returned when policy asks for algorithm which is not supported
+var ErrUnknownHashAlgorithm = errors.New("Unknown password hashing algorithm")
+
+//HashingPolicy describes how new passwords are hashed, costs which are not set get defaults
+type HashingPolicy struct {
+	Algorithm string
+	//Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+	//ScryptCost is log2 of N
+	ScryptCost int
+	//Pepper is server side secret mixed into every password before hashing, it is never stored in database
+	Pepper []byte
+	//Pool limits number of hashes computed at the same time
+	Pool HashingPoolConfig
+}
+
+func (policy HashingPolicy) withDefaults() HashingPolicy {
+
+	if len(policy.Algorithm) == 0 {
+		policy.Algorithm = HashArgon2id
+	}
+
+	//argon2id and scrypt costs recommended by OWASP
+	if policy.Argon2Memory == 0 {
+		policy.Argon2Memory = 19 * 1024
+	}
+
+	if policy.Argon2Iterations == 0 {
+		policy.Argon2Iterations = 2
+	}
+
+	if policy.Argon2Parallelism == 0 {
+		policy.Argon2Parallelism = 1
+	}
+
+	if policy.BcryptCost == 0 {
+		policy.BcryptCost = 12
+	}
+
+	if policy.ScryptCost == 0 {
+		policy.ScryptCost = 17
+	}
+
+	policy.Pool = policy.Pool.withDefaults()
+
+	return policy
+}
+
+//Encrypt hashes passwords and other secrets which are stored in database,
+//Hash and Validate run on hashing pool and return OverloadedError when it is full or they waited too long,
+//ctx is context of request, hash is not computed for client which went away
 type Encrypt struct {
-	Hash func(pass Password) (Password, string)
-	Validate func(pass Password, hashToCompare Password, salt string) bool
+	//Hash returns PHC string, it keeps algorithm, its parameters and salt together with hash
+	Hash func(ctx context.Context, pass Password) (Password, error)
+	//Validate compares password with hash in constant time, salt is needed only by legacy hashes
+	Validate func(ctx context.Context, pass Password, hashToCompare Password, salt string) (bool, error)
+	//NeedsRehash tells if hash was made with other algorithm, cost or pepper than current policy asks for
+	NeedsRehash func(hash Password) bool
+	//Stats describe load of hashing pool
+	Stats func() HashingStats
+}
+
+//phcHash is parsed $id[$v=version]$param=value,...$salt$hash string
+type phcHash struct {
+	Id      string
+	Version string
+	Params  map[string]string
+	Salt    string
+	Hash    string
 }
 
+//hasher implements single algorithm, salt and hash are encoded the way algorithm does it
+type hasher struct {
+	hash func(input []byte, params []string) (string, error)
+	//verify uses parameters stored in hash, not the ones from policy
+	verify func(input []byte, phc phcHash) bool
+	//current is true when parameters of hash are the ones from policy
+	current func(phc phcHash) bool
+}
 
-func CreateEncrypt() Encrypt {
+//CreateEncrypt hashes new passwords according to policy, hashes made with older policies are still validated
+func CreateEncrypt(policy HashingPolicy) (Encrypt, error) {
 
 	var log = logging.MustGetLogger("[Encrypt]")
 
-	cryptToHash := func(pass Password, salt string) (Password, error) {
-		hash, err := scrypt.Key([]byte(pass), []byte(salt), 1<<14, 8, 1, PW_HASH_BYTES)
+	policy = policy.withDefaults()
+
+	hashers := map[string]hasher{
+		HashArgon2id: argon2Hasher(policy),
+		HashBcrypt:   bcryptHasher(policy),
+		HashScrypt:   scryptHasher(policy),
+	}
+
+	current, ok := hashers[policy.Algorithm]
+	if !ok {
+		return Encrypt{}, ErrUnknownHashAlgorithm
+	}
+
+	pool := createHashingPool(policy.Pool)
+
+	currentKeyId := ""
+	if len(policy.Pepper) > 0 {
+		currentKeyId = pepperId
+	}
+
+	//pepperInput turns password into input of hashing, it fails for hashes peppered with key which is not configured
+	pepperInput := func(pass Password, keyId string) ([]byte, bool) {
+
+		if len(keyId) == 0 {
+			return []byte(pass), true
+		}
+
+		if keyId != currentKeyId {
+			return nil, false
+		}
+
+		mac := hmac.New(sha256.New, policy.Pepper)
+		mac.Write([]byte(pass))
+		return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))), true
+	}
+
+	hash := func(ctx context.Context, pass Password) (Password, error) {
+
+		params := []string{}
+		if len(currentKeyId) > 0 {
+			params = append(params, keyIdParam+"="+currentKeyId)
+		}
+
+		input, _ := pepperInput(pass, currentKeyId)
+
+		var hash string
+		var hashErr error
+		//results of work can be read only when there is no error
+		if err := pool.run(ctx, func() { hash, hashErr = current.hash(input, params) }); err != nil {
+			return "", err
+		}
+
+		if hashErr != nil {
+			log.Error("Could not hash password. Details: ", hashErr)
+			return "", hashErr
+		}
+
+		return Password(hash), nil
+	}
+
+	validateLegacy := func(pass Password, hashToCompare Password, salt string) bool {
+
+		hash, err := scrypt.Key([]byte(pass), []byte(salt), 1<<legacyCost, 8, 1, PW_HASH_BYTES)
+		if err != nil {
+			log.Error("Encryption error. Details: ", err)
+			return false
+		}
+
+		return subtle.ConstantTimeCompare([]byte(fmt.Sprintf("%x", hash)), []byte(hashToCompare)) == 1
+	}
+
+	//verify prepares check which is run on pool, malformed hashes are rejected without taking worker
+	verify := func(pass Password, hashToCompare Password, salt string) (func() bool, bool) {
+
+		if !strings.HasPrefix(string(hashToCompare), "$") {
+			return func() bool { return validateLegacy(pass, hashToCompare, salt) }, true
+		}
+
+		phc, ok := parsePhc(string(hashToCompare))
+		if !ok {
+			log.Error("Could not parse password hash")
+			return nil, false
+		}
+
+		hasher, ok := hashers[phc.Id]
+		if !ok {
+			log.Error("Password hash uses unknown algorithm ", phc.Id)
+			return nil, false
+		}
+
+		input, ok := pepperInput(pass, phc.Params[keyIdParam])
+		if !ok {
+			log.Error("Password hash uses pepper which is not configured")
+			return nil, false
+		}
+
+		return func() bool { return hasher.verify(input, phc) }, true
+	}
+
+	validate := func(ctx context.Context, pass Password, hashToCompare Password, salt string) (bool, error) {
+
+		check, ok := verify(pass, hashToCompare, salt)
+		if !ok {
+			return false, nil
+		}
+
+		valid := false
+		if err := pool.run(ctx, func() { valid = check() }); err != nil {
+			return false, err
+		}
+
+		return valid, nil
+	}
+
+	needsRehash := func(hash Password) bool {
+
+		phc, ok := parsePhc(string(hash))
+		if !ok || phc.Id != policy.Algorithm || phc.Params[keyIdParam] != currentKeyId {
+			return true
+		}
+
+		return !current.current(phc)
+	}
+
+	return Encrypt{
+		Hash:        hash,
+		Validate:    validate,
+		NeedsRehash: needsRehash,
+		Stats:       pool.stats,
+	}, nil
+}
+
+func argon2Hasher(policy HashingPolicy) hasher {
+
+	hash := func(input []byte, params []string) (string, error) {
+
+		salt, err := randomSalt()
 		if err != nil {
 			return "", err
 		}
 
-		return Password(fmt.Sprintf("%x", hash)), nil
+		key := argon2.IDKey(input, salt, policy.Argon2Iterations, policy.Argon2Memory, policy.Argon2Parallelism, hashBytes)
+
+		params = append([]string{
+			fmt.Sprintf("m=%d", policy.Argon2Memory),
+			fmt.Sprintf("t=%d", policy.Argon2Iterations),
+			fmt.Sprintf("p=%d", policy.Argon2Parallelism),
+		}, params...)
+
+		return formatPhc(HashArgon2id, strconv.Itoa(argon2.Version), params, encodePhc(salt), encodePhc(key)), nil
 	}
 
-	hash := func(pass Password) (Password, string) {
-		saltInBytes := make([]byte, PW_SALT_BYTES)
+	verify := func(input []byte, phc phcHash) bool {
+
+		memory, memoryErr := phcUint(phc, "m", 32)
+		iterations, iterationsErr := phcUint(phc, "t", 32)
+		parallelism, parallelismErr := phcUint(phc, "p", 8)
+		if phc.Version != strconv.Itoa(argon2.Version) || memoryErr != nil || iterationsErr != nil || parallelismErr != nil {
+			return false
+		}
+
+		salt, expected, ok := decodePhc(phc)
+		if !ok || iterations == 0 || parallelism == 0 {
+			return false
+		}
+
+		key := argon2.IDKey(input, salt, uint32(iterations), uint32(memory), uint8(parallelism), uint32(len(expected)))
+		return subtle.ConstantTimeCompare(key, expected) == 1
+	}
+
+	current := func(phc phcHash) bool {
+		return phc.Params["m"] == strconv.FormatUint(uint64(policy.Argon2Memory), 10) &&
+			phc.Params["t"] == strconv.FormatUint(uint64(policy.Argon2Iterations), 10) &&
+			phc.Params["p"] == strconv.FormatUint(uint64(policy.Argon2Parallelism), 10)
+	}
+
+	return hasher{hash: hash, verify: verify, current: current}
+}
+
+func scryptHasher(policy HashingPolicy) hasher {
+
+	hash := func(input []byte, params []string) (string, error) {
 
-		_, err := io.ReadFull(cryptoRand.Reader, saltInBytes)
+		salt, err := randomSalt()
 		if err != nil {
-			log.Error("Could not read salt in bytes. Details: ", err)
+			return "", err
 		}
 
-		salt := fmt.Sprintf("%x", saltInBytes)
-		hash, cryptErr := cryptToHash(pass, salt)
-		if cryptErr != nil {
-			log.Error("Could not hash password. Details: ", cryptErr)
+		key, err := scrypt.Key(input, salt, 1<<uint(policy.ScryptCost), 8, 1, hashBytes)
+		if err != nil {
+			return "", err
 		}
 
-		return hash, salt
+		params = append([]string{fmt.Sprintf("ln=%d", policy.ScryptCost), "r=8", "p=1"}, params...)
+		return formatPhc(HashScrypt, "", params, encodePhc(salt), encodePhc(key)), nil
 	}
 
-	validate := func(pass Password, hashToCompare Password, salt string) bool {
+	verify := func(input []byte, phc phcHash) bool {
 
-		hash, cryptErr := cryptToHash(pass, salt)
-		if cryptErr != nil {
-			log.Error("Encryption error. Details: ", cryptErr)
+		cost, costErr := phcUint(phc, "ln", 6)
+		r, rErr := phcUint(phc, "r", 31)
+		p, pErr := phcUint(phc, "p", 31)
+		salt, expected, ok := decodePhc(phc)
+		if costErr != nil || rErr != nil || pErr != nil || !ok {
 			return false
 		}
 
-		return hash == hashToCompare
+		key, err := scrypt.Key(input, salt, 1<<uint(cost), int(r), int(p), len(expected))
+		if err != nil {
+			return false
+		}
+
+		return subtle.ConstantTimeCompare(key, expected) == 1
 	}
 
-	return Encrypt{
-		Hash: hash,
-		Validate: validate,
+	current := func(phc phcHash) bool {
+		return phc.Params["ln"] == strconv.Itoa(policy.ScryptCost) && phc.Params["r"] == "8" && phc.Params["p"] == "1"
+	}
+
+	return hasher{hash: hash, verify: verify, current: current}
+}
+
+//bcryptHasher keeps salt and hash in bcrypt's own encoding, $2a$cost$ prefix is rebuilt from params
+func bcryptHasher(policy HashingPolicy) hasher {
+
+	//bcrypt salt is 22 characters long, hash is the rest
+	const encodedSaltSize = 22
+
+	bcryptInput := func(input []byte) []byte {
+
+		if len(input) <= bcryptMaxInput {
+			return input
+		}
+
+		sum := sha256.Sum256(input)
+		return []byte(base64.StdEncoding.EncodeToString(sum[:]))
 	}
+
+	hash := func(input []byte, params []string) (string, error) {
+
+		hashed, err := bcrypt.GenerateFromPassword(bcryptInput(input), policy.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		//hashed is $2a$cost$ followed by salt and hash
+		encoded := string(hashed[strings.LastIndex(string(hashed), "$")+1:])
+
+		params = append([]string{fmt.Sprintf("r=%d", policy.BcryptCost)}, params...)
+		return formatPhc(HashBcrypt, "", params, encoded[:encodedSaltSize], encoded[encodedSaltSize:]), nil
+	}
+
+	verify := func(input []byte, phc phcHash) bool {
+
+		cost, err := phcUint(phc, "r", 6)
+		if err != nil || len(phc.Salt) != encodedSaltSize {
+			return false
+		}
+
+		hashed := fmt.Sprintf("$2a$%02d$%s%s", cost, phc.Salt, phc.Hash)
+		return bcrypt.CompareHashAndPassword([]byte(hashed), bcryptInput(input)) == nil
+	}
+
+	current := func(phc phcHash) bool {
+		return phc.Params["r"] == strconv.Itoa(policy.BcryptCost)
+	}
+
+	return hasher{hash: hash, verify: verify, current: current}
+}
+
+func randomSalt() ([]byte, error) {
+
+	salt := make([]byte, saltBytes)
+	if _, err := io.ReadFull(cryptoRand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+func formatPhc(id string, version string, params []string, salt string, hash string) string {
+
+	parts := []string{"", id}
+	if len(version) > 0 {
+		parts = append(parts, "v="+version)
+	}
+
+	return strings.Join(append(parts, strings.Join(params, ","), salt, hash), "$")
+}
+
+func parsePhc(encoded string) (phcHash, bool) {
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || len(parts[0]) > 0 {
+		return phcHash{}, false
+	}
+
+	phc := phcHash{Id: parts[1], Params: map[string]string{}}
+
+	rest := parts[2:]
+	if strings.HasPrefix(rest[0], "v=") {
+		phc.Version = strings.TrimPrefix(rest[0], "v=")
+		rest = rest[1:]
+	}
+
+	if len(rest) != 3 {
+		return phcHash{}, false
+	}
+
+	for _, param := range strings.Split(rest[0], ",") {
+		nameValue := strings.SplitN(param, "=", 2)
+		if len(nameValue) != 2 {
+			return phcHash{}, false
+		}
+		phc.Params[nameValue[0]] = nameValue[1]
+	}
+
+	phc.Salt, phc.Hash = rest[1], rest[2]
+	return phc, true
+}
+
+//encodePhc uses standard base64 without padding, as PHC string format requires
+func encodePhc(value []byte) string {
+	return base64.RawStdEncoding.EncodeToString(value)
+}
+
+func decodePhc(phc phcHash) ([]byte, []byte, bool) {
+
+	salt, saltErr := base64.RawStdEncoding.DecodeString(phc.Salt)
+	hash, hashErr := base64.RawStdEncoding.DecodeString(phc.Hash)
+	if saltErr != nil || hashErr != nil || len(hash) == 0 {
+		return nil, nil, false
+	}
+
+	return salt, hash, true
+}
+
+func phcUint(phc phcHash, name string, bitSize int) (uint64, error) {
+	return strconv.ParseUint(phc.Params[name], 10, bitSize)
 }
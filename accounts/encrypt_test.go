@@ -1,43 +1,155 @@
 package accounts
 
 import (
+        "context"
         . "github.com/smartystreets/goconvey/convey"
+        "strings"
         "testing"
 )
 
-func TestEncrypt(t *testing.T) {
+//cheap costs keep tests fast
+func createTestEncrypt(algorithm string, pepper string) Encrypt {
+
+        encrypt, err := CreateEncrypt(HashingPolicy{
+                Algorithm:        algorithm,
+                Argon2Memory:     64,
+                Argon2Iterations: 1,
+                BcryptCost:       4,
+                ScryptCost:       4,
+                Pepper:           []byte(pepper),
+        })
+
+        So(err, ShouldBeNil)
+        return encrypt
+}
+
+func hashOf(encrypt Encrypt, pass Password) Password {
 
-        encrypt := CreateEncrypt()
+        hash, err := encrypt.Hash(context.Background(), pass)
+        So(err, ShouldBeNil)
+        return hash
+}
+
+func isValid(encrypt Encrypt, pass Password, hashToCompare Password, salt string) bool {
+
+        valid, err := encrypt.Validate(context.Background(), pass, hashToCompare, salt)
+        So(err, ShouldBeNil)
+        return valid
+}
+
+func TestEncrypt(t *testing.T) {
 
         Convey("For hash ", t, func() {
 
-                Convey("should return hashed password and salt", func() {
+                Convey("should return PHC string of every algorithm", func() {
 
-                        hashed, salt := encrypt.Hash("pass")
+                        prefixes := map[string]string{
+                                HashArgon2id: "$argon2id$v=19$m=64,t=1,p=1$",
+                                HashBcrypt:   "$bcrypt$r=4$",
+                                HashScrypt:   "$scrypt$ln=4,r=8,p=1$",
+                        }
 
-                        So(string(hashed), ShouldNotBeBlank)
-                        So(salt, ShouldNotBeBlank)
+                        for algorithm, prefix := range prefixes {
+                                encrypt := createTestEncrypt(algorithm, "")
+                                hashed := hashOf(encrypt, "pass")
+
+                                So(string(hashed), ShouldStartWith, prefix)
+                                So(hashed, ShouldNotEqual, hashOf(encrypt, "pass"))
+                                So(isValid(encrypt, "pass", hashed, ""), ShouldBeTrue)
+                                So(isValid(encrypt, "other", hashed, ""), ShouldBeFalse)
+                                So(encrypt.NeedsRehash(hashed), ShouldBeFalse)
+                        }
+                })
+
+                Convey("should mark peppered hashes", func() {
+
+                        hashed := hashOf(createTestEncrypt(HashArgon2id, "pepper"), "pass")
+                        So(string(hashed), ShouldContainSubstring, ",keyid=pepper$")
                 })
 
+                Convey("should fail for unknown algorithm", func() {
+
+                        _, err := CreateEncrypt(HashingPolicy{Algorithm: "md5"})
+                        So(err, ShouldEqual, ErrUnknownHashAlgorithm)
+                })
         })
 
         Convey("For validate", t, func() {
 
+                encrypt := createTestEncrypt(HashArgon2id, "")
+
                 salt := "testSalt"
                 pass := Password("testPass")
                 okHashed := Password("d71b6e68a1bddecc0451569b830e7e6d2821d1b3512e611684cec6870ed49d23895ff8648d7b4bcb48deeb807e6ef4430fb53a2f8090b04ada90fd0dbc472069")
 
                 Convey("Should return ok for valid password", func() {
 
-                        ok := encrypt.Validate(pass, okHashed, salt)
+                        ok := isValid(encrypt, pass, okHashed, salt)
 
                         So(ok, ShouldBeTrue)
                 })
 
                 Convey("Should detect invalid password", func() {
-                        ok := encrypt.Validate(pass, Password("notOk"), salt)
+                        ok := isValid(encrypt, pass, Password("notOk"), salt)
 
                         So(ok, ShouldBeFalse)
                 })
+
+                Convey("Should accept hashes of other algorithms", func() {
+
+                        So(isValid(encrypt, pass, hashOf(createTestEncrypt(HashBcrypt, ""), pass), ""), ShouldBeTrue)
+                        So(isValid(encrypt, pass, "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$Fo/eyKEdXxaXomXjGO/Q7KFarrp++n/3gNyyswGeLrw", ""), ShouldBeTrue)
+                })
+
+                Convey("Should use whole long password with bcrypt", func() {
+
+                        bcryptEncrypt := createTestEncrypt(HashBcrypt, "")
+                        long := Password(strings.Repeat("a", 100))
+
+                        So(isValid(bcryptEncrypt, long, hashOf(bcryptEncrypt, long), ""), ShouldBeTrue)
+                        So(isValid(bcryptEncrypt, long[:80], hashOf(bcryptEncrypt, long), ""), ShouldBeFalse)
+                })
+
+                Convey("Should require pepper of peppered hashes", func() {
+
+                        peppered := createTestEncrypt(HashArgon2id, "pepper")
+                        hashed := hashOf(peppered, pass)
+
+                        So(isValid(peppered, pass, hashed, ""), ShouldBeTrue)
+                        So(isValid(encrypt, pass, hashed, ""), ShouldBeFalse)
+                        So(isValid(createTestEncrypt(HashArgon2id, "other"), pass, hashed, ""), ShouldBeFalse)
+                })
+
+                Convey("Should reject malformed hashes", func() {
+
+                        So(isValid(encrypt, pass, "$argon2id$v=19$m=64,t=1,p=1$", ""), ShouldBeFalse)
+                        So(isValid(encrypt, pass, "$md5$r=1$salt$hash", ""), ShouldBeFalse)
+                        So(isValid(encrypt, pass, "", ""), ShouldBeFalse)
+                })
+        })
+
+        Convey("For needs rehash", t, func() {
+
+                encrypt := createTestEncrypt(HashArgon2id, "pepper")
+
+                Convey("Should be true for legacy hashes", func() {
+
+                        So(encrypt.NeedsRehash("d71b6e68a1bddecc0451569b830e7e6d"), ShouldBeTrue)
+                })
+
+                Convey("Should be true for other algorithm, cost or pepper", func() {
+
+                        So(encrypt.NeedsRehash(hashOf(createTestEncrypt(HashScrypt, "pepper"), "pass")), ShouldBeTrue)
+                        So(encrypt.NeedsRehash(hashOf(createTestEncrypt(HashArgon2id, ""), "pass")), ShouldBeTrue)
+
+                        stronger, _ := CreateEncrypt(HashingPolicy{Argon2Memory: 128, Argon2Iterations: 1, Pepper: []byte("pepper")})
+                        So(encrypt.NeedsRehash(hashOf(stronger, "pass")), ShouldBeTrue)
+                        So(stronger.NeedsRehash(hashOf(encrypt, "pass")), ShouldBeTrue)
+                })
+
+                Convey("Should be false for hash following policy", func() {
+
+                        So(encrypt.NeedsRehash(hashOf(encrypt, "pass")), ShouldBeFalse)
+                })
         })
 }
@@ -3,7 +3,6 @@ package accounts
 import (
 	"regexp"
 	"time"
-	"unicode"
 	e "github.com/piotrjaromin/go-login-backend/web"
 	"errors"
 )
@@ -15,6 +14,7 @@ var (
 	ErrInvalidResetCode = errors.New("Invalid reset password code")
 	ErrUnableToSetResetCode = errors.New("Invalid reset password code")
 	ErrAccountNotFound = errors.New("Account does not exist")
+	ErrInvalidRecoveryCode = errors.New("Invalid recovery code")
 )
 
 const (
@@ -22,17 +22,9 @@ const (
 	Confirmed AccountStatus = "CONFIRMED"
 )
 
+//Password is checked against PasswordPolicy before it is hashed
 type Password string
 
-func (p Password) IsValid() bool {
-
-	fiveOrMore, number, upper := verifyPassword(p)
-	if !fiveOrMore || !number || !upper {
-		return false
-	}
-	return true
-}
-
 type UpdateAccountDto struct {
 	Email          string `json:"email"`
 	FirstName      string `json:"firstName"`
@@ -47,7 +39,13 @@ type PasswordlessAccount struct {
 	LastName       string    `json:"lastName" bson:"lastName"`
 	CreatedAt      time.Time `json:"createdAt,omitempty" bson:"createdAt"`
 	Status         AccountStatus `bson:"status"`
-	AuthProviders  AuthProviders `bson:"authProviders"`
+	//Identities are accounts of social login providers linked with this account
+	Identities     []Identity    `json:"identities,omitempty" bson:"identities"`
+	Roles          []string      `json:"roles" bson:"roles"`
+}
+
+type UpdateRolesDto struct {
+	Roles []string `json:"roles"`
 }
 
 type PasswordChangeDto struct {
@@ -69,14 +67,38 @@ type Signup struct {
 	Code  string `json:"code" bson:"code"`
 }
 
-type AuthProviders struct {
-	FB string `bson:"FB"`
+//Identity is account of social login provider, subject is its id, unique only within provider
+type Identity struct {
+	Provider string `json:"provider" bson:"provider"`
+	Subject  string `json:"subject" bson:"subject"`
+}
+
+//HasIdentity tells if provider account is linked with this account
+func (account PasswordlessAccount) HasIdentity(provider string, subject string) bool {
+
+	for _, identity := range account.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return true
+		}
+	}
+
+	return false
 }
 
 type SecuredAccount struct {
 	Account           `bson:",inline"`
+	//Salt is set only for legacy hashes, PHC hashes contain salt
 	Salt              string `json:"salt" bson:"salt"`
 	ResetPasswordCode string `bson:"resetPasswordCode"`
+	RecoveryCodes     []RecoveryCode `bson:"recoveryCodes"`
+	PasswordChangedAt time.Time `bson:"passwordChangedAt"`
+	//PreviousPasswords are hashes of passwords which cannot be used again, newest first
+	PreviousPasswords []PreviousPassword `bson:"previousPasswords"`
+}
+
+type PreviousPassword struct {
+	Hash Password `bson:"hash"`
+	Salt string   `bson:"salt"`
 }
 
 const (
@@ -91,8 +113,8 @@ func (acc Account) validate() []e.ErrorDetails {
 		errors = e.AppendErrorDetails(errors, "username", "username is required", e.MissingField)
 	}
 
-	if !acc.Password.IsValid() {
-		errors = e.AppendErrorDetails(errors, "password", "Password to weak(5 characters, one capital letter, one digit)", e.InvalidField)
+	if len(acc.Password) == 0 {
+		errors = e.AppendErrorDetails(errors, "password", "password is required", e.MissingField)
 	}
 
 	emailOk, _ := regexp.MatchString(emailPattern, acc.Email)
@@ -102,18 +124,3 @@ func (acc Account) validate() []e.ErrorDetails {
 
 	return errors
 }
-
-func verifyPassword(s Password) (fiveOrMore, number, upper bool) {
-
-	pass := string(s)
-	for _, s := range pass {
-		switch {
-		case unicode.IsNumber(s):
-			number = true
-		case unicode.IsUpper(s):
-			upper = true
-		}
-	}
-	fiveOrMore = len(pass) >= 5
-	return
-}
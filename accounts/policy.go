@@ -0,0 +1,161 @@
+package accounts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/piotrjaromin/go-login-backend/config"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Character classes which can be required by password policy
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+//Types of error details returned for passwords which break policy
+const (
+	PasswordTooShort     web.ErrorType = "PASSWORD_TOO_SHORT"
+	PasswordTooLong      web.ErrorType = "PASSWORD_TOO_LONG"
+	PasswordMissingClass web.ErrorType = "PASSWORD_MISSING_CLASS"
+	PasswordTooWeak      web.ErrorType = "PASSWORD_TOO_WEAK"
+	PasswordBreached     web.ErrorType = "PASSWORD_BREACHED"
+	PasswordReused       web.ErrorType = "PASSWORD_REUSED"
+)
+
+//PasswordPolicy describes rules for new passwords, rules which are not set are not checked
+type PasswordPolicy struct {
+	//MinLength and MaxLength count characters, they default to 8 and 128
+	MinLength int
+	MaxLength int
+	//RequiredClasses are ClassLower, ClassUpper, ClassDigit or ClassSymbol
+	RequiredClasses []string
+	//MinStrength is minimal score, from 0 to 4, of strength estimation
+	MinStrength int
+	//BreachedDir has k-anonymity range files of breached passwords, see isBreached
+	BreachedDir string
+	//History is number of last passwords, current one included, which cannot be used again
+	History int
+	//MaxAge after which password has to be changed at next login
+	MaxAge time.Duration
+}
+
+//PolicyError is returned when new password breaks password policy, details list broken rules
+type PolicyError struct {
+	Details []web.ErrorDetails
+}
+
+func (err PolicyError) Error() string {
+	return "Password does not meet password policy"
+}
+
+func createPasswordPolicy(conf config.Config) PasswordPolicy {
+
+	return PasswordPolicy{
+		MinLength:       conf.PasswordPolicy.MinLength,
+		MaxLength:       conf.PasswordPolicy.MaxLength,
+		RequiredClasses: conf.PasswordPolicy.RequiredClasses,
+		MinStrength:     conf.PasswordPolicy.MinStrength,
+		BreachedDir:     conf.PasswordPolicy.BreachedDir,
+		History:         conf.PasswordPolicy.History,
+		MaxAge:          time.Duration(conf.PasswordPolicy.MaxAgeDays) * time.Hour * 24,
+	}.withDefaults()
+}
+
+func (policy PasswordPolicy) withDefaults() PasswordPolicy {
+
+	if policy.MinLength <= 0 {
+		policy.MinLength = 8
+	}
+
+	if policy.MaxLength <= 0 {
+		policy.MaxLength = 128
+	}
+
+	return policy
+}
+
+//violations checks rules which do not need previous passwords, userInputs like email or name make password weaker,
+//error is returned together with details when breached passwords could not be checked
+func (policy PasswordPolicy) violations(field string, pass Password, userInputs []string) ([]web.ErrorDetails, error) {
+
+	var details []web.ErrorDetails
+
+	length := utf8.RuneCountInString(string(pass))
+	if length < policy.MinLength {
+		details = web.AppendErrorDetails(details, field, fmt.Sprintf("Password has to be at least %d characters long", policy.MinLength), PasswordTooShort)
+	}
+
+	if length > policy.MaxLength {
+		details = web.AppendErrorDetails(details, field, fmt.Sprintf("Password can be at most %d characters long", policy.MaxLength), PasswordTooLong)
+	}
+
+	classes := characterClasses(pass)
+	for _, class := range policy.RequiredClasses {
+		if !classes[class] {
+			details = web.AppendErrorDetails(details, field, "Password has to contain "+class+" character", PasswordMissingClass)
+		}
+	}
+
+	if policy.MinStrength > 0 && estimateStrength(string(pass), userInputs) < policy.MinStrength {
+		details = web.AppendErrorDetails(details, field, "Password is too easy to guess", PasswordTooWeak)
+	}
+
+	if len(policy.BreachedDir) == 0 {
+		return details, nil
+	}
+
+	breached, err := isBreached(policy.BreachedDir, pass)
+	if breached {
+		details = web.AppendErrorDetails(details, field, "Password appeared in data breach", PasswordBreached)
+	}
+
+	return details, err
+}
+
+//expired tells if password has to be changed, accounts which never changed password count from creation
+func (policy PasswordPolicy) expired(secAccount SecuredAccount) bool {
+
+	changedAt := secAccount.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = secAccount.CreatedAt
+	}
+
+	return policy.MaxAge > 0 && !changedAt.IsZero() && time.Now().After(changedAt.Add(policy.MaxAge))
+}
+
+//userInputs are parts of account which should not be used in password
+func userInputs(account PasswordlessAccount) []string {
+
+	inputs := []string{account.Username, account.FirstName, account.LastName, account.Email}
+	if at := strings.Index(account.Email, "@"); at > 0 {
+		inputs = append(inputs, account.Email[:at])
+	}
+
+	return inputs
+}
+
+func characterClasses(pass Password) map[string]bool {
+
+	classes := map[string]bool{}
+	for _, char := range string(pass) {
+		switch {
+		case unicode.IsLower(char):
+			classes[ClassLower] = true
+		case unicode.IsUpper(char):
+			classes[ClassUpper] = true
+		case unicode.IsDigit(char):
+			classes[ClassDigit] = true
+		default:
+			classes[ClassSymbol] = true
+		}
+	}
+
+	return classes
+}
@@ -0,0 +1,157 @@
+package accounts
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/config"
+	"github.com/piotrjaromin/go-login-backend/dal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+
+	Convey("Password policy should", t, func() {
+
+		policy := PasswordPolicy{RequiredClasses: []string{ClassUpper, ClassDigit}, MinStrength: 2}.withDefaults()
+
+		Convey("accept strong password", func() {
+
+			details, err := policy.violations("password", "kT9#mQ2zLp", nil)
+			So(err, ShouldBeNil)
+			So(details, ShouldBeEmpty)
+		})
+
+		Convey("report every broken rule", func() {
+
+			details, _ := policy.violations("password", "pass", nil)
+
+			types := []interface{}{}
+			for _, detail := range details {
+				So(detail.Field, ShouldEqual, "password")
+				types = append(types, detail.Type)
+			}
+			So(types, ShouldResemble, []interface{}{PasswordTooShort, PasswordMissingClass, PasswordMissingClass, PasswordTooWeak})
+		})
+
+		Convey("reject too long password", func() {
+
+			details, _ := PasswordPolicy{MaxLength: 10}.withDefaults().violations("password", "kT9#mQ2zLpX", nil)
+			So(details, ShouldHaveLength, 1)
+			So(details[0].Type, ShouldEqual, PasswordTooLong)
+		})
+
+		Convey("reject password found in breached range file", func() {
+
+			dir, _ := ioutil.TempDir("", "breached")
+			defer os.RemoveAll(dir)
+
+			//sha1 of "Password1" is 70CCD9007338D6D81DD3B6271621B9CF9A97EA00
+			ioutil.WriteFile(filepath.Join(dir, "70CCD.txt"), []byte("0000000000000000000000000000000000A:1\r\n9007338D6D81DD3B6271621B9CF9A97EA00:111658\r\n"), 0600)
+
+			breached := PasswordPolicy{BreachedDir: dir}.withDefaults()
+
+			details, err := breached.violations("password", "Password1", nil)
+			So(err, ShouldBeNil)
+			So(details, ShouldHaveLength, 1)
+			So(details[0].Type, ShouldEqual, PasswordBreached)
+
+			details, err = breached.violations("password", "kT9#mQ2zLp", nil)
+			So(err, ShouldBeNil)
+			So(details, ShouldBeEmpty)
+		})
+
+		Convey("expire passwords older than max age", func() {
+
+			maxAge := PasswordPolicy{MaxAge: time.Hour * 24 * 90}
+
+			changed := SecuredAccount{PasswordChangedAt: time.Now().Add(-time.Hour * 24 * 91)}
+			So(maxAge.expired(changed), ShouldBeTrue)
+
+			changed.PasswordChangedAt = time.Now().Add(-time.Hour)
+			So(maxAge.expired(changed), ShouldBeFalse)
+			So(PasswordPolicy{}.expired(SecuredAccount{CreatedAt: time.Now().Add(-time.Hour * 24 * 365)}), ShouldBeFalse)
+
+			neverChanged := SecuredAccount{}
+			neverChanged.CreatedAt = time.Now().Add(-time.Hour * 24 * 365)
+			So(maxAge.expired(neverChanged), ShouldBeTrue)
+		})
+	})
+
+	Convey("Strength estimation should", t, func() {
+
+		Convey("give lowest score to common passwords and their variations", func() {
+
+			So(estimateStrength("password", nil), ShouldEqual, 0)
+			So(estimateStrength("Password1", nil), ShouldEqual, 0)
+			So(estimateStrength("p4ssw0rd", nil), ShouldEqual, 0)
+			So(estimateStrength("qwerty123", nil), ShouldEqual, 0)
+		})
+
+		Convey("find repeats, sequences, keyboard rows and years", func() {
+
+			So(estimateStrength("aaaaaaaaaaaa", nil), ShouldEqual, 0)
+			So(estimateStrength("abcdefghijkl", nil), ShouldEqual, 0)
+			So(estimateStrength("asdfghjkl1990", nil), ShouldBeLessThan, 2)
+		})
+
+		Convey("treat details of account as known words", func() {
+
+			So(estimateStrength("Jhone1990", userInputs(PasswordlessAccount{FirstName: "Jhone"})), ShouldEqual, 0)
+			So(estimateStrength("Jhone1990", nil), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("give high score to long random passwords", func() {
+
+			So(estimateStrength("kT9#mQ2z", nil), ShouldEqual, 3)
+			So(estimateStrength("correcthorsebatterystaple", nil), ShouldEqual, 4)
+		})
+	})
+
+	Convey("Changing password should", t, func() {
+
+		encrypt := createTestEncrypt(HashArgon2id, "")
+
+		conf := config.Config{}
+		conf.PasswordPolicy.History = 2
+
+		stored := SecuredAccount{}
+		stored.Password = hashOf(encrypt, "Current1pass")
+		stored.PreviousPasswords = []PreviousPassword{{Hash: hashOf(encrypt, "Older1pass")}, {Hash: hashOf(encrypt, "Oldest1pass")}}
+
+		accountDal := Dal{
+			updateByID: func(id string, handleUpdateFunc func(*SecuredAccount) error) error {
+				updated := stored
+				if err := handleUpdateFunc(&updated); err != nil {
+					return err
+				}
+				stored = updated
+				return nil
+			},
+		}
+
+		service := CreateService(conf, accountDal, dal.Dal{}, TestMail{}, encrypt)
+
+		Convey("reject last passwords", func() {
+
+			for _, pass := range []Password{"Current1pass", "Older1pass"} {
+				err := service.ChangePassword(context.Background(), "id", pass)
+				So(err, ShouldHaveSameTypeAs, PolicyError{})
+				So(err.(PolicyError).Details[0].Type, ShouldEqual, PasswordReused)
+			}
+		})
+
+		Convey("accept passwords older than history and remember current one", func() {
+
+			So(service.ChangePassword(context.Background(), "id", "Oldest1pass"), ShouldBeNil)
+			So(isValid(encrypt, "Oldest1pass", stored.Password, ""), ShouldBeTrue)
+			So(stored.PreviousPasswords, ShouldHaveLength, 1)
+			So(isValid(encrypt, "Current1pass", stored.PreviousPasswords[0].Hash, ""), ShouldBeTrue)
+			So(stored.PasswordChangedAt, ShouldHappenWithin, time.Second, time.Now())
+		})
+	})
+}
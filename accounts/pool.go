@@ -0,0 +1,173 @@
+package accounts
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+//HashingPoolConfig limits how much cpu password hashing can take, values which are not set get defaults
+type HashingPoolConfig struct {
+	//Workers is number of hashes computed at the same time, it defaults to number of cpus
+	Workers int
+	//QueueDepth is number of hashes which can wait for worker, next ones are rejected at once
+	QueueDepth int
+	//WaitTimeout is how long hash can wait in queue, it does not cover hashing which worker already started
+	WaitTimeout time.Duration
+	//RetryAfter is suggested to clients which were rejected
+	RetryAfter time.Duration
+}
+
+//HashingStats describe load of hashing pool
+type HashingStats struct {
+	Workers    int   `json:"workers"`
+	QueueDepth int   `json:"queueDepth"`
+	Busy       int64 `json:"busy"`
+	Queued     int   `json:"queued"`
+	Completed  int64 `json:"completed"`
+	Rejected   int64 `json:"rejected"`
+	TimedOut   int64 `json:"timedOut"`
+	//AverageWaitMs is average time which completed hashes spent in queue
+	AverageWaitMs float64 `json:"averageWaitMs"`
+}
+
+//OverloadedError is returned when hash was not computed because pool was full or hash waited in queue too long
+type OverloadedError struct {
+	RetryAfter time.Duration
+}
+
+func (err OverloadedError) Error() string {
+	return "Password hashing is overloaded"
+}
+
+//hashingPool runs hashes on fixed number of workers, so burst of logins cannot take every core
+type hashingPool struct {
+	//run waits until work is done by worker, it fails when queue is full, when work waits in queue longer than WaitTimeout
+	//or when ctx is done, e.g. client went away
+	run   func(ctx context.Context, work func()) error
+	stats func() HashingStats
+}
+
+//states of job, caller and worker both try to move job out of queued state, only one of them succeeds
+const (
+	jobQueued int32 = iota
+	jobTaken
+	jobSkipped
+)
+
+type hashingJob struct {
+	work     func()
+	queuedAt time.Time
+	state    int32
+	done     chan struct{}
+}
+
+func (conf HashingPoolConfig) withDefaults() HashingPoolConfig {
+
+	if conf.Workers <= 0 {
+		conf.Workers = runtime.NumCPU()
+	}
+
+	if conf.QueueDepth <= 0 {
+		conf.QueueDepth = 64 * conf.Workers
+	}
+
+	if conf.WaitTimeout <= 0 {
+		conf.WaitTimeout = 5 * time.Second
+	}
+
+	if conf.RetryAfter <= 0 {
+		conf.RetryAfter = time.Second
+	}
+
+	return conf
+}
+
+func createHashingPool(conf HashingPoolConfig) hashingPool {
+
+	conf = conf.withDefaults()
+
+	jobs := make(chan *hashingJob, conf.QueueDepth)
+
+	var busy, completed, rejected, timedOut, waitNanos int64
+
+	worker := func() {
+		for job := range jobs {
+			//callers which gave up are not waiting for result
+			if atomic.CompareAndSwapInt32(&job.state, jobQueued, jobTaken) {
+				atomic.AddInt64(&busy, 1)
+				atomic.AddInt64(&waitNanos, int64(time.Since(job.queuedAt)))
+				job.work()
+				atomic.AddInt64(&busy, -1)
+				atomic.AddInt64(&completed, 1)
+			}
+			close(job.done)
+		}
+	}
+
+	for i := 0; i < conf.Workers; i++ {
+		go worker()
+	}
+
+	run := func(ctx context.Context, work func()) error {
+
+		job := &hashingJob{work: work, queuedAt: time.Now(), done: make(chan struct{})}
+
+		select {
+		case jobs <- job:
+		default:
+			atomic.AddInt64(&rejected, 1)
+			return OverloadedError{RetryAfter: conf.RetryAfter}
+		}
+
+		wait := time.NewTimer(conf.WaitTimeout)
+		defer wait.Stop()
+
+		select {
+		case <-job.done:
+			return nil
+		case <-wait.C:
+		case <-ctx.Done():
+		}
+
+		//job which is still in queue is skipped by worker, it is not hashed for caller who went away
+		if atomic.CompareAndSwapInt32(&job.state, jobQueued, jobSkipped) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			atomic.AddInt64(&timedOut, 1)
+			return OverloadedError{RetryAfter: conf.RetryAfter}
+		}
+
+		//worker already took job, its cpu is spent, so result is awaited as long as caller is there
+		select {
+		case <-job.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	stats := func() HashingStats {
+
+		stats := HashingStats{
+			Workers:    conf.Workers,
+			QueueDepth: conf.QueueDepth,
+			Busy:       atomic.LoadInt64(&busy),
+			Queued:     len(jobs),
+			Completed:  atomic.LoadInt64(&completed),
+			Rejected:   atomic.LoadInt64(&rejected),
+			TimedOut:   atomic.LoadInt64(&timedOut),
+		}
+
+		if stats.Completed > 0 {
+			stats.AverageWaitMs = float64(atomic.LoadInt64(&waitNanos)) / float64(stats.Completed) / float64(time.Millisecond)
+		}
+
+		return stats
+	}
+
+	return hashingPool{run: run, stats: stats}
+}
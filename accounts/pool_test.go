@@ -0,0 +1,112 @@
+package accounts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHashingPool(t *testing.T) {
+
+	Convey("Hashing pool should", t, func() {
+
+		pool := createHashingPool(HashingPoolConfig{Workers: 1, QueueDepth: 1, WaitTimeout: 200 * time.Millisecond, RetryAfter: 3 * time.Second})
+
+		//first job keeps the only worker busy until release is closed
+		release := make(chan struct{})
+		started := make(chan struct{})
+		go pool.run(context.Background(), func() {
+			close(started)
+			<-release
+		})
+		<-started
+
+		Convey("reject work when queue is full", func() {
+
+			queued := make(chan error)
+			go func() { queued <- pool.run(context.Background(), func() {}) }()
+
+			//second job can be rejected only when first one is in queue
+			So(waitFor(func() bool { return pool.stats().Queued == 1 }), ShouldBeTrue)
+
+			err := pool.run(context.Background(), func() {})
+			So(err, ShouldResemble, OverloadedError{RetryAfter: 3 * time.Second})
+
+			close(release)
+			So(<-queued, ShouldBeNil)
+
+			stats := pool.stats()
+			So(stats.Rejected, ShouldEqual, 1)
+			So(waitFor(func() bool { return pool.stats().Completed == 2 }), ShouldBeTrue)
+		})
+
+		Convey("give up when work waits in queue too long", func() {
+
+			ran := false
+			err := pool.run(context.Background(), func() { ran = true })
+			So(err, ShouldHaveSameTypeAs, OverloadedError{})
+
+			close(release)
+			So(waitFor(func() bool { return pool.stats().Queued == 0 && pool.stats().Busy == 0 }), ShouldBeTrue)
+			So(ran, ShouldBeFalse)
+			So(pool.stats().TimedOut, ShouldEqual, 1)
+		})
+
+		Convey("skip work of caller who went away", func() {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				waitFor(func() bool { return pool.stats().Queued == 1 })
+				cancel()
+			}()
+
+			ran := false
+			err := pool.run(ctx, func() { ran = true })
+			So(err, ShouldEqual, context.Canceled)
+
+			close(release)
+			So(waitFor(func() bool { return pool.stats().Queued == 0 && pool.stats().Busy == 0 }), ShouldBeTrue)
+			So(ran, ShouldBeFalse)
+			So(pool.stats().TimedOut, ShouldEqual, 0)
+		})
+
+		Convey("wait for work which worker already started", func() {
+
+			close(release)
+			So(waitFor(func() bool { return pool.stats().Busy == 0 }), ShouldBeTrue)
+
+			done := false
+			err := pool.run(context.Background(), func() {
+				time.Sleep(300 * time.Millisecond)
+				done = true
+			})
+			So(err, ShouldBeNil)
+			So(done, ShouldBeTrue)
+			So(pool.stats().TimedOut, ShouldEqual, 0)
+		})
+
+		Convey("report busy workers", func() {
+
+			stats := pool.stats()
+			So(stats.Workers, ShouldEqual, 1)
+			So(stats.QueueDepth, ShouldEqual, 1)
+			So(stats.Busy, ShouldEqual, 1)
+
+			close(release)
+		})
+	})
+}
+
+func waitFor(condition func() bool) bool {
+
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+//RecoveryCodesCount is number of codes generated at once
+const RecoveryCodesCount = 10
+
+//recoveryCodeBytes gives 16 base32 characters, shown to user as four groups
+const recoveryCodeBytes = 10
+
+//RecoveryCode is single use credential, only its hash is stored,
+//codes are random enough for sha256, so they can be looked up by hash without slow password hashing
+type RecoveryCode struct {
+	Hash   string     `bson:"hash"`
+	UsedAt *time.Time `bson:"usedAt"`
+}
+
+//RecoveryCodesDto is returned only once, when codes are generated
+type RecoveryCodesDto struct {
+	Codes []string `json:"codes"`
+}
+
+//RecoveryCodesStatus tells how many codes can still be used
+type RecoveryCodesStatus struct {
+	Remaining int `json:"remaining"`
+}
+
+//RecoverAccountDto is payload for logging in with recovery code
+type RecoverAccountDto struct {
+	Code        string   `json:"code"`
+	NewPassword Password `json:"newPassword"`
+}
+
+func (acc SecuredAccount) remainingRecoveryCodes() int {
+
+	remaining := 0
+	for _, code := range acc.RecoveryCodes {
+		if code.UsedAt == nil {
+			remaining++
+		}
+	}
+
+	return remaining
+}
+
+func generateRecoveryCode() (string, error) {
+
+	codeBytes := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(codeBytes))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+//normalizeRecoveryCode lets user type code without dashes or in upper case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,44 @@
+package accounts
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+
+	Convey("Recovery codes should", t, func() {
+
+		Convey("be generated as four groups of characters", func() {
+
+			code, err := generateRecoveryCode()
+			So(err, ShouldBeNil)
+
+			matches, _ := regexp.MatchString("^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$", code)
+			So(matches, ShouldBeTrue)
+
+			other, _ := generateRecoveryCode()
+			So(other, ShouldNotEqual, code)
+		})
+
+		Convey("be accepted without dashes and in upper case", func() {
+			So(normalizeRecoveryCode(" ABCD-efgh-ijkl-mnop "), ShouldEqual, "abcdefghijklmnop")
+			So(normalizeRecoveryCode("abcdefghijklmnop"), ShouldEqual, "abcdefghijklmnop")
+		})
+
+		Convey("be hashed the same way however typed", func() {
+			So(hashRecoveryCode(" ABCD-efgh-ijkl-mnop "), ShouldEqual, hashRecoveryCode("abcdefghijklmnop"))
+			So(hashRecoveryCode("abcd-efgh-ijkl-mnop"), ShouldHaveLength, 64)
+			So(hashRecoveryCode("abcd-efgh-ijkl-mnop"), ShouldNotEqual, hashRecoveryCode("abcd-efgh-ijkl-mnoq"))
+		})
+
+		Convey("count only unused codes as remaining", func() {
+
+			usedAt := time.Now()
+			acc := SecuredAccount{RecoveryCodes: []RecoveryCode{{}, {UsedAt: &usedAt}, {}}}
+			So(acc.remainingRecoveryCodes(), ShouldEqual, 2)
+		})
+	})
+}
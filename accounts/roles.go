@@ -0,0 +1,92 @@
+package accounts
+
+import (
+	"strings"
+
+	"github.com/piotrjaromin/go-login-backend/security"
+	e "github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Roles that can be assigned to account
+const (
+	RoleUser  = "user"
+	RoleAdmin = security.RoleAdmin
+)
+
+//Scopes granting access to accounts of other users
+const (
+	ScopeAccountsRead  = "accounts:read"
+	ScopeAccountsWrite = "accounts:write"
+)
+
+//ScopeProfile lets api key read account it belongs to
+const ScopeProfile = "profile"
+
+var knownRoles = []string{RoleUser, RoleAdmin}
+
+//roleScopes lists scopes which are granted to access tokens of account with given role
+var roleScopes = map[string][]string{
+	RoleUser:  {"openid", ScopeProfile, "email"},
+	RoleAdmin: {ScopeAccountsRead, ScopeAccountsWrite},
+}
+
+//EffectiveRoles returns roles of account, accounts created before roles were introduced are treated as users
+func (acc PasswordlessAccount) EffectiveRoles() []string {
+	if len(acc.Roles) == 0 {
+		return []string{RoleUser}
+	}
+
+	return acc.Roles
+}
+
+//HasRole checks if account has given role
+func (acc PasswordlessAccount) HasRole(role string) bool {
+	for _, r := range acc.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Scope returns space separated scopes granted by roles of account
+func (acc PasswordlessAccount) Scope() string {
+
+	var scopes []string
+	seen := map[string]bool{}
+	for _, role := range acc.EffectiveRoles() {
+		for _, scope := range roleScopes[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return strings.Join(scopes, " ")
+}
+
+func (dto UpdateRolesDto) validate() []e.ErrorDetails {
+
+	var errors []e.ErrorDetails
+
+	if len(dto.Roles) == 0 {
+		errors = e.AppendErrorDetails(errors, "roles", "at least one role is required", e.MissingField)
+	}
+
+	for _, role := range dto.Roles {
+		known := false
+		for _, r := range knownRoles {
+			if r == role {
+				known = true
+			}
+		}
+
+		if !known {
+			errors = e.AppendErrorDetails(errors, "roles", "unknown role "+role, e.InvalidField)
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,34 @@
+package accounts
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+
+	Convey("Account roles should", t, func() {
+
+		Convey("default to user role when account has none", func() {
+
+			acc := PasswordlessAccount{}
+			So(acc.EffectiveRoles(), ShouldResemble, []string{RoleUser})
+			So(acc.HasRole(RoleAdmin), ShouldBeFalse)
+			So(acc.Scope(), ShouldEqual, "openid profile email")
+		})
+
+		Convey("grant accounts scopes to admin", func() {
+
+			acc := PasswordlessAccount{Roles: []string{RoleUser, RoleAdmin}}
+			So(acc.HasRole(RoleAdmin), ShouldBeTrue)
+			So(acc.Scope(), ShouldEqual, "openid profile email accounts:read accounts:write")
+		})
+
+		Convey("reject unknown roles", func() {
+
+			So(UpdateRolesDto{Roles: []string{RoleAdmin}}.validate(), ShouldBeEmpty)
+			So(UpdateRolesDto{Roles: []string{"root"}}.validate(), ShouldHaveLength, 1)
+			So(UpdateRolesDto{}.validate(), ShouldHaveLength, 1)
+		})
+	})
+}
@@ -28,7 +28,21 @@ func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.
         accountGroup.POST("/:id/reset", controller.ResetPassword)
         accountGroup.PUT("/:id/reset", controller.ConfirmResetPassword)
 
+        accountGroup.OPTIONS("/:id/roles", web.OptionsMethodHandler)
+        accountGroup.PUT("/:id/roles", controller.UpdateRoles, security.RequireRole(RoleAdmin))
+
+        //generating new codes invalidates previous ones
+        accountGroup.OPTIONS("/:id/recovery-codes", web.OptionsMethodHandler)
+        accountGroup.POST("/:id/recovery-codes", controller.GenerateRecoveryCodes, security.SecuredById("username", "username", false), security.RequireRecentAuth())
+        accountGroup.GET("/:id/recovery-codes", controller.GetRecoveryCodesStatus, security.SecuredById("username", "username", false))
+
         accountGroup.OPTIONS("/:id", web.OptionsMethodHandler)
-        accountGroup.Use(security.SecuredById("username", "username", false))
-        accountGroup.GET("/:id", controller.GetByID)
+        accountGroup.GET("/:id", controller.GetByID, security.SecuredByIdWithKeyScope("username", "username", false, ScopeProfile, ScopeAccountsRead))
+
+        //changing email and deleting account require recent login or reauthentication
+        accountGroup.PUT("/:id", controller.Update, security.SecuredById("username", "username", false, ScopeAccountsWrite), security.RequireRecentAuth())
+        accountGroup.DELETE("/:id", controller.Delete, security.SecuredById("username", "username", false, ScopeAccountsWrite), security.RequireRecentAuth())
+
+        //load of password hashing pool, for monitoring
+        echoEngine.GET("/metrics/hashing", controller.HashingStats, security.RequireRole(RoleAdmin))
 }
\ No newline at end of file
@@ -2,28 +2,53 @@ package accounts
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/op/go-logging"
 	"github.com/piotrjaromin/go-login-backend/config"
 	"github.com/piotrjaromin/go-login-backend/dal"
 	"github.com/piotrjaromin/go-login-backend/email"
+	"github.com/piotrjaromin/go-login-backend/web"
 	"github.com/satori/go.uuid"
+	"time"
 )
 
 type Service struct {
-	StartSignupAccount   func(email string, secAccount SecuredAccount) (string, error)
+	//StartSignupAccount and StartResetPassword behave the same for registered and unknown emails, owner of email is told by email,
+	//functions which hash passwords take context of request, hashing is skipped when client goes away
+	StartSignupAccount   func(ctx context.Context, email string, secAccount SecuredAccount) (string, error)
 	GetByEmail           func(email string) (PasswordlessAccount, error)
 	GetByUsername        func(email string) (PasswordlessAccount, error)
 	ConfirmAccount       func(email string, code string) (bool, error)
+	//ConfirmVerifiedEmail confirms pending account whose email was verified elsewhere, e.g. by social login provider,
+	//password and codes are dropped, pending account could be registered by someone else than owner of email
+	ConfirmVerifiedEmail func(email string) error
 	StartResetPassword   func(email string) error
-	ConfirmResetPassword func(email string, code string, newPassword Password) error
-	CreateAccount        func(email string, secAccount SecuredAccount) (string, error)
+	ConfirmResetPassword func(ctx context.Context, email string, code string, newPassword Password) error
+	CreateAccount        func(ctx context.Context, email string, secAccount SecuredAccount) (string, error)
 	UpdateByEmail        func(email string, accUpdate UpdateAccountDto) error
+	UpdateRoles          func(username string, roles []string) error
+	//GenerateRecoveryCodes replaces previous codes, so old ones cannot be used anymore
+	GenerateRecoveryCodes  func(username string) ([]string, error)
+	GetRecoveryCodesStatus func(username string) (RecoveryCodesStatus, error)
+	//RecoverAccount sets new password when code is valid and notifies user by email
+	RecoverAccount func(ctx context.Context, email string, code string, newPassword Password) (PasswordlessAccount, error)
+	Delete         func(username string) error
+	//ValidatePassword checks password of new account against password policy
+	ValidatePassword func(pass Password, account PasswordlessAccount) []web.ErrorDetails
+	//ChangePassword, like ConfirmResetPassword and RecoverAccount, fails with PolicyError when new password breaks policy
+	ChangePassword func(ctx context.Context, accountId string, newPassword Password) error
+	//PasswordExpired tells if password is older than maximum age of policy and has to be changed before login
+	PasswordExpired func(accountId string) (bool, error)
+	//HashingStats describe load of password hashing pool
+	HashingStats func() HashingStats
 }
 
 func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, emailService email.EmailService, encrypt Encrypt) Service {
 
 	var log = logging.MustGetLogger("[LoginSerivce]")
 	templates := emailService.Templates()
+	policy := createPasswordPolicy(config)
 
 	sendAccountRequestedMail := func(email string, code string, name string) error {
 
@@ -47,16 +72,111 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 		return emailService.SendEmail(email, buf.String(), "Account confirmation")
 	}
 
-	createAccount := func(email string, secAccount SecuredAccount) (string, error) {
+	createAccount := func(ctx context.Context, email string, secAccount SecuredAccount) (string, error) {
+
+		hash, err := encrypt.Hash(ctx, secAccount.Password)
+		if err != nil {
+			return "", err
+		}
 
-		hash, salt := encrypt.Hash(secAccount.Password)
 		secAccount.Password = hash
-		secAccount.Salt = salt
+		secAccount.Salt = ""
+		secAccount.PasswordChangedAt = time.Now()
 		secAccount.Email = email
+		//identity linked without provider verifying it would let its owner log in to this account
+		secAccount.Identities = nil
 		return accountDal.CreateAccount(secAccount)
 	}
 
-	startSignup := func(email string, secAccount SecuredAccount) (string, error) {
+	validatePassword := func(field string, pass Password, account PasswordlessAccount) []web.ErrorDetails {
+
+		details, err := policy.violations(field, pass, userInputs(account))
+		if err != nil {
+			log.Error("Could not check breached passwords. Details: ", err)
+		}
+
+		return details
+	}
+
+	//setPassword checks new password against policy and last passwords of account before it replaces current one
+	setPassword := func(ctx context.Context, secAccount *SecuredAccount, field string, newPassword Password) error {
+
+		details := validatePassword(field, newPassword, secAccount.PasswordlessAccount)
+
+		last := append([]PreviousPassword{{Hash: secAccount.Password, Salt: secAccount.Salt}}, secAccount.PreviousPasswords...)
+		for _, previous := range firstPasswords(last, policy.History) {
+			if len(previous.Hash) == 0 {
+				continue
+			}
+
+			reused, err := encrypt.Validate(ctx, newPassword, previous.Hash, previous.Salt)
+			if err != nil {
+				return err
+			}
+
+			if reused {
+				details = web.AppendErrorDetails(details, field, fmt.Sprintf("Password cannot be one of last %d passwords", policy.History), PasswordReused)
+				break
+			}
+		}
+
+		if len(details) > 0 {
+			return PolicyError{Details: details}
+		}
+
+		hash, err := encrypt.Hash(ctx, newPassword)
+		if err != nil {
+			return err
+		}
+
+		//new password is one of last ones, so one less previous password has to be kept
+		secAccount.PreviousPasswords = firstPasswords(last, policy.History-1)
+		secAccount.Password = hash
+		secAccount.Salt = ""
+		secAccount.PasswordChangedAt = time.Now()
+		return nil
+	}
+
+	sendAccountExistsMail := func(acc PasswordlessAccount) error {
+
+		data := struct {
+			Name string
+			Url  string
+		}{
+			acc.FirstName, config.FrontendURL,
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "account_exists.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(acc.Email, buf.String(), "Account already exists")
+	}
+
+	startSignup := func(ctx context.Context, email string, secAccount SecuredAccount) (string, error) {
+
+		existing, err := accountDal.GetByEmail(email)
+		if err != nil && err != ErrAccountNotFound {
+			return "", err
+		}
+
+		//registered email gets notification instead of error, password is hashed anyway so both cases take the same time,
+		//not confirmed accounts get confirmation email again with signup code they already have
+		if err == nil {
+			if _, err := encrypt.Hash(ctx, secAccount.Password); err != nil {
+				return "", err
+			}
+
+			if existing.Status == Pending {
+				sign := Signup{}
+				if err := signupsDal.GetById(email, &sign); err == nil && len(sign.Code) > 0 {
+					return "", sendAccountRequestedMail(email, sign.Code, existing.FirstName)
+				}
+			}
+
+			return "", sendAccountExistsMail(existing)
+		}
 
 		code := uuid.NewV4().String()
 
@@ -72,12 +192,19 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 			code = sign.Code
 		}
 
+		//account is created before email is sent, so overloaded hashing does not leave email with code of missing account,
+		//when sending fails next signup sends confirmation again
+		secAccount.Account.Status = Pending
+		id, err := createAccount(ctx, email, secAccount)
+		if err != nil {
+			return "", err
+		}
+
 		if err := sendAccountRequestedMail(email, code, secAccount.FirstName); err != nil {
 			return "", err
 		}
 
-		secAccount.Account.Status = Pending
-		return createAccount(email, secAccount)
+		return id, nil
 	}
 
 	getByEmailPasswordless := func(email string) (PasswordlessAccount, error) {
@@ -111,6 +238,48 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 
 	}
 
+	confirmVerifiedEmail := func(email string) error {
+
+		if err := accountDal.UpdateByEmail(email, func(acc *SecuredAccount) error {
+			if acc.Status == Confirmed {
+				return nil
+			}
+
+			acc.Status = Confirmed
+			acc.Password, acc.Salt = "", ""
+			acc.PasswordChangedAt = time.Time{}
+			acc.PreviousPasswords = nil
+			acc.ResetPasswordCode = ""
+			acc.RecoveryCodes = nil
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if err := signupsDal.DeleteById(email); err != nil && err != dal.ErrNotFound {
+			return err
+		}
+
+		return nil
+	}
+
+	sendNoAccountMail := func(email string) error {
+
+		data := struct {
+			Url   string
+			Email string
+		}{
+			config.FrontendURL, email,
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "no_account.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(email, buf.String(), "Password Reset")
+	}
+
 	startResetPassword := func(email string) error {
 
 		resetCode := uuid.NewV4().String()
@@ -120,6 +289,11 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 			return nil
 		})
 
+		//response is the same as for registered email, owner of email learns that there is no account
+		if updateErr == ErrAccountNotFound {
+			return sendNoAccountMail(email)
+		}
+
 		if updateErr != nil {
 			log.Error("[startResetPassword] updateErr: ", updateErr.Error())
 			return ErrUnableToSetResetCode
@@ -141,21 +315,28 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 		return emailService.SendEmail(email, buf.String(), "Password Reset")
 	}
 
-	confirmResetPassword := func(email string, code string, newPassword Password) error {
+	confirmResetPassword := func(ctx context.Context, email string, code string, newPassword Password) error {
 
 		handleUpdate := func(secAccount *SecuredAccount) error {
 			if secAccount.ResetPasswordCode != code {
 				return ErrInvalidResetCode
 			}
 
-			hash, salt := encrypt.Hash(newPassword)
-			secAccount.Password = hash
-			secAccount.Salt = salt
+			if err := setPassword(ctx, secAccount, "newPassword", newPassword); err != nil {
+				return err
+			}
+
 			secAccount.ResetPasswordCode = ""
 			return nil
 		}
 
-		if err := accountDal.UpdateByEmail(email, handleUpdate); err != nil {
+		err := accountDal.UpdateByEmail(email, handleUpdate)
+		switch err.(type) {
+		case PolicyError, OverloadedError:
+			return err
+		}
+
+		if err != nil {
 			log.Error("Error while updating account for reset password. ", err.Error())
 			return err
 		}
@@ -175,14 +356,229 @@ func CreateService(config config.Config, accountDal Dal, signupsDal dal.Dal, ema
 
 		return accountDal.UpdateByEmail(email, handleUpdate)
 	}
+
+	updateRoles := func(username string, roles []string) error {
+
+		return accountDal.updateByUsername(username, func(secAccount *SecuredAccount) error {
+			secAccount.Roles = roles
+			return nil
+		})
+	}
+
+	sendRecoveryCodesGeneratedMail := func(acc SecuredAccount) error {
+
+		data := struct {
+			Name string
+			Url  string
+		}{
+			acc.FirstName, config.FrontendURL,
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "recovery_codes_generated.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(acc.Email, buf.String(), "New recovery codes were generated")
+	}
+
+	generateRecoveryCodes := func(username string) ([]string, error) {
+
+		codes := make([]string, RecoveryCodesCount)
+		recoveryCodes := make([]RecoveryCode, RecoveryCodesCount)
+		for i := range codes {
+			code, err := generateRecoveryCode()
+			if err != nil {
+				return nil, err
+			}
+
+			codes[i] = code
+			recoveryCodes[i] = RecoveryCode{Hash: hashRecoveryCode(code)}
+		}
+
+		var updated SecuredAccount
+		err := accountDal.updateByUsername(username, func(secAccount *SecuredAccount) error {
+			secAccount.RecoveryCodes = recoveryCodes
+			updated = *secAccount
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := sendRecoveryCodesGeneratedMail(updated); err != nil {
+			log.Error("Could not send recovery codes notification. ", err)
+		}
+
+		return codes, nil
+	}
+
+	getRecoveryCodesStatus := func(username string) (RecoveryCodesStatus, error) {
+
+		acc, err := accountDal.getWithPasswordByUsername(username)
+		if err != nil {
+			return RecoveryCodesStatus{}, err
+		}
+
+		return RecoveryCodesStatus{Remaining: acc.remainingRecoveryCodes()}, nil
+	}
+
+	sendRecoveryCodeUsedMail := func(acc SecuredAccount) error {
+
+		data := struct {
+			Name      string
+			Url       string
+			Remaining int
+		}{
+			acc.FirstName, config.FrontendURL, acc.remainingRecoveryCodes(),
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "recovery_code_used.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(acc.Email, buf.String(), "Recovery code was used")
+	}
+
+	recoverAccount := func(ctx context.Context, email string, code string, newPassword Password) (PasswordlessAccount, error) {
+
+		var recovered SecuredAccount
+		codeHash := hashRecoveryCode(code)
+		handleUpdate := func(secAccount *SecuredAccount) error {
+
+			for i, recoveryCode := range secAccount.RecoveryCodes {
+				if recoveryCode.UsedAt != nil || recoveryCode.Hash != codeHash {
+					continue
+				}
+
+				if err := setPassword(ctx, secAccount, "newPassword", newPassword); err != nil {
+					return err
+				}
+
+				now := time.Now()
+				secAccount.RecoveryCodes[i].UsedAt = &now
+				secAccount.ResetPasswordCode = ""
+				recovered = *secAccount
+				return nil
+			}
+
+			return ErrInvalidRecoveryCode
+		}
+
+		if err := accountDal.updateWithRecoveryCode(email, codeHash, handleUpdate); err != nil {
+			if err == ErrAccountNotFound {
+				return PasswordlessAccount{}, ErrInvalidRecoveryCode
+			}
+			return PasswordlessAccount{}, err
+		}
+
+		if err := sendRecoveryCodeUsedMail(recovered); err != nil {
+			log.Error("Could not send recovery code notification. ", err)
+		}
+
+		return recovered.PasswordlessAccount, nil
+	}
+
+	deleteAccount := func(username string) error {
+
+		account, err := accountDal.GetByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Deleting account %s", account.Id)
+		return accountDal.deleteById(account.Id)
+	}
+
+	validateAccountPassword := func(pass Password, account PasswordlessAccount) []web.ErrorDetails {
+		return validatePassword("password", pass, account)
+	}
+
+	changePassword := func(ctx context.Context, accountId string, newPassword Password) error {
+
+		return accountDal.updateByID(accountId, func(secAccount *SecuredAccount) error {
+			return setPassword(ctx, secAccount, "newPassword", newPassword)
+		})
+	}
+
+	passwordExpired := func(accountId string) (bool, error) {
+
+		if policy.MaxAge <= 0 {
+			return false, nil
+		}
+
+		secAccount, err := accountDal.GetWithPasswordById(accountId)
+		if err != nil {
+			return false, err
+		}
+
+		return policy.expired(secAccount), nil
+	}
+
 	return Service{
-		StartSignupAccount:   startSignup,
-		GetByEmail:           getByEmailPasswordless,
-		ConfirmAccount:       confirmAccount,
-		StartResetPassword:   startResetPassword,
-		ConfirmResetPassword: confirmResetPassword,
-		CreateAccount:        createAccount,
-		UpdateByEmail:        updateByEmail,
-		GetByUsername:        getByUsernamePasswordless,
+		StartSignupAccount:     startSignup,
+		GetByEmail:             getByEmailPasswordless,
+		ConfirmAccount:         confirmAccount,
+		ConfirmVerifiedEmail:   confirmVerifiedEmail,
+		StartResetPassword:     startResetPassword,
+		ConfirmResetPassword:   confirmResetPassword,
+		CreateAccount:          createAccount,
+		UpdateByEmail:          updateByEmail,
+		GetByUsername:          getByUsernamePasswordless,
+		UpdateRoles:            updateRoles,
+		GenerateRecoveryCodes:  generateRecoveryCodes,
+		GetRecoveryCodesStatus: getRecoveryCodesStatus,
+		RecoverAccount:         recoverAccount,
+		Delete:                 deleteAccount,
+		ValidatePassword:       validateAccountPassword,
+		ChangePassword:         changePassword,
+		PasswordExpired:        passwordExpired,
+		HashingStats:           encrypt.Stats,
+	}
+}
+
+//Cleanup removes what other module keeps for account, e.g. its sessions or api keys
+type Cleanup func(account PasswordlessAccount) error
+
+//WithCleanups returns service which runs cleanups before account is deleted, so nothing issued for account works after deletion,
+//account is kept when any cleanup fails, so deletion can be retried
+func (service Service) WithCleanups(cleanups ...Cleanup) Service {
+
+	var log = logging.MustGetLogger("[AccountsCleanup]")
+	getByUsername, deleteAccount := service.GetByUsername, service.Delete
+
+	service.Delete = func(username string) error {
+
+		account, err := getByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		for _, cleanup := range cleanups {
+			if err := cleanup(account); err != nil {
+				log.Error("Could not clean up account ", account.Id, " before deletion. Details: ", err)
+				return err
+			}
+		}
+
+		return deleteAccount(username)
+	}
+
+	return service
+}
+
+//firstPasswords returns at most n newest passwords
+func firstPasswords(passwords []PreviousPassword, n int) []PreviousPassword {
+
+	if n <= 0 {
+		return nil
 	}
+
+	if len(passwords) > n {
+		return passwords[:n]
+	}
+
+	return passwords
 }
@@ -1,12 +1,15 @@
 package accounts
 
 import (
+        "context"
         . "github.com/smartystreets/goconvey/convey"
         "testing"
         "github.com/piotrjaromin/go-login-backend/config"
         "github.com/piotrjaromin/go-login-backend/dal"
         "github.com/smartystreets/assertions/should"
         "html/template"
+        "errors"
+        "strings"
         "github.com/satori/go.uuid"
 )
 
@@ -21,9 +24,27 @@ func (t TestMail) SendEmail(mail string, content string, subject string) error {
 
 func (t TestMail) Templates() *template.Template {
         tmp, _ := template.New("confirm_account.html").Parse("test confirm template")
+        template.Must(tmp.New("account_exists.html").Parse("test account exists template"))
+        template.Must(tmp.New("no_account.html").Parse("test no account template"))
+        template.Must(tmp.New("recovery_codes_generated.html").Parse("test recovery codes generated template"))
+        template.Must(tmp.New("recovery_code_used.html").Parse("test recovery code used template"))
         return template.Must(tmp.New("reset_password.html").Parse("test reset template"))
 }
 
+//recordingMail keeps content of last sent email
+type recordingMail struct {
+        content *string
+}
+
+func (r recordingMail) SendEmail(mail string, content string, subject string) error {
+        *r.content = content
+        return nil
+}
+
+func (r recordingMail) Templates() *template.Template {
+        return TestMail{}.Templates()
+}
+
 func TestService(t *testing.T) {
 
         testEmail := "test@test.com"
@@ -41,10 +62,12 @@ func TestService(t *testing.T) {
 
         Convey("CreateAccount should", t, func() {
 
-                testSalt := "testSalt"
                 hashedPass := Password("hashedPassword")
 
                 accountsRepo := Dal{
+                        GetByEmail: func(email string) (PasswordlessAccount, error) {
+                                return PasswordlessAccount{}, ErrAccountNotFound
+                        },
                         CreateAccount: func(secAccount SecuredAccount) (string, error) {
 
                                 So(secAccount.Email, should.Equal, validSecuredAcc.Email)
@@ -52,7 +75,8 @@ func TestService(t *testing.T) {
                                 So(secAccount.LastName, should.Equal, validSecuredAcc.LastName)
                                 So(secAccount.Status, should.Equal, Pending)
                                 So(secAccount.Password, should.Equal, hashedPass)
-                                So(secAccount.Salt, should.Equal, testSalt)
+                                So(secAccount.Salt, should.BeBlank)
+                                So(secAccount.Identities, should.BeEmpty)
                                 return uuid.NewV4().String(), nil
                         },
                 }
@@ -70,17 +94,77 @@ func TestService(t *testing.T) {
 
                 emailService := TestMail{testEmail}
                 encrypt := Encrypt{
-                        Hash: func(pass Password) (Password, string) {
-                                return hashedPass, testSalt
+                        Hash: func(ctx context.Context, pass Password) (Password, error) {
+                                return hashedPass, nil
                         },
                 }
                 service := CreateService(config.Config{}, accountsRepo, signupsRepo, emailService, encrypt)
 
                 Convey("Create valid account", func() {
 
-                        _, err := service.StartSignupAccount(testEmail, validSecuredAcc)
+                        _, err := service.StartSignupAccount(context.Background(), testEmail, validSecuredAcc)
+
+                        So(err, should.BeNil)
+                })
+
+                Convey("Not link identities sent with signup", func() {
+
+                        withIdentity := validSecuredAcc
+                        withIdentity.Identities = []Identity{{Provider: "google", Subject: "victim-subject"}}
+                        _, err := service.StartSignupAccount(context.Background(), testEmail, withIdentity)
+
+                        So(err, should.BeNil)
+                })
+
+                Convey("Notify owner of registered email instead of failing", func() {
+
+                        hashed := false
+                        sentTemplate := ""
+
+                        existingRepo := Dal{
+                                GetByEmail: func(email string) (PasswordlessAccount, error) {
+                                        return PasswordlessAccount{Email: email, Status: Confirmed}, nil
+                                },
+                        }
+
+                        hashingEncrypt := Encrypt{
+                                Hash: func(ctx context.Context, pass Password) (Password, error) {
+                                        hashed = true
+                                        return hashedPass, nil
+                                },
+                        }
+
+                        service := CreateService(config.Config{}, existingRepo, signupsRepo, recordingMail{&sentTemplate}, hashingEncrypt)
+                        id, err := service.StartSignupAccount(context.Background(), testEmail, validSecuredAcc)
 
                         So(err, should.BeNil)
+                        So(id, should.BeBlank)
+                        So(hashed, should.BeTrue)
+                        So(sentTemplate, should.Equal, "test account exists template")
+                })
+
+                Convey("Send confirmation again for not confirmed account", func() {
+
+                        sentTemplate := ""
+
+                        pendingRepo := Dal{
+                                GetByEmail: func(email string) (PasswordlessAccount, error) {
+                                        return PasswordlessAccount{Email: email, Status: Pending}, nil
+                                },
+                        }
+
+                        pendingSignups := dal.Dal{
+                                GetById: func(id string, data interface{}) error {
+                                        *data.(*Signup) = Signup{Email: id, Code: "signupCode"}
+                                        return nil
+                                },
+                        }
+
+                        service := CreateService(config.Config{}, pendingRepo, pendingSignups, recordingMail{&sentTemplate}, encrypt)
+                        _, err := service.StartSignupAccount(context.Background(), testEmail, validSecuredAcc)
+
+                        So(err, should.BeNil)
+                        So(sentTemplate, should.Equal, "test confirm template")
                 })
         })
 
@@ -188,18 +272,67 @@ func TestService(t *testing.T) {
                 })
         })
 
+        Convey("ConfirmVerifiedEmail should", t, func() {
+
+                stored := SecuredAccount{
+                        Account: Account{
+                                PasswordlessAccount: PasswordlessAccount{Email: testEmail, Status: Pending},
+                                Password: "hashOfSomeoneElse",
+                        },
+                        ResetPasswordCode: "resetCode",
+                        RecoveryCodes: []RecoveryCode{{Hash: "codeHash"}},
+                        PreviousPasswords: []PreviousPassword{{Hash: "hashOfSomeoneElse"}},
+                }
+                deletedSignup := ""
+
+                accountDal := Dal{
+                        UpdateByEmail: func(email string, handleUpdateFunc func(*SecuredAccount) error) (error) {
+                                So(email, should.Equal, testEmail)
+                                return handleUpdateFunc(&stored)
+                        },
+                }
+
+                signupsRepo := dal.Dal{
+                        DeleteById: func(id string) error {
+                                deletedSignup = id
+                                return nil
+                        },
+                }
+
+                service := CreateService(config.Config{}, accountDal, signupsRepo, TestMail{}, Encrypt{})
+
+                Convey("drop password and codes of pending account", func() {
+
+                        So(service.ConfirmVerifiedEmail(testEmail), should.BeNil)
+                        So(stored.Status, should.Equal, Confirmed)
+                        So(string(stored.Password), should.BeBlank)
+                        So(stored.ResetPasswordCode, should.BeBlank)
+                        So(stored.RecoveryCodes, should.BeEmpty)
+                        So(stored.PreviousPasswords, should.BeEmpty)
+                        So(deletedSignup, should.Equal, testEmail)
+                })
+
+                Convey("keep password of confirmed account", func() {
+
+                        stored.Status = Confirmed
+
+                        So(service.ConfirmVerifiedEmail(testEmail), should.BeNil)
+                        So(stored.Password, should.Equal, Password("hashOfSomeoneElse"))
+                })
+        })
+
         Convey("ConfirmResetPassword should", t, func() {
 
                 confirmCode := "testCode"
                 newPass := Password("newPassword123!")
                 hashedPass := Password("testHashed")
-                testSalt := "testSalt"
+                legacySalt := "legacySalt"
 
                 signupsRepo := dal.Dal{}
                 emailService := TestMail{}
                 encrypt := Encrypt{
-                        Hash: func(pass Password) (Password, string) {
-                                return hashedPass, testSalt
+                        Hash: func(ctx context.Context, pass Password) (Password, error) {
+                                return hashedPass, nil
                         },
                 }
 
@@ -209,12 +342,12 @@ func TestService(t *testing.T) {
                                 UpdateByEmail: func(email string, handleUpdateFunc func(*SecuredAccount) error) (error) {
                                         So(email, should.Equal, testEmail)
 
-                                        secAcc := SecuredAccount{}
+                                        secAcc := SecuredAccount{Salt: legacySalt}
                                         secAcc.ResetPasswordCode = confirmCode
                                         err := handleUpdateFunc(&secAcc)
                                         So(err, ShouldBeNil)
                                         So(secAcc.Password, should.Equal, hashedPass)
-                                        So(secAcc.Salt, should.Equal, testSalt)
+                                        So(secAcc.Salt, should.BeBlank)
                                         So(secAcc.ResetPasswordCode, should.BeBlank)
                                         return nil
                                 },
@@ -222,7 +355,7 @@ func TestService(t *testing.T) {
 
                         service := CreateService(config.Config{}, accountDal, signupsRepo, emailService, encrypt)
 
-                        err := service.ConfirmResetPassword(testEmail, confirmCode, newPass)
+                        err := service.ConfirmResetPassword(context.Background(), testEmail, confirmCode, newPass)
 
                         So(err, should.BeNil)
                 })
@@ -240,12 +373,103 @@ func TestService(t *testing.T) {
 
                         service := CreateService(config.Config{}, accountDal, signupsRepo, emailService, encrypt)
 
-                        err := service.ConfirmResetPassword(testEmail, "InvalidCode", newPass)
+                        err := service.ConfirmResetPassword(context.Background(), testEmail, "InvalidCode", newPass)
                         So(err, should.Equal, ErrInvalidResetCode)
                 })
 
         })
 
+        Convey("Recovery codes should", t, func() {
+
+                stored := SecuredAccount{Account: Account{PasswordlessAccount: PasswordlessAccount{Email: testEmail, Username: "testUser"}}}
+                sentTemplate := ""
+                encrypt := Encrypt{
+                        Hash: func(ctx context.Context, pass Password) (Password, error) {
+                                return "hashed" + pass, nil
+                        },
+                }
+
+                accountDal := Dal{
+                        updateByUsername: func(username string, handleUpdateFunc func(*SecuredAccount) error) (error) {
+                                return handleUpdateFunc(&stored)
+                        },
+                        updateWithRecoveryCode: func(email string, codeHash string, handleUpdateFunc func(*SecuredAccount) error) (error) {
+                                for _, code := range stored.RecoveryCodes {
+                                        if code.Hash == codeHash && code.UsedAt == nil {
+                                                return handleUpdateFunc(&stored)
+                                        }
+                                }
+                                return ErrInvalidRecoveryCode
+                        },
+                }
+
+                service := CreateService(config.Config{}, accountDal, dal.Dal{}, recordingMail{&sentTemplate}, encrypt)
+
+                codes, err := service.GenerateRecoveryCodes("testUser")
+                So(err, should.BeNil)
+
+                Convey("be stored as hashes and announced by email", func() {
+
+                        So(codes, should.HaveLength, RecoveryCodesCount)
+                        So(stored.RecoveryCodes, should.HaveLength, RecoveryCodesCount)
+                        So(stored.RecoveryCodes[0].Hash, should.Equal, hashRecoveryCode(codes[0]))
+                        So(stored.RecoveryCodes[0].Hash, should.NotContainSubstring, normalizeRecoveryCode(codes[0]))
+                        So(sentTemplate, should.Equal, "test recovery codes generated template")
+                })
+
+                Convey("set new password only once for the same code", func() {
+
+                        _, err := service.RecoverAccount(context.Background(), testEmail, strings.ToUpper(codes[0]), "newPassword123!")
+                        So(err, should.BeNil)
+                        So(stored.Password, should.Equal, Password("hashednewPassword123!"))
+                        So(stored.remainingRecoveryCodes(), should.Equal, RecoveryCodesCount-1)
+                        So(sentTemplate, should.Equal, "test recovery code used template")
+
+                        _, err = service.RecoverAccount(context.Background(), testEmail, codes[0], "otherPassword123!")
+                        So(err, should.Equal, ErrInvalidRecoveryCode)
+                        So(stored.Password, should.Equal, Password("hashednewPassword123!"))
+                })
+        })
+
+        Convey("Delete with cleanups should", t, func() {
+
+                deleted := ""
+                cleaned := []string{}
+                service := Service{
+                        GetByUsername: func(username string) (PasswordlessAccount, error) {
+                                return PasswordlessAccount{Id: "accId", Username: username}, nil
+                        },
+                        Delete: func(username string) error {
+                                deleted = username
+                                return nil
+                        },
+                }
+
+                cleanup := func(name string, err error) Cleanup {
+                        return func(account PasswordlessAccount) error {
+                                cleaned = append(cleaned, name+":"+account.Id)
+                                return err
+                        }
+                }
+
+                Convey("clean up account before it is deleted", func() {
+
+                        err := service.WithCleanups(cleanup("sessions", nil), cleanup("apiKeys", nil)).Delete("testUser")
+                        So(err, should.BeNil)
+                        So(cleaned, should.Resemble, []string{"sessions:accId", "apiKeys:accId"})
+                        So(deleted, should.Equal, "testUser")
+                })
+
+                Convey("keep account when cleanup fails", func() {
+
+                        failure := errors.New("connection lost")
+                        err := service.WithCleanups(cleanup("sessions", failure), cleanup("apiKeys", nil)).Delete("testUser")
+                        So(err, should.Equal, failure)
+                        So(cleaned, should.Resemble, []string{"sessions:accId"})
+                        So(deleted, should.BeBlank)
+                })
+        })
+
         Convey("StartResetPassword should", t, func() {
 
                 accountsDal := Dal{
@@ -274,17 +498,31 @@ func TestService(t *testing.T) {
                         So(err, should.BeNil)
                 })
 
-                Convey("should return not found error for not existing account", func() {
+                Convey("should email not existing account instead of returning error", func() {
                         accountsDal := Dal{
                                 UpdateByEmail: func(email string, handleUpdateFunc func(*SecuredAccount) error) (error) {
                                         return ErrAccountNotFound
                                 },
                         }
 
-                        service := CreateService(config.Config{}, accountsDal, signupsRepo, emailService, encrypt)
+                        service := CreateService(config.Config{}, accountsDal, signupsRepo, TestMail{"not@existing.com"}, encrypt)
 
                         err := service.StartResetPassword("not@existing.com")
 
+                        So(err, should.BeNil)
+                })
+
+                Convey("should return error when reset code cannot be set", func() {
+                        accountsDal := Dal{
+                                UpdateByEmail: func(email string, handleUpdateFunc func(*SecuredAccount) error) (error) {
+                                        return errors.New("connection lost")
+                                },
+                        }
+
+                        service := CreateService(config.Config{}, accountsDal, signupsRepo, emailService, encrypt)
+
+                        err := service.StartResetPassword(testEmail)
+
                         So(err, should.Equal, ErrUnableToSetResetCode)
                 })
         })
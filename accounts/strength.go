@@ -0,0 +1,234 @@
+package accounts
+
+import (
+	"strings"
+	"unicode"
+)
+
+//strength is estimated like in zxcvbn, password is split into patterns (common words, repeats, sequences,
+//keyboard rows, years) and characters guessed one by one, split which needs fewest guesses is used
+const (
+	bruteforceCardinality = 10
+	minPatternLength      = 3
+)
+
+//strengthThresholds are numbers of guesses above which password gets next score, from 0 to 4
+var strengthThresholds = []float64{1e3, 1e6, 1e8, 1e10}
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "qazwsxedc", "1qaz2wsx3edc"}
+
+//leetSubstitutions are undone before passwords are compared with dictionary
+var leetSubstitutions = map[rune]rune{'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i'}
+
+//commonPasswords are ordered by popularity, position is number of guesses needed
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+	"123123", "baseball", "abc123", "football", "monkey", "letmein", "696969", "shadow", "master", "666666",
+	"qwertyuiop", "123321", "mustang", "1234567890", "michael", "654321", "superman", "1qaz2wsx", "7777777", "121212",
+	"000000", "qazwsx", "123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter",
+	"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "charlie", "robert",
+	"thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george", "computer", "michelle",
+	"jessica", "pepper", "zxcvbn", "555555", "11111111", "131313", "freedom", "777777", "pass", "maggie",
+	"159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda", "summer", "love", "ashley",
+	"nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321", "dallas", "austin", "thunder",
+	"taylor", "matrix", "admin", "welcome", "login", "secret", "hello", "winter", "spring", "autumn",
+	"qwerty123", "password1", "football1", "monkey1", "changeme", "default", "letmein1", "root", "test", "guest",
+}
+
+var commonPasswordRanks = rankPasswords()
+
+func rankPasswords() map[string]float64 {
+
+	ranks := map[string]float64{}
+	for i, pass := range commonPasswords {
+		ranks[pass] = float64(i + 1)
+	}
+
+	return ranks
+}
+
+//estimateStrength returns score from 0 (too guessable) to 4 (very unguessable)
+func estimateStrength(pass string, userInputs []string) int {
+
+	guesses := estimateGuesses([]rune(pass), lowerInputs(userInputs))
+
+	score := 0
+	for _, threshold := range strengthThresholds {
+		if guesses >= threshold {
+			score++
+		}
+	}
+
+	return score
+}
+
+//estimateGuesses finds split of password into patterns and single characters which needs fewest guesses
+func estimateGuesses(chars []rune, inputs map[string]bool) float64 {
+
+	best := make([]float64, len(chars)+1)
+	best[0] = 1
+
+	for end := 1; end <= len(chars); end++ {
+		best[end] = best[end-1] * bruteforceCardinality
+
+		for start := 0; start <= end-minPatternLength; start++ {
+			if guesses := patternGuesses(chars[start:end], inputs); guesses > 0 && best[start]*guesses < best[end] {
+				best[end] = best[start] * guesses
+			}
+		}
+	}
+
+	return best[len(chars)]
+}
+
+//patternGuesses returns guesses needed for segment matching any pattern, 0 means no pattern matches
+func patternGuesses(segment []rune, inputs map[string]bool) float64 {
+
+	length := float64(len(segment))
+	candidates := []float64{}
+
+	lower := strings.ToLower(string(segment))
+	unleeted := unleet(lower)
+
+	for _, word := range []string{lower, unleeted} {
+		variations := uppercaseVariations(segment)
+		if word != lower {
+			variations *= 2
+		}
+
+		if inputs[word] {
+			candidates = append(candidates, variations)
+		}
+
+		if rank, ok := commonPasswordRanks[word]; ok {
+			candidates = append(candidates, rank*variations)
+		}
+	}
+
+	if isRepeat(segment) {
+		candidates = append(candidates, bruteforceCardinality*length)
+	}
+
+	if step := sequenceStep(segment); step != 0 {
+		guesses := 26 * length
+		if strings.ContainsRune("aAzZ019", segment[0]) {
+			guesses = 4 * length
+		}
+		if step < 0 {
+			guesses *= 2
+		}
+		candidates = append(candidates, guesses)
+	}
+
+	if isKeyboardRow(lower) {
+		candidates = append(candidates, 40*length)
+	}
+
+	if isRecentYear(lower) {
+		candidates = append(candidates, 120)
+	}
+
+	guesses := 0.0
+	for _, candidate := range candidates {
+		if guesses == 0 || candidate < guesses {
+			guesses = candidate
+		}
+	}
+
+	return guesses
+}
+
+func lowerInputs(userInputs []string) map[string]bool {
+
+	inputs := map[string]bool{}
+	for _, input := range userInputs {
+		if len(input) >= minPatternLength {
+			inputs[strings.ToLower(input)] = true
+		}
+	}
+
+	return inputs
+}
+
+func unleet(word string) string {
+
+	return strings.Map(func(char rune) rune {
+		if letter, ok := leetSubstitutions[char]; ok {
+			return letter
+		}
+		return char
+	}, word)
+}
+
+//uppercaseVariations is 1 for lowercase words and 2 for capitalised or uppercase ones, other mixes are rare
+func uppercaseVariations(segment []rune) float64 {
+
+	upper := 0
+	for _, char := range segment {
+		if unicode.IsUpper(char) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(segment) || (upper == 1 && unicode.IsUpper(segment[0])):
+		return 2
+	}
+
+	return float64(len(segment))
+}
+
+func isRepeat(segment []rune) bool {
+
+	for _, char := range segment {
+		if char != segment[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//sequenceStep is 1 for ascending and -1 for descending sequences like abc or 987, 0 otherwise
+func sequenceStep(segment []rune) int {
+
+	step := int(segment[1] - segment[0])
+	if step != 1 && step != -1 {
+		return 0
+	}
+
+	for i := 2; i < len(segment); i++ {
+		if int(segment[i]-segment[i-1]) != step {
+			return 0
+		}
+	}
+
+	return step
+}
+
+func isKeyboardRow(word string) bool {
+
+	for _, row := range keyboardRows {
+		if strings.Contains(row, word) || strings.Contains(reverse(row), word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isRecentYear(word string) bool {
+	return len(word) == 4 && (strings.HasPrefix(word, "19") || strings.HasPrefix(word, "20")) && strings.Trim(word, "0123456789") == ""
+}
+
+func reverse(word string) string {
+
+	chars := []rune(word)
+	for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+
+	return string(chars)
+}
@@ -0,0 +1,88 @@
+package apiKeys
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for managing api keys of account
+type Controller struct {
+	CreateApiKey func(c echo.Context) error
+	GetApiKeys   func(c echo.Context) error
+	DeleteApiKey func(c echo.Context) error
+}
+
+//Create api keys controller
+func Create(service Service) Controller {
+
+	createApiKey := func(c echo.Context) error {
+
+		//otherwise leaked key could be used to create keys which never expire
+		if _, isApiKey := security.GetApiKey(c); isApiKey {
+			return web.ForbiddenResponse(c, "Api keys cannot be created with api key")
+		}
+
+		dto := CreateApiKeyDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Invalid payload")
+		}
+
+		if validationErrors := dto.validate(time.Now()); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid api key", validationErrors)
+		}
+
+		key, err := service.Create(c.Param("id"), dto)
+		if err == ErrScopeNotAllowed {
+			return web.BadRequestResponse(c, err.Error())
+		}
+
+		if err == ErrTooManyApiKeys {
+			return web.ConflictResponse(c, err.Error())
+		}
+
+		if err == accounts.ErrAccountNotFound {
+			return web.NotFoundResponse(c)
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not create api key", err)
+		}
+
+		return web.CreatedResponse(c, key)
+	}
+
+	getApiKeys := func(c echo.Context) error {
+
+		keys, err := service.GetByUsername(c.Param("id"))
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not fetch api keys", err)
+		}
+
+		return c.JSON(http.StatusOK, keys)
+	}
+
+	deleteApiKey := func(c echo.Context) error {
+
+		err := service.Delete(c.Param("id"), c.Param("keyId"))
+		if err == ErrApiKeyNotFound {
+			return web.NotFoundResponse(c)
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not delete api key", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return Controller{
+		CreateApiKey: createApiKey,
+		GetApiKeys:   getApiKeys,
+		DeleteApiKey: deleteApiKey,
+	}
+}
@@ -0,0 +1,87 @@
+package apiKeys
+
+import (
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/dal"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+const maxKeysPerAccount = 100
+
+//Dal for api keys collection
+type Dal struct {
+	GetById        func(id string) (ApiKey, error)
+	GetByHash      func(hash string) (ApiKey, error)
+	GetByUsername  func(username string) ([]ApiKey, error)
+	Save           func(key ApiKey) error
+	UpdateLastUsed func(id string, lastUsedAt time.Time) error
+	DeleteById     func(id string) error
+}
+
+//CreateDal wraps generic dal with api key specific operations
+func CreateDal(keysRepo dal.Dal) Dal {
+
+	getById := func(id string) (ApiKey, error) {
+
+		key := ApiKey{}
+		if err := keysRepo.GetById(id, &key); err != nil {
+			return key, err
+		}
+
+		if len(key.Id) == 0 {
+			return key, ErrApiKeyNotFound
+		}
+
+		return key, nil
+	}
+
+	getByQuery := func(query dal.Query) ([]ApiKey, error) {
+
+		keys := make([]ApiKey, 0)
+		pagination := web.Pagination{PageNumber: 1, PageSize: maxKeysPerAccount}
+
+		if err := keysRepo.GetByQuery(&keys, pagination, query); err != nil {
+			return []ApiKey{}, err
+		}
+
+		return keys, nil
+	}
+
+	getByHash := func(hash string) (ApiKey, error) {
+
+		keys, err := getByQuery(dal.NewQueryBuilder().WithField("hash", hash).Build())
+		if err != nil {
+			return ApiKey{}, err
+		}
+
+		if len(keys) == 0 {
+			return ApiKey{}, ErrApiKeyNotFound
+		}
+
+		return keys[0], nil
+	}
+
+	getByUsername := func(username string) ([]ApiKey, error) {
+		return getByQuery(dal.NewQueryBuilder().WithField("username", username).Build())
+	}
+
+	save := func(key ApiKey) error {
+		return keysRepo.Upsert(key.Id, key)
+	}
+
+	updateLastUsed := func(id string, lastUsedAt time.Time) error {
+		return keysRepo.UpdateByQuery(dal.NewQueryBuilder().WithId(id).Build(), map[string]interface{}{
+			"$set": map[string]interface{}{"lastUsedAt": lastUsedAt},
+		})
+	}
+
+	return Dal{
+		GetById:        getById,
+		GetByHash:      getByHash,
+		GetByUsername:  getByUsername,
+		Save:           save,
+		UpdateLastUsed: updateLastUsed,
+		DeleteById:     keysRepo.DeleteById,
+	}
+}
@@ -0,0 +1,55 @@
+package apiKeys
+
+import (
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//ApiKey is long lived credential of account, only hash of key is stored
+type ApiKey struct {
+	Id         string     `json:"id" bson:"_id"`
+	Name       string     `json:"name" bson:"name"`
+	AccountId  string     `json:"accountId" bson:"accountId"`
+	Username   string     `json:"username" bson:"username"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	//Hint is beginning of key, so user can tell keys apart
+	Hint string `json:"hint" bson:"hint"`
+	Hash string `json:"-" bson:"hash"`
+}
+
+//ApiKeyWithSecret is returned only once, when key is created
+type ApiKeyWithSecret struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+//CreateApiKeyDto is payload for api key creation
+type CreateApiKeyDto struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+//IsExpired checks if key can still be used at given time, keys without expiry never expire
+func (key ApiKey) IsExpired(now time.Time) bool {
+	return key.ExpiresAt != nil && now.After(*key.ExpiresAt)
+}
+
+func (dto CreateApiKeyDto) validate(now time.Time) []web.ErrorDetails {
+
+	var errors []web.ErrorDetails
+
+	if len(dto.Name) == 0 {
+		errors = web.AppendErrorDetails(errors, "name", "name is required", web.MissingField)
+	}
+
+	if dto.ExpiresAt != nil && !dto.ExpiresAt.After(now) {
+		errors = web.AppendErrorDetails(errors, "expiresAt", "expiresAt must be in the future", web.InvalidField)
+	}
+
+	return errors
+}
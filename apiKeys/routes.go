@@ -0,0 +1,19 @@
+package apiKeys
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	securedByUsername := security.SecuredById("username", "username", false)
+	echoEngine.OPTIONS("/accounts/:id/api-keys", web.OptionsMethodHandler)
+	//key outlives token, so it can be created only right after login or reauthentication
+	echoEngine.POST("/accounts/:id/api-keys", controller.CreateApiKey, securedByUsername, security.RequireRecentAuth())
+	echoEngine.GET("/accounts/:id/api-keys", controller.GetApiKeys, securedByUsername)
+	echoEngine.OPTIONS("/accounts/:id/api-keys/:keyId", web.OptionsMethodHandler)
+	echoEngine.DELETE("/accounts/:id/api-keys/:keyId", controller.DeleteApiKey, securedByUsername)
+}
@@ -0,0 +1,217 @@
+package apiKeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/satori/go.uuid"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrApiKeyNotFound  = errors.New("Api key does not exist")
+	ErrInvalidApiKey   = errors.New("Invalid api key")
+	ErrTooManyApiKeys  = errors.New("Account has too many api keys")
+	ErrScopeNotAllowed = errors.New("Scope is not granted to account")
+)
+
+const apiKeyBytes = 32
+
+//hintLength is number of characters of key, after prefix, which are stored in plain text
+const hintLength = 4
+
+//lastUsedPrecision limits writes done when key is used many times in short period
+const lastUsedPrecision = time.Minute
+
+//Service manages api keys, which can be used instead of jwt by scripts
+type Service struct {
+	Create        func(username string, dto CreateApiKeyDto) (*ApiKeyWithSecret, error)
+	GetByUsername func(username string) ([]ApiKey, error)
+	Delete        func(username string, id string) error
+	//DeleteAll removes every key of account, it is used when account is deleted
+	DeleteAll    func(username string) error
+	Authenticate func(key string) (ApiKey, error)
+	//Claims returns claims equivalent to jwt ones, empty for invalid key or account which is not confirmed
+	Claims func(key string) map[string]interface{}
+}
+
+//CreateService creates api keys service, keys can have only scopes granted to account
+func CreateService(keysDal Dal, accountsDal accounts.Dal) Service {
+
+	var log = logging.MustGetLogger("[ApiKeysService]")
+
+	create := func(username string, dto CreateApiKeyDto) (*ApiKeyWithSecret, error) {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+
+		granted := strings.Fields(account.Scope())
+		for _, scope := range dto.Scopes {
+			if !contains(granted, scope) {
+				return nil, ErrScopeNotAllowed
+			}
+		}
+
+		existing, err := keysDal.GetByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(existing) >= maxKeysPerAccount {
+			return nil, ErrTooManyApiKeys
+		}
+
+		secret, err := generateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		key := ApiKey{
+			Id:        uuid.NewV4().String(),
+			Name:      dto.Name,
+			AccountId: account.Id,
+			Username:  account.Username,
+			Scopes:    dto.Scopes,
+			CreatedAt: time.Now(),
+			ExpiresAt: dto.ExpiresAt,
+			Hint:      secret[:len(security.ApiKeyPrefix)+hintLength],
+			Hash:      hashKey(secret),
+		}
+
+		if err := keysDal.Save(key); err != nil {
+			return nil, err
+		}
+
+		return &ApiKeyWithSecret{ApiKey: key, Key: secret}, nil
+	}
+
+	deleteKey := func(username string, id string) error {
+
+		key, err := keysDal.GetById(id)
+		if err != nil {
+			return err
+		}
+
+		//keys of other accounts are reported as not existing
+		if key.Username != username {
+			return ErrApiKeyNotFound
+		}
+
+		return keysDal.DeleteById(id)
+	}
+
+	deleteAll := func(username string) error {
+
+		keys, err := keysDal.GetByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := keysDal.DeleteById(key.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	authenticate := func(secret string) (ApiKey, error) {
+
+		if !strings.HasPrefix(secret, security.ApiKeyPrefix) {
+			return ApiKey{}, ErrInvalidApiKey
+		}
+
+		key, err := keysDal.GetByHash(hashKey(secret))
+		if err != nil {
+			return ApiKey{}, ErrInvalidApiKey
+		}
+
+		now := time.Now()
+		if key.IsExpired(now) {
+			return ApiKey{}, ErrInvalidApiKey
+		}
+
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedPrecision {
+			if err := keysDal.UpdateLastUsed(key.Id, now); err != nil {
+				log.Error("Could not update last use of api key. Details: ", err)
+			}
+			key.LastUsedAt = &now
+		}
+
+		return key, nil
+	}
+
+	claims := func(secret string) map[string]interface{} {
+
+		key, err := authenticate(secret)
+		if err != nil {
+			return map[string]interface{}{}
+		}
+
+		//account can be locked or lose roles after key was created, so key keeps only scopes which account still has
+		account, err := accountsDal.GetById(key.AccountId)
+		if err != nil || account.Status != accounts.Confirmed {
+			return map[string]interface{}{}
+		}
+
+		granted := strings.Fields(account.Scope())
+		scopes := []string{}
+		for _, scope := range key.Scopes {
+			if contains(granted, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		return map[string]interface{}{
+			"sub":        account.Id,
+			"username":   account.Username,
+			"scope":      strings.Join(scopes, " "),
+			"api_key_id": key.Id,
+		}
+	}
+
+	return Service{
+		Create:        create,
+		GetByUsername: keysDal.GetByUsername,
+		Delete:        deleteKey,
+		DeleteAll:     deleteAll,
+		Authenticate:  authenticate,
+		Claims:        claims,
+	}
+}
+
+func generateKey() (string, error) {
+
+	keyBytes := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", err
+	}
+
+	return security.ApiKeyPrefix + base64.RawURLEncoding.EncodeToString(keyBytes), nil
+}
+
+//hashKey uses sha256, keys are random so slow hashing is not needed
+func hashKey(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
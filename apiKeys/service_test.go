@@ -0,0 +1,169 @@
+package apiKeys
+
+import (
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createInMemoryDal() (Dal, map[string]ApiKey) {
+
+	keys := map[string]ApiKey{}
+
+	return Dal{
+		GetById: func(id string) (ApiKey, error) {
+			key, ok := keys[id]
+			if !ok {
+				return key, ErrApiKeyNotFound
+			}
+			return key, nil
+		},
+		GetByHash: func(hash string) (ApiKey, error) {
+			for _, key := range keys {
+				if key.Hash == hash {
+					return key, nil
+				}
+			}
+			return ApiKey{}, ErrApiKeyNotFound
+		},
+		GetByUsername: func(username string) ([]ApiKey, error) {
+			found := []ApiKey{}
+			for _, key := range keys {
+				if key.Username == username {
+					found = append(found, key)
+				}
+			}
+			return found, nil
+		},
+		Save: func(key ApiKey) error {
+			keys[key.Id] = key
+			return nil
+		},
+		UpdateLastUsed: func(id string, lastUsedAt time.Time) error {
+			key := keys[id]
+			key.LastUsedAt = &lastUsedAt
+			keys[id] = key
+			return nil
+		},
+		DeleteById: func(id string) error {
+			delete(keys, id)
+			return nil
+		},
+	}, keys
+}
+
+func TestService(t *testing.T) {
+
+	Convey("Api keys service should", t, func() {
+
+		stored := map[string]accounts.PasswordlessAccount{
+			"john": {Id: "johnId", Username: "john", Status: accounts.Confirmed},
+			"root": {Id: "rootId", Username: "root", Status: accounts.Confirmed, Roles: []string{accounts.RoleUser, accounts.RoleAdmin}},
+		}
+
+		accountsDal := accounts.Dal{
+			GetByUsername: func(username string) (accounts.PasswordlessAccount, error) {
+				if account, ok := stored[username]; ok {
+					return account, nil
+				}
+				return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+			},
+			GetById: func(id string) (accounts.PasswordlessAccount, error) {
+				for _, account := range stored {
+					if account.Id == id {
+						return account, nil
+					}
+				}
+				return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+			},
+		}
+
+		keysDal, keys := createInMemoryDal()
+		service := CreateService(keysDal, accountsDal)
+
+		Convey("create prefixed key which is stored hashed", func() {
+
+			key, err := service.Create("john", CreateApiKeyDto{Name: "deploy", Scopes: []string{"profile"}})
+
+			So(err, ShouldBeNil)
+			So(key.Key, ShouldStartWith, security.ApiKeyPrefix)
+			So(strings.HasPrefix(key.Key, key.Hint), ShouldBeTrue)
+			So(keys[key.Id].Hash, ShouldEqual, hashKey(key.Key))
+			So(keys[key.Id].Hash, ShouldNotContainSubstring, key.Key)
+		})
+
+		Convey("not allow scopes which account does not have", func() {
+
+			_, err := service.Create("john", CreateApiKeyDto{Name: "deploy", Scopes: []string{accounts.ScopeAccountsRead}})
+			So(err, ShouldEqual, ErrScopeNotAllowed)
+
+			_, err = service.Create("root", CreateApiKeyDto{Name: "deploy", Scopes: []string{accounts.ScopeAccountsRead}})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("authenticate key and track its last use", func() {
+
+			key, _ := service.Create("john", CreateApiKeyDto{Name: "deploy", Scopes: []string{"profile", "email"}})
+
+			claims := service.Claims(key.Key)
+			So(claims["username"], ShouldEqual, "john")
+			So(claims["sub"], ShouldEqual, "johnId")
+			So(claims["scope"], ShouldEqual, "profile email")
+			So(keys[key.Id].LastUsedAt, ShouldNotBeNil)
+		})
+
+		Convey("keep only scopes which account still has", func() {
+
+			key, _ := service.Create("root", CreateApiKeyDto{Name: "reports", Scopes: []string{"profile", accounts.ScopeAccountsRead}})
+			So(service.Claims(key.Key)["scope"], ShouldEqual, "profile "+accounts.ScopeAccountsRead)
+
+			root := stored["root"]
+			root.Roles = []string{accounts.RoleUser}
+			stored["root"] = root
+
+			So(service.Claims(key.Key)["scope"], ShouldEqual, "profile")
+		})
+
+		Convey("reject keys of accounts which are not confirmed", func() {
+
+			key, _ := service.Create("john", CreateApiKeyDto{Name: "deploy", Scopes: []string{"profile"}})
+
+			john := stored["john"]
+			john.Status = accounts.Pending
+			stored["john"] = john
+
+			So(service.Claims(key.Key), ShouldBeEmpty)
+		})
+
+		Convey("reject expired and unknown keys", func() {
+
+			expiresAt := time.Now().Add(time.Hour)
+			key, _ := service.Create("john", CreateApiKeyDto{Name: "deploy", ExpiresAt: &expiresAt})
+
+			_, err := service.Authenticate(key.Key)
+			So(err, ShouldBeNil)
+
+			expired := keys[key.Id]
+			past := time.Now().Add(-time.Minute)
+			expired.ExpiresAt = &past
+			keys[key.Id] = expired
+
+			_, err = service.Authenticate(key.Key)
+			So(err, ShouldEqual, ErrInvalidApiKey)
+
+			So(service.Claims(security.ApiKeyPrefix+"unknown"), ShouldBeEmpty)
+		})
+
+		Convey("delete only keys of given account", func() {
+
+			key, _ := service.Create("john", CreateApiKeyDto{Name: "deploy"})
+
+			So(service.Delete("root", key.Id), ShouldEqual, ErrApiKeyNotFound)
+			So(service.Delete("john", key.Id), ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+	})
+}
@@ -8,18 +8,131 @@ import (
 
 //Config contains configuration data for modules in this project
 type Config struct {
+	Host string `json:"host"`
 	Mongo struct {
 		Server string `json:"server"`
 		Database string `json:"database"`
 	} `json:"mongo"`
 	FrontendURL string `json:"frontendUrl"`
+	//Fb is facebook app of social login, kept for older configs
 	Fb struct {
 		ClientID string `json:"clientId"`
 		ClientSecret string `json:"clientSecret"` 
 	} `josn:"fb"`
 	Token struct{
 		SiginKey string `json:"siginKey"`
+		Issuer   string `json:"issuer"`
+		Audience string `json:"audience"`
+		//ResourceAudiences are internal services for which service clients can exchange tokens of users
+		ResourceAudiences []string `json:"resourceAudiences"`
+		//lifetimes and leeway are in seconds, defaults are used when not set
+		AccessTokenLifetime  int `json:"accessTokenLifetime"`
+		RefreshTokenLifetime int `json:"refreshTokenLifetime"`
+		Leeway               int `json:"leeway"`
+		//RefreshTokenMaxLifetime is how long tokens of one login can be refreshed, it is not extended by rotation
+		RefreshTokenMaxLifetime int `json:"refreshTokenMaxLifetime"`
+		//MaxAuthAge is how long, in seconds, after login sensitive operations are allowed without reauthentication
+		MaxAuthAge int `json:"maxAuthAge"`
+		//Format of access tokens: jwt (default), paseto.local, paseto.public or jwe
+		Format string `json:"format"`
+		//FormatKey is base64 encoded 32 byte key of paseto.local and jwe, FormatKeyFile is PEM with ed25519 private key of paseto.public
+		FormatKey     string `json:"formatKey"`
+		FormatKeyFile string `json:"formatKeyFile"`
+		//Keys are listed oldest first, newest key with private key file signs tokens
+		Keys []struct {
+			Kid            string `json:"kid"`
+			Alg            string `json:"alg"`
+			PrivateKeyFile string `json:"privateKeyFile"`
+			PublicKeyFile  string `json:"publicKeyFile"`
+		} `json:"keys"`
 	} `json:"tokens"`
+	OAuth struct {
+		//Clients are registered on startup, clients without grant types can use only authorization code grant
+		Clients []struct {
+			ClientID     string   `json:"clientId"`
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirectUris"`
+			GrantTypes   []string `json:"grantTypes"`
+		} `json:"clients"`
+	} `json:"oauth"`
+	//Lockout limits failed logins, durations are in seconds, defaults are used when not set
+	Lockout struct {
+		FreeAttempts    int `json:"freeAttempts"`
+		MaxAttempts     int `json:"maxAttempts"`
+		IpMaxAttempts   int `json:"ipMaxAttempts"`
+		LockoutDuration int `json:"lockoutDuration"`
+		Window          int `json:"window"`
+	} `json:"lockout"`
+	//Passwords are hashed with argon2id (default), bcrypt or scrypt, costs use defaults when not set
+	Passwords struct {
+		Algorithm string `json:"algorithm"`
+		//Argon2Memory is in KiB, ScryptCost is log2 of N
+		Argon2Memory      int `json:"argon2Memory"`
+		Argon2Iterations  int `json:"argon2Iterations"`
+		Argon2Parallelism int `json:"argon2Parallelism"`
+		BcryptCost        int `json:"bcryptCost"`
+		ScryptCost        int `json:"scryptCost"`
+		//Pepper is base64 encoded secret, PASSWORD_PEPPER environment variable takes precedence
+		Pepper string `json:"pepper"`
+		//Workers hash at the same time (default number of cpus), QueueDepth hashes can wait for them,
+		//next ones are rejected with 503 and Retry-After of RetryAfter seconds, as are those waiting longer than WaitTimeoutMs
+		Workers       int `json:"workers"`
+		QueueDepth    int `json:"queueDepth"`
+		WaitTimeoutMs int `json:"waitTimeoutMs"`
+		RetryAfter    int `json:"retryAfter"`
+	} `json:"passwords"`
+	//PasswordPolicy rules which are not set are not checked, lengths default to 8 and 128
+	PasswordPolicy struct {
+		MinLength int `json:"minLength"`
+		MaxLength int `json:"maxLength"`
+		//RequiredClasses are lower, upper, digit or symbol
+		RequiredClasses []string `json:"requiredClasses"`
+		//MinStrength is score from 0 to 4
+		MinStrength int `json:"minStrength"`
+		//BreachedDir has range files of breached SHA-1 hashes, named by first 5 hex characters of hash
+		BreachedDir string `json:"breachedDir"`
+		History     int    `json:"history"`
+		MaxAgeDays  int    `json:"maxAgeDays"`
+	} `json:"passwordPolicy"`
+	Mfa struct {
+		//Issuer is shown next to account name in authenticator apps
+		Issuer string `json:"issuer"`
+	} `json:"mfa"`
+	//SocialLogin providers google, github, apple, microsoft and facebook need only client id and secret,
+	//other providers need their endpoints, redirect uris default to any page of frontend
+	SocialLogin struct {
+		RedirectUris []string `json:"redirectUris"`
+		Providers    []struct {
+			Name                  string            `json:"name"`
+			ClientId              string            `json:"clientId"`
+			ClientSecret          string            `json:"clientSecret"`
+			AuthorizationEndpoint string            `json:"authorizationEndpoint"`
+			TokenEndpoint         string            `json:"tokenEndpoint"`
+			UserInfoEndpoint      string            `json:"userInfoEndpoint"`
+			JwksUri               string            `json:"jwksUri"`
+			Issuers               []string          `json:"issuers"`
+			Scopes                []string          `json:"scopes"`
+			AuthorizationParams   map[string]string `json:"authorizationParams"`
+			//Claims names claims of provider which hold profile fields, name is split when there are no first and last name
+			Claims struct {
+				Subject       string `json:"subject"`
+				Email         string `json:"email"`
+				EmailVerified string `json:"emailVerified"`
+				FirstName     string `json:"firstName"`
+				LastName      string `json:"lastName"`
+				Name          string `json:"name"`
+				Username      string `json:"username"`
+			} `json:"claims"`
+			//TrustEmail marks emails as verified when provider has no claim for that
+			TrustEmail bool `json:"trustEmail"`
+		} `json:"providers"`
+	} `json:"socialLogin"`
+	//Webauthn relying party, rp id and origins are taken from frontend url when not set
+	Webauthn struct {
+		RpId    string   `json:"rpId"`
+		RpName  string   `json:"rpName"`
+		Origins []string `json:"origins"`
+	} `json:"webauthn"`
 	Email struct {
 		AwsRegion string `json:"awsRegion"`
 		ReplyAddr string `json:"replyAddr"`
@@ -5,8 +5,12 @@ import (
 	"github.com/piotrjaromin/go-login-backend/web"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"time"
 )
 
+//ErrNotFound is returned by updates which did not match any document
+var ErrNotFound = mgo.ErrNotFound
+
 type DalConfig struct {
 	Server     string
 	Database   string
@@ -21,12 +25,15 @@ type Dal struct {
 	Upsert          func(id string, element interface{}) error
 	Update          func(id string, element interface{}) error
 	UpdateByQuery   func(query Query, element interface{}) error
+	UpdateAllByQuery func(query Query, element interface{}) error
+	UpsertByQuery   func(query Query, element interface{}) error
 	ClearAll        func() error
 	DeleteByQuery   func(query Query) error
 	DeleteById      func(id string) error
 	AddToArray      func(id string, field string, element interface{}) error
 	AddToSet        func(id string, field string, element interface{}) error
 	DeleteFromArray func(id string, query Query) error
+	EnsureTTLIndex  func(field string, expireAfter time.Duration) error
 }
 
 func Create(repoConfig DalConfig) Dal {
@@ -106,6 +113,18 @@ func Create(repoConfig DalConfig) Dal {
 		return c.Update(query.fields, element)
 	}
 
+	updateAllByQuery := func(query Query, element interface{}) error {
+
+		_, err := c.UpdateAll(query.fields, element)
+		return err
+	}
+
+	upsertByQuery := func(query Query, element interface{}) error {
+
+		_, err := c.Upsert(query.fields, element)
+		return err
+	}
+
 	clearAll := func() error {
 
 		return c.DropCollection()
@@ -140,6 +159,14 @@ func Create(repoConfig DalConfig) Dal {
 		return err
 	}
 
+	//documents are removed by mongo once field date is older than expireAfter
+	ensureTTLIndex := func(field string, expireAfter time.Duration) error {
+		return c.EnsureIndex(mgo.Index{
+			Key:         []string{field},
+			ExpireAfter: expireAfter,
+		})
+	}
+
 	return Dal{
 		Save:            save,
 		GetById:         getById,
@@ -150,9 +177,12 @@ func Create(repoConfig DalConfig) Dal {
 		DeleteByQuery:   deleteByQuery,
 		Update:          update,
 		UpdateByQuery:   updateByQuery,
+		UpdateAllByQuery: updateAllByQuery,
+		UpsertByQuery:   upsertByQuery,
 		DeleteById:      deleteById,
 		AddToArray:      addToArray,
 		DeleteFromArray: deleteFromArray,
 		AddToSet:	 addToSet,
+		EnsureTTLIndex:  ensureTTLIndex,
 	}
 }
@@ -21,7 +21,7 @@ type DefaultService struct {
 const encoding = "UTF-8"
 
 func (df DefaultService) Templates() *template.Template{
-        return template.Must(template.New("confirm_account.html").ParseFiles("email/templates/confirm_account.html", "email/templates/reset_password.html"))
+        return template.Must(template.New("confirm_account.html").ParseFiles("email/templates/confirm_account.html", "email/templates/reset_password.html", "email/templates/recovery_code_used.html", "email/templates/recovery_codes_generated.html", "email/templates/magic_link.html", "email/templates/email_code.html", "email/templates/account_exists.html", "email/templates/no_account.html"))
 }
 
 func (df DefaultService) SendEmail(email string, content string, subject string) error {
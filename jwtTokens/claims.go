@@ -0,0 +1,191 @@
+package jwtTokens
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+//Errors returned by claims validation
+var (
+	ErrTokenExpired     = errors.New("Token is expired")
+	ErrTokenNotValidYet = errors.New("Token is not valid yet")
+	ErrInvalidIssuer    = errors.New("Token has invalid issuer")
+	ErrInvalidAudience  = errors.New("Token has invalid audience")
+	ErrMissingClaim     = errors.New("Token is missing required claim")
+)
+
+//token_use claim tells what token was issued for, tokens issued for other uses, like id tokens, challenges or states,
+//are signed with the same keys, so they must not be accepted where access token is expected.
+//Tokens issued by oauth to clients have own use, they act only within granted scope and never as account owner
+const (
+	TokenUseClaim        = "token_use"
+	TokenUseAccess       = "access"
+	TokenUseClientAccess = "client_access"
+)
+
+const (
+	defaultAccessTokenLifetime = time.Minute * 15
+	defaultLeeway              = time.Second * 30
+)
+
+//TokenConfig controls registered claims of issued tokens and how strictly they are validated
+type TokenConfig struct {
+	Issuer              string
+	Audience            string
+	AccessTokenLifetime time.Duration
+	Leeway              time.Duration
+	//ResourceAudiences are services for which tokens are exchanged, tokens issued for them are valid too,
+	//so they can be introspected and revoked
+	ResourceAudiences []string
+}
+
+func (conf TokenConfig) withDefaults() TokenConfig {
+
+	if conf.AccessTokenLifetime <= 0 {
+		conf.AccessTokenLifetime = defaultAccessTokenLifetime
+	}
+
+	if conf.Leeway < 0 {
+		conf.Leeway = 0
+	} else if conf.Leeway == 0 {
+		conf.Leeway = defaultLeeway
+	}
+
+	return conf
+}
+
+//validateClaims checks exp, nbf, iat, iss and aud, time based claims are compared with leeway for clock skew
+func validateClaims(claims map[string]interface{}, conf TokenConfig, now time.Time) error {
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return ErrMissingClaim
+	}
+
+	if now.After(exp.Add(conf.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(conf.Leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+
+	if iat, ok := numericDate(claims["iat"]); ok && now.Add(conf.Leeway).Before(iat) {
+		return ErrTokenNotValidYet
+	}
+
+	if len(conf.Issuer) > 0 && claims["iss"] != conf.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if len(conf.Audience) > 0 && !HasAudience(claims, conf.Audience) && !HasAnyAudience(claims, conf.ResourceAudiences) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+//HasAudience checks aud claim which can be either single string or list of strings
+func HasAudience(claims map[string]interface{}, audience string) bool {
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	case []string:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//HasAnyAudience checks if aud claim contains any of given audiences
+func HasAnyAudience(claims map[string]interface{}, audiences []string) bool {
+
+	for _, audience := range audiences {
+		if HasAudience(claims, audience) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//IsAccessToken checks if token was issued as first party access token, with login of account owner
+func IsAccessToken(claims map[string]interface{}) bool {
+	_, hasClientId := claims["client_id"]
+	return claims[TokenUseClaim] == TokenUseAccess && !hasClientId
+}
+
+//IsClientAccessToken checks if token was issued by oauth to client
+func IsClientAccessToken(claims map[string]interface{}) bool {
+	return claims[TokenUseClaim] == TokenUseClientAccess
+}
+
+//HasRole checks if roles claim contains given role
+func HasRole(claims map[string]interface{}, role string) bool {
+
+	switch roles := claims["roles"].(type) {
+	case []interface{}:
+		for _, value := range roles {
+			if value == role {
+				return true
+			}
+		}
+	case []string:
+		for _, value := range roles {
+			if value == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//HasScope checks if space separated scope claim contains given scope
+func HasScope(claims map[string]interface{}, scope string) bool {
+
+	scopes, _ := claims["scope"].(string)
+	for _, value := range strings.Fields(scopes) {
+		if value == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+//AuthTime returns time of last authentication, tokens which are not result of user login do not have it
+func AuthTime(claims map[string]interface{}) (time.Time, bool) {
+	return numericDate(claims["auth_time"])
+}
+
+//ExpiresAt returns time from exp claim
+func ExpiresAt(claims map[string]interface{}) (time.Time, bool) {
+	return numericDate(claims["exp"])
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+
+	switch date := value.(type) {
+	case float64:
+		return time.Unix(int64(date), 0), true
+	case int64:
+		return time.Unix(date, 0), true
+	case int:
+		return time.Unix(int64(date), 0), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,25 @@
+package jwtTokens
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+//Controller exposing public keys used to verify tokens
+type Controller struct {
+	Jwks func(c echo.Context) error
+}
+
+//CreateController creates controller for key discovery endpoints
+func CreateController(tokenService TokenService) Controller {
+
+	jwks := func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+		return c.JSON(http.StatusOK, tokenService.Jwks())
+	}
+
+	return Controller{
+		Jwks: jwks,
+	}
+}
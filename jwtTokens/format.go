@@ -0,0 +1,59 @@
+package jwtTokens
+
+import (
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Names of supported token formats
+const (
+	FormatJwt          = "jwt"
+	FormatPasetoLocal  = "paseto.local"
+	FormatPasetoPublic = "paseto.public"
+	FormatJwe          = "jwe"
+)
+
+//ErrInvalidFormatKey is returned when key does not fit token format
+var ErrInvalidFormatKey = errors.New("Key is not valid for token format")
+
+//Format turns claims into token string and back, registered claims are filled and validated by TokenService,
+//so formats only protect integrity and, for encrypted ones, confidentiality of claims
+type Format struct {
+	Name   string
+	Encode func(claims map[string]interface{}) (string, error)
+	//Decode fails for tokens which were not issued with this format and key
+	Decode func(token string) (map[string]interface{}, error)
+}
+
+//CreateJwtFormat creates format of signed jwt, alg of token has to match alg of key picked by kid
+func CreateJwtFormat(keySet KeySet) Format {
+
+	//registered claims are validated by TokenService, jwt-go checks them without leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	encode := func(claims map[string]interface{}) (string, error) {
+		return keySet.Sign(jwt.MapClaims(claims))
+	}
+
+	decode := func(tokenString string) (map[string]interface{}, error) {
+
+		token, err := parser.Parse(tokenString, keySet.VerificationKey)
+		if err != nil {
+			return nil, err
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
+			return nil, ErrInvalidToken
+		}
+
+		return claims, nil
+	}
+
+	return Format{
+		Name:   FormatJwt,
+		Encode: encode,
+		Decode: decode,
+	}
+}
@@ -0,0 +1,161 @@
+package jwtTokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func TestFormats(t *testing.T) {
+
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	localKey := randomKey()
+	jweKey := randomKey()
+
+	pasetoLocal, _ := CreatePasetoLocalFormat(localKey)
+	pasetoPublic, _ := CreatePasetoPublicFormat(privateKey)
+	jwe, _ := CreateJweFormat(jweKey)
+
+	formats := []Format{CreateJwtFormat(CreateHMACKeySet("someTestKey")), pasetoLocal, pasetoPublic, jwe}
+
+	Convey("Every format should", t, func() {
+
+		for _, format := range formats {
+
+			service := CreateWithFormat(format, CreateHMACKeySet("someTestKey"), createInMemoryRevocationStore(), TokenConfig{Issuer: "http://issuer"})
+
+			token, err := service.GenerateToken(AccountClaims{Username: "testUser", AccountId: "testUserId", AuthTime: time.Now()})
+			So(err, ShouldBeNil)
+			So(service.Format, ShouldEqual, format.Name)
+
+			claims := service.GetClaims(token)
+			So(claims["sub"], ShouldEqual, "testUserId")
+			So(claims["iss"], ShouldEqual, "http://issuer")
+			So(claims["exp"], ShouldHaveSameTypeAs, float64(0))
+			So(service.Validate(token, "username", "testUser"), ShouldBeTrue)
+
+			So(service.Revoke(token), ShouldBeNil)
+			So(service.GetClaims(token), ShouldBeEmpty)
+
+			expired, _ := service.Sign(map[string]interface{}{"sub": "testUserId"}, -time.Hour)
+			So(service.GetClaims(expired), ShouldBeEmpty)
+		}
+	})
+
+	Convey("Formats should", t, func() {
+
+		claims := map[string]interface{}{"sub": "testUserId", "email": "test@test.com", "exp": time.Now().Add(time.Hour).Unix()}
+
+		Convey("not accept tokens of other formats", func() {
+
+			for _, issuer := range formats {
+				token, _ := issuer.Encode(claims)
+
+				for _, verifier := range formats {
+					if verifier.Name != issuer.Name {
+						_, err := verifier.Decode(token)
+						So(err, ShouldNotBeNil)
+					}
+				}
+			}
+		})
+
+		Convey("hide claims of encrypted tokens", func() {
+
+			for _, format := range []Format{pasetoLocal, jwe} {
+				token, _ := format.Encode(claims)
+				So(token, ShouldNotContainSubstring, base64.RawURLEncoding.EncodeToString([]byte("test@test.com")))
+
+				for _, part := range strings.Split(strings.TrimPrefix(token, pasetoLocalHeader), ".") {
+					decoded, _ := base64.RawURLEncoding.DecodeString(part)
+					So(string(decoded), ShouldNotContainSubstring, "test@test.com")
+				}
+			}
+		})
+
+		Convey("reject tokens created with other key", func() {
+
+			otherLocal, _ := CreatePasetoLocalFormat(randomKey())
+			otherPublic, _ := CreatePasetoPublicFormat(otherPrivateKey)
+			otherJwe, _ := CreateJweFormat(randomKey())
+
+			for i, other := range []Format{otherLocal, otherPublic, otherJwe} {
+				token, _ := other.Encode(claims)
+				_, err := formats[i+1].Decode(token)
+				So(err, ShouldEqual, ErrInvalidToken)
+			}
+		})
+
+		Convey("reject modified tokens", func() {
+
+			for _, format := range formats[1:] {
+				token, _ := format.Encode(claims)
+
+				tampered := []byte(token)
+				tampered[len(tampered)-10] ^= 'A' ^ 'B'
+				_, err := format.Decode(string(tampered))
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("keep time claims of paseto as RFC 3339 dates", func() {
+
+			token, _ := pasetoPublic.Encode(claims)
+			payload, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, pasetoPublicHeader))
+
+			message := map[string]interface{}{}
+			So(json.Unmarshal(payload[:len(payload)-ed25519.SignatureSize], &message), ShouldBeNil)
+			So(message["exp"], ShouldEqual, time.Unix(claims["exp"].(int64), 0).UTC().Format(time.RFC3339))
+
+			decoded, _ := pasetoPublic.Decode(token)
+			So(decoded["exp"], ShouldEqual, float64(claims["exp"].(int64)))
+		})
+
+		Convey("verify paseto v4.public test vector", func() {
+
+			secretKey, _ := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+				"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+			vector, _ := CreatePasetoPublicFormat(ed25519.PrivateKey(secretKey))
+
+			decoded, err := vector.Decode("v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+				"bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA")
+			So(err, ShouldBeNil)
+			So(decoded["data"], ShouldEqual, "this is a signed message")
+			So(decoded["exp"], ShouldEqual, float64(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Unix()))
+		})
+
+		Convey("accept only dir and A256GCM in jwe header", func() {
+
+			token, _ := jwe.Encode(claims)
+			parts := strings.Split(token, ".")
+			parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"dir","enc":"A128GCM"}`))
+
+			_, err := jwe.Decode(strings.Join(parts, "."))
+			So(err, ShouldEqual, ErrInvalidToken)
+		})
+
+		Convey("require keys of proper size", func() {
+
+			_, err := CreatePasetoLocalFormat([]byte("short"))
+			So(err, ShouldEqual, ErrInvalidFormatKey)
+
+			_, err = CreateJweFormat([]byte("short"))
+			So(err, ShouldEqual, ErrInvalidFormatKey)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+package jwtTokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	jweKeySize   = 32
+	jweNonceSize = 12
+	jweTagSize   = 16
+)
+
+//jweHeader is the only header which is ever issued or accepted
+type jweHeader struct {
+	Alg  string   `json:"alg"`
+	Enc  string   `json:"enc"`
+	Typ  string   `json:"typ,omitempty"`
+	Zip  string   `json:"zip,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+//CreateJweFormat creates format of encrypted jwt (RFC 7516) using shared key directly (dir) with A256GCM,
+//claims can be read only by services which have the key
+func CreateJweFormat(key []byte) (Format, error) {
+
+	if len(key) != jweKeySize {
+		return Format{}, ErrInvalidFormatKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return Format{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return Format{}, err
+	}
+
+	header, _ := json.Marshal(jweHeader{Alg: "dir", Enc: "A256GCM", Typ: "JWT"})
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+
+	encode := func(claims map[string]interface{}) (string, error) {
+
+		plaintext, err := json.Marshal(claims)
+		if err != nil {
+			return "", err
+		}
+
+		nonce := make([]byte, jweNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		//encoded protected header is additional authenticated data
+		sealed := gcm.Seal(nil, nonce, plaintext, []byte(encodedHeader))
+		ciphertext, tag := sealed[:len(sealed)-jweTagSize], sealed[len(sealed)-jweTagSize:]
+
+		return strings.Join([]string{
+			encodedHeader,
+			"",
+			base64.RawURLEncoding.EncodeToString(nonce),
+			base64.RawURLEncoding.EncodeToString(ciphertext),
+			base64.RawURLEncoding.EncodeToString(tag),
+		}, "."), nil
+	}
+
+	decode := func(token string) (map[string]interface{}, error) {
+
+		parts := strings.Split(token, ".")
+		if len(parts) != 5 || len(parts[1]) > 0 {
+			return nil, ErrInvalidToken
+		}
+
+		headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		header := jweHeader{}
+		if err := json.Unmarshal(headerJson, &header); err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		if header.Alg != "dir" || header.Enc != "A256GCM" || len(header.Zip) > 0 || len(header.Crit) > 0 {
+			return nil, ErrInvalidToken
+		}
+
+		nonce, nonceErr := base64.RawURLEncoding.DecodeString(parts[2])
+		ciphertext, ciphertextErr := base64.RawURLEncoding.DecodeString(parts[3])
+		tag, tagErr := base64.RawURLEncoding.DecodeString(parts[4])
+		if nonceErr != nil || ciphertextErr != nil || tagErr != nil || len(nonce) != jweNonceSize || len(tag) != jweTagSize {
+			return nil, ErrInvalidToken
+		}
+
+		plaintext, err := gcm.Open(nil, nonce, append(ciphertext, tag...), []byte(parts[0]))
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		claims := map[string]interface{}{}
+		if err := json.Unmarshal(plaintext, &claims); err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		return claims, nil
+	}
+
+	return Format{
+		Name:   FormatJwe,
+		Encode: encode,
+		Decode: decode,
+	}, nil
+}
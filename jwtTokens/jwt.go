@@ -1,43 +1,111 @@
 package jwtTokens
 
 import (
-	"fmt"
+	"errors"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/op/go-logging"
+	"github.com/satori/go.uuid"
 )
 
+//Errors that can be returned by this module
+var (
+	//ErrInvalidToken is returned when token cannot be parsed or is no longer valid
+	ErrInvalidToken = errors.New("Invalid token")
+	//ErrTokenAlreadyUsed is returned when token was revoked by someone else first, single use token was used twice
+	ErrTokenAlreadyUsed = errors.New("Token was already used")
+)
+
+//AccountClaims describes account for which access token is issued
+type AccountClaims struct {
+	AccountId string
+	Username  string
+	Roles     []string
+	//Scope is space separated list of scopes granted to token
+	Scope string
+	//SessionId is emitted as sid claim when token belongs to login session
+	SessionId string
+	//TokenId is used as jti when set, so session can revoke token it issued
+	TokenId string
+	//AuthTime is when user last proved identity and Amr lists methods used then(RFC 8176), they survive refreshes
+	AuthTime time.Time
+	Amr      []string
+}
+
 type TokenService struct {
+	//AccessTokenLifetime is short, clients are expected to use refresh tokens to obtain new ones
+	AccessTokenLifetime time.Duration
+	//Format is name of format of issued tokens
+	Format string
+	//SigningAlg is alg of tokens from SignJwt
+	SigningAlg string
+	//ResourceAudiences are services for which tokens can be exchanged, their tokens are not meant for this api
+	ResourceAudiences []string
+
 	Validate      func(token string, claimName string, claimValue string) bool
-	GenerateToken func(username string, userId string) (string, error)
-	GetClaims     func(tokenString string) map[string]interface{}
+	GenerateToken func(account AccountClaims) (string, error)
+	Sign          func(claims map[string]interface{}, lifetime time.Duration) (string, error)
+	//SignJwt always issues signed jwt, it is used for tokens which clients have to read, like id tokens
+	SignJwt   func(claims map[string]interface{}, lifetime time.Duration) (string, error)
+	GetClaims func(tokenString string) map[string]interface{}
+	Revoke    func(tokenString string) error
+	RevokeId  func(jti string, expiresAt time.Time) error
+	//RevokeSubject invalidates all tokens issued so far for subject, e.g. for account which is deleted
+	RevokeSubject func(sub string) error
+	Jwks          func() Jwks
 }
 
-//Create service which generates and validates jwt tokens
-func Create(signingKey string) TokenService {
+//Create service which generates and validates jwt tokens,
+//tokens are signed with newest key from keySet and verified with key matching kid header
+func Create(keySet KeySet, revocations RevocationStore, tokenConfig TokenConfig) TokenService {
+	return CreateWithFormat(CreateJwtFormat(keySet), keySet, revocations, tokenConfig)
+}
+
+//CreateWithFormat creates service which issues and validates tokens in given format,
+//keySet is still used for tokens which have to be jwt
+func CreateWithFormat(format Format, keySet KeySet, revocations RevocationStore, tokenConfig TokenConfig) TokenService {
 	var log = logging.MustGetLogger("[jwtTokens]")
 
+	tokenConfig = tokenConfig.withDefaults()
+	jwtFormat := CreateJwtFormat(keySet)
+
+	parseClaims := func(tokenString string) map[string]interface{} {
+
+		claims, err := format.Decode(tokenString)
+
+		if err != nil {
+			log.Debugf("Token %+v could not be decoded, error occured %+v", tokenString, err)
+			return map[string]interface{}{}
+		}
+
+		if err := validateClaims(claims, tokenConfig, time.Now()); err != nil {
+			log.Debugf("Token claims are invalid %+v", err)
+			return map[string]interface{}{}
+		}
+
+		return claims
+	}
+
 	getClaims := func(tokenString string) map[string]interface{} {
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(signingKey), nil
-		})
+		claims := parseClaims(tokenString)
 
-		if token == nil || err != nil {
-			log.Debugf("Token is nil %+v or error occured %+v", tokenString, err)
+		jti, ok := claims["jti"].(string)
+		if !ok || len(jti) == 0 {
 			return map[string]interface{}{}
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			return claims
+		ids := []string{jti}
+		if sub, ok := claims["sub"].(string); ok && len(sub) > 0 {
+			ids = append(ids, subjectRevocationId(sub))
+		}
+
+		if revocations.IsRevoked(ids...) {
+			log.Debugf("Token %s was revoked", jti)
+			return map[string]interface{}{}
 		}
 
-		return map[string]interface{}{}
+		return claims
 	}
 
 	validate := func(tokenString string, claimName string, claimValue string) bool {
@@ -47,26 +115,124 @@ func Create(signingKey string) TokenService {
 		return claims[claimName] == claimValue
 	}
 
-	generateToken := func(username string, id string) (string, error) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"username":  username,
-			"userId":    id,
-			"expiresAt": time.Now().Add(time.Hour * 24 * 7).Unix(),
-		})
+	//registeredClaims fills registered claims, iss and aud can be overridden by passed claims
+	registeredClaims := func(customClaims map[string]interface{}, lifetime time.Duration) map[string]interface{} {
+		now := time.Now()
+		claims := map[string]interface{}{
+			"jti": uuid.NewV4().String(),
+			"iat": now.Unix(),
+			"nbf": now.Unix(),
+			"exp": now.Add(lifetime).Unix(),
+		}
+
+		if len(tokenConfig.Issuer) > 0 {
+			claims["iss"] = tokenConfig.Issuer
+		}
+
+		if len(tokenConfig.Audience) > 0 {
+			claims["aud"] = tokenConfig.Audience
+		}
+
+		for name, value := range customClaims {
+			claims[name] = value
+		}
+
+		return claims
+	}
+
+	encode := func(format Format, claims map[string]interface{}) (string, error) {
 
-		tokenString, err := token.SignedString([]byte(signingKey))
+		tokenString, err := format.Encode(claims)
 
 		if err != nil {
-			log.Errorf("Could not generate token string %+v", err)
+			log.Errorf("Could not generate %s token string %+v", format.Name, err)
 			return "", err
 		}
 
 		return tokenString, nil
 	}
 
+	sign := func(customClaims map[string]interface{}, lifetime time.Duration) (string, error) {
+		return encode(format, registeredClaims(customClaims, lifetime))
+	}
+
+	signJwt := func(customClaims map[string]interface{}, lifetime time.Duration) (string, error) {
+		return encode(jwtFormat, registeredClaims(customClaims, lifetime))
+	}
+
+	generateToken := func(account AccountClaims) (string, error) {
+
+		claims := map[string]interface{}{
+			"sub":         account.AccountId,
+			"username":    account.Username,
+			"roles":       account.Roles,
+			TokenUseClaim: TokenUseAccess,
+		}
+
+		if len(account.Scope) > 0 {
+			claims["scope"] = account.Scope
+		}
+
+		if len(account.SessionId) > 0 {
+			claims["sid"] = account.SessionId
+		}
+
+		if len(account.TokenId) > 0 {
+			claims["jti"] = account.TokenId
+		}
+
+		if !account.AuthTime.IsZero() {
+			claims["auth_time"] = account.AuthTime.Unix()
+		}
+
+		if len(account.Amr) > 0 {
+			claims["amr"] = account.Amr
+		}
+
+		return sign(claims, tokenConfig.AccessTokenLifetime)
+	}
+
+	revoke := func(tokenString string) error {
+
+		claims := getClaims(tokenString)
+
+		jti, ok := claims["jti"].(string)
+		if !ok {
+			return ErrInvalidToken
+		}
+
+		expiresAt, ok := numericDate(claims["exp"])
+		if !ok {
+			return ErrInvalidToken
+		}
+
+		return revocations.Revoke(jti, expiresAt)
+	}
+
+	//revokeSubject is kept as long as access token issued just before it can be accepted
+	revokeSubject := func(sub string) error {
+
+		err := revocations.Revoke(subjectRevocationId(sub), time.Now().Add(tokenConfig.AccessTokenLifetime+tokenConfig.Leeway))
+		if err == ErrTokenAlreadyUsed {
+			return nil
+		}
+
+		return err
+	}
+
 	return TokenService{
-		Validate:      validate,
-		GenerateToken: generateToken,
-		GetClaims:     getClaims,
+		AccessTokenLifetime: tokenConfig.AccessTokenLifetime,
+		Format:              format.Name,
+		SigningAlg:          keySet.signing.Method.Alg(),
+		ResourceAudiences:   tokenConfig.ResourceAudiences,
+		Validate:            validate,
+		GenerateToken:       generateToken,
+		Sign:                sign,
+		SignJwt:             signJwt,
+		GetClaims:           getClaims,
+		Revoke:              revoke,
+		RevokeId:            revocations.Revoke,
+		RevokeSubject:       revokeSubject,
+		Jwks:                keySet.Jwks,
 	}
 }
@@ -1,22 +1,46 @@
 package jwtTokens
 
 import (
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
 	"strings"
-        . "github.com/smartystreets/goconvey/convey"
-		"testing"
+	"testing"
+	"time"
 )
 
+func createInMemoryRevocationStore() RevocationStore {
+
+	revoked := map[string]time.Time{}
+	return RevocationStore{
+		Revoke: func(jti string, expiresAt time.Time) error {
+			if _, ok := revoked[jti]; ok {
+				return ErrTokenAlreadyUsed
+			}
+			revoked[jti] = expiresAt
+			return nil
+		},
+		IsRevoked: func(ids ...string) bool {
+			for _, id := range ids {
+				if _, ok := revoked[id]; ok {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
 func TestService(t *testing.T) {
 
 	const siginKey = "someTestKey"
-	servce := Create(siginKey)
+	servce := Create(CreateHMACKeySet(siginKey), createInMemoryRevocationStore(), TokenConfig{})
 	user := "testUser"
 	userID := "testUserId"
 
 	Convey("Service should", t, func() {
 
 		Convey("create valid token", func() {
-			token, err := servce.GenerateToken(user, userID)
+			token, err := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
 			So(token, ShouldNotBeBlank)
 			So(err, ShouldBeNil)
 
@@ -24,13 +48,188 @@ func TestService(t *testing.T) {
 			So(tokenElements, ShouldHaveLength, 3)
 
 			So(servce.Validate(token, "username", user), ShouldBeTrue)
-			So(servce.Validate(token, "userId", userID), ShouldBeTrue)
+			So(servce.Validate(token, "sub", userID), ShouldBeTrue)
+		})
+
+		Convey("contain unique token id", func() {
+			first, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+			second, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+
+			So(servce.GetClaims(first)["jti"], ShouldNotBeBlank)
+			So(servce.GetClaims(first)["jti"], ShouldNotEqual, servce.GetClaims(second)["jti"])
+		})
+
+		Convey("reject revoked token", func() {
+			token, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+			other, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+
+			So(servce.Revoke(token), ShouldBeNil)
+
+			So(servce.Validate(token, "username", user), ShouldBeFalse)
+			So(servce.GetClaims(token), ShouldBeEmpty)
+			So(servce.Validate(other, "username", user), ShouldBeTrue)
+		})
+
+		Convey("let only one of concurrent uses revoke token", func() {
+			//both uses read claims before any of them revoked token
+			store := createInMemoryRevocationStore()
+			store.IsRevoked = func(ids ...string) bool { return false }
+			racing := Create(CreateHMACKeySet(siginKey), store, TokenConfig{})
+			token, _ := racing.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+
+			So(racing.Revoke(token), ShouldBeNil)
+			So(racing.Revoke(token), ShouldEqual, ErrTokenAlreadyUsed)
+		})
+
+		Convey("reject all tokens of revoked subject", func() {
+			token, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: "deletedId"})
+			signed, _ := servce.Sign(map[string]interface{}{"sub": "deletedId"}, time.Minute)
+			other, _ := servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+
+			So(servce.RevokeSubject("deletedId"), ShouldBeNil)
+			So(servce.RevokeSubject("deletedId"), ShouldBeNil)
+
+			So(servce.GetClaims(token), ShouldBeEmpty)
+			So(servce.GetClaims(signed), ShouldBeEmpty)
+			So(servce.Validate(other, "username", user), ShouldBeTrue)
+		})
+
+		Convey("contain roles and scope of account", func() {
+			token, _ := servce.GenerateToken(AccountClaims{
+				Username:  user,
+				AccountId: userID,
+				Roles:     []string{"user", "admin"},
+				Scope:     "openid accounts:read",
+			})
+
+			claims := servce.GetClaims(token)
+			So(claims["roles"], ShouldResemble, []interface{}{"user", "admin"})
+			So(claims["scope"], ShouldEqual, "openid accounts:read")
+			So(IsAccessToken(claims), ShouldBeTrue)
+		})
+
+		Convey("bind token to session", func() {
+			token, _ := servce.GenerateToken(AccountClaims{
+				Username:  user,
+				AccountId: userID,
+				SessionId: "sessionId",
+				TokenId:   "tokenId",
+			})
+
+			claims := servce.GetClaims(token)
+			So(claims["sid"], ShouldEqual, "sessionId")
+			So(claims["jti"], ShouldEqual, "tokenId")
+		})
+
+		Convey("contain time and methods of authentication", func() {
+			authTime := time.Now().Add(-time.Hour)
+			token, _ := servce.GenerateToken(AccountClaims{
+				Username:  user,
+				AccountId: userID,
+				AuthTime:  authTime,
+				Amr:       []string{"pwd", "otp", "mfa"},
+			})
+
+			claims := servce.GetClaims(token)
+			parsed, ok := AuthTime(claims)
+			So(ok, ShouldBeTrue)
+			So(parsed.Unix(), ShouldEqual, authTime.Unix())
+			So(claims["amr"], ShouldResemble, []interface{}{"pwd", "otp", "mfa"})
+
+			token, _ = servce.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+			_, ok = AuthTime(servce.GetClaims(token))
+			So(ok, ShouldBeFalse)
 		})
 
 		Convey("return false for invalid token", func() {
 			So(servce.Validate("randomToken", "username", user), ShouldBeFalse)
-			So(servce.Validate("randomToken", "userId", userID), ShouldBeFalse)
+			So(servce.Validate("randomToken", "sub", userID), ShouldBeFalse)
 		})
 	})
 
-}
\ No newline at end of file
+	Convey("Registered claims should", t, func() {
+
+		keySet := CreateHMACKeySet(siginKey)
+		conf := TokenConfig{
+			Issuer:              "http://issuer",
+			Audience:            "test-api",
+			AccessTokenLifetime: time.Minute,
+			Leeway:              time.Second * 10,
+			ResourceAudiences:   []string{"orders-api"},
+		}
+		service := Create(keySet, createInMemoryRevocationStore(), conf)
+
+		sign := func(claims jwt.MapClaims) string {
+			token, _ := keySet.Sign(claims)
+			return token
+		}
+
+		validClaims := func() jwt.MapClaims {
+			now := time.Now()
+			return jwt.MapClaims{
+				"jti": "id",
+				"sub": userID,
+				"iss": conf.Issuer,
+				"aud": conf.Audience,
+				"iat": now.Unix(),
+				"nbf": now.Unix(),
+				"exp": now.Add(time.Minute).Unix(),
+			}
+		}
+
+		Convey("be set on generated token", func() {
+			token, _ := service.GenerateToken(AccountClaims{Username: user, AccountId: userID})
+			claims := service.GetClaims(token)
+
+			So(claims["iss"], ShouldEqual, conf.Issuer)
+			So(claims["aud"], ShouldEqual, conf.Audience)
+			So(claims["sub"], ShouldEqual, userID)
+			So(claims["exp"], ShouldAlmostEqual, float64(time.Now().Add(time.Minute).Unix()), 2)
+		})
+
+		Convey("reject expired token", func() {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			So(service.GetClaims(sign(claims)), ShouldBeEmpty)
+		})
+
+		Convey("accept token expired within leeway", func() {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Second * 5).Unix()
+			So(service.GetClaims(sign(claims)), ShouldNotBeEmpty)
+		})
+
+		Convey("reject token without exp", func() {
+			claims := validClaims()
+			delete(claims, "exp")
+			So(service.GetClaims(sign(claims)), ShouldBeEmpty)
+		})
+
+		Convey("reject token which is not valid yet", func() {
+			claims := validClaims()
+			claims["nbf"] = time.Now().Add(time.Minute).Unix()
+			So(service.GetClaims(sign(claims)), ShouldBeEmpty)
+		})
+
+		Convey("reject token from other issuer", func() {
+			claims := validClaims()
+			claims["iss"] = "http://other"
+			So(service.GetClaims(sign(claims)), ShouldBeEmpty)
+		})
+
+		Convey("accept audience list containing expected audience", func() {
+			claims := validClaims()
+			claims["aud"] = []string{"other-api", conf.Audience}
+			So(service.GetClaims(sign(claims)), ShouldNotBeEmpty)
+
+			claims["aud"] = []string{"other-api"}
+			So(service.GetClaims(sign(claims)), ShouldBeEmpty)
+		})
+
+		Convey("accept token exchanged for resource audience", func() {
+			claims := validClaims()
+			claims["aud"] = "orders-api"
+			So(service.GetClaims(sign(claims)), ShouldNotBeEmpty)
+		})
+	})
+}
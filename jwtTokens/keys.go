@@ -0,0 +1,238 @@
+package jwtTokens
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Errors returned while loading keys
+var (
+	ErrNoSigningKey       = errors.New("No key with private part was configured for signing")
+	ErrUnsupportedKeyType = errors.New("Unsupported key type")
+	ErrUnknownKey         = errors.New("Token was signed with unknown key")
+)
+
+//KeyConfig describes single key, keys with only public part can be used only for verification
+type KeyConfig struct {
+	Kid            string
+	Alg            string
+	PrivateKeyFile string
+	PublicKeyFile  string
+}
+
+//Key used for signing or verification of tokens
+type Key struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+//KeySet holds all keys accepted for verification and the one used for signing
+type KeySet struct {
+	signing Key
+	keys    map[string]Key
+	ordered []Key
+}
+
+//CreateHMACKeySet creates key set with single shared secret, used when no asymmetric keys are configured
+func CreateHMACKeySet(secret string) KeySet {
+
+	key := Key{
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+
+	return KeySet{
+		signing: key,
+		keys:    map[string]Key{"": key},
+		ordered: []Key{key},
+	}
+}
+
+//LoadKeySet reads PEM files, configs are expected oldest first so newest key with private part signs new tokens
+func LoadKeySet(configs []KeyConfig) (KeySet, error) {
+
+	keySet := KeySet{keys: map[string]Key{}}
+	hasSigningKey := false
+
+	for _, conf := range configs {
+
+		key, err := loadKey(conf)
+		if err != nil {
+			return KeySet{}, fmt.Errorf("Could not load key %s: %s", conf.Kid, err.Error())
+		}
+
+		if _, dup := keySet.keys[key.Kid]; dup {
+			return KeySet{}, fmt.Errorf("Duplicated key id %s", key.Kid)
+		}
+
+		keySet.keys[key.Kid] = key
+		keySet.ordered = append(keySet.ordered, key)
+
+		if key.Private != nil {
+			keySet.signing = key
+			hasSigningKey = true
+		}
+	}
+
+	if !hasSigningKey {
+		return KeySet{}, ErrNoSigningKey
+	}
+
+	return keySet, nil
+}
+
+//Sign creates signed token string with newest key
+func (keySet KeySet) Sign(claims jwt.Claims) (string, error) {
+
+	token := jwt.NewWithClaims(keySet.signing.Method, claims)
+	if len(keySet.signing.Kid) > 0 {
+		token.Header["kid"] = keySet.signing.Kid
+	}
+
+	return token.SignedString(keySet.signing.Private)
+}
+
+//VerificationKey picks key by kid from token header, alg has to match the one configured for key
+func (keySet KeySet) VerificationKey(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keySet.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+func loadKey(conf KeyConfig) (Key, error) {
+
+	method := jwt.GetSigningMethod(conf.Alg)
+	if method == nil {
+		return Key{}, fmt.Errorf("unknown alg %s", conf.Alg)
+	}
+
+	if _, isHmac := method.(*jwt.SigningMethodHMAC); isHmac {
+		return Key{}, errors.New("only asymmetric algorithms can be loaded from files")
+	}
+
+	key := Key{Kid: conf.Kid, Method: method}
+
+	if len(conf.PrivateKeyFile) > 0 {
+		private, err := readPrivateKey(conf.PrivateKeyFile)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Private = private
+		key.Public = private.Public()
+	}
+
+	if len(conf.PublicKeyFile) > 0 {
+		public, err := readPublicKey(conf.PublicKeyFile)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Public = public
+	}
+
+	if key.Public == nil {
+		return Key{}, errors.New("either private or public key file is required")
+	}
+
+	if !keyMatchesMethod(key.Public, method) {
+		return Key{}, fmt.Errorf("key type does not match alg %s", conf.Alg)
+	}
+
+	return key, nil
+}
+
+func keyMatchesMethod(public crypto.PublicKey, method jwt.SigningMethod) bool {
+
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := public.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		ecKey, ok := public.(*ecdsa.PublicKey)
+		return ok && ecKey.Curve.Params().BitSize == method.(*jwt.SigningMethodECDSA).CurveBits
+	case *SigningMethodEdDSA:
+		_, ok := public.(ed25519.PublicKey)
+		return ok
+	}
+
+	return false
+}
+
+func readPemBlock(file string) (*pem.Block, error) {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("file does not contain PEM data")
+	}
+
+	return block, nil
+}
+
+func readPrivateKey(file string) (crypto.Signer, error) {
+
+	block, err := readPemBlock(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKeyType
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrUnsupportedKeyType
+}
+
+func readPublicKey(file string) (crypto.PublicKey, error) {
+
+	block, err := readPemBlock(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrUnsupportedKeyType
+}
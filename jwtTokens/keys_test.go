@@ -0,0 +1,156 @@
+package jwtTokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writePrivateKey(dir string, name string, key interface{}) string {
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	So(err, ShouldBeNil)
+
+	file := filepath.Join(dir, name)
+	err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	So(err, ShouldBeNil)
+	return file
+}
+
+func writePublicKey(dir string, name string, key interface{}) string {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	So(err, ShouldBeNil)
+
+	file := filepath.Join(dir, name)
+	err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	So(err, ShouldBeNil)
+	return file
+}
+
+func TestKeys(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "jwtKeys")
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	Convey("Key set should", t, func() {
+
+		rsaFile := writePrivateKey(dir, "rsa.pem", rsaKey)
+		ecFile := writePrivateKey(dir, "ec.pem", ecKey)
+		edFile := writePrivateKey(dir, "ed.pem", edKey)
+
+		Convey("sign and verify tokens with each supported algorithm", func() {
+
+			for _, conf := range []KeyConfig{
+				{Kid: "rsa", Alg: "RS256", PrivateKeyFile: rsaFile},
+				{Kid: "ec", Alg: "ES256", PrivateKeyFile: ecFile},
+				{Kid: "ed", Alg: "EdDSA", PrivateKeyFile: edFile},
+			} {
+				keySet, err := LoadKeySet([]KeyConfig{conf})
+				So(err, ShouldBeNil)
+
+				service := Create(keySet, createInMemoryRevocationStore(), TokenConfig{})
+				token, err := service.GenerateToken(AccountClaims{Username: "user", AccountId: "id"})
+				So(err, ShouldBeNil)
+
+				parsed, _ := jwt.Parse(token, keySet.VerificationKey)
+				So(parsed.Header["kid"], ShouldEqual, conf.Kid)
+				So(parsed.Header["alg"], ShouldEqual, conf.Alg)
+				So(service.Validate(token, "username", "user"), ShouldBeTrue)
+			}
+		})
+
+		Convey("sign with newest key and keep verifying with older ones", func() {
+
+			oldKeySet, _ := LoadKeySet([]KeyConfig{{Kid: "old", Alg: "RS256", PrivateKeyFile: rsaFile}})
+			oldToken, _ := Create(oldKeySet, createInMemoryRevocationStore(), TokenConfig{}).GenerateToken(AccountClaims{Username: "user", AccountId: "id"})
+
+			keySet, err := LoadKeySet([]KeyConfig{
+				{Kid: "old", Alg: "RS256", PublicKeyFile: writePublicKey(dir, "rsa.pub", &rsaKey.PublicKey)},
+				{Kid: "new", Alg: "ES256", PrivateKeyFile: ecFile},
+			})
+			So(err, ShouldBeNil)
+
+			service := Create(keySet, createInMemoryRevocationStore(), TokenConfig{})
+			newToken, _ := service.GenerateToken(AccountClaims{Username: "user", AccountId: "id"})
+
+			parsed, _ := jwt.Parse(newToken, keySet.VerificationKey)
+			So(parsed.Header["kid"], ShouldEqual, "new")
+			So(service.Validate(newToken, "username", "user"), ShouldBeTrue)
+			So(service.Validate(oldToken, "username", "user"), ShouldBeTrue)
+		})
+
+		Convey("reject token with alg not matching the key", func() {
+
+			keySet, _ := LoadKeySet([]KeyConfig{{Kid: "rsa", Alg: "RS256", PrivateKeyFile: rsaFile}})
+			service := Create(keySet, createInMemoryRevocationStore(), TokenConfig{})
+
+			//public key used as hmac secret is the classic algorithm confusion attack
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"username": "user", "jti": "id"})
+			forged.Header["kid"] = "rsa"
+			der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+			forgedStr, _ := forged.SignedString(der)
+
+			So(service.Validate(forgedStr, "username", "user"), ShouldBeFalse)
+		})
+
+		Convey("fail when there is no key to sign with", func() {
+
+			_, err := LoadKeySet([]KeyConfig{{Kid: "old", Alg: "RS256", PublicKeyFile: writePublicKey(dir, "rsa.pub", &rsaKey.PublicKey)}})
+			So(err, ShouldEqual, ErrNoSigningKey)
+		})
+
+		Convey("fail when key does not match alg", func() {
+
+			_, err := LoadKeySet([]KeyConfig{{Kid: "ec", Alg: "RS256", PrivateKeyFile: ecFile}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("expose public keys as jwks", func() {
+
+			keySet, _ := LoadKeySet([]KeyConfig{
+				{Kid: "rsa", Alg: "RS256", PrivateKeyFile: rsaFile},
+				{Kid: "ec", Alg: "ES256", PrivateKeyFile: ecFile},
+				{Kid: "ed", Alg: "EdDSA", PrivateKeyFile: edFile},
+			})
+
+			jwks := keySet.Jwks()
+			So(jwks.Keys, ShouldHaveLength, 3)
+			So(jwks.Keys[0].Kty, ShouldEqual, "RSA")
+			So(jwks.Keys[0].E, ShouldEqual, "AQAB")
+			So(jwks.Keys[1].Kty, ShouldEqual, "EC")
+			So(jwks.Keys[1].Crv, ShouldEqual, "P-256")
+			So(jwks.Keys[2].Kty, ShouldEqual, "OKP")
+			So(strings.Contains(jwks.Keys[2].X, "="), ShouldBeFalse)
+
+			for i, public := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey, edKey.Public()} {
+				decoded, err := jwks.Keys[i].PublicKey()
+				So(err, ShouldBeNil)
+				So(decoded, ShouldResemble, public)
+			}
+
+			_, err := Jwk{Kty: "EC", Crv: "P-256", X: jwks.Keys[1].X, Y: jwks.Keys[1].X}.PublicKey()
+			So(err, ShouldEqual, ErrUnsupportedKeyType)
+		})
+
+		Convey("never expose shared secret", func() {
+			So(CreateHMACKeySet("secret").Jwks().Keys, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,284 @@
+package jwtTokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+//PASETO v4 headers, version and purpose are fixed so token cannot ask for different algorithm
+const (
+	pasetoLocalHeader  = "v4.local."
+	pasetoPublicHeader = "v4.public."
+)
+
+const (
+	pasetoKeySize   = 32
+	pasetoNonceSize = 32
+	pasetoMacSize   = 32
+)
+
+//pasetoTimeClaims are RFC 3339 strings in PASETO, TokenService works with unix timestamps
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+//CreatePasetoLocalFormat creates format of PASETO v4.local tokens,
+//claims are encrypted with XChaCha20 and authenticated with keyed BLAKE2b, so clients cannot read them
+func CreatePasetoLocalFormat(key []byte) (Format, error) {
+
+	if len(key) != pasetoKeySize {
+		return Format{}, ErrInvalidFormatKey
+	}
+
+	encode := func(claims map[string]interface{}) (string, error) {
+
+		message, err := json.Marshal(toPasetoClaims(claims))
+		if err != nil {
+			return "", err
+		}
+
+		nonce := make([]byte, pasetoNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		encryptionKey, counterNonce, authKey := splitPasetoKey(key, nonce)
+
+		cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+		if err != nil {
+			return "", err
+		}
+
+		ciphertext := make([]byte, len(message))
+		cipher.XORKeyStream(ciphertext, message)
+
+		tag := pasetoMac(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, nil, nil))
+
+		payload := append(append(nonce, ciphertext...), tag...)
+		return pasetoLocalHeader + base64.RawURLEncoding.EncodeToString(payload), nil
+	}
+
+	decode := func(token string) (map[string]interface{}, error) {
+
+		payload, footer, err := splitPasetoToken(token, pasetoLocalHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(payload) < pasetoNonceSize+pasetoMacSize {
+			return nil, ErrInvalidToken
+		}
+
+		nonce := payload[:pasetoNonceSize]
+		ciphertext := payload[pasetoNonceSize : len(payload)-pasetoMacSize]
+		tag := payload[len(payload)-pasetoMacSize:]
+
+		encryptionKey, counterNonce, authKey := splitPasetoKey(key, nonce)
+
+		expected := pasetoMac(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, nil))
+		if subtle.ConstantTimeCompare(expected, tag) != 1 {
+			return nil, ErrInvalidToken
+		}
+
+		cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+		if err != nil {
+			return nil, err
+		}
+
+		message := make([]byte, len(ciphertext))
+		cipher.XORKeyStream(message, ciphertext)
+
+		return fromPasetoClaims(message)
+	}
+
+	return Format{
+		Name:   FormatPasetoLocal,
+		Encode: encode,
+		Decode: decode,
+	}, nil
+}
+
+//CreatePasetoPublicFormat creates format of PASETO v4.public tokens signed with Ed25519,
+//claims are readable by anyone like in jwt, but only Ed25519 signature is ever accepted
+func CreatePasetoPublicFormat(privateKey ed25519.PrivateKey) (Format, error) {
+
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return Format{}, ErrInvalidFormatKey
+	}
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	encode := func(claims map[string]interface{}) (string, error) {
+
+		message, err := json.Marshal(toPasetoClaims(claims))
+		if err != nil {
+			return "", err
+		}
+
+		signature := ed25519.Sign(privateKey, pae([]byte(pasetoPublicHeader), message, nil, nil))
+
+		payload := append(message, signature...)
+		return pasetoPublicHeader + base64.RawURLEncoding.EncodeToString(payload), nil
+	}
+
+	decode := func(token string) (map[string]interface{}, error) {
+
+		payload, footer, err := splitPasetoToken(token, pasetoPublicHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(payload) < ed25519.SignatureSize {
+			return nil, ErrInvalidToken
+		}
+
+		message := payload[:len(payload)-ed25519.SignatureSize]
+		signature := payload[len(payload)-ed25519.SignatureSize:]
+
+		if !ed25519.Verify(publicKey, pae([]byte(pasetoPublicHeader), message, footer, nil), signature) {
+			return nil, ErrInvalidToken
+		}
+
+		return fromPasetoClaims(message)
+	}
+
+	return Format{
+		Name:   FormatPasetoPublic,
+		Encode: encode,
+		Decode: decode,
+	}, nil
+}
+
+//LoadPasetoPublicFormat reads Ed25519 private key from PEM file
+func LoadPasetoPublicFormat(privateKeyFile string) (Format, error) {
+
+	signer, err := readPrivateKey(privateKeyFile)
+	if err != nil {
+		return Format{}, err
+	}
+
+	privateKey, ok := signer.(ed25519.PrivateKey)
+	if !ok {
+		return Format{}, ErrInvalidFormatKey
+	}
+
+	return CreatePasetoPublicFormat(privateKey)
+}
+
+//splitPasetoKey derives encryption key, XChaCha20 nonce and authentication key from key and random nonce of token
+func splitPasetoKey(key []byte, nonce []byte) ([]byte, []byte, []byte) {
+
+	tmp := pasetoHash(key, 56, []byte("paseto-encryption-key"), nonce)
+	authKey := pasetoHash(key, pasetoKeySize, []byte("paseto-auth-key-for-aead"), nonce)
+
+	return tmp[:32], tmp[32:], authKey
+}
+
+func pasetoMac(authKey []byte, preAuth []byte) []byte {
+	return pasetoHash(authKey, pasetoMacSize, preAuth)
+}
+
+func pasetoHash(key []byte, size int, parts ...[]byte) []byte {
+
+	//size and key length are constant and valid, so New cannot fail
+	hash, _ := blake2b.New(size, key)
+	for _, part := range parts {
+		hash.Write(part)
+	}
+
+	return hash.Sum(nil)
+}
+
+//pae is pre-authentication encoding, it makes concatenation of pieces unambiguous before they are signed
+func pae(pieces ...[]byte) []byte {
+
+	encoded := le64(uint64(len(pieces)))
+	for _, piece := range pieces {
+		encoded = append(encoded, le64(uint64(len(piece)))...)
+		encoded = append(encoded, piece...)
+	}
+
+	return encoded
+}
+
+func le64(n uint64) []byte {
+
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, n&(1<<63-1))
+	return encoded
+}
+
+//splitPasetoToken checks header and returns decoded payload and optional footer
+func splitPasetoToken(token string, header string) ([]byte, []byte, error) {
+
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(token[len(header):], ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	var footer []byte
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, ErrInvalidToken
+		}
+	}
+
+	return payload, footer, nil
+}
+
+func toPasetoClaims(claims map[string]interface{}) map[string]interface{} {
+
+	converted := map[string]interface{}{}
+	for name, value := range claims {
+		converted[name] = value
+	}
+
+	for _, name := range pasetoTimeClaims {
+		if date, ok := numericDate(claims[name]); ok {
+			converted[name] = date.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return converted
+}
+
+func fromPasetoClaims(message []byte) (map[string]interface{}, error) {
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	for _, name := range pasetoTimeClaims {
+
+		value, ok := claims[name].(string)
+		if !ok {
+			continue
+		}
+
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		claims[name] = float64(date.Unix())
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,75 @@
+package jwtTokens
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//RevokedToken is kept until original token expires, after that mongo removes it
+type RevokedToken struct {
+	Id        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+//RevocationStore keeps ids(jti) of tokens which are no longer valid
+type RevocationStore struct {
+	//Revoke fails with ErrTokenAlreadyUsed when token is already revoked, so only one of concurrent uses of single use token succeeds
+	Revoke func(jti string, expiresAt time.Time) error
+	//IsRevoked tells if any of ids was revoked, token is checked by its jti and its subject in single query
+	IsRevoked func(ids ...string) bool
+}
+
+//subjectRevocationId is stored instead of jti when all tokens of subject are revoked
+func subjectRevocationId(sub string) string {
+	return "sub:" + sub
+}
+
+//CreateRevocationStore creates store backed by collection with ttl index on expiresAt
+func CreateRevocationStore(revokedRepo dal.Dal) RevocationStore {
+
+	var log = logging.MustGetLogger("[RevocationStore]")
+
+	if err := revokedRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for revoked tokens. Details: ", err)
+	}
+
+	revoke := func(jti string, expiresAt time.Time) error {
+
+		isDup, err := revokedRepo.Save(RevokedToken{Id: jti, ExpiresAt: expiresAt})
+		if err != nil {
+			return err
+		}
+
+		if isDup {
+			return ErrTokenAlreadyUsed
+		}
+
+		return nil
+	}
+
+	isRevoked := func(ids ...string) bool {
+
+		values := make([]interface{}, len(ids))
+		for i, id := range ids {
+			values[i] = id
+		}
+
+		revoked := make([]RevokedToken, 0)
+		query := dal.NewQueryBuilder().WithAnyOfValues("_id", values...).Build()
+		if err := revokedRepo.GetByQuery(&revoked, web.DefaultPagination(), query); err != nil {
+			//when we cannot tell, token is treated as revoked
+			log.Error("Could not check token revocation. Details: ", err)
+			return true
+		}
+
+		return len(revoked) > 0
+	}
+
+	return RevocationStore{
+		Revoke:    revoke,
+		IsRevoked: isRevoked,
+	}
+}
@@ -0,0 +1,13 @@
+package jwtTokens
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller) {
+
+	echoEngine.OPTIONS("/.well-known/jwks.json", web.OptionsMethodHandler)
+	echoEngine.GET("/.well-known/jwks.json", controller.Jwks)
+}
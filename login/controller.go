@@ -1,28 +1,40 @@
 package login
 
 import (
+        "math"
+        "net/http"
+        "strconv"
+
         "github.com/labstack/echo"
         "github.com/piotrjaromin/go-login-backend/web"
         "github.com/piotrjaromin/go-login-backend/accounts"
-        "github.com/op/go-logging"
+        "github.com/piotrjaromin/go-login-backend/mfa"
+        "github.com/piotrjaromin/go-login-backend/security"
+        "github.com/piotrjaromin/go-login-backend/sessions"
 )
 
 //Controller struct with login and logout functions
 type Controller struct {
-        Login  func(c echo.Context) error
-        Logout func(c echo.Context) error
+        Login   func(c echo.Context) error
+        Logout  func(c echo.Context) error
+        Refresh func(c echo.Context) error
+        VerifyMfa func(c echo.Context) error
+        VerifyCode func(c echo.Context) error
+        ResendCode func(c echo.Context) error
+        Reauthenticate func(c echo.Context) error
+        Recover func(c echo.Context) error
+        ChangeExpiredPassword func(c echo.Context) error
 }
 
 //Create controller responsible for logging in user
 func Create(loginService Service) Controller {
 
-        var log = logging.MustGetLogger("[LoginController]")
-        
         login := func(c echo.Context) error {
                 username := c.FormValue("username")
                 pass := c.FormValue("password")
                 
-                token, err := loginService.Login(username, accounts.Password(pass))
+                device := GetDevice(c)
+                token, err := loginService.Login(c.Request().Context(), username, accounts.Password(pass), device)
 
                 if err == ErrNotFoundAccount {
                         return web.NotFoundResponse(c)
@@ -32,24 +44,235 @@ func Create(loginService Service) Controller {
                         return web.BadRequestResponse(c, "Wrong credentials")
                 }
 
+                if attemptsErr, ok := err.(AttemptsError); ok {
+                        return attemptsResponse(c, attemptsErr)
+                }
+
                 if err == ErrNotConfirmedAccount {
                         return web.ConflictResponse(c, "Account is not confirmed")
                 }
                 
+                if overloaded, ok := err.(accounts.OverloadedError); ok {
+                        return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+                }
+
                 if err != nil {
                         return web.LogAndReturnInternalError(c, "Error while performing login", err)
                 }
 
-                log.Infof("Generated token is %s", token.Token)
                 return c.JSON(200, token)
         }
 
         logout := func(c echo.Context) error {
+                token, found := security.GetToken(c)
+                if !found {
+                        return web.UnauthorizedResponse(c, "Invalid authorization header")
+                }
+
+                err := loginService.Logout(token, c.FormValue("refreshToken"))
+
+                if err == ErrInvalidToken {
+                        return web.UnauthorizedResponse(c, "Invalid token")
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while performing logout", err)
+                }
+
                 return c.String(200, "")
         }
 
+        verifySecondFactor := func(c echo.Context, method string) error {
+                token, err := loginService.VerifyMfa(c.FormValue("challenge"), method, c.FormValue("code"), GetDevice(c))
+
+                if err == ErrInvalidChallenge {
+                        return web.UnauthorizedResponse(c, "Invalid or expired challenge")
+                }
+
+                if err == ErrInvalidMfaCode {
+                        return web.BadRequestResponse(c, "Invalid code")
+                }
+
+                if err == ErrMfaMethodNotEnabled {
+                        return web.BadRequestResponse(c, err.Error())
+                }
+
+                if attemptsErr, ok := err.(AttemptsError); ok {
+                        return attemptsResponse(c, attemptsErr)
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while verifying second factor", err)
+                }
+
+                return c.JSON(200, token)
+        }
+
+        verifyMfa := func(c echo.Context) error {
+                method := c.FormValue("method")
+                if len(method) == 0 {
+                        method = mfa.MethodTotp
+                }
+
+                return verifySecondFactor(c, method)
+        }
+
+        verifyCode := func(c echo.Context) error {
+                return verifySecondFactor(c, mfa.MethodEmail)
+        }
+
+        resendCode := func(c echo.Context) error {
+                err := loginService.ResendCode(c.FormValue("challenge"))
+
+                if err == ErrInvalidChallenge {
+                        return web.UnauthorizedResponse(c, "Invalid or expired challenge")
+                }
+
+                if err == ErrEmailCodeNotEnabled {
+                        return web.BadRequestResponse(c, err.Error())
+                }
+
+                if err == ErrResendTooSoon {
+                        c.Response().Header().Set("Retry-After", strconv.Itoa(int(mfa.EmailCodeResendInterval.Seconds())))
+                        return web.TooManyRequestsResponse(c, err.Error())
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while sending code", err)
+                }
+
+                return c.NoContent(http.StatusAccepted)
+        }
+
+        reauthenticate := func(c echo.Context) error {
+                token, found := security.GetToken(c)
+                if !found {
+                        return web.UnauthorizedResponse(c, "Invalid authorization header")
+                }
+
+                newToken, err := loginService.Reauthenticate(c.Request().Context(), token, accounts.Password(c.FormValue("password")), GetDevice(c))
+
+                if err == ErrInvalidToken {
+                        return web.UnauthorizedResponse(c, "Invalid token")
+                }
+
+                if err == ErrBadCredentials {
+                        return web.BadRequestResponse(c, "Wrong credentials")
+                }
+
+                if attemptsErr, ok := err.(AttemptsError); ok {
+                        return attemptsResponse(c, attemptsErr)
+                }
+
+                if overloaded, ok := err.(accounts.OverloadedError); ok {
+                        return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while reauthenticating", err)
+                }
+
+                return c.JSON(200, newToken)
+        }
+
+        recoverAccount := func(c echo.Context) error {
+                dto := accounts.RecoverAccountDto{}
+                if err := c.Bind(&dto); err != nil {
+                        return web.BadRequestResponse(c, "Unable to parse request body")
+                }
+
+                token, err := loginService.Recover(c.Request().Context(), c.Param("id"), dto.Code, dto.NewPassword, GetDevice(c))
+
+                if err == ErrInvalidRecoveryCode {
+                        return web.BadRequestResponse(c, "Invalid recovery code")
+                }
+
+                if policyErr, ok := err.(accounts.PolicyError); ok {
+                        return web.BadRequestResponseWithDetails(c, "Password does not meet password policy", policyErr.Details)
+                }
+
+                if attemptsErr, ok := err.(AttemptsError); ok {
+                        return attemptsResponse(c, attemptsErr)
+                }
+
+                if overloaded, ok := err.(accounts.OverloadedError); ok {
+                        return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while recovering account", err)
+                }
+
+                return c.JSON(200, token)
+        }
+
+        changeExpiredPassword := func(c echo.Context) error {
+                newPassword := accounts.Password(c.FormValue("newPassword"))
+
+                token, err := loginService.ChangeExpiredPassword(c.Request().Context(), c.FormValue("challenge"), newPassword, GetDevice(c))
+
+                if err == ErrInvalidChallenge {
+                        return web.UnauthorizedResponse(c, "Invalid or expired challenge")
+                }
+
+                if policyErr, ok := err.(accounts.PolicyError); ok {
+                        return web.BadRequestResponseWithDetails(c, "Password does not meet password policy", policyErr.Details)
+                }
+
+                if overloaded, ok := err.(accounts.OverloadedError); ok {
+                        return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while changing expired password", err)
+                }
+
+                return c.JSON(200, token)
+        }
+
+        refresh := func(c echo.Context) error {
+                refreshToken := c.FormValue("refreshToken")
+
+                token, err := loginService.Refresh(refreshToken)
+
+                if err == ErrInvalidRefreshToken {
+                        return web.UnauthorizedResponse(c, "Invalid refresh token")
+                }
+
+                if err != nil {
+                        return web.LogAndReturnInternalError(c, "Error while refreshing token", err)
+                }
+
+                return c.JSON(200, token)
+        }
+
         return Controller{
                 Login: login,
                 Logout: logout,
+                Refresh: refresh,
+                VerifyMfa: verifyMfa,
+                VerifyCode: verifyCode,
+                ResendCode: resendCode,
+                Reauthenticate: reauthenticate,
+                Recover: recoverAccount,
+                ChangeExpiredPassword: changeExpiredPassword,
+        }
+}
+
+//attemptsResponse answers request rejected because of failed attempts, locked account gets 423, other limits 429
+func attemptsResponse(c echo.Context, err AttemptsError) error {
+        c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+
+        if err.Err == ErrAccountLocked {
+                return web.LockedResponse(c, "Account is temporarily locked")
         }
-}
\ No newline at end of file
+        return web.TooManyRequestsResponse(c, err.Error())
+}
+
+//GetDevice describes client which sent request
+func GetDevice(c echo.Context) sessions.Device {
+        return sessions.Device{
+                UserAgent: c.Request().UserAgent(),
+                Ip:        c.RealIP(),
+        }
+}
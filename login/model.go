@@ -1,5 +1,11 @@
 package login
 
 type Token struct {
-        Token string `json:"token"`
-}
\ No newline at end of file
+        Token        string `json:"token,omitempty"`
+        RefreshToken string `json:"refreshToken,omitempty"`
+        ExpiresIn    int64  `json:"expiresIn,omitempty"`
+        //Status, Challenge and MfaMethods are set instead of token when second factor is required
+        Status       string   `json:"status,omitempty"`
+        Challenge    string   `json:"challenge,omitempty"`
+        MfaMethods   []string `json:"mfaMethods,omitempty"`
+}
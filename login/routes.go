@@ -14,4 +14,29 @@ func InitRoutes(echoEngine *echo.Echo, controller Controller) {
         echoEngine.POST("/login", controller.Login)
         echoEngine.POST("/logout", controller.Logout)
 
+        //second step of login for accounts with mfa enabled
+        echoEngine.OPTIONS("/login/mfa", web.OptionsMethodHandler)
+        echoEngine.POST("/login/mfa", controller.VerifyMfa)
+
+        //second step of login with code sent by email, resend also sends code when other method is preferred
+        echoEngine.OPTIONS("/login/verify-code", web.OptionsMethodHandler)
+        echoEngine.POST("/login/verify-code", controller.VerifyCode)
+        echoEngine.OPTIONS("/login/verify-code/resend", web.OptionsMethodHandler)
+        echoEngine.POST("/login/verify-code/resend", controller.ResendCode)
+
+        //step-up for sensitive operations, token of current session is exchanged for one with fresh auth_time
+        echoEngine.OPTIONS("/login/reauthenticate", web.OptionsMethodHandler)
+        echoEngine.POST("/login/reauthenticate", controller.Reauthenticate)
+
+        //login with expired password returns challenge which is exchanged for token together with new password
+        echoEngine.OPTIONS("/login/password", web.OptionsMethodHandler)
+        echoEngine.POST("/login/password", controller.ChangeExpiredPassword)
+
+        //id is email of account, recovery code replaces password
+        echoEngine.OPTIONS("/accounts/:id/recover", web.OptionsMethodHandler)
+        echoEngine.POST("/accounts/:id/recover", controller.Recover)
+
+        echoEngine.OPTIONS("/token/refresh", web.OptionsMethodHandler)
+        echoEngine.POST("/token/refresh", controller.Refresh)
+
 }
\ No newline at end of file
@@ -1,10 +1,18 @@
 package login
 
 import (
+	"context"
 	"errors"
+	"time"
+
 	"github.com/op/go-logging"
 	"github.com/piotrjaromin/go-login-backend/accounts"
 	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/loginAttempts"
+	"github.com/piotrjaromin/go-login-backend/mfa"
+	"github.com/piotrjaromin/go-login-backend/refreshTokens"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	"github.com/satori/go.uuid"
 )
 
 //Errors that can be returned by this module
@@ -15,57 +23,637 @@ var (
 	ErrNotConfirmedAccount       = errors.New("Account is not confirmed")
 	ErrBadCredentials            = errors.New("Invalid credentials")
 	ErrCouldNotGenerateToken     = errors.New("Could not generate token")
+	ErrInvalidRefreshToken       = errors.New("Invalid refresh token")
+	ErrInvalidToken              = errors.New("Invalid token")
+	ErrAccountLocked             = errors.New("Account is temporarily locked")
+	ErrTooManyAttempts           = errors.New("Too many login attempts")
+	ErrInvalidChallenge          = errors.New("Invalid or expired mfa challenge")
+	ErrInvalidMfaCode            = errors.New("Invalid mfa code")
+	ErrMfaMethodNotEnabled       = errors.New("Second factor method is not enabled for account")
+	ErrInvalidRecoveryCode       = errors.New("Invalid recovery code")
+	ErrResendTooSoon             = errors.New("Code was sent recently, try again later")
+	ErrEmailCodeNotEnabled       = errors.New("Email codes are not enabled for account")
+)
+
+//Authentication methods(RFC 8176) which are put into amr claim, email and fed are not registered there
+const (
+	AmrPassword         = "pwd"
+	AmrOtp              = "otp"
+	AmrMfa              = "mfa"
+	AmrHardwareKey      = "hwk"
+	AmrUserVerification = "user"
+	AmrEmailLink        = "email"
+	AmrFederated        = "fed"
+)
+
+//StatusMfaRequired is returned instead of token when second factor has to be verified
+const StatusMfaRequired = "mfa_required"
+
+//StatusPasswordChangeRequired is returned instead of token when password is older than policy allows
+const StatusPasswordChangeRequired = "password_change_required"
+
+//MfaChallengeLifetime is time user has for providing second factor
+const MfaChallengeLifetime = time.Minute * 5
+
+//PasswordChangeLifetime is time user has for setting new password after login with expired one
+const PasswordChangeLifetime = time.Minute * 10
+
+//challenge is jwt without sub and username, so it cannot be used as access token,
+//password change challenge has the same claims with other token_use
+const (
+	tokenUseClaim          = "token_use"
+	tokenUseMfaChallenge   = "mfa_challenge"
+	tokenUsePasswordChange = "password_change"
+	challengeAccountClaim  = "mfa_account"
+	challengeAmrClaim      = "mfa_amr"
 )
 
 //Service with login function
 type Service struct {
-	Login func(username string, pass accounts.Password) (*Token, error)
+	//Login, like other functions which check or set password, takes context of request, password is not hashed when client goes away
+	Login     func(ctx context.Context, username string, pass accounts.Password, device sessions.Device) (*Token, error)
+	VerifyMfa func(challenge string, method string, code string, device sessions.Device) (*Token, error)
+	//ResendCode emails new code for mfa challenge, it also lets user switch from authenticator app to email
+	ResendCode func(challenge string) error
+	//ChangeExpiredPassword sets new password with challenge returned for expired one and finishes login
+	ChangeExpiredPassword func(ctx context.Context, challenge string, newPassword accounts.Password, device sessions.Device) (*Token, error)
+	//Recover logs in with recovery code and sets new password, other sessions are logged out
+	Recover func(ctx context.Context, email string, code string, newPassword accounts.Password, device sessions.Device) (*Token, error)
+	Refresh func(refreshToken string) (*Token, error)
+	//IssueToken starts session of account authenticated with amr methods
+	IssueToken func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*Token, error)
+	//Complete finishes login of account which proved its first factor, it returns mfa challenge when account has second factor
+	Complete func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*Token, error)
+	//Reauthenticate lets user of session prove identity again, new token has fresh auth_time
+	Reauthenticate func(ctx context.Context, token string, pass accounts.Password, device sessions.Device) (*Token, error)
+	Logout         func(token string, refreshToken string) error
+}
+
+//AttemptsError is returned when request is rejected because of too many failed attempts,
+//Err is ErrAccountLocked or ErrTooManyAttempts and RetryAfter tells when next attempt is allowed
+type AttemptsError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (err AttemptsError) Error() string {
+	return err.Err.Error()
 }
 
-//CreateService creates service responsible for issuing tokens
-func CreateService(accountsDal accounts.Dal, encrypt accounts.Encrypt, tokenService jwtTokens.TokenService) Service {
+//CreateService creates service responsible for issuing tokens, every login starts new session
+func CreateService(accountsDal accounts.Dal, encrypt accounts.Encrypt, tokenService jwtTokens.TokenService, refreshService refreshTokens.Service, sessionsService sessions.Service, attemptsService loginAttempts.Service, mfaService mfa.Service, accountsService accounts.Service) Service {
 
 	var log = logging.MustGetLogger("[LoginService]")
 
-	login := func(username string, pass accounts.Password) (*Token, error) {
+	//dummyHash is validated for unknown emails, so they take as long as known ones
+	dummyHash, dummyErr := encrypt.Hash(context.Background(), accounts.Password(uuid.NewV4().String()))
+	if dummyErr != nil {
+		log.Error("Could not hash dummy password. Details: ", dummyErr.Error())
+	}
+
+	generateToken := func(account accounts.PasswordlessAccount, session sessions.Session) (string, error) {
+		return tokenService.GenerateToken(jwtTokens.AccountClaims{
+			AccountId: account.Id,
+			Username:  account.Username,
+			Roles:     account.EffectiveRoles(),
+			Scope:     account.Scope(),
+			SessionId: session.Id,
+			TokenId:   session.TokenId(),
+			AuthTime:  session.AuthTime,
+			Amr:       session.Amr,
+		})
+	}
+
+	issuePasswordChallenge := func(accountId string, amr []string) (*Token, error) {
+
+		challenge, err := tokenService.Sign(map[string]interface{}{
+			tokenUseClaim:         tokenUsePasswordChange,
+			challengeAccountClaim: accountId,
+			challengeAmrClaim:     amr,
+		}, PasswordChangeLifetime)
+
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		return &Token{
+			Status:    StatusPasswordChangeRequired,
+			Challenge: challenge,
+			ExpiresIn: int64(PasswordChangeLifetime.Seconds()),
+		}, nil
+	}
+
+	issueToken := func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*Token, error) {
+
+		//expired password is checked after second factor, so it cannot be changed with password alone
+		if hasAmr(amr, AmrPassword) {
+			expired, err := accountsService.PasswordExpired(account.Id)
+			if err != nil {
+				log.Error("Could not check password age. Details: ", err.Error())
+				return nil, ErrCouldNotFetchAccount
+			}
+
+			if expired {
+				return issuePasswordChallenge(account.Id, amr)
+			}
+		}
+
+		session, err := sessionsService.Start(account.Id, account.Username, device, amr)
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		tokenStr, err := generateToken(account, session)
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		//refresh tokens of session share its id, so they are revoked together with session
+		refreshToken, err := refreshService.IssueInFamily(session.Id, account.Id, account.Username)
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		return &Token{
+			Token:        tokenStr,
+			RefreshToken: refreshToken,
+			ExpiresIn:    int64(tokenService.AccessTokenLifetime.Seconds()),
+		}, nil
+	}
+
+	issueChallenge := func(accountId string, methods []string, amr []string) (*Token, error) {
+
+		//methods of first factor are kept, so final token lists all of them
+		challenge, err := tokenService.Sign(map[string]interface{}{
+			tokenUseClaim:         tokenUseMfaChallenge,
+			challengeAccountClaim: accountId,
+			challengeAmrClaim:     amr,
+		}, MfaChallengeLifetime)
+
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		//code is sent right away only when email is preferred method, otherwise user asks for it
+		if methods[0] == mfa.MethodEmail {
+			if err := mfaService.SendEmailCode(accountId); err != nil {
+				log.Error("Could not send mfa code by email. Details: ", err.Error())
+			}
+		}
+
+		return &Token{
+			Status:     StatusMfaRequired,
+			Challenge:  challenge,
+			MfaMethods: methods,
+			ExpiresIn:  int64(MfaChallengeLifetime.Seconds()),
+		}, nil
+	}
+
+	complete := func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*Token, error) {
+
+		methods, err := mfaService.Methods(account.Id)
+		if err != nil {
+			log.Error("Could not fetch mfa settings. Details: ", err.Error())
+			return nil, ErrCouldNotFetchAccount
+		}
+
+		if len(methods) > 0 {
+			return issueChallenge(account.Id, methods, amr)
+		}
+
+		return issueToken(account, device, amr)
+	}
+
+	//rehash upgrades hash made with outdated algorithm, cost or pepper, plain password is known only during login
+	rehash := func(ctx context.Context, secAccount accounts.SecuredAccount, pass accounts.Password) {
+
+		hash, err := encrypt.Hash(ctx, pass)
+		if err != nil {
+			log.Error("Could not rehash password. Details: ", err.Error())
+			return
+		}
+
+		err = accountsDal.UpdateByEmail(secAccount.Email, func(stored *accounts.SecuredAccount) error {
+			//password changed in the meantime is not overwritten
+			if stored.Password != secAccount.Password {
+				return nil
+			}
+
+			stored.Password = hash
+			stored.Salt = ""
+			return nil
+		})
+
+		if err != nil {
+			log.Error("Could not rehash password. Details: ", err.Error())
+		}
+	}
+
+	//checkAttempts rejects attempt of key which failed too many times, before credentials are checked
+	checkAttempts := func(key string, ip string) error {
+
+		retryAfter, err := attemptsService.Check(key, ip)
+		if err == loginAttempts.ErrAccountLocked {
+			return AttemptsError{Err: ErrAccountLocked, RetryAfter: retryAfter}
+		}
+
+		if err != nil {
+			return AttemptsError{Err: ErrTooManyAttempts, RetryAfter: retryAfter}
+		}
+
+		return nil
+	}
+
+	login := func(ctx context.Context, username string, pass accounts.Password, device sessions.Device) (*Token, error) {
 
-		log.Debug("got from query params ", username, pass)
 		if len(username) == 0 || len(pass) == 0 {
 			return nil, ErrMissingPasswordOrUsername
 		}
 
-		secAccount, getAccErr := accountsDal.GetWithPasswordByEmail(username)
-		if getAccErr != nil {
-			if getAccErr == accounts.ErrAccountNotFound {
-				return nil, ErrBadCredentials
+		if err := checkAttempts(username, device.Ip); err != nil {
+			return nil, err
+		}
+
+		badCredentials := func() (*Token, error) {
+			if err := attemptsService.RecordFailure(username, device.Ip); err != nil {
+				log.Error("Could not record failed login. Details: ", err.Error())
 			}
+			return nil, ErrBadCredentials
+		}
+
+		secAccount, getAccErr := accountsDal.GetWithPasswordByEmail(username)
+		if getAccErr != nil && getAccErr != accounts.ErrAccountNotFound {
 			log.Error("Cold not fetch account. Detials: ", getAccErr.Error())
 			return nil, ErrCouldNotFetchAccount
 		}
 
+		//unknown accounts and accounts created by social login, which have no password, are checked against dummy hash,
+		//so they take as long as wrong password and answer the same when hashing is overloaded
+		hash, salt := secAccount.Password, secAccount.Salt
+		if getAccErr != nil || len(hash) == 0 {
+			hash, salt = dummyHash, ""
+		}
+
+		valid, err := encrypt.Validate(ctx, pass, hash, salt)
+		if err != nil {
+			return nil, err
+		}
+
+		if !valid || getAccErr != nil || len(secAccount.Password) == 0 {
+			return badCredentials()
+		}
+
 		log.Debugf("found user %s", secAccount.Id)
+
+		//status is checked after password, so it is not revealed to someone who does not know it
 		if secAccount.Status != accounts.Confirmed {
 			return nil, ErrNotConfirmedAccount
 		}
 
-		if !encrypt.Validate(pass, secAccount.Password, secAccount.Salt) {
+		if encrypt.NeedsRehash(secAccount.Password) {
+			rehash(ctx, secAccount, pass)
+		}
+
+		if err := attemptsService.RecordSuccess(username, device.Ip); err != nil {
+			log.Error("Could not reset failed logins. Details: ", err.Error())
+		}
+
+		return complete(secAccount.PasswordlessAccount, device, []string{AmrPassword})
+	}
+
+	challengeAccount := func(challenge string, tokenUse string) (string, []string, error) {
+
+		claims := tokenService.GetClaims(challenge)
+		accountId, _ := claims[challengeAccountClaim].(string)
+		if claims[tokenUseClaim] != tokenUse || len(accountId) == 0 {
+			return "", nil, ErrInvalidChallenge
+		}
+
+		amr := []string{}
+		values, _ := claims[challengeAmrClaim].([]interface{})
+		for _, value := range values {
+			if method, ok := value.(string); ok {
+				amr = append(amr, method)
+			}
+		}
+
+		return accountId, amr, nil
+	}
+
+	verifyMfa := func(challenge string, method string, code string, device sessions.Device) (*Token, error) {
+
+		accountId, amr, err := challengeAccount(challenge, tokenUseMfaChallenge)
+		if err != nil {
+			return nil, err
+		}
+
+		//codes are short, so attempts are throttled like passwords
+		attemptsKey := "mfa:" + accountId
+		if err := checkAttempts(attemptsKey, device.Ip); err != nil {
+			return nil, err
+		}
+
+		//method which is not enrolled cannot be guessed, so it is not counted as failed attempt
+		err = mfaService.Verify(accountId, method, code)
+		if err == mfa.ErrNotEnabled || err == mfa.ErrUnsupportedMethod {
+			return nil, ErrMfaMethodNotEnabled
+		}
+
+		if err == mfa.ErrInvalidCode {
+			if err := attemptsService.RecordFailure(attemptsKey, device.Ip); err != nil {
+				log.Error("Could not record failed mfa attempt. Details: ", err.Error())
+			}
+			return nil, ErrInvalidMfaCode
+		}
+
+		if err != nil {
+			log.Error("Could not verify mfa code. Details: ", err.Error())
+			return nil, err
+		}
+
+		if err := attemptsService.RecordSuccess(attemptsKey, device.Ip); err != nil {
+			log.Error("Could not reset failed mfa attempts. Details: ", err.Error())
+		}
+
+		//challenge can be used only once
+		if err := tokenService.Revoke(challenge); err != nil {
+			log.Error("Could not revoke mfa challenge. Details: ", err.Error())
+			return nil, ErrInvalidChallenge
+		}
+
+		account, err := accountsDal.GetById(accountId)
+		if err != nil {
+			log.Error("Could not fetch account after mfa. Details: ", err.Error())
+			return nil, ErrCouldNotFetchAccount
+		}
+
+		//code sent by email proves access to mailbox, not possession of authenticator
+		methodAmr := AmrOtp
+		if method == mfa.MethodEmail {
+			methodAmr = AmrEmailLink
+		}
+
+		if !hasAmr(amr, methodAmr) {
+			amr = append(amr, methodAmr)
+		}
+
+		return issueToken(account, device, append(amr, AmrMfa))
+	}
+
+	resendCode := func(challenge string) error {
+
+		accountId, _, err := challengeAccount(challenge, tokenUseMfaChallenge)
+		if err != nil {
+			return err
+		}
+
+		err = mfaService.SendEmailCode(accountId)
+		switch err {
+		case mfa.ErrResendTooSoon:
+			return ErrResendTooSoon
+		case mfa.ErrNotEnabled:
+			return ErrEmailCodeNotEnabled
+		}
+
+		return err
+	}
+
+	changeExpiredPassword := func(ctx context.Context, challenge string, newPassword accounts.Password, device sessions.Device) (*Token, error) {
+
+		accountId, amr, err := challengeAccount(challenge, tokenUsePasswordChange)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := accountsService.ChangePassword(ctx, accountId, newPassword); err != nil {
+			switch err.(type) {
+			case accounts.PolicyError, accounts.OverloadedError:
+			default:
+				log.Error("Could not change expired password. Details: ", err.Error())
+			}
+			return nil, err
+		}
+
+		//challenge stays valid until password is changed, so user can retry after policy violation
+		if err := tokenService.Revoke(challenge); err != nil {
+			log.Error("Could not revoke password change challenge. Details: ", err.Error())
+			return nil, ErrInvalidChallenge
+		}
+
+		account, err := accountsDal.GetById(accountId)
+		if err != nil {
+			log.Error("Could not fetch account after password change. Details: ", err.Error())
+			return nil, ErrCouldNotFetchAccount
+		}
+
+		return issueToken(account, device, amr)
+	}
+
+	recoverAccount := func(ctx context.Context, email string, code string, newPassword accounts.Password, device sessions.Device) (*Token, error) {
+
+		//recovery codes are guessed like passwords, so they share lockout with login
+		if err := checkAttempts(email, device.Ip); err != nil {
+			return nil, err
+		}
+
+		account, err := accountsService.RecoverAccount(ctx, email, code, newPassword)
+		if err == accounts.ErrInvalidRecoveryCode {
+			if err := attemptsService.RecordFailure(email, device.Ip); err != nil {
+				log.Error("Could not record failed recovery. Details: ", err.Error())
+			}
+			return nil, ErrInvalidRecoveryCode
+		}
+
+		switch err.(type) {
+		case accounts.PolicyError, accounts.OverloadedError:
+			return nil, err
+		}
+
+		if err != nil {
+			log.Error("Could not recover account. Details: ", err.Error())
+			return nil, err
+		}
+
+		if err := attemptsService.RecordSuccess(email, device.Ip); err != nil {
+			log.Error("Could not reset failed logins. Details: ", err.Error())
+		}
+
+		if err := sessionsService.RevokeOthers(account.Username, ""); err != nil {
+			log.Error("Could not log out sessions of recovered account. Details: ", err.Error())
+		}
+
+		//recovery code is one time password
+		return issueToken(account, device, []string{AmrOtp})
+	}
+
+	reauthenticate := func(ctx context.Context, token string, pass accounts.Password, device sessions.Device) (*Token, error) {
+
+		claims := tokenService.GetClaims(token)
+		sid, _ := claims["sid"].(string)
+		accountId, _ := claims["sub"].(string)
+		if len(sid) == 0 || len(accountId) == 0 {
+			return nil, ErrInvalidToken
+		}
+
+		secAccount, err := accountsDal.GetWithPasswordById(accountId)
+		if err == accounts.ErrAccountNotFound {
+			return nil, ErrInvalidToken
+		}
+
+		if err != nil {
+			log.Error("Could not fetch account for reauthentication. Details: ", err.Error())
+			return nil, ErrCouldNotFetchAccount
+		}
+
+		//password guesses with stolen token count towards the same lockout as logins
+		if err := checkAttempts(secAccount.Email, device.Ip); err != nil {
+			return nil, err
+		}
+
+		valid := false
+		if len(pass) > 0 {
+			if valid, err = encrypt.Validate(ctx, pass, secAccount.Password, secAccount.Salt); err != nil {
+				return nil, err
+			}
+		}
+
+		if !valid {
+			if err := attemptsService.RecordFailure(secAccount.Email, device.Ip); err != nil {
+				log.Error("Could not record failed reauthentication. Details: ", err.Error())
+			}
 			return nil, ErrBadCredentials
 		}
 
-		tokenStr, err := tokenService.GenerateToken(secAccount.Username, secAccount.Id)
+		if err := attemptsService.RecordSuccess(secAccount.Email, device.Ip); err != nil {
+			log.Error("Could not reset failed logins. Details: ", err.Error())
+		}
+
+		session, err := sessionsService.Reauthenticate(sid, []string{AmrPassword})
+		if err == sessions.ErrSessionNotFound {
+			return nil, ErrInvalidToken
+		}
+
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		tokenStr, err := generateToken(secAccount.PasswordlessAccount, session)
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		return &Token{
+			Token:     tokenStr,
+			ExpiresIn: int64(tokenService.AccessTokenLifetime.Seconds()),
+		}, nil
+	}
+
+	refresh := func(refreshToken string) (*Token, error) {
+
+		if len(refreshToken) == 0 {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		oldToken, newRefreshToken, err := refreshService.Rotate(refreshToken)
+		if err == refreshTokens.ErrInvalidRefreshToken || err == refreshTokens.ErrRefreshTokenReused {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
+		}
+
+		account, err := accountsDal.GetById(oldToken.AccountId)
+		if err != nil {
+			if err == accounts.ErrAccountNotFound {
+				return nil, ErrInvalidRefreshToken
+			}
+			log.Error("Could not fetch account for refresh. Details: ", err.Error())
+			return nil, ErrCouldNotFetchAccount
+		}
+
+		//account could have been disabled since login, token is built from current roles so demoted account loses them too
+		if account.Status != accounts.Confirmed {
+			if err := refreshService.RevokeFamily(oldToken.FamilyId); err != nil {
+				log.Error("Could not revoke refresh tokens of account which is not confirmed. Details: ", err.Error())
+			}
+			return nil, ErrInvalidRefreshToken
+		}
+
+		session, err := sessionsService.Touch(oldToken.FamilyId)
+		if err == sessions.ErrSessionNotFound {
+			//session was deleted, refresh token should have been revoked with it
+			return nil, ErrInvalidRefreshToken
+		}
+
 		if err != nil {
 			return nil, ErrCouldNotGenerateToken
 		}
 
-		token := Token{
-			Token: tokenStr,
+		tokenStr, err := generateToken(account, session)
+		if err != nil {
+			return nil, ErrCouldNotGenerateToken
 		}
 
-		log.Infof("Generated token is %s", token.Token)
-		return &token, nil
+		return &Token{
+			Token:        tokenStr,
+			RefreshToken: newRefreshToken,
+			ExpiresIn:    int64(tokenService.AccessTokenLifetime.Seconds()),
+		}, nil
+	}
+
+	logout := func(token string, refreshToken string) error {
+
+		sid, _ := tokenService.GetClaims(token)["sid"].(string)
+
+		if err := tokenService.Revoke(token); err != nil {
+			//token revoked by concurrent logout is no longer valid
+			if err == jwtTokens.ErrInvalidToken || err == jwtTokens.ErrTokenAlreadyUsed {
+				return ErrInvalidToken
+			}
+			log.Error("Could not revoke token. Details: ", err.Error())
+			return err
+		}
+
+		//refresh token is optional, logout should not fail when it is already gone
+		if len(refreshToken) > 0 {
+			if err := refreshService.Revoke(refreshToken); err != nil && err != refreshTokens.ErrInvalidRefreshToken {
+				log.Error("Could not revoke refresh token. Details: ", err.Error())
+				return err
+			}
+		}
+
+		if len(sid) > 0 {
+			if err := sessionsService.End(sid); err != nil {
+				log.Error("Could not end session. Details: ", err.Error())
+				return err
+			}
+		}
+
+		return nil
 	}
 
 	return Service{
-		login,
+		Login:                 login,
+		VerifyMfa:             verifyMfa,
+		ResendCode:            resendCode,
+		Recover:               recoverAccount,
+		ChangeExpiredPassword: changeExpiredPassword,
+		Refresh:               refresh,
+		IssueToken:            issueToken,
+		Complete:              complete,
+		Reauthenticate:        reauthenticate,
+		Logout:                logout,
 	}
 }
+
+func hasAmr(amr []string, method string) bool {
+
+	for _, value := range amr {
+		if value == method {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,41 @@
+package loginAttempts
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller with admin endpoints for lifting lockouts
+type Controller struct {
+	UnlockAccount func(c echo.Context) error
+	UnlockIp      func(c echo.Context) error
+}
+
+//Create lockout controller
+func Create(service Service) Controller {
+
+	unlockAccount := func(c echo.Context) error {
+
+		if err := service.Unlock(c.Param("id")); err != nil {
+			return web.LogAndReturnInternalError(c, "Could not unlock account", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	unlockIp := func(c echo.Context) error {
+
+		if err := service.UnlockIp(c.Param("ip")); err != nil {
+			return web.LogAndReturnInternalError(c, "Could not unlock ip", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return Controller{
+		UnlockAccount: unlockAccount,
+		UnlockIp:      unlockIp,
+	}
+}
@@ -0,0 +1,75 @@
+package loginAttempts
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+)
+
+//Dal for login attempts collection
+type Dal struct {
+	GetById func(id string) (Attempts, error)
+	//Increment atomically adds failure, so parallel attempts are all counted
+	Increment  func(id string, failedAt time.Time, expiresAt time.Time) (Attempts, error)
+	SetLimits  func(id string, nextAttemptAt time.Time, lockedUntil time.Time, expiresAt time.Time) error
+	DeleteById func(id string) error
+}
+
+//CreateDal wraps generic dal with login attempts specific operations
+func CreateDal(attemptsRepo dal.Dal) Dal {
+
+	var log = logging.MustGetLogger("[LoginAttemptsDal]")
+
+	if err := attemptsRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for login attempts. Details: ", err)
+	}
+
+	getById := func(id string) (Attempts, error) {
+
+		attempts := Attempts{}
+		err := attemptsRepo.GetById(id, &attempts)
+		return attempts, err
+	}
+
+	increment := func(id string, failedAt time.Time, expiresAt time.Time) (Attempts, error) {
+
+		err := attemptsRepo.UpsertByQuery(dal.NewQueryBuilder().WithId(id).Build(), map[string]interface{}{
+			"$inc": map[string]interface{}{"failures": 1},
+			"$set": map[string]interface{}{"lastFailureAt": failedAt, "expiresAt": expiresAt},
+		})
+
+		if err != nil {
+			return Attempts{}, err
+		}
+
+		return getById(id)
+	}
+
+	setLimits := func(id string, nextAttemptAt time.Time, lockedUntil time.Time, expiresAt time.Time) error {
+		return attemptsRepo.UpdateByQuery(dal.NewQueryBuilder().WithId(id).Build(), map[string]interface{}{
+			"$set": map[string]interface{}{
+				"nextAttemptAt": nextAttemptAt,
+				"lockedUntil":   lockedUntil,
+				"expiresAt":     expiresAt,
+			},
+		})
+	}
+
+	deleteById := func(id string) error {
+
+		err := attemptsRepo.DeleteById(id)
+		if err == dal.ErrNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return Dal{
+		GetById:    getById,
+		Increment:  increment,
+		SetLimits:  setLimits,
+		DeleteById: deleteById,
+	}
+}
@@ -0,0 +1,81 @@
+package loginAttempts
+
+import "time"
+
+//Attempts counts failed logins for account or source ip, it is removed by mongo after expiresAt
+type Attempts struct {
+	Id            string    `json:"id" bson:"_id"`
+	Failures      int       `json:"failures" bson:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt" bson:"lastFailureAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LockedUntil   time.Time `json:"lockedUntil" bson:"lockedUntil"`
+	ExpiresAt     time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+//Policy describes when login attempts are delayed or rejected
+type Policy struct {
+	//FreeAttempts can fail without any delay
+	FreeAttempts int
+	//BaseDelay is required after first failure above free ones, it doubles with every next failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	//MaxAttempts of failures locks account for LockoutDuration
+	MaxAttempts int
+	//IpMaxAttempts of failures from single ip, for any account, throttle that ip for LockoutDuration
+	IpMaxAttempts   int
+	LockoutDuration time.Duration
+	//Window after last failure in which failures are remembered
+	Window time.Duration
+}
+
+func (policy Policy) withDefaults() Policy {
+
+	if policy.FreeAttempts <= 0 {
+		policy.FreeAttempts = 3
+	}
+
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = time.Second
+	}
+
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = time.Second * 30
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 10
+	}
+
+	if policy.IpMaxAttempts <= 0 {
+		policy.IpMaxAttempts = 100
+	}
+
+	if policy.LockoutDuration <= 0 {
+		policy.LockoutDuration = time.Minute * 15
+	}
+
+	if policy.Window <= 0 {
+		policy.Window = time.Hour
+	}
+
+	return policy
+}
+
+//delay returns time which has to pass before next attempt after given number of failures
+func (policy Policy) delay(failures int) time.Duration {
+
+	if failures <= policy.FreeAttempts {
+		return 0
+	}
+
+	delay := policy.BaseDelay
+	for i := policy.FreeAttempts + 1; i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+
+	return delay
+}
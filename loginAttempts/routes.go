@@ -0,0 +1,20 @@
+package loginAttempts
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths, lockouts can be lifted only by admins
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	requireAdmin := security.RequireRole(accounts.RoleAdmin)
+
+	//id is login(email) for which attempts failed
+	echoEngine.OPTIONS("/accounts/:id/lockout", web.OptionsMethodHandler)
+	echoEngine.DELETE("/accounts/:id/lockout", controller.UnlockAccount, requireAdmin)
+	echoEngine.OPTIONS("/lockouts/ips/:ip", web.OptionsMethodHandler)
+	echoEngine.DELETE("/lockouts/ips/:ip", controller.UnlockIp, requireAdmin)
+}
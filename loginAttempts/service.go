@@ -0,0 +1,135 @@
+package loginAttempts
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrAccountLocked   = errors.New("Account is temporarily locked")
+	ErrTooManyAttempts = errors.New("Too many login attempts")
+	ErrCouldNotRecord  = errors.New("Could not record login attempt")
+)
+
+//Service tracks failed logins per account and per source ip
+type Service struct {
+	//Check returns error when attempt should be rejected without checking credentials and time after which it can be retried
+	Check         func(username string, ip string) (time.Duration, error)
+	RecordFailure func(username string, ip string) error
+	RecordSuccess func(username string, ip string) error
+	Unlock        func(username string) error
+	UnlockIp      func(ip string) error
+}
+
+//CreateService creates login attempts service, policy fields which are not set get defaults
+func CreateService(attemptsDal Dal, policy Policy) Service {
+
+	var log = logging.MustGetLogger("[LoginAttemptsService]")
+	policy = policy.withDefaults()
+
+	accountKey := func(username string) string {
+		return "account:" + strings.ToLower(username)
+	}
+
+	ipKey := func(ip string) string {
+		return "ip:" + ip
+	}
+
+	check := func(username string, ip string) (time.Duration, error) {
+
+		now := time.Now()
+		account, err := attemptsDal.GetById(accountKey(username))
+		if err != nil {
+			//store is unavailable, login is still allowed so users are not locked out by outage
+			log.Error("Could not fetch login attempts. Details: ", err)
+			return 0, nil
+		}
+
+		if account.LockedUntil.After(now) {
+			return account.LockedUntil.Sub(now), ErrAccountLocked
+		}
+
+		if account.NextAttemptAt.After(now) {
+			return account.NextAttemptAt.Sub(now), ErrTooManyAttempts
+		}
+
+		source, err := attemptsDal.GetById(ipKey(ip))
+		if err != nil {
+			log.Error("Could not fetch login attempts. Details: ", err)
+			return 0, nil
+		}
+
+		if source.LockedUntil.After(now) {
+			return source.LockedUntil.Sub(now), ErrTooManyAttempts
+		}
+
+		return 0, nil
+	}
+
+	record := func(id string, maxAttempts int, delayed bool) error {
+
+		now := time.Now()
+		attempts, err := attemptsDal.Increment(id, now, now.Add(policy.Window))
+		if err != nil {
+			log.Error("Could not record failed login. Details: ", err)
+			return ErrCouldNotRecord
+		}
+
+		var nextAttemptAt, lockedUntil time.Time
+		if attempts.Failures >= maxAttempts {
+			lockedUntil = now.Add(policy.LockoutDuration)
+		} else if delayed {
+			nextAttemptAt = now.Add(policy.delay(attempts.Failures))
+		}
+
+		expiresAt := now.Add(policy.Window)
+		if lockedUntil.After(expiresAt) {
+			expiresAt = lockedUntil
+		}
+
+		if err := attemptsDal.SetLimits(id, nextAttemptAt, lockedUntil, expiresAt); err != nil {
+			log.Error("Could not set login limits. Details: ", err)
+			return ErrCouldNotRecord
+		}
+
+		if !lockedUntil.IsZero() {
+			log.Warningf("Too many failed logins for %s, locked until %s", id, lockedUntil)
+		}
+
+		return nil
+	}
+
+	recordFailure := func(username string, ip string) error {
+
+		if err := record(accountKey(username), policy.MaxAttempts, true); err != nil {
+			return err
+		}
+
+		return record(ipKey(ip), policy.IpMaxAttempts, false)
+	}
+
+	//only account counter is reset, otherwise attacker could reset ip counter by logging into own account
+	recordSuccess := func(username string, ip string) error {
+		return attemptsDal.DeleteById(accountKey(username))
+	}
+
+	unlock := func(username string) error {
+		return attemptsDal.DeleteById(accountKey(username))
+	}
+
+	unlockIp := func(ip string) error {
+		return attemptsDal.DeleteById(ipKey(ip))
+	}
+
+	return Service{
+		Check:         check,
+		RecordFailure: recordFailure,
+		RecordSuccess: recordSuccess,
+		Unlock:        unlock,
+		UnlockIp:      unlockIp,
+	}
+}
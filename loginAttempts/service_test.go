@@ -0,0 +1,139 @@
+package loginAttempts
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func createInMemoryDal() (Dal, map[string]Attempts) {
+
+	attempts := map[string]Attempts{}
+
+	return Dal{
+		GetById: func(id string) (Attempts, error) {
+			return attempts[id], nil
+		},
+		Increment: func(id string, failedAt time.Time, expiresAt time.Time) (Attempts, error) {
+			current := attempts[id]
+			current.Id = id
+			current.Failures++
+			current.LastFailureAt = failedAt
+			current.ExpiresAt = expiresAt
+			attempts[id] = current
+			return current, nil
+		},
+		SetLimits: func(id string, nextAttemptAt time.Time, lockedUntil time.Time, expiresAt time.Time) error {
+			current := attempts[id]
+			current.NextAttemptAt = nextAttemptAt
+			current.LockedUntil = lockedUntil
+			current.ExpiresAt = expiresAt
+			attempts[id] = current
+			return nil
+		},
+		DeleteById: func(id string) error {
+			delete(attempts, id)
+			return nil
+		},
+	}, attempts
+}
+
+func TestService(t *testing.T) {
+
+	Convey("Login attempts service should", t, func() {
+
+		attemptsDal, attempts := createInMemoryDal()
+		service := CreateService(attemptsDal, Policy{
+			FreeAttempts:  2,
+			MaxAttempts:   4,
+			IpMaxAttempts: 6,
+		})
+
+		//delays are skipped, so next failures can be recorded immediately
+		skipDelay := func(id string) {
+			current := attempts[id]
+			current.NextAttemptAt = time.Time{}
+			attempts[id] = current
+		}
+
+		Convey("allow free attempts without delay", func() {
+
+			service.RecordFailure("John@test.com", "10.0.0.1")
+			service.RecordFailure("john@test.com", "10.0.0.1")
+
+			_, err := service.Check("john@test.com", "10.0.0.1")
+			So(err, ShouldBeNil)
+			So(attempts["account:john@test.com"].Failures, ShouldEqual, 2)
+		})
+
+		Convey("delay attempts after free ones", func() {
+
+			for i := 0; i < 3; i++ {
+				service.RecordFailure("john@test.com", "10.0.0.1")
+			}
+
+			retryAfter, err := service.Check("john@test.com", "10.0.0.1")
+			So(err, ShouldEqual, ErrTooManyAttempts)
+			So(retryAfter, ShouldBeGreaterThan, 0)
+			So(retryAfter, ShouldBeLessThanOrEqualTo, time.Second)
+		})
+
+		Convey("lock account after max attempts", func() {
+
+			for i := 0; i < 4; i++ {
+				service.RecordFailure("john@test.com", "10.0.0.1")
+				skipDelay("account:john@test.com")
+			}
+
+			retryAfter, err := service.Check("john@test.com", "10.0.0.2")
+			So(err, ShouldEqual, ErrAccountLocked)
+			So(retryAfter, ShouldBeGreaterThan, time.Minute*14)
+
+			Convey("until admin unlocks it", func() {
+				So(service.Unlock("john@test.com"), ShouldBeNil)
+				_, err := service.Check("john@test.com", "10.0.0.2")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("throttle ip which fails for many accounts", func() {
+
+			for i := 0; i < 6; i++ {
+				service.RecordFailure(string(rune('a'+i))+"@test.com", "10.0.0.1")
+			}
+
+			_, err := service.Check("other@test.com", "10.0.0.1")
+			So(err, ShouldEqual, ErrTooManyAttempts)
+
+			_, err = service.Check("other@test.com", "10.0.0.2")
+			So(err, ShouldBeNil)
+
+			So(service.UnlockIp("10.0.0.1"), ShouldBeNil)
+			_, err = service.Check("other@test.com", "10.0.0.1")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("reset account counter after successful login", func() {
+
+			service.RecordFailure("john@test.com", "10.0.0.1")
+			So(service.RecordSuccess("john@test.com", "10.0.0.1"), ShouldBeNil)
+
+			So(attempts, ShouldNotContainKey, "account:john@test.com")
+			So(attempts["ip:10.0.0.1"].Failures, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Policy delay should", t, func() {
+
+		policy := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Second * 5}.withDefaults()
+
+		Convey("double for every failure up to max", func() {
+			So(policy.delay(3), ShouldEqual, 0)
+			So(policy.delay(4), ShouldEqual, time.Second)
+			So(policy.delay(5), ShouldEqual, time.Second*2)
+			So(policy.delay(6), ShouldEqual, time.Second*4)
+			So(policy.delay(7), ShouldEqual, time.Second*5)
+			So(policy.delay(50), ShouldEqual, time.Second*5)
+		})
+	})
+}
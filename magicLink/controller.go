@@ -0,0 +1,72 @@
+package magicLink
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for magic link endpoints
+type Controller struct {
+	SendLink func(c echo.Context) error
+	Verify   func(c echo.Context) error
+}
+
+//Create magic link controller
+func Create(service Service) Controller {
+
+	var log = logging.MustGetLogger("[MagicLinkController]")
+
+	sendLink := func(c echo.Context) error {
+
+		dto := RequestLinkDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if validationErrors := dto.validate(); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid payload", validationErrors)
+		}
+
+		//response is the same for every email and link is sent in background, so neither response nor its time reveal accounts,
+		//failures are only logged
+		go func(email string) {
+			if err := service.SendLink(email); err != nil {
+				log.Error("Could not send magic link. Details: ", err.Error())
+			}
+		}(dto.Email)
+
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	verify := func(c echo.Context) error {
+
+		dto := VerifyLinkDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if validationErrors := dto.validate(); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid payload", validationErrors)
+		}
+
+		token, err := service.Verify(dto.Code, login.GetDevice(c))
+		if err == ErrInvalidLink {
+			return web.UnauthorizedResponse(c, err.Error())
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not login with magic link", err)
+		}
+
+		return c.JSON(http.StatusOK, token)
+	}
+
+	return Controller{
+		SendLink: sendLink,
+		Verify:   verify,
+	}
+}
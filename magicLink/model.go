@@ -0,0 +1,33 @@
+package magicLink
+
+import (
+	e "github.com/piotrjaromin/go-login-backend/web"
+)
+
+//RequestLinkDto is payload of magic link request
+type RequestLinkDto struct {
+	Email string `json:"email"`
+}
+
+//VerifyLinkDto carries code taken from magic link
+type VerifyLinkDto struct {
+	Code string `json:"code"`
+}
+
+func (dto RequestLinkDto) validate() (errors []e.ErrorDetails) {
+
+	if len(dto.Email) == 0 {
+		errors = e.AppendErrorDetails(errors, "email", "email is required", e.MissingField)
+	}
+
+	return
+}
+
+func (dto VerifyLinkDto) validate() (errors []e.ErrorDetails) {
+
+	if len(dto.Code) == 0 {
+		errors = e.AppendErrorDetails(errors, "code", "code is required", e.MissingField)
+	}
+
+	return
+}
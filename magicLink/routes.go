@@ -0,0 +1,15 @@
+package magicLink
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller) {
+
+	echoEngine.OPTIONS("/login/magic-link", web.OptionsMethodHandler)
+	echoEngine.POST("/login/magic-link", controller.SendLink)
+	echoEngine.OPTIONS("/login/magic-link/verify", web.OptionsMethodHandler)
+	echoEngine.POST("/login/magic-link/verify", controller.Verify)
+}
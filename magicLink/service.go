@@ -0,0 +1,124 @@
+package magicLink
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/email"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrInvalidLink = errors.New("Invalid or expired login link")
+)
+
+//LinkLifetime is time user has for clicking the link
+const LinkLifetime = time.Minute * 15
+
+//link is jwt without sub and username, so it cannot be used as access token
+const (
+	tokenUseClaim     = "token_use"
+	tokenUseMagicLink = "magic_link"
+	linkAccountClaim  = "link_account"
+	linkEmailClaim    = "link_email"
+)
+
+//Service for passwordless login with link sent by email
+type Service struct {
+	//SendLink does not tell whether email belongs to any account, it takes longer for accounts though, so it should not be awaited by request
+	SendLink func(email string) error
+	Verify   func(code string, device sessions.Device) (*login.Token, error)
+}
+
+//CreateService creates magic link service, links point to frontendUrl
+func CreateService(frontendUrl string, accountsDal accounts.Dal, emailService email.EmailService, tokenService jwtTokens.TokenService, loginService login.Service) Service {
+
+	var log = logging.MustGetLogger("[MagicLinkService]")
+	templates := emailService.Templates()
+
+	sendLink := func(email string) error {
+
+		account, err := accountsDal.GetByEmail(email)
+		if err == accounts.ErrAccountNotFound {
+			log.Debugf("Magic link requested for unknown email %s", email)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if account.Status != accounts.Confirmed {
+			log.Debugf("Magic link requested for not confirmed account %s", account.Id)
+			return nil
+		}
+
+		code, err := tokenService.Sign(map[string]interface{}{
+			tokenUseClaim:    tokenUseMagicLink,
+			linkAccountClaim: account.Id,
+			linkEmailClaim:   account.Email,
+		}, LinkLifetime)
+
+		if err != nil {
+			return err
+		}
+
+		data := struct {
+			Name    string
+			Code    string
+			Url     string
+			Minutes int
+		}{
+			account.FirstName, code, frontendUrl, int(LinkLifetime.Minutes()),
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "magic_link.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(account.Email, buf.String(), "Your login link")
+	}
+
+	verify := func(code string, device sessions.Device) (*login.Token, error) {
+
+		claims := tokenService.GetClaims(code)
+		accountId, _ := claims[linkAccountClaim].(string)
+		if claims[tokenUseClaim] != tokenUseMagicLink || len(accountId) == 0 {
+			return nil, ErrInvalidLink
+		}
+
+		//link can be used only once
+		if err := tokenService.Revoke(code); err != nil {
+			log.Error("Could not revoke magic link. Details: ", err.Error())
+			return nil, ErrInvalidLink
+		}
+
+		account, err := accountsDal.GetById(accountId)
+		if err == accounts.ErrAccountNotFound {
+			return nil, ErrInvalidLink
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		//link sent to previous address stops working when email changes
+		if claims[linkEmailClaim] != account.Email || account.Status != accounts.Confirmed {
+			return nil, ErrInvalidLink
+		}
+
+		return loginService.Complete(account, device, []string{login.AmrEmailLink})
+	}
+
+	return Service{
+		SendLink: sendLink,
+		Verify:   verify,
+	}
+}
@@ -0,0 +1,134 @@
+package magicLink
+
+import (
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	. "github.com/smartystreets/goconvey/convey"
+	"html/template"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type sentEmail struct {
+	to      string
+	content string
+}
+
+type testMail struct {
+	sent *[]sentEmail
+}
+
+func (t testMail) SendEmail(mail string, content string, subject string) error {
+	*t.sent = append(*t.sent, sentEmail{mail, content})
+	return nil
+}
+
+func (t testMail) Templates() *template.Template {
+	return template.Must(template.New("magic_link.html").Parse("code={{.Code}}"))
+}
+
+func TestService(t *testing.T) {
+
+	testAccounts := []accounts.PasswordlessAccount{
+		{Id: "johnId", Username: "john", Email: "john@test.com", Status: accounts.Confirmed},
+		{Id: "pendingId", Username: "pending", Email: "pending@test.com", Status: accounts.Pending},
+	}
+
+	Convey("Magic link service should", t, func() {
+
+		sent := []sentEmail{}
+		revoked := map[string]bool{}
+
+		accountsDal := accounts.Dal{
+			GetByEmail: func(email string) (accounts.PasswordlessAccount, error) {
+				for _, account := range testAccounts {
+					if account.Email == email {
+						return account, nil
+					}
+				}
+				return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+			},
+			GetById: func(id string) (accounts.PasswordlessAccount, error) {
+				for _, account := range testAccounts {
+					if account.Id == id {
+						return account, nil
+					}
+				}
+				return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+			},
+		}
+
+		tokenService := jwtTokens.Create(jwtTokens.CreateHMACKeySet("secret"), jwtTokens.RevocationStore{
+			Revoke: func(jti string, expiresAt time.Time) error {
+				revoked[jti] = true
+				return nil
+			},
+			IsRevoked: func(ids ...string) bool {
+				for _, id := range ids {
+					if revoked[id] {
+						return true
+					}
+				}
+				return false
+			},
+		}, jwtTokens.TokenConfig{})
+
+		loginService := login.Service{
+			Complete: func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*login.Token, error) {
+				return &login.Token{Token: "token-" + account.Id}, nil
+			},
+		}
+
+		service := CreateService("http://frontend", accountsDal, testMail{&sent}, tokenService, loginService)
+
+		codeFromEmail := func() string {
+			return regexp.MustCompile("code=(.*)").FindStringSubmatch(sent[len(sent)-1].content)[1]
+		}
+
+		Convey("send link which logs in once", func() {
+
+			So(service.SendLink("john@test.com"), ShouldBeNil)
+			So(sent, ShouldHaveLength, 1)
+			So(sent[0].to, ShouldEqual, "john@test.com")
+
+			code := codeFromEmail()
+			token, err := service.Verify(code, sessions.Device{})
+			So(err, ShouldBeNil)
+			So(token.Token, ShouldEqual, "token-johnId")
+
+			_, err = service.Verify(code, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidLink)
+		})
+
+		Convey("silently skip unknown and not confirmed accounts", func() {
+
+			So(service.SendLink("unknown@test.com"), ShouldBeNil)
+			So(service.SendLink("pending@test.com"), ShouldBeNil)
+			So(sent, ShouldBeEmpty)
+		})
+
+		Convey("not accept other tokens as link", func() {
+
+			accessToken, _ := tokenService.GenerateToken(jwtTokens.AccountClaims{AccountId: "johnId", Username: "john"})
+
+			_, err := service.Verify(accessToken, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidLink)
+
+			_, err = service.Verify("garbage", sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidLink)
+		})
+
+		Convey("reject link sent to previous email of account", func() {
+
+			service.SendLink("john@test.com")
+			testAccounts[0].Email = "new@test.com"
+			defer func() { testAccounts[0].Email = "john@test.com" }()
+
+			_, err := service.Verify(codeFromEmail(), sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidLink)
+		})
+	})
+}
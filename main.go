@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"time"
+
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/op/go-logging"
 
 	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/apiKeys"
 	"github.com/piotrjaromin/go-login-backend/config"
 	"github.com/piotrjaromin/go-login-backend/dal"
 	"github.com/piotrjaromin/go-login-backend/email"
-	"github.com/piotrjaromin/go-login-backend/fbLogin"
 	"github.com/piotrjaromin/go-login-backend/jwtTokens"
 	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/loginAttempts"
+	"github.com/piotrjaromin/go-login-backend/magicLink"
+	"github.com/piotrjaromin/go-login-backend/mfa"
+	"github.com/piotrjaromin/go-login-backend/oauth"
+	"github.com/piotrjaromin/go-login-backend/refreshTokens"
 	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	"github.com/piotrjaromin/go-login-backend/socialLogin"
+	"github.com/piotrjaromin/go-login-backend/webauthn"
 )
 
 var log = logging.MustGetLogger("[Main]")
@@ -30,10 +43,10 @@ func main() {
 	headers := func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			c.Response().Header().Add("Content-type", "application/json")
-			c.Response().Header().Add("Allow", "GET,POST,HEAD,OPTIONS,PUT")
-			c.Response().Header().Add("Access-Control-Allow-Methods", "GET,POST,HEAD,OPTIONS,PUT")
+			c.Response().Header().Add("Allow", "GET,POST,HEAD,OPTIONS,PUT,DELETE")
+			c.Response().Header().Add("Access-Control-Allow-Methods", "GET,POST,HEAD,OPTIONS,PUT,DELETE")
 			c.Response().Header().Add("Access-Control-Allow-Origin", "*")
-			c.Response().Header().Add("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+			c.Response().Header().Add("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With, X-API-Key")
 			c.Response().Header().Add("Access-Control-Max-Age", "3600")
 			return next(c)
 		}
@@ -41,37 +54,124 @@ func main() {
 
 	e.Use(headers)
 
-	tokenService := jwtTokens.Create(conf.Token.SiginKey)
-	security := security.CreateSecurity(tokenService)
+	revocationStore := jwtTokens.CreateRevocationStore(getCollection("revokedTokens", conf))
+	keySet := getKeySet(conf)
+	tokenService := jwtTokens.CreateWithFormat(getTokenFormat(conf, keySet), keySet, revocationStore, jwtTokens.TokenConfig{
+		Issuer:              conf.Token.Issuer,
+		Audience:            conf.Token.Audience,
+		AccessTokenLifetime: time.Duration(conf.Token.AccessTokenLifetime) * time.Second,
+		Leeway:              time.Duration(conf.Token.Leeway) * time.Second,
+		ResourceAudiences:   conf.Token.ResourceAudiences,
+	})
+	security := security.CreateSecurity(tokenService).WithMaxAuthAge(time.Duration(conf.Token.MaxAuthAge) * time.Second)
+	jwtTokens.InitRoutes(e, jwtTokens.CreateController(tokenService))
 
 	//Accounts endpoints
 	accDal := accounts.CreateDal(getCollection("accounts", conf))
+
+	//Api keys are accepted by security, so it has to be extended before any route is secured
+	apiKeysService := apiKeys.CreateService(apiKeys.CreateDal(getCollection("apiKeys", conf)), accDal)
+	security = security.WithApiKeys(apiKeysService.Claims)
+	apiKeys.InitRoutes(e, apiKeys.Create(apiKeysService), security)
+
 	singupDal := getCollection("signups", conf)
 	emailService, emailErr := email.Create(conf.Email.AwsRegion, conf.Email.ReplyAddr)
 	if emailErr != nil {
 		panic("Could not creat email service. Details: " + emailErr.Error())
 	}
 
-	encrypt := accounts.CreateEncrypt()
+	encrypt, encryptErr := accounts.CreateEncrypt(getHashingPolicy(conf))
+	if encryptErr != nil {
+		panic("Could not create password hashing. Details: " + encryptErr.Error())
+	}
+
 	accService := accounts.CreateService(conf, accDal, singupDal, emailService, encrypt)
-	accController := accounts.Create(accService)
-	accounts.InitRoutes(e, accController, security)
 
 	//Login endpoints
-	loginService := login.CreateService(accDal, encrypt, tokenService)
+	refreshDal := refreshTokens.CreateDal(getCollection("refreshTokens", conf))
+	refreshLifetime := refreshTokens.DefaultLifetime
+	if conf.Token.RefreshTokenLifetime > 0 {
+		refreshLifetime = time.Duration(conf.Token.RefreshTokenLifetime) * time.Second
+	}
+	refreshMaxLifetime := refreshTokens.DefaultMaxLifetime
+	if conf.Token.RefreshTokenMaxLifetime > 0 {
+		refreshMaxLifetime = time.Duration(conf.Token.RefreshTokenMaxLifetime) * time.Second
+	}
+	refreshService := refreshTokens.CreateService(refreshDal, refreshLifetime, refreshMaxLifetime)
+
+	//Sessions live as long as their refresh tokens
+	sessionsDal := sessions.CreateDal(getCollection("sessions", conf))
+	sessionsService := sessions.CreateService(sessionsDal, tokenService, refreshService, refreshLifetime)
+	sessionsController := sessions.Create(sessionsService)
+	sessions.InitRoutes(e, sessionsController, security)
+
+	//Failed logins are throttled per account and per ip
+	attemptsService := loginAttempts.CreateService(loginAttempts.CreateDal(getCollection("loginAttempts", conf)), loginAttempts.Policy{
+		FreeAttempts:    conf.Lockout.FreeAttempts,
+		MaxAttempts:     conf.Lockout.MaxAttempts,
+		IpMaxAttempts:   conf.Lockout.IpMaxAttempts,
+		LockoutDuration: time.Duration(conf.Lockout.LockoutDuration) * time.Second,
+		Window:          time.Duration(conf.Lockout.Window) * time.Second,
+	})
+	loginAttempts.InitRoutes(e, loginAttempts.Create(attemptsService), security)
+
+	//Second factors
+	mfaService := mfa.CreateService(mfa.CreateDal(getCollection("mfa", conf)), accDal, conf.Mfa.Issuer, emailService, attemptsService)
+	mfa.InitRoutes(e, mfa.Create(mfaService), security)
+
+	loginService := login.CreateService(accDal, encrypt, tokenService, refreshService, sessionsService, attemptsService, mfaService, accService)
 	loginController := login.Create(loginService)
 	login.InitRoutes(e, loginController)
 
-	//Fb login endpoints
-	fbLoginService := fbLogin.CreateService(fbLogin.FbConfig{
-		ClientID:     conf.Fb.ClientID,
-		ClientSecret: conf.Fb.ClientSecret,
-	}, accDal, accService, tokenService)
+	//Login with google, github, apple, microsoft, facebook or other OpenID Connect providers
+	socialLoginService := socialLogin.CreateService(getSocialLoginProviders(conf), conf.SocialLogin.RedirectUris, conf.FrontendURL, accDal, accService, mfaService, tokenService, loginService)
+	socialLogin.InitRoutes(e, socialLogin.Create(socialLoginService))
+
+	//Passwordless login with link sent by email
+	magicLinkService := magicLink.CreateService(conf.FrontendURL, accDal, emailService, tokenService, loginService)
+	magicLink.InitRoutes(e, magicLink.Create(magicLinkService))
+
+	//Passkeys
+	webauthnService := webauthn.CreateService(
+		webauthn.CreateConfig(conf.Webauthn.RpId, conf.Webauthn.RpName, conf.Webauthn.Origins, conf.FrontendURL),
+		webauthn.CreateCredentialsDal(getCollection("webauthnCredentials", conf)),
+		webauthn.CreateChallengesDal(getCollection("webauthnChallenges", conf)),
+		accDal, loginService)
+	webauthn.InitRoutes(e, webauthn.Create(webauthnService), security)
+
+	//OpenID Connect provider endpoints
+	issuer := conf.Token.Issuer
+	if len(issuer) == 0 {
+		issuer = conf.Host
+	}
+
+	clientsDal := oauth.CreateClientsDal(getCollection("clients", conf))
+	codesDal := oauth.CreateCodesDal(getCollection("authorizationCodes", conf))
+	deviceGrantsDal := oauth.CreateDeviceGrantsDal(getCollection("deviceGrants", conf))
+	exchangesDal := oauth.CreateExchangesDal(getCollection("tokenExchanges", conf))
+	oauthService := oauth.CreateService(oauth.ProviderConfig{
+		Issuer:          issuer,
+		BaseUrl:         conf.Host,
+		VerificationUri: conf.FrontendURL + "/device",
+	}, clientsDal, codesDal, deviceGrantsDal, exchangesDal, accDal, tokenService, encrypt)
+	oauthController := oauth.Create(oauthService, conf.FrontendURL)
+	oauth.InitRoutes(e, oauthController, security)
 
-	fbLoginController := fbLogin.Create(fbLoginService)
-	fbLogin.InitRoutes(e, fbLoginController)
+	//Accounts endpoints are added last, deleted account leaves nothing behind in other modules
+	accService = accService.WithCleanups(
+		func(account accounts.PasswordlessAccount) error { return tokenService.RevokeSubject(account.Id) },
+		func(account accounts.PasswordlessAccount) error { return sessionsService.RevokeAll(account.Username) },
+		func(account accounts.PasswordlessAccount) error { return refreshService.RevokeAccount(account.Id) },
+		func(account accounts.PasswordlessAccount) error { return apiKeysService.DeleteAll(account.Username) },
+		func(account accounts.PasswordlessAccount) error { return oauthService.DeleteClients(account.Username) },
+		func(account accounts.PasswordlessAccount) error { return webauthnService.DeleteAll(account.Id) },
+		func(account accounts.PasswordlessAccount) error { return mfaService.Reset(account.Id) },
+	)
+	accController := accounts.Create(accService)
+	accounts.InitRoutes(e, accController, security)
 
 	createAccount(accService)
+	registerClients(clientsDal, conf)
 
 	log.Info("Starting to listen")
 	// error := e.Run(standard.New(":8080"))
@@ -92,6 +192,144 @@ func getCollection(collection string, conf config.Config) dal.Dal {
 	return dal.Create(config)
 }
 
+//getKeySet loads asymmetric keys when configured, otherwise shared secret is used
+func getKeySet(conf config.Config) jwtTokens.KeySet {
+
+	if len(conf.Token.Keys) == 0 {
+		return jwtTokens.CreateHMACKeySet(conf.Token.SiginKey)
+	}
+
+	keyConfigs := make([]jwtTokens.KeyConfig, 0, len(conf.Token.Keys))
+	for _, key := range conf.Token.Keys {
+		keyConfigs = append(keyConfigs, jwtTokens.KeyConfig{
+			Kid:            key.Kid,
+			Alg:            key.Alg,
+			PrivateKeyFile: key.PrivateKeyFile,
+			PublicKeyFile:  key.PublicKeyFile,
+		})
+	}
+
+	keySet, err := jwtTokens.LoadKeySet(keyConfigs)
+	if err != nil {
+		panic("Could not load token signing keys. Details: " + err.Error())
+	}
+
+	return keySet
+}
+
+//getTokenFormat picks format of access tokens, jwt signed with keySet is used when none is configured
+func getTokenFormat(conf config.Config, keySet jwtTokens.KeySet) jwtTokens.Format {
+
+	var format jwtTokens.Format
+	var err error
+
+	switch conf.Token.Format {
+	case "", jwtTokens.FormatJwt:
+		return jwtTokens.CreateJwtFormat(keySet)
+	case jwtTokens.FormatPasetoLocal, jwtTokens.FormatJwe:
+		key, decodeErr := base64.StdEncoding.DecodeString(conf.Token.FormatKey)
+		if decodeErr != nil {
+			panic("Could not decode token format key. Details: " + decodeErr.Error())
+		}
+
+		if conf.Token.Format == jwtTokens.FormatJwe {
+			format, err = jwtTokens.CreateJweFormat(key)
+		} else {
+			format, err = jwtTokens.CreatePasetoLocalFormat(key)
+		}
+	case jwtTokens.FormatPasetoPublic:
+		format, err = jwtTokens.LoadPasetoPublicFormat(conf.Token.FormatKeyFile)
+	default:
+		panic("Unknown token format " + conf.Token.Format)
+	}
+
+	if err != nil {
+		panic("Could not create token format. Details: " + err.Error())
+	}
+
+	return format
+}
+
+//getHashingPolicy reads password hashing policy, pepper is kept out of config file when set in environment
+func getHashingPolicy(conf config.Config) accounts.HashingPolicy {
+
+	pepper, err := base64.StdEncoding.DecodeString(config.GetEnvOrDefault("PASSWORD_PEPPER", conf.Passwords.Pepper))
+	if err != nil {
+		panic("Could not decode password pepper. Details: " + err.Error())
+	}
+
+	return accounts.HashingPolicy{
+		Algorithm:         conf.Passwords.Algorithm,
+		Argon2Memory:      uint32(conf.Passwords.Argon2Memory),
+		Argon2Iterations:  uint32(conf.Passwords.Argon2Iterations),
+		Argon2Parallelism: uint8(conf.Passwords.Argon2Parallelism),
+		BcryptCost:        conf.Passwords.BcryptCost,
+		ScryptCost:        conf.Passwords.ScryptCost,
+		Pepper:            pepper,
+		Pool: accounts.HashingPoolConfig{
+			Workers:     conf.Passwords.Workers,
+			QueueDepth:  conf.Passwords.QueueDepth,
+			WaitTimeout: time.Duration(conf.Passwords.WaitTimeoutMs) * time.Millisecond,
+			RetryAfter:  time.Duration(conf.Passwords.RetryAfter) * time.Second,
+		},
+	}
+}
+
+//getSocialLoginProviders creates configured providers, facebook app from fb config is added when it is not listed
+func getSocialLoginProviders(conf config.Config) []socialLogin.Provider {
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	providers := []socialLogin.Provider{}
+	hasFacebook := false
+	for _, provider := range conf.SocialLogin.Providers {
+		hasFacebook = hasFacebook || provider.Name == socialLogin.Facebook
+		providers = append(providers, socialLogin.CreateProvider(socialLogin.ProviderConfig{
+			Name:                  provider.Name,
+			ClientId:              provider.ClientId,
+			ClientSecret:          provider.ClientSecret,
+			AuthorizationEndpoint: provider.AuthorizationEndpoint,
+			TokenEndpoint:         provider.TokenEndpoint,
+			UserInfoEndpoint:      provider.UserInfoEndpoint,
+			JwksUri:               provider.JwksUri,
+			Issuers:               provider.Issuers,
+			Scopes:                provider.Scopes,
+			AuthorizationParams:   provider.AuthorizationParams,
+			Claims:                socialLogin.ClaimMapping(provider.Claims),
+			TrustEmail:            provider.TrustEmail,
+		}, client))
+	}
+
+	if !hasFacebook && len(conf.Fb.ClientID) > 0 {
+		providers = append(providers, socialLogin.CreateProvider(socialLogin.ProviderConfig{
+			Name:         socialLogin.Facebook,
+			ClientId:     conf.Fb.ClientID,
+			ClientSecret: conf.Fb.ClientSecret,
+		}, client))
+	}
+
+	return providers
+}
+
+//registerClients stores clients from configuration, changes in config overwrite stored ones
+func registerClients(clientsDal oauth.ClientsDal, conf config.Config) {
+
+	for _, client := range conf.OAuth.Clients {
+		log.Info("Registering client ", client.ClientID)
+
+		err := clientsDal.Save(oauth.Client{
+			Id:           client.ClientID,
+			Name:         client.Name,
+			RedirectUris: client.RedirectURIs,
+			GrantTypes:   client.GrantTypes,
+		})
+
+		if err != nil {
+			log.Error("Could not register client. Details: ", err)
+		}
+	}
+}
+
 func createAccount(accService accounts.Service) {
 
 	const email = "test@test.com"
@@ -102,7 +340,7 @@ func createAccount(accService accounts.Service) {
 	if err != nil && err == accounts.ErrAccountNotFound {
 		log.Info("Creating test account")
 
-		accService.CreateAccount(email, accounts.SecuredAccount{
+		accService.CreateAccount(context.Background(), email, accounts.SecuredAccount{
 			Account: accounts.Account{
 				Password: "test",
 				PasswordlessAccount: accounts.PasswordlessAccount{
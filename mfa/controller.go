@@ -0,0 +1,114 @@
+package mfa
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for managing second factors of account
+type Controller struct {
+	GetStatus           func(c echo.Context) error
+	StartTotpEnrollment func(c echo.Context) error
+	ConfirmTotp         func(c echo.Context) error
+	DisableTotp         func(c echo.Context) error
+	EnableEmail         func(c echo.Context) error
+	DisableEmail        func(c echo.Context) error
+}
+
+//Create mfa controller
+func Create(service Service) Controller {
+
+	handleError := func(c echo.Context, msg string, err error) error {
+
+		switch err {
+		case accounts.ErrAccountNotFound:
+			return web.NotFoundResponse(c)
+		case ErrInvalidCode, ErrNoPendingEnrollment, ErrNotEnabled:
+			return web.BadRequestResponse(c, err.Error())
+		case ErrAlreadyEnabled:
+			return web.ConflictResponse(c, err.Error())
+		case ErrTooManyAttempts:
+			return web.TooManyRequestsResponse(c, err.Error())
+		}
+
+		return web.LogAndReturnInternalError(c, msg, err)
+	}
+
+	getStatus := func(c echo.Context) error {
+
+		status, err := service.Status(c.Param("id"))
+		if err != nil {
+			return handleError(c, "Could not fetch mfa status", err)
+		}
+
+		return c.JSON(http.StatusOK, status)
+	}
+
+	startTotpEnrollment := func(c echo.Context) error {
+
+		enrollment, err := service.StartTotpEnrollment(c.Param("id"))
+		if err != nil {
+			return handleError(c, "Could not start totp enrollment", err)
+		}
+
+		return web.CreatedResponse(c, enrollment)
+	}
+
+	confirmTotp := func(c echo.Context) error {
+
+		dto := CodeDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if err := service.ConfirmTotp(c.Param("id"), dto.Code); err != nil {
+			return handleError(c, "Could not confirm totp enrollment", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	disableTotp := func(c echo.Context) error {
+
+		dto := CodeDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if err := service.DisableTotp(c.Param("id"), dto.Code, c.RealIP()); err != nil {
+			return handleError(c, "Could not disable totp", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	enableEmail := func(c echo.Context) error {
+
+		if err := service.EnableEmail(c.Param("id")); err != nil {
+			return handleError(c, "Could not enable email codes", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	disableEmail := func(c echo.Context) error {
+
+		if err := service.DisableEmail(c.Param("id")); err != nil {
+			return handleError(c, "Could not disable email codes", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return Controller{
+		GetStatus:           getStatus,
+		StartTotpEnrollment: startTotpEnrollment,
+		ConfirmTotp:         confirmTotp,
+		DisableTotp:         disableTotp,
+		EnableEmail:         enableEmail,
+		DisableEmail:        disableEmail,
+	}
+}
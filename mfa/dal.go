@@ -0,0 +1,104 @@
+package mfa
+
+import (
+	"github.com/piotrjaromin/go-login-backend/dal"
+)
+
+//Dal for mfa settings collection
+type Dal struct {
+	//GetById returns empty settings for account which never enrolled
+	GetById func(accountId string) (Settings, error)
+	Save    func(settings Settings) error
+	//MarkTotpUsed stores step of used code, it fails when newer step was already used
+	MarkTotpUsed func(accountId string, step int64) error
+	//AddEmailAttempt counts attempt of verifying email code with given hash, it fails when code has no attempts left
+	AddEmailAttempt func(accountId string, codeHash string) error
+	//UseEmailCode drops email code with given hash, it fails when code was already used
+	UseEmailCode func(accountId string, codeHash string) error
+	//DeleteById removes all second factors of account, it does not fail for account which never enrolled
+	DeleteById func(accountId string) error
+}
+
+//CreateDal wraps generic dal with mfa specific operations
+func CreateDal(settingsRepo dal.Dal) Dal {
+
+	getById := func(accountId string) (Settings, error) {
+
+		settings := Settings{}
+		if err := settingsRepo.GetById(accountId, &settings); err != nil {
+			return settings, err
+		}
+
+		settings.Id = accountId
+		return settings, nil
+	}
+
+	save := func(settings Settings) error {
+		return settingsRepo.Upsert(settings.Id, settings)
+	}
+
+	markTotpUsed := func(accountId string, step int64) error {
+
+		query := dal.NewQueryBuilder().WithId(accountId).WithField("totp.lastUsedStep", map[string]interface{}{"$lt": step}).Build()
+		err := settingsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"totp.lastUsedStep": step},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrInvalidCode
+		}
+
+		return err
+	}
+
+	addEmailAttempt := func(accountId string, codeHash string) error {
+
+		query := dal.NewQueryBuilder().WithId(accountId).
+			WithField("email.codeHash", codeHash).
+			WithField("email.attempts", map[string]interface{}{"$lt": EmailCodeMaxAttempts}).
+			Build()
+
+		err := settingsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$inc": map[string]interface{}{"email.attempts": 1},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrInvalidCode
+		}
+
+		return err
+	}
+
+	useEmailCode := func(accountId string, codeHash string) error {
+
+		query := dal.NewQueryBuilder().WithId(accountId).WithField("email.codeHash", codeHash).Build()
+		err := settingsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"email.codeHash": ""},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrInvalidCode
+		}
+
+		return err
+	}
+
+	deleteById := func(accountId string) error {
+
+		err := settingsRepo.DeleteById(accountId)
+		if err == dal.ErrNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return Dal{
+		GetById:         getById,
+		Save:            save,
+		MarkTotpUsed:    markTotpUsed,
+		AddEmailAttempt: addEmailAttempt,
+		UseEmailCode:    useEmailCode,
+		DeleteById:      deleteById,
+	}
+}
@@ -0,0 +1,47 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+//Email code parameters, codes are short so they expire quickly and allow only few attempts
+const (
+	emailCodeDigits = 6
+	//EmailCodeLifetime is time user has for typing code from email
+	EmailCodeLifetime = time.Minute * 10
+	//EmailCodeMaxAttempts after which code is dropped and new one has to be sent
+	EmailCodeMaxAttempts = 5
+	//EmailCodeResendInterval is minimal time between two emails with code
+	EmailCodeResendInterval = time.Minute
+)
+
+func generateEmailCode() (string, error) {
+
+	max := big.NewInt(1)
+	for i := 0; i < emailCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	value, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", emailCodeDigits, value), nil
+}
+
+//hashEmailCode binds code to account, so hash copied between accounts is useless
+func hashEmailCode(accountId string, code string) string {
+	sum := sha256.Sum256([]byte(accountId + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (otp EmailOtp) matches(accountId string, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(hashEmailCode(accountId, code))) == 1
+}
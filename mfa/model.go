@@ -0,0 +1,70 @@
+package mfa
+
+import "time"
+
+//Second factor methods
+const (
+	MethodTotp  = "totp"
+	MethodEmail = "email"
+)
+
+//Settings of second factors for single account
+type Settings struct {
+	Id       string   `bson:"_id"`
+	Username string   `bson:"username"`
+	Totp     Totp     `bson:"totp"`
+	Email    EmailOtp `bson:"email"`
+}
+
+//Totp is enabled only after user confirms enrollment with first code
+type Totp struct {
+	Secret        string    `bson:"secret"`
+	PendingSecret string    `bson:"pendingSecret"`
+	Enabled       bool      `bson:"enabled"`
+	EnabledAt     time.Time `bson:"enabledAt"`
+	//LastUsedStep prevents the same code from being used twice
+	LastUsedStep int64 `bson:"lastUsedStep"`
+}
+
+//EmailOtp sends one time code to account email at login, only last sent code is kept
+type EmailOtp struct {
+	Enabled   bool      `bson:"enabled"`
+	EnabledAt time.Time `bson:"enabledAt"`
+	CodeHash  string    `bson:"codeHash"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	//Attempts of verifying current code, code is not accepted after EmailCodeMaxAttempts
+	Attempts int       `bson:"attempts"`
+	SentAt   time.Time `bson:"sentAt"`
+}
+
+//TotpEnrollment is returned once, when enrollment starts
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"otpauthUri"`
+}
+
+//Status lists enabled second factors
+type Status struct {
+	Enabled bool     `json:"enabled"`
+	Methods []string `json:"methods"`
+}
+
+//CodeDto is payload with code from authenticator
+type CodeDto struct {
+	Code string `json:"code"`
+}
+
+//Methods returns enabled second factor methods
+func (settings Settings) Methods() []string {
+
+	methods := []string{}
+	if settings.Totp.Enabled {
+		methods = append(methods, MethodTotp)
+	}
+
+	if settings.Email.Enabled {
+		methods = append(methods, MethodEmail)
+	}
+
+	return methods
+}
@@ -0,0 +1,27 @@
+package mfa
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	securedByUsername := security.SecuredById("username", "username", false)
+
+	echoEngine.OPTIONS("/accounts/:id/mfa", web.OptionsMethodHandler)
+	echoEngine.GET("/accounts/:id/mfa", controller.GetStatus, securedByUsername)
+
+	//POST starts enrollment, PUT confirms it with first code, DELETE disables totp
+	echoEngine.OPTIONS("/accounts/:id/mfa/totp", web.OptionsMethodHandler)
+	echoEngine.POST("/accounts/:id/mfa/totp", controller.StartTotpEnrollment, securedByUsername, security.RequireRecentAuth())
+	echoEngine.PUT("/accounts/:id/mfa/totp", controller.ConfirmTotp, securedByUsername, security.RequireRecentAuth())
+	echoEngine.DELETE("/accounts/:id/mfa/totp", controller.DisableTotp, securedByUsername, security.RequireRecentAuth())
+
+	//codes are sent to account email at login
+	echoEngine.OPTIONS("/accounts/:id/mfa/email", web.OptionsMethodHandler)
+	echoEngine.POST("/accounts/:id/mfa/email", controller.EnableEmail, securedByUsername, security.RequireRecentAuth())
+	echoEngine.DELETE("/accounts/:id/mfa/email", controller.DisableEmail, securedByUsername, security.RequireRecentAuth())
+}
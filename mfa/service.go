@@ -0,0 +1,333 @@
+package mfa
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/email"
+	"github.com/piotrjaromin/go-login-backend/loginAttempts"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrInvalidCode         = errors.New("Invalid code")
+	ErrNoPendingEnrollment = errors.New("Enrollment was not started")
+	ErrAlreadyEnabled      = errors.New("Second factor is already enabled")
+	ErrNotEnabled          = errors.New("Second factor is not enabled")
+	ErrUnsupportedMethod   = errors.New("Unsupported second factor method")
+	ErrResendTooSoon       = errors.New("Code was sent recently, try again later")
+	ErrTooManyAttempts     = errors.New("Too many attempts")
+)
+
+//DefaultIssuer is shown in authenticator app next to account name
+const DefaultIssuer = "go-login-backend"
+
+//Service manages second factors of accounts
+type Service struct {
+	Status              func(username string) (Status, error)
+	Methods             func(accountId string) ([]string, error)
+	StartTotpEnrollment func(username string) (*TotpEnrollment, error)
+	ConfirmTotp         func(username string, code string) error
+	//DisableTotp requires current code, wrong codes are throttled together with second factor at login
+	DisableTotp  func(username string, code string, ip string) error
+	EnableEmail  func(username string) error
+	DisableEmail func(username string) error
+	//SendEmailCode replaces previous code, it is throttled by EmailCodeResendInterval
+	SendEmailCode func(accountId string) error
+	Verify        func(accountId string, method string, code string) error
+	//Reset removes every second factor of account, e.g. when account is taken over by owner of its email or deleted
+	Reset func(accountId string) error
+}
+
+//CreateService creates mfa service, issuer is used as label of totp entries
+func CreateService(settingsDal Dal, accountsDal accounts.Dal, issuer string, emailService email.EmailService, attemptsService loginAttempts.Service) Service {
+
+	var log = logging.MustGetLogger("[MfaService]")
+	templates := emailService.Templates()
+
+	if len(issuer) == 0 {
+		issuer = DefaultIssuer
+	}
+
+	getSettings := func(username string) (accounts.PasswordlessAccount, Settings, error) {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return account, Settings{}, err
+		}
+
+		settings, err := settingsDal.GetById(account.Id)
+		if err != nil {
+			return account, Settings{}, err
+		}
+
+		settings.Username = account.Username
+		return account, settings, nil
+	}
+
+	status := func(username string) (Status, error) {
+
+		_, settings, err := getSettings(username)
+		if err != nil {
+			return Status{}, err
+		}
+
+		methods := settings.Methods()
+		return Status{Enabled: len(methods) > 0, Methods: methods}, nil
+	}
+
+	methods := func(accountId string) ([]string, error) {
+
+		settings, err := settingsDal.GetById(accountId)
+		if err != nil {
+			return nil, err
+		}
+
+		return settings.Methods(), nil
+	}
+
+	startTotpEnrollment := func(username string) (*TotpEnrollment, error) {
+
+		account, settings, err := getSettings(username)
+		if err != nil {
+			return nil, err
+		}
+
+		if settings.Totp.Enabled {
+			return nil, ErrAlreadyEnabled
+		}
+
+		secret, err := generateTotpSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		settings.Totp.PendingSecret = secret
+		if err := settingsDal.Save(settings); err != nil {
+			return nil, err
+		}
+
+		return &TotpEnrollment{
+			Secret: secret,
+			Uri:    totpUri(issuer, account.Username, secret),
+		}, nil
+	}
+
+	confirmTotp := func(username string, code string) error {
+
+		_, settings, err := getSettings(username)
+		if err != nil {
+			return err
+		}
+
+		if settings.Totp.Enabled {
+			return ErrAlreadyEnabled
+		}
+
+		if len(settings.Totp.PendingSecret) == 0 {
+			return ErrNoPendingEnrollment
+		}
+
+		now := time.Now()
+		step, valid := verifyTotp(settings.Totp.PendingSecret, code, now, 0)
+		if !valid {
+			return ErrInvalidCode
+		}
+
+		settings.Totp = Totp{
+			Secret:       settings.Totp.PendingSecret,
+			Enabled:      true,
+			EnabledAt:    now,
+			LastUsedStep: step,
+		}
+
+		log.Infof("Totp enabled for account %s", settings.Id)
+		return settingsDal.Save(settings)
+	}
+
+	verifyTotpCode := func(settings Settings, code string) error {
+
+		if !settings.Totp.Enabled {
+			return ErrNotEnabled
+		}
+
+		step, valid := verifyTotp(settings.Totp.Secret, code, time.Now(), settings.Totp.LastUsedStep)
+		if !valid {
+			return ErrInvalidCode
+		}
+
+		return settingsDal.MarkTotpUsed(settings.Id, step)
+	}
+
+	disableTotp := func(username string, code string, ip string) error {
+
+		_, settings, err := getSettings(username)
+		if err != nil {
+			return err
+		}
+
+		//codes are short, so guesses share the same limit as at login, otherwise they could be guessed here
+		attemptsKey := "mfa:" + settings.Id
+		if _, err := attemptsService.Check(attemptsKey, ip); err != nil {
+			return ErrTooManyAttempts
+		}
+
+		//current code is required, so stolen token alone cannot turn second factor off
+		if err := verifyTotpCode(settings, code); err != nil {
+			if err == ErrInvalidCode {
+				if err := attemptsService.RecordFailure(attemptsKey, ip); err != nil {
+					log.Error("Could not record failed mfa attempt. Details: ", err.Error())
+				}
+			}
+			return err
+		}
+
+		if err := attemptsService.RecordSuccess(attemptsKey, ip); err != nil {
+			log.Error("Could not reset failed mfa attempts. Details: ", err.Error())
+		}
+
+		settings.Totp = Totp{}
+		log.Infof("Totp disabled for account %s", settings.Id)
+		return settingsDal.Save(settings)
+	}
+
+	enableEmail := func(username string) error {
+
+		_, settings, err := getSettings(username)
+		if err != nil {
+			return err
+		}
+
+		if settings.Email.Enabled {
+			return ErrAlreadyEnabled
+		}
+
+		settings.Email = EmailOtp{Enabled: true, EnabledAt: time.Now()}
+
+		log.Infof("Email codes enabled for account %s", settings.Id)
+		return settingsDal.Save(settings)
+	}
+
+	disableEmail := func(username string) error {
+
+		_, settings, err := getSettings(username)
+		if err != nil {
+			return err
+		}
+
+		if !settings.Email.Enabled {
+			return ErrNotEnabled
+		}
+
+		settings.Email = EmailOtp{}
+		log.Infof("Email codes disabled for account %s", settings.Id)
+		return settingsDal.Save(settings)
+	}
+
+	sendEmailCode := func(accountId string) error {
+
+		settings, err := settingsDal.GetById(accountId)
+		if err != nil {
+			return err
+		}
+
+		if !settings.Email.Enabled {
+			return ErrNotEnabled
+		}
+
+		now := time.Now()
+		if now.Sub(settings.Email.SentAt) < EmailCodeResendInterval {
+			return ErrResendTooSoon
+		}
+
+		account, err := accountsDal.GetById(accountId)
+		if err != nil {
+			return err
+		}
+
+		code, err := generateEmailCode()
+		if err != nil {
+			return err
+		}
+
+		settings.Email.CodeHash = hashEmailCode(accountId, code)
+		settings.Email.ExpiresAt = now.Add(EmailCodeLifetime)
+		settings.Email.Attempts = 0
+		settings.Email.SentAt = now
+
+		if err := settingsDal.Save(settings); err != nil {
+			return err
+		}
+
+		data := struct {
+			Name    string
+			Code    string
+			Minutes int
+		}{
+			account.FirstName, code, int(EmailCodeLifetime.Minutes()),
+		}
+
+		buf := new(bytes.Buffer)
+		if err := templates.ExecuteTemplate(buf, "email_code.html", data); err != nil {
+			return err
+		}
+
+		return emailService.SendEmail(account.Email, buf.String(), "Your login code")
+	}
+
+	verifyEmailCode := func(settings Settings, code string) error {
+
+		if !settings.Email.Enabled {
+			return ErrNotEnabled
+		}
+
+		otp := settings.Email
+		if len(otp.CodeHash) == 0 || time.Now().After(otp.ExpiresAt) {
+			return ErrInvalidCode
+		}
+
+		//attempt is counted before code is checked, so concurrent guesses cannot exceed EmailCodeMaxAttempts
+		if err := settingsDal.AddEmailAttempt(settings.Id, otp.CodeHash); err != nil {
+			return err
+		}
+
+		if !otp.matches(settings.Id, code) {
+			return ErrInvalidCode
+		}
+
+		return settingsDal.UseEmailCode(settings.Id, otp.CodeHash)
+	}
+
+	verify := func(accountId string, method string, code string) error {
+
+		settings, err := settingsDal.GetById(accountId)
+		if err != nil {
+			return err
+		}
+
+		switch method {
+		case MethodTotp:
+			return verifyTotpCode(settings, code)
+		case MethodEmail:
+			return verifyEmailCode(settings, code)
+		}
+
+		return ErrUnsupportedMethod
+	}
+
+	return Service{
+		Status:              status,
+		Methods:             methods,
+		StartTotpEnrollment: startTotpEnrollment,
+		ConfirmTotp:         confirmTotp,
+		DisableTotp:         disableTotp,
+		EnableEmail:         enableEmail,
+		DisableEmail:        disableEmail,
+		SendEmailCode:       sendEmailCode,
+		Verify:              verify,
+		Reset:               settingsDal.DeleteById,
+	}
+}
@@ -0,0 +1,251 @@
+package mfa
+
+import (
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/loginAttempts"
+	. "github.com/smartystreets/goconvey/convey"
+	"html/template"
+	"testing"
+	"time"
+)
+
+//testMail keeps last sent code
+type testMail struct {
+	codes *[]string
+}
+
+func (t testMail) SendEmail(mail string, content string, subject string) error {
+	So(mail, ShouldEqual, "john@test.com")
+	*t.codes = append(*t.codes, content)
+	return nil
+}
+
+func (t testMail) Templates() *template.Template {
+	return template.Must(template.New("email_code.html").Parse("{{.Code}}"))
+}
+
+func createInMemoryDal() (Dal, map[string]Settings) {
+
+	settings := map[string]Settings{}
+
+	return Dal{
+		GetById: func(accountId string) (Settings, error) {
+			found := settings[accountId]
+			found.Id = accountId
+			return found, nil
+		},
+		Save: func(s Settings) error {
+			settings[s.Id] = s
+			return nil
+		},
+		MarkTotpUsed: func(accountId string, step int64) error {
+			s := settings[accountId]
+			if s.Totp.LastUsedStep >= step {
+				return ErrInvalidCode
+			}
+			s.Totp.LastUsedStep = step
+			settings[accountId] = s
+			return nil
+		},
+		AddEmailAttempt: func(accountId string, codeHash string) error {
+			s := settings[accountId]
+			if s.Email.CodeHash != codeHash || s.Email.Attempts >= EmailCodeMaxAttempts {
+				return ErrInvalidCode
+			}
+			s.Email.Attempts++
+			settings[accountId] = s
+			return nil
+		},
+		UseEmailCode: func(accountId string, codeHash string) error {
+			s := settings[accountId]
+			if s.Email.CodeHash != codeHash {
+				return ErrInvalidCode
+			}
+			s.Email.CodeHash = ""
+			settings[accountId] = s
+			return nil
+		},
+	}, settings
+}
+
+func TestService(t *testing.T) {
+
+	john := accounts.PasswordlessAccount{Id: "johnId", Username: "john", Email: "john@test.com"}
+	accountsDal := accounts.Dal{
+		GetByUsername: func(username string) (accounts.PasswordlessAccount, error) {
+			if username == "john" {
+				return john, nil
+			}
+			return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+		},
+		GetById: func(id string) (accounts.PasswordlessAccount, error) {
+			if id == "johnId" {
+				return john, nil
+			}
+			return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+		},
+	}
+
+	currentCode := func(secret string) string {
+		code, _ := totpCode(secret, totpStep(time.Now()))
+		return code
+	}
+
+	Convey("Mfa service should", t, func() {
+
+		settingsDal, settings := createInMemoryDal()
+		codes := []string{}
+		failures := map[string]int{}
+		attemptsService := loginAttempts.Service{
+			Check: func(key string, ip string) (time.Duration, error) {
+				if failures[key] >= 3 {
+					return time.Minute, loginAttempts.ErrTooManyAttempts
+				}
+				return 0, nil
+			},
+			RecordFailure: func(key string, ip string) error {
+				failures[key]++
+				return nil
+			},
+			RecordSuccess: func(key string, ip string) error {
+				delete(failures, key)
+				return nil
+			},
+		}
+		service := CreateService(settingsDal, accountsDal, "", testMail{&codes}, attemptsService)
+
+		Convey("not enable totp before enrollment is confirmed", func() {
+
+			enrollment, err := service.StartTotpEnrollment("john")
+
+			So(err, ShouldBeNil)
+			So(enrollment.Uri, ShouldStartWith, "otpauth://totp/"+DefaultIssuer+":john?")
+
+			methods, _ := service.Methods("johnId")
+			So(methods, ShouldBeEmpty)
+		})
+
+		Convey("enable totp after confirmation with valid code", func() {
+
+			enrollment, _ := service.StartTotpEnrollment("john")
+
+			So(service.ConfirmTotp("john", "000000"), ShouldEqual, ErrInvalidCode)
+			So(service.ConfirmTotp("john", currentCode(enrollment.Secret)), ShouldBeNil)
+
+			methods, _ := service.Methods("johnId")
+			So(methods, ShouldResemble, []string{MethodTotp})
+
+			status, _ := service.Status("john")
+			So(status.Enabled, ShouldBeTrue)
+
+			_, err := service.StartTotpEnrollment("john")
+			So(err, ShouldEqual, ErrAlreadyEnabled)
+		})
+
+		Convey("reject confirmation without enrollment", func() {
+			So(service.ConfirmTotp("john", "123456"), ShouldEqual, ErrNoPendingEnrollment)
+		})
+
+		Convey("verify codes only once", func() {
+
+			secret, _ := generateTotpSecret()
+			settings["johnId"] = Settings{Id: "johnId", Totp: Totp{Secret: secret, Enabled: true}}
+			code := currentCode(secret)
+
+			So(service.Verify("johnId", MethodTotp, code), ShouldBeNil)
+			So(service.Verify("johnId", MethodTotp, code), ShouldEqual, ErrInvalidCode)
+			So(service.Verify("johnId", "sms", code), ShouldEqual, ErrUnsupportedMethod)
+		})
+
+		Convey("disable totp only with valid code", func() {
+
+			secret, _ := generateTotpSecret()
+			settings["johnId"] = Settings{Id: "johnId", Totp: Totp{Secret: secret, Enabled: true}}
+
+			So(service.DisableTotp("john", "000000", "10.0.0.1"), ShouldEqual, ErrInvalidCode)
+			So(failures["mfa:johnId"], ShouldEqual, 1)
+			So(service.DisableTotp("john", currentCode(secret), "10.0.0.1"), ShouldBeNil)
+			So(failures, ShouldBeEmpty)
+
+			methods, _ := service.Methods("johnId")
+			So(methods, ShouldBeEmpty)
+		})
+
+		Convey("throttle guessing code which disables totp like login", func() {
+
+			secret, _ := generateTotpSecret()
+			settings["johnId"] = Settings{Id: "johnId", Totp: Totp{Secret: secret, Enabled: true}}
+
+			for i := 0; i < 3; i++ {
+				So(service.DisableTotp("john", "000000", "10.0.0.1"), ShouldEqual, ErrInvalidCode)
+			}
+			So(service.DisableTotp("john", currentCode(secret), "10.0.0.1"), ShouldEqual, ErrTooManyAttempts)
+			So(settings["johnId"].Totp.Enabled, ShouldBeTrue)
+		})
+
+		Convey("send email codes only when enabled", func() {
+
+			So(service.SendEmailCode("johnId"), ShouldEqual, ErrNotEnabled)
+			So(service.EnableEmail("john"), ShouldBeNil)
+			So(service.EnableEmail("john"), ShouldEqual, ErrAlreadyEnabled)
+
+			methods, _ := service.Methods("johnId")
+			So(methods, ShouldResemble, []string{MethodEmail})
+
+			So(service.SendEmailCode("johnId"), ShouldBeNil)
+			So(codes, ShouldHaveLength, 1)
+			So(codes[0], ShouldHaveLength, 6)
+			So(settings["johnId"].Email.CodeHash, ShouldNotContainSubstring, codes[0])
+		})
+
+		Convey("verify email code only once", func() {
+
+			service.EnableEmail("john")
+			service.SendEmailCode("johnId")
+
+			So(service.Verify("johnId", MethodEmail, "abcdef"), ShouldEqual, ErrInvalidCode)
+			So(service.Verify("johnId", MethodEmail, codes[0]), ShouldBeNil)
+			So(service.Verify("johnId", MethodEmail, codes[0]), ShouldEqual, ErrInvalidCode)
+		})
+
+		Convey("drop email code after too many attempts or when it expires", func() {
+
+			service.EnableEmail("john")
+			service.SendEmailCode("johnId")
+
+			for i := 0; i < EmailCodeMaxAttempts; i++ {
+				So(service.Verify("johnId", MethodEmail, "abcdef"), ShouldEqual, ErrInvalidCode)
+			}
+			So(service.Verify("johnId", MethodEmail, codes[0]), ShouldEqual, ErrInvalidCode)
+
+			s := settings["johnId"]
+			s.Email.SentAt = time.Time{}
+			settings["johnId"] = s
+			service.SendEmailCode("johnId")
+
+			s = settings["johnId"]
+			s.Email.ExpiresAt = time.Now().Add(-time.Second)
+			settings["johnId"] = s
+			So(service.Verify("johnId", MethodEmail, codes[1]), ShouldEqual, ErrInvalidCode)
+		})
+
+		Convey("throttle resending email codes", func() {
+
+			service.EnableEmail("john")
+
+			So(service.SendEmailCode("johnId"), ShouldBeNil)
+			So(service.SendEmailCode("johnId"), ShouldEqual, ErrResendTooSoon)
+			So(codes, ShouldHaveLength, 1)
+		})
+
+		Convey("disable email codes", func() {
+
+			So(service.DisableEmail("john"), ShouldEqual, ErrNotEnabled)
+			service.EnableEmail("john")
+			So(service.DisableEmail("john"), ShouldBeNil)
+
+			methods, _ := service.Methods("johnId")
+			So(methods, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package mfa
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotp(t *testing.T) {
+
+	//secret and expected values from RFC 6238 appendix B, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	Convey("Totp should", t, func() {
+
+		Convey("generate codes from RFC test vectors", func() {
+
+			for unix, expected := range map[int64]string{
+				59:         "287082",
+				1111111109: "081804",
+				1234567890: "005924",
+				2000000000: "279037",
+			} {
+				code, err := totpCode(secret, totpStep(time.Unix(unix, 0)))
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, expected)
+			}
+		})
+
+		Convey("accept codes from neighbouring steps", func() {
+
+			now := time.Unix(1234567890, 0)
+			previous, _ := totpCode(secret, totpStep(now)-1)
+			tooOld, _ := totpCode(secret, totpStep(now)-2)
+
+			_, valid := verifyTotp(secret, previous, now, 0)
+			So(valid, ShouldBeTrue)
+
+			_, valid = verifyTotp(secret, tooOld, now, 0)
+			So(valid, ShouldBeFalse)
+		})
+
+		Convey("reject code which was already used", func() {
+
+			now := time.Unix(1234567890, 0)
+			code, _ := totpCode(secret, totpStep(now))
+
+			step, valid := verifyTotp(secret, code, now, 0)
+			So(valid, ShouldBeTrue)
+
+			_, valid = verifyTotp(secret, code, now, step)
+			So(valid, ShouldBeFalse)
+		})
+
+		Convey("build otpauth uri", func() {
+
+			uri := totpUri("My App", "john", secret)
+			So(uri, ShouldStartWith, "otpauth://totp/My%20App:john?")
+			So(uri, ShouldContainSubstring, "secret="+secret)
+			So(uri, ShouldContainSubstring, "issuer=My+App")
+		})
+
+		Convey("generate base32 secrets", func() {
+
+			generated, err := generateTotpSecret()
+			So(err, ShouldBeNil)
+			So(generated, ShouldHaveLength, 32)
+			So(strings.ToUpper(generated), ShouldEqual, generated)
+		})
+	})
+}
@@ -0,0 +1,390 @@
+package oauth
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for openid connect endpoints
+type Controller struct {
+	StartAuthorize func(c echo.Context) error
+	Authorize      func(c echo.Context) error
+	Token          func(c echo.Context) error
+	UserInfo       func(c echo.Context) error
+	Discovery      func(c echo.Context) error
+	Introspect     func(c echo.Context) error
+	Revoke         func(c echo.Context) error
+	CreateClient   func(c echo.Context) error
+	//CreateServiceClient registers client with service scopes for account, route has to be available only to admins
+	CreateServiceClient func(c echo.Context) error
+	GetClients          func(c echo.Context) error
+	DeleteClient        func(c echo.Context) error
+	DeviceCode          func(c echo.Context) error
+	GetDevice           func(c echo.Context) error
+	VerifyDevice        func(c echo.Context) error
+}
+
+//Create controller, frontendUrl points to page where user logs in before authorization is granted
+func Create(service Service, frontendUrl string) Controller {
+
+	var log = logging.MustGetLogger("[OAuthController]")
+
+	//startAuthorize is where browser lands, request is validated and user is sent to frontend to log in
+	startAuthorize := func(c echo.Context) error {
+
+		req := readAuthorizeRequest(c)
+		_, err := service.ValidateAuthorizeRequest(req)
+
+		if err == ErrUnknownClient || err == ErrInvalidRedirectUri {
+			return web.BadRequestResponse(c, err.Error())
+		}
+
+		if oauthErr, ok := err.(Error); ok {
+			return c.Redirect(http.StatusFound, errorRedirect(req, oauthErr))
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not validate authorization request", err)
+		}
+
+		return c.Redirect(http.StatusFound, frontendUrl+"/authorize?"+c.QueryString())
+	}
+
+	//authorize is called by frontend with token of logged in user, it returns where browser should go next
+	authorize := func(c echo.Context) error {
+
+		accountId, ok := c.Get("sub").(string)
+		if !ok {
+			return web.UnauthorizedResponse(c, "Invalid authorization header")
+		}
+		username, _ := c.Get("username").(string)
+
+		req := readAuthorizeRequest(c)
+		redirectUri, err := service.Authorize(req, accountId, username)
+
+		if err == ErrUnknownClient || err == ErrInvalidRedirectUri {
+			return web.BadRequestResponse(c, err.Error())
+		}
+
+		if oauthErr, ok := err.(Error); ok {
+			redirectUri = errorRedirect(req, oauthErr)
+		} else if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not authorize client", err)
+		}
+
+		return c.JSON(http.StatusOK, AuthorizeResponse{RedirectUri: redirectUri})
+	}
+
+	token := func(c echo.Context) error {
+
+		clientId, clientSecret := readClientCredentials(c)
+		req := TokenRequest{
+			GrantType:    c.FormValue("grant_type"),
+			ClientId:     clientId,
+			ClientSecret: clientSecret,
+			Code:         c.FormValue("code"),
+			RedirectUri:  c.FormValue("redirect_uri"),
+			CodeVerifier: c.FormValue("code_verifier"),
+			DeviceCode:   c.FormValue("device_code"),
+			Scope:        c.FormValue("scope"),
+
+			SubjectToken:       c.FormValue("subject_token"),
+			SubjectTokenType:   c.FormValue("subject_token_type"),
+			ActorToken:         c.FormValue("actor_token"),
+			RequestedTokenType: c.FormValue("requested_token_type"),
+			Audience:           c.FormValue("audience"),
+		}
+
+		c.Response().Header().Set("Cache-Control", "no-store")
+		c.Response().Header().Set("Pragma", "no-cache")
+
+		tokens, err := service.Token(c.Request().Context(), req)
+		if err != nil {
+			if _, ok := err.(Error); !ok {
+				log.Error("Token endpoint error. Details: ", err)
+			}
+			return errorResponse(c, err)
+		}
+
+		log.Debugf("Issued tokens for client %s", req.ClientId)
+		return c.JSON(http.StatusOK, tokens)
+	}
+
+	userInfo := func(c echo.Context) error {
+
+		accessToken, found := security.GetToken(c)
+		if !found {
+			c.Response().Header().Set("WWW-Authenticate", "Bearer")
+			return web.UnauthorizedResponse(c, "Invalid authorization header")
+		}
+
+		info, err := service.UserInfo(accessToken)
+		if err == ErrInvalidAccessToken {
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			return web.UnauthorizedResponse(c, err.Error())
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not fetch user info", err)
+		}
+
+		return c.JSON(http.StatusOK, info)
+	}
+
+	discovery := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, service.Discovery())
+	}
+
+	readTokenActionRequest := func(c echo.Context) TokenActionRequest {
+		clientId, clientSecret := readClientCredentials(c)
+		return TokenActionRequest{
+			ClientId:      clientId,
+			ClientSecret:  clientSecret,
+			Token:         c.FormValue("token"),
+			TokenTypeHint: c.FormValue("token_type_hint"),
+		}
+	}
+
+	introspect := func(c echo.Context) error {
+
+		c.Response().Header().Set("Cache-Control", "no-store")
+
+		response, err := service.Introspect(c.Request().Context(), readTokenActionRequest(c))
+		if err != nil {
+			if _, ok := err.(Error); !ok {
+				log.Error("Introspection error. Details: ", err)
+			}
+			return errorResponse(c, err)
+		}
+
+		return c.JSON(http.StatusOK, response)
+	}
+
+	revoke := func(c echo.Context) error {
+
+		if err := service.Revoke(c.Request().Context(), readTokenActionRequest(c)); err != nil {
+			if _, ok := err.(Error); !ok {
+				log.Error("Revocation error. Details: ", err)
+			}
+			return errorResponse(c, err)
+		}
+
+		return c.String(http.StatusOK, "")
+	}
+
+	createClientWith := func(create func(ctx context.Context, owner string, dto CreateClientDto) (*ClientWithSecret, error)) func(c echo.Context) error {
+		return func(c echo.Context) error {
+
+			dto := CreateClientDto{}
+			if err := c.Bind(&dto); err != nil {
+				return web.BadRequestResponse(c, "Invalid payload")
+			}
+
+			if validationErrors := dto.validate(); len(validationErrors) > 0 {
+				return web.BadRequestResponseWithDetails(c, "Invalid payload", validationErrors)
+			}
+
+			client, err := create(c.Request().Context(), c.Param("id"), dto)
+			if err == ErrTooManyClients {
+				return web.ConflictResponse(c, err.Error())
+			}
+
+			if err == ErrServiceScope || err == ErrServiceGrant {
+				return web.ForbiddenResponse(c, err.Error())
+			}
+
+			if err == ErrUnknownAudience {
+				return web.BadRequestResponse(c, err.Error())
+			}
+
+			if overloaded, ok := err.(accounts.OverloadedError); ok {
+				return web.ServiceUnavailableResponse(c, "Server is busy, try again later", overloaded.RetryAfter)
+			}
+
+			if err != nil {
+				return web.LogAndReturnInternalError(c, "Could not create client", err)
+			}
+
+			return web.CreatedResponse(c, client)
+		}
+	}
+
+	getClients := func(c echo.Context) error {
+
+		clients, err := service.GetClients(c.Param("id"))
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not fetch clients", err)
+		}
+
+		return c.JSON(http.StatusOK, clients)
+	}
+
+	deleteClient := func(c echo.Context) error {
+
+		err := service.DeleteClient(c.Param("id"), c.Param("clientId"))
+		if err == ErrClientNotFound {
+			return web.NotFoundResponse(c)
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not delete client", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	deviceCode := func(c echo.Context) error {
+
+		clientId, clientSecret := readClientCredentials(c)
+		req := DeviceAuthorizationRequest{
+			ClientId:     clientId,
+			ClientSecret: clientSecret,
+			Scope:        c.FormValue("scope"),
+		}
+
+		c.Response().Header().Set("Cache-Control", "no-store")
+
+		response, err := service.AuthorizeDevice(c.Request().Context(), req)
+		if err != nil {
+			if _, ok := err.(Error); !ok {
+				log.Error("Device authorization error. Details: ", err)
+			}
+			return errorResponse(c, err)
+		}
+
+		return c.JSON(http.StatusOK, response)
+	}
+
+	//getDevice lets frontend show which client asks for access before user approves it
+	getDevice := func(c echo.Context) error {
+
+		if _, ok := c.Get("sub").(string); !ok {
+			return web.UnauthorizedResponse(c, "Invalid authorization header")
+		}
+
+		verification, err := service.GetDeviceVerification(c.QueryParam("user_code"))
+		if err == ErrInvalidUserCode {
+			return web.NotFoundResponse(c)
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not fetch device grant", err)
+		}
+
+		return c.JSON(http.StatusOK, verification)
+	}
+
+	//verifyDevice approves grant for logged in user, it is denied when action=deny is sent
+	verifyDevice := func(c echo.Context) error {
+
+		accountId, ok := c.Get("sub").(string)
+		if !ok {
+			return web.UnauthorizedResponse(c, "Invalid authorization header")
+		}
+		username, _ := c.Get("username").(string)
+
+		approve := c.FormValue("action") != "deny"
+		err := service.VerifyDevice(c.FormValue("user_code"), approve, accountId, username)
+		if err == ErrInvalidUserCode {
+			return web.BadRequestResponse(c, err.Error())
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not verify device", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return Controller{
+		StartAuthorize:      startAuthorize,
+		Authorize:           authorize,
+		Token:               token,
+		UserInfo:            userInfo,
+		Discovery:           discovery,
+		Introspect:          introspect,
+		Revoke:              revoke,
+		CreateClient:        createClientWith(service.CreateClient),
+		CreateServiceClient: createClientWith(service.CreateServiceClient),
+		GetClients:          getClients,
+		DeleteClient:        deleteClient,
+		DeviceCode:          deviceCode,
+		GetDevice:           getDevice,
+		VerifyDevice:        verifyDevice,
+	}
+}
+
+func readAuthorizeRequest(c echo.Context) AuthorizeRequest {
+	return AuthorizeRequest{
+		ResponseType:        c.FormValue("response_type"),
+		ClientId:            c.FormValue("client_id"),
+		RedirectUri:         c.FormValue("redirect_uri"),
+		Scope:               c.FormValue("scope"),
+		State:               c.FormValue("state"),
+		Nonce:               c.FormValue("nonce"),
+		CodeChallenge:       c.FormValue("code_challenge"),
+		CodeChallengeMethod: c.FormValue("code_challenge_method"),
+	}
+}
+
+//readClientCredentials supports both client_secret_basic and client_secret_post authentication
+func readClientCredentials(c echo.Context) (string, string) {
+
+	if clientId, clientSecret, ok := c.Request().BasicAuth(); ok {
+		//credentials are form encoded before being put into basic auth header (RFC 6749 2.3.1)
+		decodedId, idErr := url.QueryUnescape(clientId)
+		decodedSecret, secretErr := url.QueryUnescape(clientSecret)
+		if idErr == nil && secretErr == nil {
+			return decodedId, decodedSecret
+		}
+	}
+
+	return c.FormValue("client_id"), c.FormValue("client_secret")
+}
+
+func errorRedirect(req AuthorizeRequest, oauthErr Error) string {
+
+	params := url.Values{}
+	params.Set("error", oauthErr.Code)
+	if len(oauthErr.Description) > 0 {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if len(req.State) > 0 {
+		params.Set("state", req.State)
+	}
+
+	return AppendQuery(req.RedirectUri, params)
+}
+
+//errorResponse writes error in format expected by oauth clients
+func errorResponse(c echo.Context, err error) error {
+
+	if overloaded, ok := err.(accounts.OverloadedError); ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(overloaded.RetryAfter.Seconds()))))
+		return c.JSON(http.StatusServiceUnavailable, Error{Code: ErrCodeTemporarilyUnavailable})
+	}
+
+	oauthErr, ok := err.(Error)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, Error{Code: ErrCodeServerError})
+	}
+
+	switch oauthErr.Code {
+	case ErrCodeInvalidClient:
+		c.Response().Header().Set("WWW-Authenticate", "Basic")
+		return c.JSON(http.StatusUnauthorized, oauthErr)
+	case ErrCodeServerError:
+		return c.JSON(http.StatusInternalServerError, oauthErr)
+	}
+
+	return c.JSON(http.StatusBadRequest, oauthErr)
+}
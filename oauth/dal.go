@@ -0,0 +1,236 @@
+package oauth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+const maxClientsPerOwner = 100
+
+//Errors returned by dal
+var (
+	ErrClientNotFound = errors.New("Client does not exist")
+	ErrCodeNotFound   = errors.New("Authorization code does not exist or was already used")
+	//ErrDeviceGrantNotFound is returned for unknown, expired or already used device grants
+	ErrDeviceGrantNotFound = errors.New("Device grant does not exist")
+)
+
+//ClientsDal gives access to registered client applications
+type ClientsDal struct {
+	GetById    func(id string) (Client, error)
+	GetByOwner func(owner string) ([]Client, error)
+	Save       func(client Client) error
+	DeleteById func(id string) error
+}
+
+//CodesDal stores issued authorization codes
+type CodesDal struct {
+	Save    func(code AuthorizationCode) error
+	Consume func(id string) (AuthorizationCode, error)
+}
+
+//CreateClientsDal wraps generic dal for clients collection
+func CreateClientsDal(clientsRepo dal.Dal) ClientsDal {
+
+	getById := func(id string) (Client, error) {
+
+		client := Client{}
+		if err := clientsRepo.GetById(id, &client); err != nil {
+			return client, err
+		}
+
+		if len(client.Id) == 0 {
+			return client, ErrClientNotFound
+		}
+
+		return client, nil
+	}
+
+	getByOwner := func(owner string) ([]Client, error) {
+
+		clients := make([]Client, 0)
+		query := dal.NewQueryBuilder().WithField("owner", owner).Build()
+		pagination := web.Pagination{PageNumber: 1, PageSize: maxClientsPerOwner}
+
+		if err := clientsRepo.GetByQuery(&clients, pagination, query); err != nil {
+			return []Client{}, err
+		}
+
+		return clients, nil
+	}
+
+	save := func(client Client) error {
+		return clientsRepo.Upsert(client.Id, client)
+	}
+
+	return ClientsDal{
+		GetById:    getById,
+		GetByOwner: getByOwner,
+		Save:       save,
+		DeleteById: clientsRepo.DeleteById,
+	}
+}
+
+//CreateCodesDal wraps generic dal for authorization codes, expired codes are removed by ttl index
+func CreateCodesDal(codesRepo dal.Dal) CodesDal {
+
+	var log = logging.MustGetLogger("[AuthorizationCodesDal]")
+
+	if err := codesRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for authorization codes. Details: ", err)
+	}
+
+	save := func(code AuthorizationCode) error {
+		_, err := codesRepo.Save(code)
+		return err
+	}
+
+	//consume marks code as used in single update so code cannot be exchanged twice
+	consume := func(id string) (AuthorizationCode, error) {
+
+		query := dal.NewQueryBuilder().WithId(id).WithField("used", false).Build()
+		err := codesRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"used": true},
+		})
+
+		if err == dal.ErrNotFound {
+			return AuthorizationCode{}, ErrCodeNotFound
+		}
+
+		if err != nil {
+			return AuthorizationCode{}, err
+		}
+
+		code := AuthorizationCode{}
+		if err := codesRepo.GetById(id, &code); err != nil {
+			return code, err
+		}
+
+		return code, nil
+	}
+
+	return CodesDal{
+		Save:    save,
+		Consume: consume,
+	}
+}
+
+//DeviceGrantsDal stores pending device authorizations
+type DeviceGrantsDal struct {
+	GetById       func(id string) (DeviceGrant, error)
+	GetByUserCode func(userCode string) (DeviceGrant, error)
+	Save          func(grant DeviceGrant) error
+	//Decide sets status of pending grant, ErrDeviceGrantNotFound is returned when grant was already decided
+	Decide func(id string, status string, accountId string, username string) error
+	//UpdatePolling records when device asked for token and how long it has to wait before next poll
+	UpdatePolling func(id string, polledAt time.Time, interval int) error
+	//DeleteById fails with ErrDeviceGrantNotFound when grant was already removed, so token is issued only once
+	DeleteById func(id string) error
+}
+
+//CreateDeviceGrantsDal wraps generic dal for device grants, expired grants are removed by ttl index
+func CreateDeviceGrantsDal(grantsRepo dal.Dal) DeviceGrantsDal {
+
+	var log = logging.MustGetLogger("[DeviceGrantsDal]")
+
+	if err := grantsRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for device grants. Details: ", err)
+	}
+
+	getById := func(id string) (DeviceGrant, error) {
+
+		grant := DeviceGrant{}
+		if err := grantsRepo.GetById(id, &grant); err != nil {
+			return grant, err
+		}
+
+		if len(grant.Id) == 0 {
+			return grant, ErrDeviceGrantNotFound
+		}
+
+		return grant, nil
+	}
+
+	getByUserCode := func(userCode string) (DeviceGrant, error) {
+
+		grants := make([]DeviceGrant, 0)
+		query := dal.NewQueryBuilder().WithField("userCode", userCode).Build()
+
+		if err := grantsRepo.GetByQuery(&grants, web.Pagination{PageNumber: 1, PageSize: 1}, query); err != nil {
+			return DeviceGrant{}, err
+		}
+
+		if len(grants) == 0 {
+			return DeviceGrant{}, ErrDeviceGrantNotFound
+		}
+
+		return grants[0], nil
+	}
+
+	save := func(grant DeviceGrant) error {
+		_, err := grantsRepo.Save(grant)
+		return err
+	}
+
+	decide := func(id string, status string, accountId string, username string) error {
+
+		query := dal.NewQueryBuilder().WithId(id).WithField("status", DeviceGrantPending).Build()
+		err := grantsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"status": status, "accountId": accountId, "username": username},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrDeviceGrantNotFound
+		}
+
+		return err
+	}
+
+	updatePolling := func(id string, polledAt time.Time, interval int) error {
+
+		return grantsRepo.Update(id, map[string]interface{}{
+			"$set": map[string]interface{}{"lastPolledAt": polledAt, "interval": interval},
+		})
+	}
+
+	deleteById := func(id string) error {
+
+		err := grantsRepo.DeleteById(id)
+		if err == dal.ErrNotFound {
+			return ErrDeviceGrantNotFound
+		}
+
+		return err
+	}
+
+	return DeviceGrantsDal{
+		GetById:       getById,
+		GetByUserCode: getByUserCode,
+		Save:          save,
+		Decide:        decide,
+		UpdatePolling: updatePolling,
+		DeleteById:    deleteById,
+	}
+}
+
+//ExchangesDal keeps audit trail of token exchanges
+type ExchangesDal struct {
+	Save func(exchange TokenExchange) error
+}
+
+//CreateExchangesDal wraps generic dal for token exchanges collection
+func CreateExchangesDal(exchangesRepo dal.Dal) ExchangesDal {
+
+	save := func(exchange TokenExchange) error {
+		_, err := exchangesRepo.Save(exchange)
+		return err
+	}
+
+	return ExchangesDal{
+		Save: save,
+	}
+}
@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+//userCodeAlphabet has no vowels and no characters which look alike, as suggested by RFC 8628 6.1
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const (
+	userCodeLength   = 8
+	userCodeAttempts = 5
+)
+
+//generateUserCode returns code which user types on verification page, it is formatted as XXXX-XXXX
+func generateUserCode() (string, error) {
+
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+
+	return formatUserCode(string(code)), nil
+}
+
+//normalizeUserCode makes code typed by user comparable with stored one, case, dashes and spaces are ignored
+func normalizeUserCode(userCode string) string {
+
+	normalized := make([]rune, 0, userCodeLength)
+	for _, char := range strings.ToUpper(userCode) {
+		if strings.ContainsRune(userCodeAlphabet, char) {
+			normalized = append(normalized, char)
+		}
+	}
+
+	if len(normalized) != userCodeLength {
+		return ""
+	}
+
+	return formatUserCode(string(normalized))
+}
+
+func formatUserCode(code string) string {
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
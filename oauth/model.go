@@ -0,0 +1,350 @@
+package oauth
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Client is application registered to use authorization endpoints,
+//confidential clients have secret which is stored hashed
+type Client struct {
+	Id           string    `json:"clientId" bson:"_id"`
+	Name         string    `json:"name" bson:"name"`
+	RedirectUris []string  `json:"redirectUris" bson:"redirectUris"`
+	Owner        string    `json:"owner,omitempty" bson:"owner"`
+	Scopes       []string  `json:"scopes,omitempty" bson:"scopes"`
+	GrantTypes   []string  `json:"grantTypes,omitempty" bson:"grantTypes"`
+	Audiences    []string  `json:"audiences,omitempty" bson:"audiences"`
+	CreatedAt    time.Time `json:"createdAt,omitempty" bson:"createdAt"`
+	SecretHash   string    `json:"-" bson:"secretHash"`
+	//Service clients are registered by admins, only they can obtain service scopes
+	Service bool `json:"service,omitempty" bson:"service"`
+	//SecretSalt is set only for clients with legacy secret hashes, PHC hashes contain salt
+	SecretSalt string `json:"-" bson:"secretSalt"`
+}
+
+//ClientWithSecret is returned only once, when client is created
+type ClientWithSecret struct {
+	Client
+	ClientSecret string `json:"clientSecret"`
+}
+
+//CreateClientDto is payload for client registration
+type CreateClientDto struct {
+	Name         string   `json:"name"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grantTypes"`
+	//Audiences are services for which client can exchange tokens of users
+	Audiences []string `json:"audiences"`
+}
+
+//AuthorizationCode is stored hashed and can be exchanged for tokens only once
+type AuthorizationCode struct {
+	Id                  string    `bson:"_id"`
+	ClientId            string    `bson:"clientId"`
+	RedirectUri         string    `bson:"redirectUri"`
+	AccountId           string    `bson:"accountId"`
+	Username            string    `bson:"username"`
+	Scope               string    `bson:"scope"`
+	Nonce               string    `bson:"nonce"`
+	CodeChallenge       string    `bson:"codeChallenge"`
+	CodeChallengeMethod string    `bson:"codeChallengeMethod"`
+	ExpiresAt           time.Time `bson:"expiresAt"`
+	Used                bool      `bson:"used"`
+}
+
+//DeviceGrant is pending device authorization (RFC 8628), device code is stored hashed
+//and grant is removed by ttl index when it expires
+type DeviceGrant struct {
+	Id           string    `bson:"_id"`
+	UserCode     string    `bson:"userCode"`
+	ClientId     string    `bson:"clientId"`
+	Scope        string    `bson:"scope"`
+	Status       string    `bson:"status"`
+	AccountId    string    `bson:"accountId"`
+	Username     string    `bson:"username"`
+	Interval     int       `bson:"interval"`
+	LastPolledAt time.Time `bson:"lastPolledAt"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+}
+
+//Statuses of device grant
+const (
+	DeviceGrantPending  = "pending"
+	DeviceGrantApproved = "approved"
+	DeviceGrantDenied   = "denied"
+)
+
+//DeviceAuthorizationRequest holds parameters of /device/code call
+type DeviceAuthorizationRequest struct {
+	ClientId     string
+	ClientSecret string
+	Scope        string
+}
+
+//DeviceAuthorizationResponse tells device what to show to user and how often to poll token endpoint
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+//DeviceVerification is shown to logged in user before device is approved
+type DeviceVerification struct {
+	UserCode   string `json:"userCode"`
+	ClientId   string `json:"clientId"`
+	ClientName string `json:"clientName"`
+	Scope      string `json:"scope"`
+}
+
+//AuthorizeRequest holds parameters of /authorize call
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectUri         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+//TokenRequest holds parameters of /token call
+type TokenRequest struct {
+	GrantType    string
+	ClientId     string
+	ClientSecret string
+	Code         string
+	RedirectUri  string
+	CodeVerifier string
+	DeviceCode   string
+	Scope        string
+	//token exchange parameters (RFC 8693)
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	RequestedTokenType string
+	Audience           string
+}
+
+//TokenResponse is returned from token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IdToken     string `json:"id_token,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	//IssuedTokenType is set only for token exchange
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+//TokenExchange records which client exchanged token of which subject, it is kept for auditing
+type TokenExchange struct {
+	Id             string    `json:"id" bson:"_id"`
+	ClientId       string    `json:"clientId" bson:"clientId"`
+	Subject        string    `json:"subject" bson:"subject"`
+	Username       string    `json:"username,omitempty" bson:"username"`
+	SubjectTokenId string    `json:"subjectTokenId" bson:"subjectTokenId"`
+	Audience       string    `json:"audience" bson:"audience"`
+	Scope          string    `json:"scope" bson:"scope"`
+	CreatedAt      time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+//TokenActionRequest is sent by resource servers to introspect or revoke token
+type TokenActionRequest struct {
+	ClientId      string
+	ClientSecret  string
+	Token         string
+	TokenTypeHint string
+}
+
+//IntrospectionResponse describes token state (RFC 7662), inactive tokens have only active field
+type IntrospectionResponse struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientId  string      `json:"client_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Iat       int64       `json:"iat,omitempty"`
+	Sub       string      `json:"sub,omitempty"`
+	Aud       interface{} `json:"aud,omitempty"`
+	Iss       string      `json:"iss,omitempty"`
+	Jti       string      `json:"jti,omitempty"`
+}
+
+//AuthorizeResponse tells frontend where to send user after login
+type AuthorizeResponse struct {
+	RedirectUri string `json:"redirectUri"`
+}
+
+//Discovery is openid provider metadata
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+//Error is returned in format defined by RFC 6749, clients expect it instead of web.Error
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (err Error) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+//Error codes from RFC 6749
+const (
+	ErrCodeInvalidRequest          = "invalid_request"
+	ErrCodeInvalidClient           = "invalid_client"
+	ErrCodeInvalidGrant            = "invalid_grant"
+	ErrCodeInvalidScope            = "invalid_scope"
+	ErrCodeUnauthorizedClient      = "unauthorized_client"
+	ErrCodeUnsupportedGrantType    = "unsupported_grant_type"
+	ErrCodeUnsupportedResponseType = "unsupported_response_type"
+	ErrCodeAccessDenied            = "access_denied"
+	ErrCodeServerError             = "server_error"
+	ErrCodeTemporarilyUnavailable  = "temporarily_unavailable"
+)
+
+//Error codes returned while device polls token endpoint (RFC 8628)
+const (
+	ErrCodeAuthorizationPending = "authorization_pending"
+	ErrCodeSlowDown             = "slow_down"
+	ErrCodeExpiredToken         = "expired_token"
+)
+
+//ErrCodeInvalidTarget is returned when token is exchanged for audience which client cannot ask for (RFC 8693)
+const ErrCodeInvalidTarget = "invalid_target"
+
+//IsConfidential is true for clients which can authenticate with secret
+func (client Client) IsConfidential() bool {
+	return len(client.SecretHash) > 0
+}
+
+//AllowsGrant checks if client can use grant type, clients without grant types are browser apps from before they were introduced
+func (client Client) AllowsGrant(grantType string) bool {
+
+	if len(client.GrantTypes) == 0 {
+		return grantType == grantAuthorizationCode
+	}
+
+	return contains(client.GrantTypes, grantType)
+}
+
+func (dto CreateClientDto) validate() []web.ErrorDetails {
+
+	var errors []web.ErrorDetails
+
+	if len(dto.Name) == 0 {
+		errors = web.AppendErrorDetails(errors, "name", "name is required", web.MissingField)
+	}
+
+	for _, grantType := range dto.GrantTypes {
+		if grantType != grantAuthorizationCode && grantType != grantClientCredentials && grantType != grantDeviceCode && grantType != grantTokenExchange {
+			errors = web.AppendErrorDetails(errors, "grantTypes", "unsupported grant type "+grantType, web.InvalidField)
+		}
+	}
+
+	if contains(dto.GrantTypes, grantTokenExchange) && len(dto.Audiences) == 0 {
+		errors = web.AppendErrorDetails(errors, "audiences", "audiences are required for token exchange grant", web.MissingField)
+	}
+
+	if contains(dto.GrantTypes, grantAuthorizationCode) && len(dto.RedirectUris) == 0 {
+		errors = web.AppendErrorDetails(errors, "redirectUris", "redirect uris are required for authorization_code grant", web.MissingField)
+	}
+
+	for _, redirectUri := range dto.RedirectUris {
+		parsed, err := url.Parse(redirectUri)
+		if err != nil || !parsed.IsAbs() || len(parsed.Fragment) > 0 {
+			errors = web.AppendErrorDetails(errors, "redirectUris", "invalid redirect uri "+redirectUri, web.InvalidField)
+		}
+	}
+
+	for _, scope := range dto.Scopes {
+		if !isSupportedScope(scope) && !contains(serviceScopes, scope) {
+			errors = web.AppendErrorDetails(errors, "scopes", "unsupported scope "+scope, web.InvalidField)
+		}
+	}
+
+	return errors
+}
+
+//IsRedirectUriAllowed compares uri with registered ones,
+//for loopback addresses port is ignored as native apps pick it at runtime (RFC 8252)
+func (client Client) IsRedirectUriAllowed(redirectUri string) bool {
+
+	for _, allowed := range client.RedirectUris {
+		if allowed == redirectUri {
+			return true
+		}
+
+		if isSameLoopbackUri(allowed, redirectUri) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSameLoopbackUri(allowed string, requested string) bool {
+
+	allowedUrl, err := url.Parse(allowed)
+	if err != nil || allowedUrl.Scheme != "http" {
+		return false
+	}
+
+	requestedUrl, err := url.Parse(requested)
+	if err != nil || requestedUrl.Scheme != "http" {
+		return false
+	}
+
+	ip := net.ParseIP(allowedUrl.Hostname())
+	if ip == nil || !ip.IsLoopback() {
+		return false
+	}
+
+	return allowedUrl.Hostname() == requestedUrl.Hostname() &&
+		allowedUrl.Path == requestedUrl.Path &&
+		allowedUrl.RawQuery == requestedUrl.RawQuery
+}
+
+//HasScope checks space separated scope list
+func HasScope(scope string, wanted string) bool {
+	return contains(strings.Fields(scope), wanted)
+}
+
+func contains(values []string, wanted string) bool {
+
+	for _, value := range values {
+		if value == wanted {
+			return true
+		}
+	}
+
+	return false
+}
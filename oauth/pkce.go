@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+//PKCE methods from RFC 7636
+const (
+	PkceS256  = "S256"
+	PkcePlain = "plain"
+)
+
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+func isSupportedChallengeMethod(method string) bool {
+	return method == PkceS256 || method == PkcePlain
+}
+
+//verifyCodeChallenge checks code_verifier sent to token endpoint against challenge sent to authorize
+func verifyCodeChallenge(challenge string, method string, verifier string) bool {
+
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+
+	computed := verifier
+	if method == PkceS256 {
+		hash := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(hash[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,50 @@
+package oauth
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	echoEngine.OPTIONS("/.well-known/openid-configuration", web.OptionsMethodHandler)
+	echoEngine.GET("/.well-known/openid-configuration", controller.Discovery)
+
+	echoEngine.OPTIONS("/authorize", web.OptionsMethodHandler)
+	echoEngine.GET("/authorize", controller.StartAuthorize)
+	echoEngine.POST("/authorize", controller.Authorize, security.FillClaims())
+
+	echoEngine.OPTIONS("/token", web.OptionsMethodHandler)
+	echoEngine.POST("/token", controller.Token)
+
+	echoEngine.OPTIONS("/device/code", web.OptionsMethodHandler)
+	echoEngine.POST("/device/code", controller.DeviceCode)
+	echoEngine.OPTIONS("/device/verify", web.OptionsMethodHandler)
+	echoEngine.GET("/device/verify", controller.GetDevice, security.FillClaims())
+	echoEngine.POST("/device/verify", controller.VerifyDevice, security.FillClaims())
+
+	echoEngine.OPTIONS("/introspect", web.OptionsMethodHandler)
+	echoEngine.POST("/introspect", controller.Introspect)
+
+	echoEngine.OPTIONS("/revoke", web.OptionsMethodHandler)
+	echoEngine.POST("/revoke", controller.Revoke)
+
+	echoEngine.OPTIONS("/userinfo", web.OptionsMethodHandler)
+	echoEngine.GET("/userinfo", controller.UserInfo)
+	echoEngine.POST("/userinfo", controller.UserInfo)
+
+	//clients are managed by account which owns them
+	securedByUsername := security.SecuredById("username", "username", false)
+	echoEngine.OPTIONS("/accounts/:id/clients", web.OptionsMethodHandler)
+	echoEngine.POST("/accounts/:id/clients", controller.CreateClient, securedByUsername)
+	echoEngine.GET("/accounts/:id/clients", controller.GetClients, securedByUsername)
+	echoEngine.OPTIONS("/accounts/:id/clients/:clientId", web.OptionsMethodHandler)
+	echoEngine.DELETE("/accounts/:id/clients/:clientId", controller.DeleteClient, securedByUsername)
+
+	//service clients can read and change every account, so only admins register them
+	echoEngine.OPTIONS("/accounts/:id/service-clients", web.OptionsMethodHandler)
+	echoEngine.POST("/accounts/:id/service-clients", controller.CreateServiceClient, security.RequireRole(accounts.RoleAdmin))
+}
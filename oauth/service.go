@@ -0,0 +1,1035 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/satori/go.uuid"
+)
+
+//Errors which cannot be sent back to redirect_uri, user has to see them directly
+var (
+	ErrUnknownClient       = errors.New("Unknown client")
+	ErrInvalidRedirectUri  = errors.New("Redirect uri is not registered for client")
+	ErrInvalidAccessToken  = errors.New("Invalid access token")
+	ErrCouldNotIssueTokens = errors.New("Could not issue tokens")
+	ErrTooManyClients      = errors.New("Limit of clients for account was reached")
+	ErrServiceScope        = errors.New("Service scopes can be granted only by admin")
+	ErrServiceGrant        = errors.New("Token exchange can be granted only by admin")
+	ErrUnknownAudience     = errors.New("Audience is not configured resource audience")
+	ErrInvalidUserCode     = errors.New("Invalid or expired user code")
+)
+
+const (
+	authorizationCodeLifetime = time.Minute * 5
+	authorizationCodeBytes    = 32
+	grantAuthorizationCode    = "authorization_code"
+	grantClientCredentials    = "client_credentials"
+	grantDeviceCode           = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeLifetime        = time.Minute * 10
+	deviceCodeBytes           = 32
+	devicePollInterval        = 5
+	devicePollSlowDown        = 5
+	grantTokenExchange        = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken      = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeJwt              = "urn:ietf:params:oauth:token-type:jwt"
+	clientSecretBytes         = 32
+	scopeOpenId               = "openid"
+	scopeProfile              = "profile"
+	scopeEmail                = "email"
+)
+
+var supportedScopes = []string{scopeOpenId, scopeProfile, scopeEmail}
+
+//serviceScopes can be granted only to confidential clients through client_credentials grant
+var serviceScopes = []string{accounts.ScopeAccountsRead, accounts.ScopeAccountsWrite}
+
+//ProviderConfig describes where provider is available,
+//VerificationUri is page where user enters code shown by device
+type ProviderConfig struct {
+	Issuer          string
+	BaseUrl         string
+	VerificationUri string
+}
+
+//Service implementing authorization code flow with PKCE
+type Service struct {
+	ValidateAuthorizeRequest func(req AuthorizeRequest) (Client, error)
+	Authorize                func(req AuthorizeRequest, accountId string, username string) (string, error)
+	Token                    func(ctx context.Context, req TokenRequest) (*TokenResponse, error)
+	UserInfo                 func(accessToken string) (map[string]interface{}, error)
+	Discovery                func() Discovery
+	Introspect               func(ctx context.Context, req TokenActionRequest) (*IntrospectionResponse, error)
+	Revoke                   func(ctx context.Context, req TokenActionRequest) error
+	CreateClient             func(ctx context.Context, owner string, dto CreateClientDto) (*ClientWithSecret, error)
+	//CreateServiceClient registers client which can be granted service scopes, it is available only to admins
+	CreateServiceClient func(ctx context.Context, owner string, dto CreateClientDto) (*ClientWithSecret, error)
+	GetClients          func(owner string) ([]Client, error)
+	DeleteClient        func(owner string, clientId string) error
+	//DeleteClients removes clients registered by account, it is used when account is deleted
+	DeleteClients func(owner string) error
+	//AuthorizeDevice starts device authorization grant, device polls token endpoint until user approves user code
+	AuthorizeDevice func(ctx context.Context, req DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error)
+	//GetDeviceVerification describes pending grant so user can check which client asks for access
+	GetDeviceVerification func(userCode string) (*DeviceVerification, error)
+	//VerifyDevice approves or denies pending grant on behalf of logged in account
+	VerifyDevice func(userCode string, approve bool, accountId string, username string) error
+}
+
+//CreateService creates openid connect provider
+func CreateService(conf ProviderConfig, clientsDal ClientsDal, codesDal CodesDal, deviceGrantsDal DeviceGrantsDal, exchangesDal ExchangesDal, accountsDal accounts.Dal, tokenService jwtTokens.TokenService, encrypt accounts.Encrypt) Service {
+
+	var log = logging.MustGetLogger("[OAuthService]")
+
+	//validateAuthorizeRequest returns ErrUnknownClient or ErrInvalidRedirectUri when error cannot be redirected,
+	//other problems are returned as Error which should be sent back to client redirect_uri
+	validateAuthorizeRequest := func(req AuthorizeRequest) (Client, error) {
+
+		client, err := clientsDal.GetById(req.ClientId)
+		if err != nil {
+			if err != ErrClientNotFound {
+				log.Error("Could not fetch client. Details: ", err)
+			}
+			return Client{}, ErrUnknownClient
+		}
+
+		if len(req.RedirectUri) == 0 || !client.IsRedirectUriAllowed(req.RedirectUri) {
+			return Client{}, ErrInvalidRedirectUri
+		}
+
+		if !client.AllowsGrant(grantAuthorizationCode) {
+			return client, Error{ErrCodeUnauthorizedClient, "client cannot use authorization code grant"}
+		}
+
+		if req.ResponseType != "code" {
+			return client, Error{ErrCodeUnsupportedResponseType, "only code response type is supported"}
+		}
+
+		for _, scope := range strings.Fields(req.Scope) {
+			if !isSupportedScope(scope) {
+				return client, Error{ErrCodeInvalidScope, "unsupported scope " + scope}
+			}
+		}
+
+		if len(req.CodeChallenge) == 0 {
+			return client, Error{ErrCodeInvalidRequest, "code_challenge is required"}
+		}
+
+		if !isSupportedChallengeMethod(challengeMethod(req)) {
+			return client, Error{ErrCodeInvalidRequest, "unsupported code_challenge_method"}
+		}
+
+		return client, nil
+	}
+
+	authorize := func(req AuthorizeRequest, accountId string, username string) (string, error) {
+
+		if _, err := validateAuthorizeRequest(req); err != nil {
+			return "", err
+		}
+
+		code, err := randomString(authorizationCodeBytes)
+		if err != nil {
+			log.Error("Could not generate authorization code. Details: ", err)
+			return "", Error{ErrCodeServerError, ""}
+		}
+
+		authCode := AuthorizationCode{
+			Id:                  hashCode(code),
+			ClientId:            req.ClientId,
+			RedirectUri:         req.RedirectUri,
+			AccountId:           accountId,
+			Username:            username,
+			Scope:               req.Scope,
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: challengeMethod(req),
+			ExpiresAt:           time.Now().Add(authorizationCodeLifetime),
+		}
+
+		if err := codesDal.Save(authCode); err != nil {
+			log.Error("Could not save authorization code. Details: ", err)
+			return "", Error{ErrCodeServerError, ""}
+		}
+
+		params := url.Values{}
+		params.Set("code", code)
+		if len(req.State) > 0 {
+			params.Set("state", req.State)
+		}
+
+		return AppendQuery(req.RedirectUri, params), nil
+	}
+
+	issueTokens := func(clientId string, account accounts.PasswordlessAccount, scope string, nonce string) (*TokenResponse, error) {
+
+		//token of client acts on behalf of account only within granted scope, so it has no username and roles of account
+		accessToken, err := tokenService.Sign(map[string]interface{}{
+			"sub":                   account.Id,
+			"scope":                 scope,
+			"client_id":             clientId,
+			jwtTokens.TokenUseClaim: jwtTokens.TokenUseClientAccess,
+		}, tokenService.AccessTokenLifetime)
+
+		if err != nil {
+			return nil, ErrCouldNotIssueTokens
+		}
+
+		response := TokenResponse{
+			AccessToken: accessToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(tokenService.AccessTokenLifetime.Seconds()),
+			Scope:       scope,
+		}
+
+		if HasScope(scope, scopeOpenId) {
+
+			claims := map[string]interface{}{
+				"iss": conf.Issuer,
+				"aud": clientId,
+				"azp": clientId,
+			}
+
+			for name, value := range userClaims(account, scope) {
+				claims[name] = value
+			}
+
+			if len(nonce) > 0 {
+				claims["nonce"] = nonce
+			}
+
+			idToken, err := tokenService.SignJwt(claims, tokenService.AccessTokenLifetime)
+			if err != nil {
+				return nil, ErrCouldNotIssueTokens
+			}
+
+			response.IdToken = idToken
+		}
+
+		return &response, nil
+	}
+
+	//authenticateClient checks secret of confidential clients, public clients are identified only by client_id,
+	//ctx is context of request, secret is checked on password hashing pool
+	authenticateClient := func(ctx context.Context, clientId string, clientSecret string) (Client, error) {
+
+		client, err := clientsDal.GetById(clientId)
+		if err != nil {
+			if err != ErrClientNotFound {
+				log.Error("Could not fetch client. Details: ", err)
+			}
+			return Client{}, Error{ErrCodeInvalidClient, ""}
+		}
+
+		if !client.IsConfidential() {
+			return client, nil
+		}
+
+		if len(clientSecret) == 0 {
+			return Client{}, Error{ErrCodeInvalidClient, ""}
+		}
+
+		valid, err := encrypt.Validate(ctx, accounts.Password(clientSecret), accounts.Password(client.SecretHash), client.SecretSalt)
+		if err != nil {
+			return Client{}, err
+		}
+
+		if !valid {
+			return Client{}, Error{ErrCodeInvalidClient, ""}
+		}
+
+		return client, nil
+	}
+
+	exchangeCode := func(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+
+		if len(req.Code) == 0 || len(req.RedirectUri) == 0 {
+			return nil, Error{ErrCodeInvalidRequest, "code and redirect_uri are required"}
+		}
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.AllowsGrant(grantAuthorizationCode) {
+			return nil, Error{ErrCodeUnauthorizedClient, ""}
+		}
+
+		code, err := codesDal.Consume(hashCode(req.Code))
+		if err != nil {
+			if err != ErrCodeNotFound {
+				log.Error("Could not fetch authorization code. Details: ", err)
+				return nil, Error{ErrCodeServerError, ""}
+			}
+			return nil, Error{ErrCodeInvalidGrant, "invalid authorization code"}
+		}
+
+		if time.Now().After(code.ExpiresAt) || code.ClientId != req.ClientId || code.RedirectUri != req.RedirectUri {
+			return nil, Error{ErrCodeInvalidGrant, "invalid authorization code"}
+		}
+
+		if !verifyCodeChallenge(code.CodeChallenge, code.CodeChallengeMethod, req.CodeVerifier) {
+			return nil, Error{ErrCodeInvalidGrant, "invalid code_verifier"}
+		}
+
+		account, err := accountsDal.GetById(code.AccountId)
+		if err != nil {
+			return nil, Error{ErrCodeInvalidGrant, "account does not exist"}
+		}
+
+		return issueTokens(code.ClientId, account, code.Scope, code.Nonce)
+	}
+
+	//clientCredentials issues token for client itself, there is no account behind it
+	clientCredentials := func(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.IsConfidential() || !client.AllowsGrant(grantClientCredentials) {
+			return nil, Error{ErrCodeUnauthorizedClient, "client cannot use client credentials grant"}
+		}
+
+		scopes := strings.Fields(req.Scope)
+		if len(scopes) == 0 {
+			scopes = client.Scopes
+		}
+
+		for _, scope := range scopes {
+			//clients registered by users before service clients were introduced can still have service scopes stored
+			if !contains(client.Scopes, scope) || (contains(serviceScopes, scope) && !client.Service) {
+				return nil, Error{ErrCodeInvalidScope, "scope " + scope + " is not allowed for client"}
+			}
+		}
+
+		scope := strings.Join(scopes, " ")
+		accessToken, err := tokenService.Sign(map[string]interface{}{
+			"sub":                   client.Id,
+			"client_id":             client.Id,
+			"scope":                 scope,
+			jwtTokens.TokenUseClaim: jwtTokens.TokenUseClientAccess,
+		}, tokenService.AccessTokenLifetime)
+
+		if err != nil {
+			return nil, ErrCouldNotIssueTokens
+		}
+
+		return &TokenResponse{
+			AccessToken: accessToken,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(tokenService.AccessTokenLifetime.Seconds()),
+			Scope:       scope,
+		}, nil
+	}
+
+	//deviceCode is polled by device, tokens are issued once user approved grant
+	deviceCode := func(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+
+		if len(req.DeviceCode) == 0 {
+			return nil, Error{ErrCodeInvalidRequest, "device_code is required"}
+		}
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.AllowsGrant(grantDeviceCode) {
+			return nil, Error{ErrCodeUnauthorizedClient, ""}
+		}
+
+		grant, err := deviceGrantsDal.GetById(hashCode(req.DeviceCode))
+		if err != nil {
+			if err != ErrDeviceGrantNotFound {
+				log.Error("Could not fetch device grant. Details: ", err)
+				return nil, Error{ErrCodeServerError, ""}
+			}
+			return nil, Error{ErrCodeInvalidGrant, "invalid device code"}
+		}
+
+		if grant.ClientId != client.Id {
+			return nil, Error{ErrCodeInvalidGrant, "invalid device code"}
+		}
+
+		now := time.Now()
+		if now.After(grant.ExpiresAt) {
+			return nil, Error{ErrCodeExpiredToken, ""}
+		}
+
+		switch grant.Status {
+		case DeviceGrantDenied:
+			deviceGrantsDal.DeleteById(grant.Id)
+			return nil, Error{ErrCodeAccessDenied, ""}
+
+		case DeviceGrantApproved:
+			//only request which removed grant may issue tokens
+			if err := deviceGrantsDal.DeleteById(grant.Id); err != nil {
+				if err != ErrDeviceGrantNotFound {
+					log.Error("Could not remove device grant. Details: ", err)
+					return nil, Error{ErrCodeServerError, ""}
+				}
+				return nil, Error{ErrCodeInvalidGrant, "invalid device code"}
+			}
+
+			account, err := accountsDal.GetById(grant.AccountId)
+			if err != nil {
+				return nil, Error{ErrCodeInvalidGrant, "account does not exist"}
+			}
+
+			return issueTokens(grant.ClientId, account, grant.Scope, "")
+		}
+
+		//device which polls too often has to wait longer from now on (RFC 8628 3.5)
+		code := ErrCodeAuthorizationPending
+		interval := grant.Interval
+		if now.Sub(grant.LastPolledAt) < time.Duration(grant.Interval)*time.Second {
+			code = ErrCodeSlowDown
+			interval += devicePollSlowDown
+		}
+
+		if err := deviceGrantsDal.UpdatePolling(grant.Id, now, interval); err != nil {
+			log.Error("Could not update device grant. Details: ", err)
+		}
+
+		return nil, Error{code, ""}
+	}
+
+	//exchangeToken mints token for single audience on behalf of subject (RFC 8693),
+	//new token never has more scopes or longer life than subject token and carries client as actor in act claim
+	exchangeToken := func(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+
+		if len(req.SubjectToken) == 0 || len(req.Audience) == 0 {
+			return nil, Error{ErrCodeInvalidRequest, "subject_token and audience are required"}
+		}
+
+		if req.SubjectTokenType != tokenTypeAccessToken && req.SubjectTokenType != tokenTypeJwt {
+			return nil, Error{ErrCodeInvalidRequest, "unsupported subject_token_type"}
+		}
+
+		if len(req.RequestedTokenType) > 0 && req.RequestedTokenType != tokenTypeAccessToken {
+			return nil, Error{ErrCodeInvalidRequest, "only access tokens can be requested"}
+		}
+
+		if len(req.ActorToken) > 0 {
+			return nil, Error{ErrCodeInvalidRequest, "actor_token is not supported, client is the actor"}
+		}
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		//clients registered by users before exchange was limited to service clients can still have the grant stored
+		if !client.IsConfidential() || !client.Service || !client.AllowsGrant(grantTokenExchange) {
+			return nil, Error{ErrCodeUnauthorizedClient, "client cannot use token exchange grant"}
+		}
+
+		if !contains(client.Audiences, req.Audience) || !contains(tokenService.ResourceAudiences, req.Audience) {
+			return nil, Error{ErrCodeInvalidTarget, "audience " + req.Audience + " is not allowed for client"}
+		}
+
+		//id tokens, challenges and states are signed with the same keys, only access tokens can be exchanged
+		subject := tokenService.GetClaims(req.SubjectToken)
+		sub, hasSub := subject["sub"].(string)
+		expiresAt, hasExp := jwtTokens.ExpiresAt(subject)
+		if !hasSub || !hasExp || !(jwtTokens.IsAccessToken(subject) || jwtTokens.IsClientAccessToken(subject)) {
+			return nil, Error{ErrCodeInvalidGrant, "invalid subject token"}
+		}
+
+		granted := subjectScopes(subject)
+		scopes := strings.Fields(req.Scope)
+		if len(scopes) == 0 {
+			scopes = granted
+		}
+
+		for _, scope := range scopes {
+			if !contains(granted, scope) {
+				return nil, Error{ErrCodeInvalidScope, "scope " + scope + " was not granted to subject token"}
+			}
+		}
+
+		lifetime := tokenService.AccessTokenLifetime
+		if remaining := expiresAt.Sub(time.Now()); remaining < lifetime {
+			lifetime = remaining
+		}
+
+		//subject token accepted thanks to leeway cannot be exchanged
+		if lifetime <= 0 {
+			return nil, Error{ErrCodeInvalidGrant, "subject token is expired"}
+		}
+
+		//actor of subject token is kept nested, so whole delegation chain is visible
+		act := map[string]interface{}{"sub": client.Id}
+		if previous, ok := subject["act"]; ok {
+			act["act"] = previous
+		}
+
+		scope := strings.Join(scopes, " ")
+		claims := map[string]interface{}{
+			"jti":                   uuid.NewV4().String(),
+			"sub":                   sub,
+			"aud":                   req.Audience,
+			"scope":                 scope,
+			"client_id":             client.Id,
+			"act":                   act,
+			jwtTokens.TokenUseClaim: jwtTokens.TokenUseClientAccess,
+		}
+
+		//username is only recorded, exchanged token is issued to client and must not pass as account owner
+		username, _ := subject["username"].(string)
+
+		accessToken, err := tokenService.Sign(claims, lifetime)
+		if err != nil {
+			return nil, ErrCouldNotIssueTokens
+		}
+
+		subjectTokenId, _ := subject["jti"].(string)
+		now := time.Now()
+		exchange := TokenExchange{
+			Id:             claims["jti"].(string),
+			ClientId:       client.Id,
+			Subject:        sub,
+			Username:       username,
+			SubjectTokenId: subjectTokenId,
+			Audience:       req.Audience,
+			Scope:          scope,
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(lifetime),
+		}
+
+		//token which was not recorded is not returned
+		if err := exchangesDal.Save(exchange); err != nil {
+			log.Error("Could not record token exchange. Details: ", err)
+			return nil, Error{ErrCodeServerError, ""}
+		}
+
+		log.Infof("Client %s exchanged token %s of %s for audience %s", client.Id, subjectTokenId, sub, req.Audience)
+
+		return &TokenResponse{
+			AccessToken:     accessToken,
+			TokenType:       "Bearer",
+			ExpiresIn:       int64(lifetime.Seconds()),
+			Scope:           scope,
+			IssuedTokenType: tokenTypeAccessToken,
+		}, nil
+	}
+
+	token := func(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+
+		switch req.GrantType {
+		case grantAuthorizationCode:
+			return exchangeCode(ctx, req)
+		case grantClientCredentials:
+			return clientCredentials(ctx, req)
+		case grantDeviceCode:
+			return deviceCode(ctx, req)
+		case grantTokenExchange:
+			return exchangeToken(ctx, req)
+		}
+
+		return nil, Error{ErrCodeUnsupportedGrantType, ""}
+	}
+
+	userInfo := func(accessToken string) (map[string]interface{}, error) {
+
+		claims := tokenService.GetClaims(accessToken)
+		sub, ok := claims["sub"].(string)
+		if !ok || !(jwtTokens.IsAccessToken(claims) || jwtTokens.IsClientAccessToken(claims)) {
+			return nil, ErrInvalidAccessToken
+		}
+
+		//tokens from /login are first party and carry no scope, they can read whole profile
+		scope, hasScope := claims["scope"].(string)
+		if !hasScope {
+			scope = strings.Join(supportedScopes, " ")
+		}
+
+		if !HasScope(scope, scopeOpenId) {
+			return nil, ErrInvalidAccessToken
+		}
+
+		account, err := accountsDal.GetById(sub)
+		if err != nil {
+			return nil, ErrInvalidAccessToken
+		}
+
+		return userClaims(account, scope), nil
+	}
+
+	discovery := func() Discovery {
+		return Discovery{
+			Issuer:                            conf.Issuer,
+			AuthorizationEndpoint:             conf.BaseUrl + "/authorize",
+			TokenEndpoint:                     conf.BaseUrl + "/token",
+			UserinfoEndpoint:                  conf.BaseUrl + "/userinfo",
+			JwksUri:                           conf.BaseUrl + "/.well-known/jwks.json",
+			IntrospectionEndpoint:             conf.BaseUrl + "/introspect",
+			DeviceAuthorizationEndpoint:       conf.BaseUrl + "/device/code",
+			RevocationEndpoint:                conf.BaseUrl + "/revoke",
+			ScopesSupported:                   append(append([]string{}, supportedScopes...), serviceScopes...),
+			ResponseTypesSupported:            []string{"code"},
+			GrantTypesSupported:               []string{grantAuthorizationCode, grantClientCredentials, grantDeviceCode, grantTokenExchange},
+			SubjectTypesSupported:             []string{"public"},
+			IdTokenSigningAlgValuesSupported:  []string{tokenService.SigningAlg},
+			TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+			CodeChallengeMethodsSupported:     []string{PkceS256, PkcePlain},
+			ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "given_name",
+				"family_name", "preferred_username", "email", "email_verified"},
+		}
+	}
+
+	//authenticateConfidentialClient allows only clients which can prove their identity to act on tokens
+	authenticateConfidentialClient := func(ctx context.Context, req TokenActionRequest) (Client, error) {
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return Client{}, err
+		}
+
+		if !client.IsConfidential() {
+			return Client{}, Error{ErrCodeInvalidClient, "only confidential clients can call this endpoint"}
+		}
+
+		return client, nil
+	}
+
+	//introspect describes tokens of every account, so only service clients registered by admin can call it
+	introspect := func(ctx context.Context, req TokenActionRequest) (*IntrospectionResponse, error) {
+
+		client, err := authenticateConfidentialClient(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.Service {
+			return nil, Error{ErrCodeUnauthorizedClient, "only service clients can introspect tokens"}
+		}
+
+		if len(req.Token) == 0 {
+			return nil, Error{ErrCodeInvalidRequest, "token is required"}
+		}
+
+		//expired, revoked and malformed tokens have no claims
+		claims := tokenService.GetClaims(req.Token)
+		if len(claims) == 0 {
+			return &IntrospectionResponse{Active: false}, nil
+		}
+
+		response := IntrospectionResponse{Active: true, TokenType: "Bearer"}
+		response.Sub, _ = claims["sub"].(string)
+		response.Scope, _ = claims["scope"].(string)
+		response.ClientId, _ = claims["client_id"].(string)
+		response.Username, _ = claims["username"].(string)
+		response.Iss, _ = claims["iss"].(string)
+		response.Jti, _ = claims["jti"].(string)
+		response.Aud = claims["aud"]
+
+		if exp, ok := claims["exp"].(float64); ok {
+			response.Exp = int64(exp)
+		}
+
+		if iat, ok := claims["iat"].(float64); ok {
+			response.Iat = int64(iat)
+		}
+
+		return &response, nil
+	}
+
+	//revoke follows RFC 7009, invalid and unknown tokens are not reported as errors.
+	//Client can revoke only tokens issued to it, first party access and refresh tokens are revoked by logout
+	revoke := func(ctx context.Context, req TokenActionRequest) error {
+
+		client, err := authenticateConfidentialClient(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		if len(req.Token) == 0 {
+			return Error{ErrCodeInvalidRequest, "token is required"}
+		}
+
+		claims := tokenService.GetClaims(req.Token)
+		if tokenClient, _ := claims["client_id"].(string); len(tokenClient) == 0 || tokenClient != client.Id {
+			return nil
+		}
+
+		//token revoked concurrently is revoked all the same
+		if err := tokenService.Revoke(req.Token); err != nil && err != jwtTokens.ErrTokenAlreadyUsed {
+			return err
+		}
+
+		return nil
+	}
+
+	saveClient := func(ctx context.Context, owner string, dto CreateClientDto, service bool) (*ClientWithSecret, error) {
+
+		existing, err := clientsDal.GetByOwner(owner)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(existing) >= maxClientsPerOwner {
+			return nil, ErrTooManyClients
+		}
+
+		secret, err := randomString(clientSecretBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		grantTypes := dto.GrantTypes
+		if len(grantTypes) == 0 {
+			grantTypes = []string{grantClientCredentials}
+		}
+
+		hash, err := encrypt.Hash(ctx, accounts.Password(secret))
+		if err != nil {
+			return nil, err
+		}
+
+		client := Client{
+			Id:           uuid.NewV4().String(),
+			Name:         dto.Name,
+			RedirectUris: dto.RedirectUris,
+			Owner:        owner,
+			Scopes:       dto.Scopes,
+			GrantTypes:   grantTypes,
+			Audiences:    dto.Audiences,
+			CreatedAt:    time.Now(),
+			SecretHash:   string(hash),
+			Service:      service,
+		}
+
+		if err := clientsDal.Save(client); err != nil {
+			return nil, err
+		}
+
+		return &ClientWithSecret{Client: client, ClientSecret: secret}, nil
+	}
+
+	//createClient registers client of user, service scopes would let it read every account
+	//and token exchange would let it act as any user who sent it token
+	createClient := func(ctx context.Context, owner string, dto CreateClientDto) (*ClientWithSecret, error) {
+
+		for _, scope := range dto.Scopes {
+			if contains(serviceScopes, scope) {
+				return nil, ErrServiceScope
+			}
+		}
+
+		if contains(dto.GrantTypes, grantTokenExchange) || len(dto.Audiences) > 0 {
+			return nil, ErrServiceGrant
+		}
+
+		return saveClient(ctx, owner, dto, false)
+	}
+
+	//createServiceClient registers client by admin, audiences have to be configured, tokens for other audiences could not be validated
+	createServiceClient := func(ctx context.Context, owner string, dto CreateClientDto) (*ClientWithSecret, error) {
+
+		for _, audience := range dto.Audiences {
+			if !contains(tokenService.ResourceAudiences, audience) {
+				return nil, ErrUnknownAudience
+			}
+		}
+
+		return saveClient(ctx, owner, dto, true)
+	}
+
+	deleteClient := func(owner string, clientId string) error {
+
+		client, err := clientsDal.GetById(clientId)
+		if err != nil {
+			return err
+		}
+
+		//clients of other accounts are reported as not existing
+		if client.Owner != owner {
+			return ErrClientNotFound
+		}
+
+		return clientsDal.DeleteById(clientId)
+	}
+
+	deleteClients := func(owner string) error {
+
+		clients, err := clientsDal.GetByOwner(owner)
+		if err != nil {
+			return err
+		}
+
+		for _, client := range clients {
+			if err := clientsDal.DeleteById(client.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	//newUserCode draws codes until it finds one which is not used by other grant, user codes are short
+	newUserCode := func() (string, error) {
+
+		for attempt := 0; attempt < userCodeAttempts; attempt++ {
+
+			userCode, err := generateUserCode()
+			if err != nil {
+				return "", err
+			}
+
+			_, err = deviceGrantsDal.GetByUserCode(userCode)
+			if err == ErrDeviceGrantNotFound {
+				return userCode, nil
+			}
+
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return "", errors.New("Could not find unused user code")
+	}
+
+	authorizeDevice := func(ctx context.Context, req DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error) {
+
+		client, err := authenticateClient(ctx, req.ClientId, req.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !client.AllowsGrant(grantDeviceCode) {
+			return nil, Error{ErrCodeUnauthorizedClient, "client cannot use device authorization grant"}
+		}
+
+		for _, scope := range strings.Fields(req.Scope) {
+			if !isSupportedScope(scope) {
+				return nil, Error{ErrCodeInvalidScope, "unsupported scope " + scope}
+			}
+		}
+
+		code, err := randomString(deviceCodeBytes)
+		if err != nil {
+			log.Error("Could not generate device code. Details: ", err)
+			return nil, Error{ErrCodeServerError, ""}
+		}
+
+		userCode, err := newUserCode()
+		if err != nil {
+			log.Error("Could not generate user code. Details: ", err)
+			return nil, Error{ErrCodeServerError, ""}
+		}
+
+		grant := DeviceGrant{
+			Id:        hashCode(code),
+			UserCode:  userCode,
+			ClientId:  client.Id,
+			Scope:     req.Scope,
+			Status:    DeviceGrantPending,
+			Interval:  devicePollInterval,
+			ExpiresAt: time.Now().Add(deviceCodeLifetime),
+		}
+
+		if err := deviceGrantsDal.Save(grant); err != nil {
+			log.Error("Could not save device grant. Details: ", err)
+			return nil, Error{ErrCodeServerError, ""}
+		}
+
+		params := url.Values{}
+		params.Set("user_code", userCode)
+
+		return &DeviceAuthorizationResponse{
+			DeviceCode:              code,
+			UserCode:                userCode,
+			VerificationUri:         conf.VerificationUri,
+			VerificationUriComplete: AppendQuery(conf.VerificationUri, params),
+			ExpiresIn:               int64(deviceCodeLifetime.Seconds()),
+			Interval:                devicePollInterval,
+		}, nil
+	}
+
+	getPendingGrant := func(userCode string) (DeviceGrant, error) {
+
+		normalized := normalizeUserCode(userCode)
+		if len(normalized) == 0 {
+			return DeviceGrant{}, ErrInvalidUserCode
+		}
+
+		grant, err := deviceGrantsDal.GetByUserCode(normalized)
+		if err == ErrDeviceGrantNotFound {
+			return DeviceGrant{}, ErrInvalidUserCode
+		}
+
+		if err != nil {
+			return DeviceGrant{}, err
+		}
+
+		if grant.Status != DeviceGrantPending || time.Now().After(grant.ExpiresAt) {
+			return DeviceGrant{}, ErrInvalidUserCode
+		}
+
+		return grant, nil
+	}
+
+	getDeviceVerification := func(userCode string) (*DeviceVerification, error) {
+
+		grant, err := getPendingGrant(userCode)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := clientsDal.GetById(grant.ClientId)
+		if err == ErrClientNotFound {
+			return nil, ErrInvalidUserCode
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DeviceVerification{
+			UserCode:   grant.UserCode,
+			ClientId:   client.Id,
+			ClientName: client.Name,
+			Scope:      grant.Scope,
+		}, nil
+	}
+
+	verifyDevice := func(userCode string, approve bool, accountId string, username string) error {
+
+		grant, err := getPendingGrant(userCode)
+		if err != nil {
+			return err
+		}
+
+		status := DeviceGrantDenied
+		if approve {
+			status = DeviceGrantApproved
+		}
+
+		err = deviceGrantsDal.Decide(grant.Id, status, accountId, username)
+		if err == ErrDeviceGrantNotFound {
+			return ErrInvalidUserCode
+		}
+
+		return err
+	}
+
+	return Service{
+		ValidateAuthorizeRequest: validateAuthorizeRequest,
+		Authorize:                authorize,
+		Token:                    token,
+		UserInfo:                 userInfo,
+		Discovery:                discovery,
+		Introspect:               introspect,
+		Revoke:                   revoke,
+		CreateClient:             createClient,
+		CreateServiceClient:      createServiceClient,
+		GetClients:               clientsDal.GetByOwner,
+		DeleteClient:             deleteClient,
+		DeleteClients:            deleteClients,
+		AuthorizeDevice:          authorizeDevice,
+		GetDeviceVerification:    getDeviceVerification,
+		VerifyDevice:             verifyDevice,
+	}
+}
+
+//userClaims returns claims of account allowed by scope
+func userClaims(account accounts.PasswordlessAccount, scope string) map[string]interface{} {
+
+	claims := map[string]interface{}{"sub": account.Id}
+
+	if HasScope(scope, scopeProfile) {
+		claims["name"] = strings.TrimSpace(account.FirstName + " " + account.LastName)
+		claims["given_name"] = account.FirstName
+		claims["family_name"] = account.LastName
+		claims["preferred_username"] = account.Username
+	}
+
+	if HasScope(scope, scopeEmail) {
+		claims["email"] = account.Email
+		claims["email_verified"] = account.Status == accounts.Confirmed
+	}
+
+	return claims
+}
+
+//subjectScopes returns scopes granted to token, first party tokens carry no scope for profile and can read it whole
+func subjectScopes(claims map[string]interface{}) []string {
+
+	scope, _ := claims["scope"].(string)
+	scopes := strings.Fields(scope)
+
+	if _, issuedToClient := claims["client_id"]; !issuedToClient {
+		for _, supported := range supportedScopes {
+			if !contains(scopes, supported) {
+				scopes = append(scopes, supported)
+			}
+		}
+	}
+
+	return scopes
+}
+
+//AppendQuery adds params to uri keeping query params which were already there
+func AppendQuery(uri string, params url.Values) string {
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	query := parsed.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func challengeMethod(req AuthorizeRequest) string {
+
+	if len(req.CodeChallengeMethod) == 0 {
+		return PkcePlain
+	}
+
+	return req.CodeChallengeMethod
+}
+
+func isSupportedScope(scope string) bool {
+
+	for _, supported := range supportedScopes {
+		if supported == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomString(size int) (string, error) {
+
+	randomBytes := make([]byte, size)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+func hashCode(code string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+}
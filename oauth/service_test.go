@@ -0,0 +1,770 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func createTestTokenService() jwtTokens.TokenService {
+
+	revoked := map[string]bool{}
+	revocations := jwtTokens.RevocationStore{
+		Revoke: func(jti string, expiresAt time.Time) error {
+			revoked[jti] = true
+			return nil
+		},
+		IsRevoked: func(ids ...string) bool {
+			for _, id := range ids {
+				if revoked[id] {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return jwtTokens.Create(jwtTokens.CreateHMACKeySet("testKey"), revocations, jwtTokens.TokenConfig{Issuer: "http://issuer", Audience: "login-api", ResourceAudiences: []string{"orders-api", "billing-api"}})
+}
+
+func createInMemoryCodesDal() CodesDal {
+
+	codes := map[string]AuthorizationCode{}
+	return CodesDal{
+		Save: func(code AuthorizationCode) error {
+			codes[code.Id] = code
+			return nil
+		},
+		Consume: func(id string) (AuthorizationCode, error) {
+			code, ok := codes[id]
+			if !ok || code.Used {
+				return AuthorizationCode{}, ErrCodeNotFound
+			}
+			code.Used = true
+			codes[id] = code
+			return code, nil
+		},
+	}
+}
+
+func createInMemoryDeviceGrantsDal() DeviceGrantsDal {
+
+	grants := map[string]DeviceGrant{}
+	return DeviceGrantsDal{
+		GetById: func(id string) (DeviceGrant, error) {
+			grant, ok := grants[id]
+			if !ok {
+				return DeviceGrant{}, ErrDeviceGrantNotFound
+			}
+			return grant, nil
+		},
+		GetByUserCode: func(userCode string) (DeviceGrant, error) {
+			for _, grant := range grants {
+				if grant.UserCode == userCode {
+					return grant, nil
+				}
+			}
+			return DeviceGrant{}, ErrDeviceGrantNotFound
+		},
+		Save: func(grant DeviceGrant) error {
+			grants[grant.Id] = grant
+			return nil
+		},
+		Decide: func(id string, status string, accountId string, username string) error {
+			grant, ok := grants[id]
+			if !ok || grant.Status != DeviceGrantPending {
+				return ErrDeviceGrantNotFound
+			}
+			grant.Status, grant.AccountId, grant.Username = status, accountId, username
+			grants[id] = grant
+			return nil
+		},
+		UpdatePolling: func(id string, polledAt time.Time, interval int) error {
+			grant := grants[id]
+			grant.LastPolledAt, grant.Interval = polledAt, interval
+			grants[id] = grant
+			return nil
+		},
+		DeleteById: func(id string) error {
+			if _, ok := grants[id]; !ok {
+				return ErrDeviceGrantNotFound
+			}
+			delete(grants, id)
+			return nil
+		},
+	}
+}
+
+func createInMemoryExchangesDal(exchanges ...*[]TokenExchange) ExchangesDal {
+	return ExchangesDal{
+		Save: func(exchange TokenExchange) error {
+			for _, recorded := range exchanges {
+				*recorded = append(*recorded, exchange)
+			}
+			return nil
+		},
+	}
+}
+
+func createInMemoryClientsDal(initial ...Client) ClientsDal {
+
+	clients := map[string]Client{}
+	for _, client := range initial {
+		clients[client.Id] = client
+	}
+
+	return ClientsDal{
+		GetById: func(id string) (Client, error) {
+			client, ok := clients[id]
+			if !ok {
+				return Client{}, ErrClientNotFound
+			}
+			return client, nil
+		},
+		GetByOwner: func(owner string) ([]Client, error) {
+			owned := []Client{}
+			for _, client := range clients {
+				if client.Owner == owner {
+					owned = append(owned, client)
+				}
+			}
+			return owned, nil
+		},
+		Save: func(client Client) error {
+			clients[client.Id] = client
+			return nil
+		},
+		DeleteById: func(id string) error {
+			delete(clients, id)
+			return nil
+		},
+	}
+}
+
+func createTestEncrypt() accounts.Encrypt {
+	return accounts.Encrypt{
+		Hash: func(ctx context.Context, pass accounts.Password) (accounts.Password, error) {
+			return "hashed-" + pass, nil
+		},
+		Validate: func(ctx context.Context, pass accounts.Password, hashToCompare accounts.Password, salt string) (bool, error) {
+			return "hashed-"+pass == hashToCompare, nil
+		},
+	}
+}
+
+func TestService(t *testing.T) {
+
+	client := Client{
+		Id:           "spa",
+		RedirectUris: []string{"https://app.com/callback", "http://127.0.0.1/native"},
+	}
+
+	account := accounts.PasswordlessAccount{
+		Id:        "accId",
+		Email:     "test@test.com",
+		Username:  "testUser",
+		FirstName: "Jhone",
+		LastName:  "Doe",
+		Status:    accounts.Confirmed,
+	}
+
+	clientsDal := createInMemoryClientsDal(client)
+
+	accountsDal := accounts.Dal{
+		GetById: func(id string) (accounts.PasswordlessAccount, error) {
+			if id == account.Id {
+				return account, nil
+			}
+			return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+		},
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challengeHash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(challengeHash[:])
+
+	validRequest := func() AuthorizeRequest {
+		return AuthorizeRequest{
+			ResponseType:        "code",
+			ClientId:            client.Id,
+			RedirectUri:         "https://app.com/callback",
+			Scope:               "openid email",
+			State:               "xyz",
+			Nonce:               "n-0S6",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: PkceS256,
+		}
+	}
+
+	tokenService := createTestTokenService()
+	conf := ProviderConfig{Issuer: "http://issuer", BaseUrl: "http://issuer"}
+
+	Convey("Authorize request validation should", t, func() {
+
+		service := CreateService(conf, clientsDal, createInMemoryCodesDal(), createInMemoryDeviceGrantsDal(), createInMemoryExchangesDal(), accountsDal, tokenService, createTestEncrypt())
+
+		Convey("accept valid request", func() {
+			_, err := service.ValidateAuthorizeRequest(validRequest())
+			So(err, ShouldBeNil)
+		})
+
+		Convey("reject unknown client", func() {
+			req := validRequest()
+			req.ClientId = "other"
+			_, err := service.ValidateAuthorizeRequest(req)
+			So(err, ShouldEqual, ErrUnknownClient)
+		})
+
+		Convey("reject redirect uri which is not registered", func() {
+			req := validRequest()
+			req.RedirectUri = "https://evil.com/callback"
+			_, err := service.ValidateAuthorizeRequest(req)
+			So(err, ShouldEqual, ErrInvalidRedirectUri)
+		})
+
+		Convey("accept any port for loopback redirect uri", func() {
+			req := validRequest()
+			req.RedirectUri = "http://127.0.0.1:51004/native"
+			_, err := service.ValidateAuthorizeRequest(req)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("require code challenge", func() {
+			req := validRequest()
+			req.CodeChallenge = ""
+			_, err := service.ValidateAuthorizeRequest(req)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidRequest)
+		})
+
+		Convey("reject unsupported scope", func() {
+			req := validRequest()
+			req.Scope = "openid admin"
+			_, err := service.ValidateAuthorizeRequest(req)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidScope)
+		})
+	})
+
+	Convey("Authorization code flow should", t, func() {
+
+		service := CreateService(conf, clientsDal, createInMemoryCodesDal(), createInMemoryDeviceGrantsDal(), createInMemoryExchangesDal(), accountsDal, tokenService, createTestEncrypt())
+
+		redirect, err := service.Authorize(validRequest(), account.Id, account.Username)
+		So(err, ShouldBeNil)
+
+		redirectUrl, _ := url.Parse(redirect)
+		code := redirectUrl.Query().Get("code")
+
+		So(redirectUrl.Host, ShouldEqual, "app.com")
+		So(redirectUrl.Query().Get("state"), ShouldEqual, "xyz")
+		So(code, ShouldNotBeBlank)
+
+		tokenRequest := TokenRequest{
+			GrantType:    grantAuthorizationCode,
+			ClientId:     client.Id,
+			Code:         code,
+			RedirectUri:  "https://app.com/callback",
+			CodeVerifier: verifier,
+		}
+
+		Convey("exchange code for id and access token", func() {
+
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+			So(tokens.TokenType, ShouldEqual, "Bearer")
+
+			accessClaims := tokenService.GetClaims(tokens.AccessToken)
+			So(accessClaims["sub"], ShouldEqual, account.Id)
+			So(accessClaims["scope"], ShouldEqual, "openid email")
+			So(accessClaims["username"], ShouldBeNil)
+			So(accessClaims["roles"], ShouldBeNil)
+			So(jwtTokens.IsAccessToken(accessClaims), ShouldBeFalse)
+			So(jwtTokens.IsClientAccessToken(accessClaims), ShouldBeTrue)
+
+			info, err := service.UserInfo(tokens.AccessToken)
+			So(err, ShouldBeNil)
+			So(info["email"], ShouldEqual, account.Email)
+			So(info["given_name"], ShouldBeNil)
+
+			idToken, _ := jwtParts(tokens.IdToken)
+			So(idToken["aud"], ShouldEqual, client.Id)
+			So(idToken["nonce"], ShouldEqual, "n-0S6")
+			So(idToken["iss"], ShouldEqual, "http://issuer")
+		})
+
+		Convey("allow code to be used only once", func() {
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+
+			_, err = service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidGrant)
+		})
+
+		Convey("reject invalid code verifier", func() {
+
+			tokenRequest.CodeVerifier = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidGrant)
+		})
+
+		Convey("reject redirect uri different than in authorize request", func() {
+
+			tokenRequest.RedirectUri = "http://127.0.0.1/native"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidGrant)
+		})
+
+		Convey("reject unsupported grant type", func() {
+
+			tokenRequest.GrantType = "password"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnsupportedGrantType)
+		})
+	})
+
+	Convey("Client credentials flow should", t, func() {
+
+		clientsDal := createInMemoryClientsDal(client)
+		service := CreateService(conf, clientsDal, createInMemoryCodesDal(), createInMemoryDeviceGrantsDal(), createInMemoryExchangesDal(), accountsDal, tokenService, createTestEncrypt())
+
+		created, err := service.CreateServiceClient(context.Background(), "owner", CreateClientDto{
+			Name:   "worker",
+			Scopes: []string{"accounts:read", "accounts:write"},
+		})
+		So(err, ShouldBeNil)
+
+		stored, _ := clientsDal.GetById(created.Id)
+		So(created.ClientSecret, ShouldNotBeBlank)
+		So(stored.SecretHash, ShouldNotEqual, created.ClientSecret)
+		So(stored.GrantTypes, ShouldResemble, []string{grantClientCredentials})
+
+		tokenRequest := TokenRequest{
+			GrantType:    grantClientCredentials,
+			ClientId:     created.Id,
+			ClientSecret: created.ClientSecret,
+			Scope:        "accounts:read",
+		}
+
+		Convey("issue token for client with requested scope", func() {
+
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+			So(tokens.IdToken, ShouldBeBlank)
+
+			claims := tokenService.GetClaims(tokens.AccessToken)
+			So(claims["sub"], ShouldEqual, created.Id)
+			So(claims["client_id"], ShouldEqual, created.Id)
+			So(claims["scope"], ShouldEqual, "accounts:read")
+			So(claims["username"], ShouldBeNil)
+		})
+
+		Convey("grant all client scopes when none are requested", func() {
+
+			tokenRequest.Scope = ""
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+			So(tokens.Scope, ShouldEqual, "accounts:read accounts:write")
+		})
+
+		Convey("reject invalid secret", func() {
+
+			tokenRequest.ClientSecret = "wrong"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidClient)
+		})
+
+		Convey("reject scope which was not granted to client", func() {
+
+			tokenRequest.Scope = "openid"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidScope)
+		})
+
+		Convey("reject public client", func() {
+
+			_, err := service.Token(context.Background(), TokenRequest{GrantType: grantClientCredentials, ClientId: client.Id})
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+		})
+
+		Convey("not let users register clients with service scopes", func() {
+
+			_, err := service.CreateClient(context.Background(), "owner", CreateClientDto{Name: "worker", Scopes: []string{"openid", "accounts:read"}})
+			So(err, ShouldEqual, ErrServiceScope)
+
+			//client registered by user before service clients existed
+			legacy, _ := clientsDal.GetById(created.Id)
+			legacy.Service = false
+			clientsDal.Save(legacy)
+
+			_, err = service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidScope)
+		})
+
+		Convey("allow only owner to delete client", func() {
+
+			So(service.DeleteClient("other", created.Id), ShouldEqual, ErrClientNotFound)
+			So(service.DeleteClient("owner", created.Id), ShouldBeNil)
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidClient)
+		})
+	})
+
+	Convey("Device authorization grant should", t, func() {
+
+		cli := Client{Id: "cli", Name: "Command line tool", GrantTypes: []string{grantDeviceCode}}
+		deviceGrantsDal := createInMemoryDeviceGrantsDal()
+		deviceConf := ProviderConfig{Issuer: "http://issuer", BaseUrl: "http://issuer", VerificationUri: "http://app.com/device"}
+		service := CreateService(deviceConf, createInMemoryClientsDal(client, cli), createInMemoryCodesDal(), deviceGrantsDal, createInMemoryExchangesDal(), accountsDal, tokenService, createTestEncrypt())
+
+		device, err := service.AuthorizeDevice(context.Background(), DeviceAuthorizationRequest{ClientId: cli.Id, Scope: "openid profile"})
+		So(err, ShouldBeNil)
+		So(device.DeviceCode, ShouldNotBeBlank)
+		So(device.UserCode, ShouldHaveLength, 9)
+		So(device.VerificationUri, ShouldEqual, "http://app.com/device")
+		So(device.VerificationUriComplete, ShouldEqual, "http://app.com/device?user_code="+device.UserCode)
+		So(device.Interval, ShouldEqual, 5)
+
+		tokenRequest := TokenRequest{GrantType: grantDeviceCode, ClientId: cli.Id, DeviceCode: device.DeviceCode}
+
+		//moves last poll back so next poll is not too early
+		waitInterval := func() {
+			deviceGrantsDal.UpdatePolling(hashCode(device.DeviceCode), time.Now().Add(-time.Minute), devicePollInterval)
+		}
+
+		Convey("keep device waiting until user approves code", func() {
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeAuthorizationPending)
+
+			verification, err := service.GetDeviceVerification(strings.ToLower(strings.Replace(device.UserCode, "-", " ", 1)))
+			So(err, ShouldBeNil)
+			So(verification.ClientName, ShouldEqual, cli.Name)
+			So(verification.Scope, ShouldEqual, "openid profile")
+
+			So(service.VerifyDevice(device.UserCode, true, account.Id, account.Username), ShouldBeNil)
+			So(service.VerifyDevice(device.UserCode, false, "other", "other"), ShouldEqual, ErrInvalidUserCode)
+
+			waitInterval()
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+			So(tokenService.GetClaims(tokens.AccessToken)["sub"], ShouldEqual, account.Id)
+			So(tokens.IdToken, ShouldNotBeBlank)
+
+			_, err = service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidGrant)
+		})
+
+		Convey("ask device to slow down when it polls too often", func() {
+
+			service.Token(context.Background(), tokenRequest)
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeSlowDown)
+
+			grant, _ := deviceGrantsDal.GetById(hashCode(device.DeviceCode))
+			So(grant.Interval, ShouldEqual, devicePollInterval+devicePollSlowDown)
+		})
+
+		Convey("tell device when user denied access", func() {
+
+			So(service.VerifyDevice(device.UserCode, false, account.Id, account.Username), ShouldBeNil)
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeAccessDenied)
+		})
+
+		Convey("tell device when code expired", func() {
+
+			grant, _ := deviceGrantsDal.GetById(hashCode(device.DeviceCode))
+			grant.ExpiresAt = time.Now().Add(-time.Second)
+			deviceGrantsDal.Save(grant)
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeExpiredToken)
+
+			_, err = service.GetDeviceVerification(device.UserCode)
+			So(err, ShouldEqual, ErrInvalidUserCode)
+		})
+
+		Convey("reject device code of other client", func() {
+
+			tokenRequest.ClientId = client.Id
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+
+			_, err = service.AuthorizeDevice(context.Background(), DeviceAuthorizationRequest{ClientId: client.Id})
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+		})
+
+		Convey("reject unknown user code", func() {
+
+			So(service.VerifyDevice("BCDF-GHJK", true, account.Id, account.Username), ShouldEqual, ErrInvalidUserCode)
+			So(service.VerifyDevice("invalid", true, account.Id, account.Username), ShouldEqual, ErrInvalidUserCode)
+		})
+	})
+
+	Convey("Token exchange should", t, func() {
+
+		clientsDal := createInMemoryClientsDal(client)
+		exchanges := []TokenExchange{}
+		service := CreateService(conf, clientsDal, createInMemoryCodesDal(), createInMemoryDeviceGrantsDal(), createInMemoryExchangesDal(&exchanges), accountsDal, tokenService, createTestEncrypt())
+
+		gatewayDto := CreateClientDto{
+			Name:       "gateway",
+			GrantTypes: []string{grantTokenExchange},
+			Audiences:  []string{"orders-api"},
+		}
+		gateway, err := service.CreateServiceClient(context.Background(), "owner", gatewayDto)
+		So(err, ShouldBeNil)
+
+		userToken, _ := tokenService.GenerateToken(jwtTokens.AccountClaims{Username: account.Username, AccountId: account.Id, Roles: []string{"admin"}})
+		userClaims := tokenService.GetClaims(userToken)
+
+		tokenRequest := TokenRequest{
+			GrantType:        grantTokenExchange,
+			ClientId:         gateway.Id,
+			ClientSecret:     gateway.ClientSecret,
+			SubjectToken:     userToken,
+			SubjectTokenType: tokenTypeAccessToken,
+			Audience:         "orders-api",
+			Scope:            "profile",
+		}
+
+		Convey("issue narrower token for audience with client as actor", func() {
+
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+			So(tokens.IssuedTokenType, ShouldEqual, tokenTypeAccessToken)
+			So(tokens.Scope, ShouldEqual, "profile")
+
+			claims, _ := jwtParts(tokens.AccessToken)
+			So(claims["sub"], ShouldEqual, account.Id)
+			So(claims["aud"], ShouldEqual, "orders-api")
+			So(claims["roles"], ShouldBeNil)
+			So(claims["username"], ShouldBeNil)
+			So(claims["act"], ShouldResemble, map[string]interface{}{"sub": gateway.Id})
+			So(claims["exp"], ShouldBeLessThanOrEqualTo, userClaims["exp"])
+
+			So(exchanges, ShouldHaveLength, 1)
+			So(exchanges[0].Id, ShouldEqual, claims["jti"])
+			So(exchanges[0].SubjectTokenId, ShouldEqual, userClaims["jti"])
+			So(exchanges[0].ClientId, ShouldEqual, gateway.Id)
+		})
+
+		Convey("issue token which can be introspected and revoked", func() {
+
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+
+			request := TokenActionRequest{ClientId: gateway.Id, ClientSecret: gateway.ClientSecret, Token: tokens.AccessToken}
+			response, err := service.Introspect(context.Background(), request)
+			So(err, ShouldBeNil)
+			So(response.Active, ShouldBeTrue)
+			So(response.Aud, ShouldEqual, "orders-api")
+
+			So(service.Revoke(context.Background(), request), ShouldBeNil)
+			response, _ = service.Introspect(context.Background(), request)
+			So(response.Active, ShouldBeFalse)
+		})
+
+		Convey("allow only admins to register clients with token exchange", func() {
+
+			_, err := service.CreateClient(context.Background(), "owner", gatewayDto)
+			So(err, ShouldEqual, ErrServiceGrant)
+
+			_, err = service.CreateClient(context.Background(), "owner", CreateClientDto{Name: "gateway", Audiences: []string{"orders-api"}})
+			So(err, ShouldEqual, ErrServiceGrant)
+
+			//client registered by user before token exchange was limited to service clients
+			legacy, _ := clientsDal.GetById(gateway.Id)
+			legacy.Service = false
+			clientsDal.Save(legacy)
+
+			_, err = service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+		})
+
+		Convey("not register audience which is not configured", func() {
+
+			gatewayDto.Audiences = []string{"unknown-api"}
+			_, err := service.CreateServiceClient(context.Background(), "owner", gatewayDto)
+			So(err, ShouldEqual, ErrUnknownAudience)
+		})
+
+		Convey("not exchange token which is not access token", func() {
+
+			idToken, _ := tokenService.SignJwt(map[string]interface{}{"sub": account.Id, "scope": "profile"}, time.Minute)
+			tokenRequest.SubjectToken = idToken
+
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldResemble, Error{ErrCodeInvalidGrant, "invalid subject token"})
+		})
+
+		Convey("keep previous actor nested in act claim", func() {
+
+			delegated, _ := tokenService.Sign(map[string]interface{}{
+				"sub":       account.Id,
+				"scope":     "profile",
+				"client_id": "frontend-gateway",
+				"act":       map[string]interface{}{"sub": "frontend-gateway"},
+				"token_use": "client_access",
+			}, time.Minute)
+
+			tokenRequest.SubjectToken = delegated
+			tokens, err := service.Token(context.Background(), tokenRequest)
+			So(err, ShouldBeNil)
+
+			claims, _ := jwtParts(tokens.AccessToken)
+			So(claims["act"], ShouldResemble, map[string]interface{}{
+				"sub": gateway.Id,
+				"act": map[string]interface{}{"sub": "frontend-gateway"},
+			})
+			So(tokens.ExpiresIn, ShouldBeLessThanOrEqualTo, 60)
+		})
+
+		Convey("not allow scopes which subject token does not have", func() {
+
+			tokenRequest.Scope = "profile accounts:write"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidScope)
+			So(exchanges, ShouldBeEmpty)
+		})
+
+		Convey("not allow audience which is not registered for client", func() {
+
+			tokenRequest.Audience = "billing-api"
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidTarget)
+		})
+
+		Convey("reject invalid subject token", func() {
+
+			tokenService.Revoke(userToken)
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidGrant)
+		})
+
+		Convey("reject clients without token exchange grant", func() {
+
+			worker, _ := service.CreateServiceClient(context.Background(), "owner", CreateClientDto{Name: "worker", Scopes: []string{"accounts:read"}})
+			tokenRequest.ClientId, tokenRequest.ClientSecret = worker.Id, worker.ClientSecret
+			_, err := service.Token(context.Background(), tokenRequest)
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+		})
+	})
+
+	Convey("Introspection and revocation should", t, func() {
+
+		clientsDal := createInMemoryClientsDal(client)
+		service := CreateService(conf, clientsDal, createInMemoryCodesDal(), createInMemoryDeviceGrantsDal(), createInMemoryExchangesDal(), accountsDal, tokenService, createTestEncrypt())
+
+		resourceServer, _ := service.CreateServiceClient(context.Background(), "owner", CreateClientDto{Name: "legacy api", Scopes: []string{"accounts:read"}})
+		userClient, _ := service.CreateClient(context.Background(), "owner", CreateClientDto{Name: "user app"})
+		otherClient, _ := service.CreateServiceClient(context.Background(), "owner", CreateClientDto{Name: "other", Scopes: []string{"accounts:read"}})
+
+		userToken, _ := tokenService.GenerateToken(jwtTokens.AccountClaims{Username: account.Username, AccountId: account.Id})
+		request := TokenActionRequest{
+			ClientId:     resourceServer.Id,
+			ClientSecret: resourceServer.ClientSecret,
+			Token:        userToken,
+		}
+
+		Convey("describe active token", func() {
+
+			response, err := service.Introspect(context.Background(), request)
+			So(err, ShouldBeNil)
+			So(response.Active, ShouldBeTrue)
+			So(response.Sub, ShouldEqual, account.Id)
+			So(response.Username, ShouldEqual, account.Username)
+			So(response.Exp, ShouldBeGreaterThan, time.Now().Unix())
+		})
+
+		Convey("report invalid token as inactive", func() {
+
+			request.Token = "invalid"
+			response, err := service.Introspect(context.Background(), request)
+			So(err, ShouldBeNil)
+			So(response.Active, ShouldBeFalse)
+			So(response.Sub, ShouldBeBlank)
+		})
+
+		Convey("require client authentication", func() {
+
+			request.ClientSecret = "wrong"
+			_, err := service.Introspect(context.Background(), request)
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidClient)
+
+			_, err = service.Introspect(context.Background(), TokenActionRequest{ClientId: client.Id, Token: userToken})
+			So(err.(Error).Code, ShouldEqual, ErrCodeInvalidClient)
+		})
+
+		Convey("let only service clients introspect tokens", func() {
+
+			_, err := service.Introspect(context.Background(), TokenActionRequest{ClientId: userClient.Id, ClientSecret: userClient.ClientSecret, Token: userToken})
+			So(err.(Error).Code, ShouldEqual, ErrCodeUnauthorizedClient)
+		})
+
+		Convey("revoke token issued to client so it is no longer active", func() {
+
+			tokens, _ := service.Token(context.Background(), TokenRequest{
+				GrantType:    grantClientCredentials,
+				ClientId:     resourceServer.Id,
+				ClientSecret: resourceServer.ClientSecret,
+			})
+
+			request.Token = tokens.AccessToken
+			So(service.Revoke(context.Background(), request), ShouldBeNil)
+
+			response, _ := service.Introspect(context.Background(), request)
+			So(response.Active, ShouldBeFalse)
+		})
+
+		Convey("not revoke first party token", func() {
+
+			for _, caller := range []*ClientWithSecret{resourceServer, userClient} {
+				So(service.Revoke(context.Background(), TokenActionRequest{ClientId: caller.Id, ClientSecret: caller.ClientSecret, Token: userToken}), ShouldBeNil)
+			}
+
+			response, _ := service.Introspect(context.Background(), request)
+			So(response.Active, ShouldBeTrue)
+		})
+
+		Convey("not revoke token issued to other client", func() {
+
+			otherTokens, _ := service.Token(context.Background(), TokenRequest{
+				GrantType:    grantClientCredentials,
+				ClientId:     otherClient.Id,
+				ClientSecret: otherClient.ClientSecret,
+			})
+
+			request.Token = otherTokens.AccessToken
+			So(service.Revoke(context.Background(), request), ShouldBeNil)
+
+			response, _ := service.Introspect(context.Background(), request)
+			So(response.Active, ShouldBeTrue)
+			So(response.ClientId, ShouldEqual, otherClient.Id)
+		})
+	})
+}
+
+//jwtParts decodes token payload without verification, id token audience is client so it cannot be checked with GetClaims
+func jwtParts(token string) (map[string]interface{}, error) {
+
+	claims := map[string]interface{}{}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	if err != nil {
+		return claims, err
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	return claims, err
+}
@@ -0,0 +1,86 @@
+package refreshTokens
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+)
+
+//Dal for refresh tokens collection
+type Dal struct {
+	GetById      func(id string) (RefreshToken, error)
+	Save         func(token RefreshToken) error
+	MarkReplaced func(id string, replacedBy string) error
+	RevokeFamily func(familyId string) error
+	//RevokeAccount revokes tokens of all families of account
+	RevokeAccount func(accountId string) error
+}
+
+//CreateDal wraps generic dal with refresh token specific operations, expired tokens are removed by mongo
+func CreateDal(tokensRepo dal.Dal) Dal {
+
+	var log = logging.MustGetLogger("[RefreshTokensDal]")
+
+	if err := tokensRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for refresh tokens. Details: ", err)
+	}
+
+	getById := func(id string) (RefreshToken, error) {
+
+		token := RefreshToken{}
+		if err := tokensRepo.GetById(id, &token); err != nil {
+			return token, err
+		}
+
+		if len(token.Id) == 0 {
+			return token, ErrInvalidRefreshToken
+		}
+
+		return token, nil
+	}
+
+	save := func(token RefreshToken) error {
+		_, err := tokensRepo.Save(token)
+		return err
+	}
+
+	markReplaced := func(id string, replacedBy string) error {
+
+		//only token which was not rotated yet can be marked, so concurrent refreshes are detected
+		query := dal.NewQueryBuilder().WithId(id).WithField("replacedBy", "").Build()
+		err := tokensRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"replacedBy": replacedBy},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrRefreshTokenReused
+		}
+
+		return err
+	}
+
+	revokeFamily := func(familyId string) error {
+
+		query := dal.NewQueryBuilder().WithField("familyId", familyId).Build()
+		return tokensRepo.UpdateAllByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"revoked": true},
+		})
+	}
+
+	revokeAccount := func(accountId string) error {
+
+		query := dal.NewQueryBuilder().WithField("accountId", accountId).Build()
+		return tokensRepo.UpdateAllByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"revoked": true},
+		})
+	}
+
+	return Dal{
+		GetById:       getById,
+		Save:          save,
+		MarkReplaced:  markReplaced,
+		RevokeFamily:  revokeFamily,
+		RevokeAccount: revokeAccount,
+	}
+}
@@ -0,0 +1,38 @@
+package refreshTokens
+
+import "time"
+
+//RefreshToken is stored server side, Id is hash of opaque token handed to client
+type RefreshToken struct {
+	Id         string    `bson:"_id"`
+	FamilyId   string    `bson:"familyId"`
+	AccountId  string    `bson:"accountId"`
+	Username   string    `bson:"username"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	ExpiresAt  time.Time `bson:"expiresAt"`
+	ReplacedBy string    `bson:"replacedBy"`
+	Revoked    bool      `bson:"revoked"`
+	//FamilyCreatedAt is time of login which started family, rotated tokens keep it
+	FamilyCreatedAt time.Time `bson:"familyCreatedAt"`
+}
+
+//IsRotated returns true when token was already exchanged for new one
+func (token RefreshToken) IsRotated() bool {
+	return len(token.ReplacedBy) > 0
+}
+
+//familyStart returns time from which lifetime of family is counted,
+//tokens issued before families had limited lifetime count it from their last rotation
+func (token RefreshToken) familyStart() time.Time {
+
+	if token.FamilyCreatedAt.IsZero() {
+		return token.CreatedAt
+	}
+
+	return token.FamilyCreatedAt
+}
+
+//IsExpired checks if token can still be used at given time
+func (token RefreshToken) IsExpired(now time.Time) bool {
+	return now.After(token.ExpiresAt)
+}
@@ -0,0 +1,161 @@
+package refreshTokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/satori/go.uuid"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrInvalidRefreshToken       = errors.New("Invalid refresh token")
+	ErrRefreshTokenReused        = errors.New("Refresh token was already used")
+	ErrCouldNotIssueRefreshToken = errors.New("Could not issue refresh token")
+)
+
+const refreshTokenBytes = 32
+
+//DefaultLifetime of refresh token, counted from last rotation
+const DefaultLifetime = time.Hour * 24 * 30
+
+//DefaultMaxLifetime of refresh token family, counted from login, family cannot be refreshed after it
+const DefaultMaxLifetime = time.Hour * 24 * 90
+
+//Service issues opaque refresh tokens and rotates them on every use
+type Service struct {
+	Issue func(accountId string, username string) (string, error)
+	//IssueInFamily starts family with given id, so it can be revoked together with session
+	IssueInFamily func(familyId string, accountId string, username string) (string, error)
+	Rotate        func(token string) (RefreshToken, string, error)
+	Revoke        func(token string) error
+	RevokeFamily  func(familyId string) error
+	//RevokeAccount revokes refresh tokens of sessions and clients of account
+	RevokeAccount func(accountId string) error
+}
+
+//CreateService creates refresh token service, issued tokens are valid for lifetime since last rotation,
+//but no longer than maxLifetime since login which started their family
+func CreateService(tokensDal Dal, lifetime time.Duration, maxLifetime time.Duration) Service {
+
+	var log = logging.MustGetLogger("[RefreshTokenService]")
+
+	save := func(familyId string, familyCreatedAt time.Time, accountId string, username string) (string, string, error) {
+
+		opaque, err := generateOpaqueToken()
+		if err != nil {
+			log.Error("Could not generate refresh token. Details: ", err)
+			return "", "", ErrCouldNotIssueRefreshToken
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(lifetime)
+		if familyExpiresAt := familyCreatedAt.Add(maxLifetime); familyExpiresAt.Before(expiresAt) {
+			expiresAt = familyExpiresAt
+		}
+
+		token := RefreshToken{
+			Id:              hashToken(opaque),
+			FamilyId:        familyId,
+			AccountId:       accountId,
+			Username:        username,
+			CreatedAt:       now,
+			ExpiresAt:       expiresAt,
+			FamilyCreatedAt: familyCreatedAt,
+		}
+
+		if err := tokensDal.Save(token); err != nil {
+			log.Error("Could not save refresh token. Details: ", err)
+			return "", "", ErrCouldNotIssueRefreshToken
+		}
+
+		return opaque, token.Id, nil
+	}
+
+	issueInFamily := func(familyId string, accountId string, username string) (string, error) {
+		opaque, _, err := save(familyId, time.Now(), accountId, username)
+		return opaque, err
+	}
+
+	issue := func(accountId string, username string) (string, error) {
+		return issueInFamily(uuid.NewV4().String(), accountId, username)
+	}
+
+	rotate := func(opaque string) (RefreshToken, string, error) {
+
+		token, err := tokensDal.GetById(hashToken(opaque))
+		if err != nil {
+			return RefreshToken{}, "", ErrInvalidRefreshToken
+		}
+
+		//expiry of token is capped by lifetime of family, family is checked too for tokens saved before it was
+		now := time.Now()
+		if token.Revoked || token.IsExpired(now) || now.After(token.familyStart().Add(maxLifetime)) {
+			return RefreshToken{}, "", ErrInvalidRefreshToken
+		}
+
+		if token.IsRotated() {
+			log.Warningf("Refresh token reuse detected, revoking family %s", token.FamilyId)
+			if err := tokensDal.RevokeFamily(token.FamilyId); err != nil {
+				log.Error("Could not revoke refresh token family. Details: ", err)
+			}
+			return RefreshToken{}, "", ErrRefreshTokenReused
+		}
+
+		newOpaque, newId, err := save(token.FamilyId, token.familyStart(), token.AccountId, token.Username)
+		if err != nil {
+			return RefreshToken{}, "", err
+		}
+
+		if err := tokensDal.MarkReplaced(token.Id, newId); err != nil {
+			if err == ErrRefreshTokenReused {
+				log.Warningf("Concurrent refresh token reuse detected, revoking family %s", token.FamilyId)
+				tokensDal.RevokeFamily(token.FamilyId)
+				return RefreshToken{}, "", ErrRefreshTokenReused
+			}
+
+			log.Error("Could not rotate refresh token. Details: ", err)
+			return RefreshToken{}, "", ErrCouldNotIssueRefreshToken
+		}
+
+		return token, newOpaque, nil
+	}
+
+	revoke := func(opaque string) error {
+
+		token, err := tokensDal.GetById(hashToken(opaque))
+		if err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		return tokensDal.RevokeFamily(token.FamilyId)
+	}
+
+	return Service{
+		Issue:         issue,
+		IssueInFamily: issueInFamily,
+		Rotate:        rotate,
+		Revoke:        revoke,
+		RevokeFamily:  tokensDal.RevokeFamily,
+		RevokeAccount: tokensDal.RevokeAccount,
+	}
+}
+
+func generateOpaqueToken() (string, error) {
+
+	tokenBytes := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+func hashToken(opaque string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(opaque)))
+}
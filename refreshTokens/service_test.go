@@ -0,0 +1,166 @@
+package refreshTokens
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func createInMemoryDal() (Dal, map[string]RefreshToken) {
+
+	tokens := map[string]RefreshToken{}
+
+	return Dal{
+		GetById: func(id string) (RefreshToken, error) {
+			token, ok := tokens[id]
+			if !ok {
+				return token, ErrInvalidRefreshToken
+			}
+			return token, nil
+		},
+		Save: func(token RefreshToken) error {
+			tokens[token.Id] = token
+			return nil
+		},
+		MarkReplaced: func(id string, replacedBy string) error {
+			token := tokens[id]
+			if token.IsRotated() {
+				return ErrRefreshTokenReused
+			}
+			token.ReplacedBy = replacedBy
+			tokens[id] = token
+			return nil
+		},
+		RevokeFamily: func(familyId string) error {
+			for id, token := range tokens {
+				if token.FamilyId == familyId {
+					token.Revoked = true
+					tokens[id] = token
+				}
+			}
+			return nil
+		},
+		RevokeAccount: func(accountId string) error {
+			for id, token := range tokens {
+				if token.AccountId == accountId {
+					token.Revoked = true
+					tokens[id] = token
+				}
+			}
+			return nil
+		},
+	}, tokens
+}
+
+func TestService(t *testing.T) {
+
+	Convey("Refresh token service should", t, func() {
+
+		tokensDal, tokens := createInMemoryDal()
+		service := CreateService(tokensDal, time.Hour, DefaultMaxLifetime)
+
+		Convey("issue token which is stored hashed", func() {
+
+			token, err := service.Issue("accId", "user")
+
+			So(err, ShouldBeNil)
+			So(token, ShouldNotBeBlank)
+			So(tokens, ShouldHaveLength, 1)
+			So(tokens[token], ShouldResemble, RefreshToken{})
+			So(tokens[hashToken(token)].AccountId, ShouldEqual, "accId")
+		})
+
+		Convey("issue token in given family", func() {
+
+			token, err := service.IssueInFamily("sessionId", "accId", "user")
+
+			So(err, ShouldBeNil)
+			So(tokens[hashToken(token)].FamilyId, ShouldEqual, "sessionId")
+
+			So(service.RevokeFamily("sessionId"), ShouldBeNil)
+			_, _, err = service.Rotate(token)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("rotate token into new one from same family", func() {
+
+			token, _ := service.Issue("accId", "user")
+			old, rotated, err := service.Rotate(token)
+
+			So(err, ShouldBeNil)
+			So(rotated, ShouldNotEqual, token)
+			So(old.AccountId, ShouldEqual, "accId")
+			So(tokens[hashToken(rotated)].FamilyId, ShouldEqual, tokens[hashToken(token)].FamilyId)
+
+			_, _, err = service.Rotate(rotated)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("revoke tokens of all families of account", func() {
+
+			first, _ := service.Issue("accId", "user")
+			second, _ := service.IssueInFamily("sessionId", "accId", "user")
+			other, _ := service.Issue("otherId", "other")
+
+			So(service.RevokeAccount("accId"), ShouldBeNil)
+
+			_, _, err := service.Rotate(first)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+			_, _, err = service.Rotate(second)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+			_, _, err = service.Rotate(other)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("revoke whole family when rotated token is reused", func() {
+
+			token, _ := service.Issue("accId", "user")
+			_, rotated, _ := service.Rotate(token)
+
+			_, _, err := service.Rotate(token)
+			So(err, ShouldEqual, ErrRefreshTokenReused)
+
+			_, _, err = service.Rotate(rotated)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("not extend family beyond max lifetime", func() {
+
+			cappedService := CreateService(tokensDal, time.Hour, time.Minute)
+			token, _ := cappedService.Issue("accId", "user")
+			issued := tokens[hashToken(token)]
+			So(issued.ExpiresAt, ShouldEqual, issued.FamilyCreatedAt.Add(time.Minute))
+
+			_, rotated, err := cappedService.Rotate(token)
+			So(err, ShouldBeNil)
+			So(tokens[hashToken(rotated)].FamilyCreatedAt, ShouldEqual, issued.FamilyCreatedAt)
+			So(tokens[hashToken(rotated)].ExpiresAt, ShouldEqual, issued.ExpiresAt)
+		})
+
+		Convey("reject token of family older than max lifetime", func() {
+
+			token, _ := service.Issue("accId", "user")
+			started := tokens[hashToken(token)]
+			started.FamilyCreatedAt = time.Now().Add(-DefaultMaxLifetime - time.Minute)
+			tokens[started.Id] = started
+
+			_, _, err := service.Rotate(token)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("reject unknown token", func() {
+
+			_, _, err := service.Rotate("unknown")
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+
+		Convey("reject expired token", func() {
+
+			expiredService := CreateService(tokensDal, -time.Minute, DefaultMaxLifetime)
+			token, _ := expiredService.Issue("accId", "user")
+
+			_, _, err := service.Rotate(token)
+			So(err, ShouldEqual, ErrInvalidRefreshToken)
+		})
+	})
+}
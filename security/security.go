@@ -9,20 +9,113 @@ import (
 	"github.com/piotrjaromin/go-login-backend/web"
 	"io/ioutil"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const CLAIM_IN_QUOTES_PATTERN = "\".*\""
 
+//RoleAdmin is allowed to access resources of every account
+const RoleAdmin = "admin"
+
+//ApiKeyPrefix marks api keys sent as bearer value instead of jwt
+const ApiKeyPrefix = "lbk_"
+
+//ApiKeyHeader can be used to send api key instead of authorization header
+const ApiKeyHeader = "X-API-Key"
+
+//DefaultMaxAuthAge is how long after login or reauthentication sensitive operations are allowed
+const DefaultMaxAuthAge = time.Minute * 5
+
+//ReauthenticationRequired is error code returned when user has to reauthenticate, frontend should ask for password
+const ReauthenticationRequired = "reauthentication_required"
+
 type Security struct {
 	tokenService jwtTokens.TokenService
+	apiKeyClaims func(key string) map[string]interface{}
+	maxAuthAge   time.Duration
 }
 
 func CreateSecurity(tokenService jwtTokens.TokenService) Security {
-	return Security{tokenService: tokenService}
+	return Security{
+		tokenService: tokenService,
+		apiKeyClaims: func(key string) map[string]interface{} {
+			return map[string]interface{}{}
+		},
+		maxAuthAge: DefaultMaxAuthAge,
+	}
+}
+
+//WithMaxAuthAge returns security in which RequireRecentAuth accepts tokens authenticated within maxAuthAge
+func (sec Security) WithMaxAuthAge(maxAuthAge time.Duration) Security {
+	if maxAuthAge > 0 {
+		sec.maxAuthAge = maxAuthAge
+	}
+	return sec
+}
+
+//WithApiKeys returns security which also accepts api keys, apiKeyClaims returns empty map for invalid key
+func (sec Security) WithApiKeys(apiKeyClaims func(key string) map[string]interface{}) Security {
+	sec.apiKeyClaims = apiKeyClaims
+	return sec
+}
+
+//getClaims returns claims of api key or first party access token sent with request,
+//other tokens, like id tokens or tokens issued to oauth clients, have no claims here
+func (sec Security) getClaims(c echo.Context) (map[string]interface{}, bool) {
+	return sec.getAllowedClaims(c, jwtTokens.IsAccessToken)
+}
+
+//getScopeClaims works like getClaims, but also returns claims of tokens issued to oauth clients, they can be used only where scope is checked
+func (sec Security) getScopeClaims(c echo.Context) (map[string]interface{}, bool) {
+	return sec.getAllowedClaims(c, func(claims map[string]interface{}) bool {
+		return jwtTokens.IsAccessToken(claims) || jwtTokens.IsClientAccessToken(claims)
+	})
+}
+
+func (sec Security) getAllowedClaims(c echo.Context, allowed func(claims map[string]interface{}) bool) (map[string]interface{}, bool) {
+
+	if apiKey, found := GetApiKey(c); found {
+		return sec.apiKeyClaims(apiKey), true
+	}
+
+	if token, found := GetToken(c); found {
+		//tokens exchanged for other services are valid, so they can be introspected, but they are not meant for this api
+		claims := sec.tokenService.GetClaims(token)
+		if !allowed(claims) || jwtTokens.HasAnyAudience(claims, sec.tokenService.ResourceAudiences) {
+			return map[string]interface{}{}, true
+		}
+		return claims, true
+	}
+
+	return map[string]interface{}{}, false
+}
+
+//validate checks if claim of api key or access token sent with request has given value
+func (sec Security) validate(c echo.Context, claimName string, claimValue string) bool {
+
+	if apiKey, found := GetApiKey(c); found {
+		return sec.apiKeyClaims(apiKey)[claimName] == claimValue
+	}
+
+	token, _ := GetToken(c)
+	return jwtTokens.IsAccessToken(sec.tokenService.GetClaims(token)) && sec.tokenService.Validate(token, claimName, claimValue)
 }
 
-func (sec Security) SecuredById(tokenClaimName string, requestClaimName string, claimInBody bool) func(next echo.HandlerFunc) echo.HandlerFunc {
+//SecuredById allows request when token claim matches id from request, admins and tokens with any of bypassScopes are always allowed.
+//Api keys are allowed only with bypassScopes, key matching account would let anyone who has it manage account, e.g. register passkey
+func (sec Security) SecuredById(tokenClaimName string, requestClaimName string, claimInBody bool, bypassScopes ...string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return sec.securedById(tokenClaimName, requestClaimName, claimInBody, "", bypassScopes)
+}
+
+//SecuredByIdWithKeyScope works like SecuredById, but also allows api key of account from request when key has keyScope,
+//it is used by routes which only read account, so scripts can do it with key of account
+func (sec Security) SecuredByIdWithKeyScope(tokenClaimName string, requestClaimName string, claimInBody bool, keyScope string, bypassScopes ...string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return sec.securedById(tokenClaimName, requestClaimName, claimInBody, keyScope, bypassScopes)
+}
+
+func (sec Security) securedById(tokenClaimName string, requestClaimName string, claimInBody bool, keyScope string, bypassScopes []string) func(next echo.HandlerFunc) echo.HandlerFunc {
 
 	var log = logging.MustGetLogger("[Security]")
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -32,8 +125,7 @@ func (sec Security) SecuredById(tokenClaimName string, requestClaimName string,
 				return nil
 			}
 
-			token, found := getToken(c)
-			if !found {
+			if !hasCredentials(c) {
 				return web.UnauthorizedResponse(c, "Invalid authorization header")
 			}
 
@@ -69,7 +161,25 @@ func (sec Security) SecuredById(tokenClaimName string, requestClaimName string,
 				idValue = c.Param("id")
 			}
 
-			valid := sec.tokenService.Validate(token, tokenClaimName, idValue)
+			if apiKey, found := GetApiKey(c); found {
+				claims := sec.apiKeyClaims(apiKey)
+				for _, scope := range bypassScopes {
+					if jwtTokens.HasScope(claims, scope) {
+						log.Info("api key access granted by scope " + scope)
+						return next(c)
+					}
+				}
+
+				if len(keyScope) > 0 && jwtTokens.HasScope(claims, keyScope) && claims[tokenClaimName] == idValue {
+					log.Info("api key access to " + idValue + " granted by scope " + keyScope)
+					c.Set(tokenClaimName, idValue)
+					return next(c)
+				}
+
+				return web.UnauthorizedResponse(c, "Api key cannot be used to perform this method")
+			}
+
+			valid := sec.validate(c, tokenClaimName, idValue)
 
 			if valid {
 				log.Info("saving " + tokenClaimName + " with value " + idValue)
@@ -77,20 +187,37 @@ func (sec Security) SecuredById(tokenClaimName string, requestClaimName string,
 				return next(c)
 			}
 
+			if claims, _ := sec.getClaims(c); jwtTokens.HasRole(claims, RoleAdmin) {
+				log.Info("admin access to " + idValue)
+				return next(c)
+			}
+
+			//oauth clients act only within granted scopes, they never pass as account owner or admin
+			claims, _ := sec.getScopeClaims(c)
+			for _, scope := range bypassScopes {
+				if jwtTokens.HasScope(claims, scope) {
+					log.Info("access to " + idValue + " granted by scope " + scope)
+					return next(c)
+				}
+			}
+
 			log.Info("invalid claim")
 			return web.UnauthorizedResponse(c, "You do not have required scopes to perform this method")
 		}
 	}
 }
 
+//FillClaims copies claims of token to request, it is used by pages where user acts in browser, so api keys are ignored
 func (sec Security) FillClaims() func(next echo.HandlerFunc) echo.HandlerFunc {
 	var log = logging.MustGetLogger("[Security]")
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 
-			token, found := getToken(c)
-			if found {
-				claims := sec.tokenService.GetClaims(token)
+			if _, isApiKey := GetApiKey(c); isApiKey {
+				return next(c)
+			}
+
+			if claims, found := sec.getClaims(c); found {
 				for key, value := range claims {
 					log.Debugf("adding claim to request key %s, value %v", key, value)
 					c.Set(key, value)
@@ -102,7 +229,118 @@ func (sec Security) FillClaims() func(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func getToken(c echo.Context) (string, bool) {
+//RequireRole allows only requests with first party token containing given role
+func (sec Security) RequireRole(role string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return sec.requireClaim(sec.getClaims, func(claims map[string]interface{}) bool {
+		return jwtTokens.HasRole(claims, role)
+	}, "Role "+role+" is required to perform this method")
+}
+
+//RequireScope allows only requests with token containing given scope, tokens of oauth clients are accepted, admins are always allowed
+func (sec Security) RequireScope(scope string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return sec.requireClaim(sec.getScopeClaims, func(claims map[string]interface{}) bool {
+		return jwtTokens.HasScope(claims, scope) || (jwtTokens.IsAccessToken(claims) && jwtTokens.HasRole(claims, RoleAdmin))
+	}, "Scope "+scope+" is required to perform this method")
+}
+
+func (sec Security) requireClaim(getClaims func(c echo.Context) (map[string]interface{}, bool), allowed func(claims map[string]interface{}) bool, forbiddenMsg string) func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			if c.Request().Method == "OPTIONS" {
+				return nil
+			}
+
+			claims, found := getClaims(c)
+			if !found {
+				return web.UnauthorizedResponse(c, "Invalid authorization header")
+			}
+
+			if len(claims) == 0 {
+				return web.UnauthorizedResponse(c, "Invalid token")
+			}
+
+			if !allowed(claims) {
+				return web.ForbiddenResponse(c, forbiddenMsg)
+			}
+
+			for key, value := range claims {
+				c.Set(key, value)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+//RequireRecentAuth allows only tokens whose user authenticated recently(auth_time claim), it is used for sensitive operations.
+//Api keys and older tokens are rejected with ReauthenticationRequired code and step-up challenge(RFC 9470)
+func (sec Security) RequireRecentAuth() func(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			if c.Request().Method == "OPTIONS" {
+				return nil
+			}
+
+			claims, found := sec.getClaims(c)
+			if !found {
+				return web.UnauthorizedResponse(c, "Invalid authorization header")
+			}
+
+			if len(claims) == 0 {
+				return web.UnauthorizedResponse(c, "Invalid token")
+			}
+
+			authTime, ok := jwtTokens.AuthTime(claims)
+			if !ok || time.Since(authTime) > sec.maxAuthAge {
+				maxAge := strconv.Itoa(int(sec.maxAuthAge.Seconds()))
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="insufficient_user_authentication", max_age=`+maxAge)
+				return web.UnauthorizedResponseWithCode(c, "Recent authentication is required to perform this method", ReauthenticationRequired)
+			}
+
+			for key, value := range claims {
+				c.Set(key, value)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+//GetToken reads bearer token from authorization header, api keys are not returned
+func GetToken(c echo.Context) (string, bool) {
+
+	bearer, found := getBearer(c)
+	if !found || strings.HasPrefix(bearer, ApiKeyPrefix) {
+		return "", false
+	}
+
+	return bearer, true
+}
+
+//GetApiKey reads api key from X-API-Key header or from prefixed bearer value
+func GetApiKey(c echo.Context) (string, bool) {
+
+	if apiKey := c.Request().Header.Get(ApiKeyHeader); len(apiKey) > 0 {
+		return apiKey, true
+	}
+
+	bearer, found := getBearer(c)
+	if !found || !strings.HasPrefix(bearer, ApiKeyPrefix) {
+		return "", false
+	}
+
+	return bearer, true
+}
+
+func hasCredentials(c echo.Context) bool {
+	_, isToken := GetToken(c)
+	_, isApiKey := GetApiKey(c)
+	return isToken || isApiKey
+}
+
+func getBearer(c echo.Context) (string, bool) {
 	authHeader := c.Request().Header.Get("Authorization")
 
 	if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
@@ -0,0 +1,281 @@
+package security
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurity(t *testing.T) {
+
+	tokens := map[string]map[string]interface{}{
+		"user":    {"username": "john", "roles": []interface{}{"user"}, "scope": "openid profile", "token_use": "access"},
+		"admin":   {"username": "root", "roles": []interface{}{"user", RoleAdmin}, "token_use": "access"},
+		"service": {"sub": "client", "client_id": "client", "scope": "accounts:read", "token_use": "client_access"},
+		//token issued to oauth client on behalf of user
+		"app": {"sub": "root", "username": "root", "roles": []interface{}{RoleAdmin}, "client_id": "app", "scope": "profile", "token_use": "client_access"},
+		//token with client id is never first party, even if it claims to be access token
+		//token exchanged for other service
+		"exchanged": {"sub": "root", "aud": "orders-api", "client_id": "gateway", "scope": "accounts:read", "token_use": "client_access"},
+		"legacyApp": {"username": "root", "roles": []interface{}{RoleAdmin}, "client_id": "app", "scope": "profile", "token_use": "access"},
+		//id token is signed with the same keys as access tokens
+		"idToken": {"username": "root", "roles": []interface{}{"user", RoleAdmin}, "scope": "accounts:read"},
+	}
+
+	sec := CreateSecurity(jwtTokens.TokenService{
+		ResourceAudiences: []string{"orders-api"},
+		Validate: func(token string, claimName string, claimValue string) bool {
+			claims, found := tokens[token]
+			return found && claims[claimName] == claimValue
+		},
+		GetClaims: func(token string) map[string]interface{} {
+			if claims, found := tokens[token]; found {
+				return claims
+			}
+			return map[string]interface{}{}
+		},
+	})
+
+	sec = sec.WithApiKeys(func(key string) map[string]interface{} {
+		if key == ApiKeyPrefix+"john" {
+			return map[string]interface{}{"username": "john", "scope": "profile"}
+		}
+		if key == ApiKeyPrefix+"reader" {
+			return map[string]interface{}{"username": "root", "scope": "accounts:read"}
+		}
+		return map[string]interface{}{}
+	})
+
+	ok := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+
+	serveWithHeader := func(path string, header string, value string, middleware echo.MiddlewareFunc) int {
+		e := echo.New()
+		e.GET("/accounts/:id", ok, middleware)
+
+		req, _ := http.NewRequest(echo.GET, path, nil)
+		if len(value) > 0 {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	serve := func(path string, token string, middleware echo.MiddlewareFunc) int {
+		if len(token) == 0 {
+			return serveWithHeader(path, "Authorization", "", middleware)
+		}
+		return serveWithHeader(path, "Authorization", "Bearer "+token, middleware)
+	}
+
+	Convey("RequireRole should", t, func() {
+
+		Convey("allow token with role", func() {
+			So(serve("/accounts/john", "admin", sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("return forbidden for token without role", func() {
+			So(serve("/accounts/john", "user", sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("return unauthorized for missing or invalid token", func() {
+			So(serve("/accounts/john", "", sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/john", "unknown", sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("RequireScope should", t, func() {
+
+		Convey("allow token with scope", func() {
+			So(serve("/accounts/john", "service", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("allow admin without scope", func() {
+			So(serve("/accounts/john", "admin", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("return forbidden for token without scope", func() {
+			So(serve("/accounts/john", "user", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusForbidden)
+		})
+	})
+
+	Convey("SecuredById should", t, func() {
+
+		Convey("allow owner of resource", func() {
+			So(serve("/accounts/john", "user", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("reject other users", func() {
+			So(serve("/accounts/jane", "user", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("allow admin to access any resource", func() {
+			So(serve("/accounts/jane", "admin", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("allow token with bypass scope", func() {
+			So(serve("/accounts/jane", "service", sec.SecuredById("username", "username", false, "accounts:read")), ShouldEqual, http.StatusOK)
+			So(serve("/accounts/jane", "service", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("tokens other than access tokens should", t, func() {
+
+		Convey("be rejected everywhere", func() {
+			So(serve("/accounts/root", "idToken", sec.SecuredById("username", "username", false, "accounts:read")), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/root", "idToken", sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/root", "idToken", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+
+	Convey("tokens issued to oauth clients should", t, func() {
+
+		Convey("be accepted only within their scopes", func() {
+			So(serve("/accounts/root", "app", sec.RequireScope("profile")), ShouldEqual, http.StatusOK)
+			So(serve("/accounts/root", "app", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("not be accepted when exchanged for other service", func() {
+			So(serve("/accounts/root", "exchanged", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/jane", "exchanged", sec.SecuredById("username", "username", false, "accounts:read")), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("not act as account owner or admin", func() {
+			for _, token := range []string{"app", "legacyApp"} {
+				So(serve("/accounts/root", token, sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+				So(serve("/accounts/jane", token, sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+				So(serve("/accounts/root", token, sec.RequireRole(RoleAdmin)), ShouldEqual, http.StatusUnauthorized)
+			}
+		})
+	})
+
+	Convey("api keys should", t, func() {
+
+		Convey("be accepted from header and as bearer value", func() {
+			So(serveWithHeader("/accounts/jane", ApiKeyHeader, ApiKeyPrefix+"reader", sec.SecuredById("username", "username", false, "accounts:read")), ShouldEqual, http.StatusOK)
+			So(serve("/accounts/jane", ApiKeyPrefix+"reader", sec.SecuredById("username", "username", false, "accounts:read")), ShouldEqual, http.StatusOK)
+			So(serve("/accounts/john", ApiKeyPrefix+"john", sec.RequireScope("profile")), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("be limited to their scopes", func() {
+			So(serve("/accounts/jane", ApiKeyPrefix+"john", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/john", ApiKeyPrefix+"john", sec.RequireScope("accounts:read")), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("not manage account they belong to", func() {
+			So(serve("/accounts/john", ApiKeyPrefix+"john", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/root", ApiKeyPrefix+"reader", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("read account they belong to when route allows it by scope", func() {
+			readOwn := sec.SecuredByIdWithKeyScope("username", "username", false, "profile", "accounts:read")
+			So(serve("/accounts/john", ApiKeyPrefix+"john", readOwn), ShouldEqual, http.StatusOK)
+			So(serve("/accounts/jane", ApiKeyPrefix+"john", readOwn), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/root", ApiKeyPrefix+"reader", sec.SecuredByIdWithKeyScope("username", "username", false, "profile")), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("not act for user in browser flows", func() {
+			filled := func(c echo.Context) error {
+				if c.Get("username") != nil {
+					return c.String(http.StatusOK, "filled")
+				}
+				return c.String(http.StatusNoContent, "")
+			}
+
+			e := echo.New()
+			e.GET("/authorize", filled, sec.FillClaims())
+
+			for token, expected := range map[string]int{ApiKeyPrefix + "john": http.StatusNoContent, "app": http.StatusNoContent, "user": http.StatusOK} {
+				req, _ := http.NewRequest(echo.GET, "/authorize", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, expected)
+			}
+		})
+
+		Convey("be rejected when invalid", func() {
+			So(serveWithHeader("/accounts/john", ApiKeyHeader, ApiKeyPrefix+"jane", sec.SecuredById("username", "username", false)), ShouldEqual, http.StatusUnauthorized)
+			So(serve("/accounts/john", ApiKeyPrefix+"jane", sec.RequireScope("profile")), ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+
+	tokens := map[string]map[string]interface{}{
+		"fresh": {"username": "john", "auth_time": float64(time.Now().Add(-time.Minute).Unix()), "token_use": "access"},
+		"stale": {"username": "john", "auth_time": float64(time.Now().Add(-time.Hour).Unix()), "token_use": "access"},
+		"old":   {"username": "john", "token_use": "access"},
+	}
+
+	sec := CreateSecurity(jwtTokens.TokenService{
+		GetClaims: func(token string) map[string]interface{} {
+			if claims, found := tokens[token]; found {
+				return claims
+			}
+			return map[string]interface{}{}
+		},
+	}).WithApiKeys(func(key string) map[string]interface{} {
+		return map[string]interface{}{"username": "john"}
+	})
+
+	serveWith := func(sec Security, header string, value string) *httptest.ResponseRecorder {
+		e := echo.New()
+		e.DELETE("/accounts/:id", func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, sec.RequireRecentAuth())
+
+		req, _ := http.NewRequest(echo.DELETE, "/accounts/john", nil)
+		if len(value) > 0 {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve := func(header string, value string) *httptest.ResponseRecorder {
+		return serveWith(sec, header, value)
+	}
+
+	Convey("RequireRecentAuth should", t, func() {
+
+		Convey("allow token with recent auth_time", func() {
+			So(serve("Authorization", "Bearer fresh").Code, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("ask for reauthentication when auth_time is too old or missing", func() {
+
+			for _, token := range []string{"stale", "old"} {
+				rec := serve("Authorization", "Bearer "+token)
+				So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+				So(rec.Body.String(), ShouldContainSubstring, ReauthenticationRequired)
+				So(rec.Header().Get(echo.HeaderWWWAuthenticate), ShouldContainSubstring, "insufficient_user_authentication")
+			}
+		})
+
+		Convey("ask for reauthentication when api key is used", func() {
+			rec := serve(ApiKeyHeader, ApiKeyPrefix+"john")
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			So(rec.Body.String(), ShouldContainSubstring, ReauthenticationRequired)
+		})
+
+		Convey("accept older authentication when window is longer", func() {
+			So(serveWith(sec.WithMaxAuthAge(time.Hour*2), "Authorization", "Bearer stale").Code, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("return unauthorized without code for missing or invalid token", func() {
+			So(serve("Authorization", "").Body.String(), ShouldNotContainSubstring, ReauthenticationRequired)
+			So(serve("Authorization", "Bearer unknown").Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package sessions
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for managing sessions of account
+type Controller struct {
+	GetSessions         func(c echo.Context) error
+	DeleteSession       func(c echo.Context) error
+	DeleteOtherSessions func(c echo.Context) error
+}
+
+//Create session controller, handlers expect sid claim of caller token in context
+func Create(service Service) Controller {
+
+	currentSessionId := func(c echo.Context) string {
+		sid, _ := c.Get("sid").(string)
+		return sid
+	}
+
+	getSessions := func(c echo.Context) error {
+
+		sessions, err := service.GetByUsername(c.Param("id"))
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not fetch sessions", err)
+		}
+
+		current := currentSessionId(c)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].Id == current
+		}
+
+		return c.JSON(http.StatusOK, sessions)
+	}
+
+	deleteSession := func(c echo.Context) error {
+
+		err := service.Revoke(c.Param("id"), c.Param("sid"))
+		if err == ErrSessionNotFound {
+			return web.NotFoundResponse(c)
+		}
+
+		if err != nil {
+			return web.LogAndReturnInternalError(c, "Could not delete session", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	deleteOtherSessions := func(c echo.Context) error {
+
+		if err := service.RevokeOthers(c.Param("id"), currentSessionId(c)); err != nil {
+			return web.LogAndReturnInternalError(c, "Could not delete sessions", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return Controller{
+		GetSessions:         getSessions,
+		DeleteSession:       deleteSession,
+		DeleteOtherSessions: deleteOtherSessions,
+	}
+}
@@ -0,0 +1,129 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//maxSessionsPerAccount limits how many sessions are listed for single account
+const maxSessionsPerAccount = 100
+
+//notRevoked matches also sessions saved before revoked field was introduced
+var notRevoked = map[string]interface{}{"$ne": true}
+
+//Dal for sessions collection
+type Dal struct {
+	GetById       func(id string) (Session, error)
+	GetByUsername func(username string) ([]Session, error)
+	Save          func(session Session) error
+	//Touch and Reauthenticate add token to session which was not revoked, ErrSessionNotFound is returned for revoked one
+	Touch          func(id string, lastSeenAt time.Time, expiresAt time.Time, token IssuedToken) error
+	Reauthenticate func(id string, authTime time.Time, amr []string, token IssuedToken) error
+	//MarkRevoked stops session from getting new tokens, so its tokens can be revoked
+	MarkRevoked func(id string) error
+	DeleteById  func(id string) error
+}
+
+//CreateDal wraps generic dal with session specific operations, expired sessions are removed by mongo
+func CreateDal(sessionsRepo dal.Dal) Dal {
+
+	var log = logging.MustGetLogger("[SessionsDal]")
+
+	if err := sessionsRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for sessions. Details: ", err)
+	}
+
+	getById := func(id string) (Session, error) {
+
+		session := Session{}
+		if err := sessionsRepo.GetById(id, &session); err != nil {
+			return session, err
+		}
+
+		if len(session.Id) == 0 {
+			return session, ErrSessionNotFound
+		}
+
+		return session, nil
+	}
+
+	getByUsername := func(username string) ([]Session, error) {
+
+		sessions := make([]Session, 0)
+		query := dal.NewQueryBuilder().WithField("username", username).WithField("revoked", notRevoked).Build()
+		pagination := web.Pagination{PageNumber: 1, PageSize: maxSessionsPerAccount}
+
+		if err := sessionsRepo.GetByQuery(&sessions, pagination, query); err != nil {
+			return []Session{}, err
+		}
+
+		return sessions, nil
+	}
+
+	save := func(session Session) error {
+		return sessionsRepo.Upsert(session.Id, session)
+	}
+
+	//issueToken updates only changed fields of session which was not revoked, saving whole session could bring revoked one back
+	issueToken := func(id string, fields map[string]interface{}, token IssuedToken) error {
+
+		query := dal.NewQueryBuilder().WithId(id).WithField("revoked", notRevoked).Build()
+		err := sessionsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set":  fields,
+			"$push": map[string]interface{}{"tokens": token},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrSessionNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		//expired tokens do not need to be revoked with session, so they are forgotten
+		err = sessionsRepo.UpdateByQuery(dal.NewQueryBuilder().WithId(id).Build(), map[string]interface{}{
+			"$pull": map[string]interface{}{"tokens": map[string]interface{}{"expiresAt": map[string]interface{}{"$lte": time.Now()}}},
+		})
+
+		if err != nil && err != dal.ErrNotFound {
+			log.Error("Could not remove expired tokens of session. Details: ", err)
+		}
+
+		return nil
+	}
+
+	touch := func(id string, lastSeenAt time.Time, expiresAt time.Time, token IssuedToken) error {
+		return issueToken(id, map[string]interface{}{"lastSeenAt": lastSeenAt, "expiresAt": expiresAt}, token)
+	}
+
+	reauthenticate := func(id string, authTime time.Time, amr []string, token IssuedToken) error {
+		return issueToken(id, map[string]interface{}{"lastSeenAt": authTime, "authTime": authTime, "amr": amr}, token)
+	}
+
+	markRevoked := func(id string) error {
+
+		err := sessionsRepo.UpdateByQuery(dal.NewQueryBuilder().WithId(id).Build(), map[string]interface{}{
+			"$set": map[string]interface{}{"revoked": true},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrSessionNotFound
+		}
+
+		return err
+	}
+
+	return Dal{
+		GetById:        getById,
+		GetByUsername:  getByUsername,
+		Save:           save,
+		Touch:          touch,
+		Reauthenticate: reauthenticate,
+		MarkRevoked:    markRevoked,
+		DeleteById:     sessionsRepo.DeleteById,
+	}
+}
@@ -0,0 +1,65 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+//Device describes client from which user logged in
+type Device struct {
+	UserAgent string
+	Ip        string
+}
+
+//Session is created on every login, it lives as long as its refresh token family
+//and tracks id(jti) of last access token issued for it
+type Session struct {
+	Id         string    `json:"id" bson:"_id"`
+	AccountId  string    `json:"accountId" bson:"accountId"`
+	Username   string    `json:"username" bson:"username"`
+	UserAgent  string    `json:"userAgent" bson:"userAgent"`
+	Ip         string    `json:"ip" bson:"ip"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt" bson:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt" bson:"expiresAt"`
+	//AuthTime is when user last proved identity in this session and Amr lists methods used then
+	AuthTime time.Time `json:"authTime" bson:"authTime"`
+	Amr      []string  `json:"amr" bson:"amr"`
+	//Tokens lists access tokens issued for session which did not expire yet
+	Tokens []IssuedToken `json:"-" bson:"tokens"`
+	//Revoked session gets no new tokens, it is set before tokens are revoked and session is deleted
+	Revoked bool `json:"-" bson:"revoked"`
+	//Current is set for session to which token of request belongs
+	Current bool `json:"current" bson:"-"`
+}
+
+//IssuedToken is access token(jti) issued for session
+type IssuedToken struct {
+	Id        string    `bson:"id"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+//TokenId returns id of newest access token issued for session
+func (session Session) TokenId() string {
+	if len(session.Tokens) == 0 {
+		return ""
+	}
+
+	return session.Tokens[len(session.Tokens)-1].Id
+}
+
+//issueToken adds new token to session and forgets tokens which already expired, dal forgets them the same way
+func (session *Session) issueToken(expiresAt time.Time, now time.Time) IssuedToken {
+
+	var active []IssuedToken
+	for _, token := range session.Tokens {
+		if token.ExpiresAt.After(now) {
+			active = append(active, token)
+		}
+	}
+
+	token := IssuedToken{Id: uuid.NewV4().String(), ExpiresAt: expiresAt}
+	session.Tokens = append(active, token)
+	return token
+}
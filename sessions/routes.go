@@ -0,0 +1,21 @@
+package sessions
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	securedByUsername := security.SecuredById("username", "username", false)
+	fillClaims := security.FillClaims()
+
+	echoEngine.OPTIONS("/accounts/:id/sessions", web.OptionsMethodHandler)
+	echoEngine.GET("/accounts/:id/sessions", controller.GetSessions, securedByUsername, fillClaims)
+	//deletes every session except the one used to make request
+	echoEngine.DELETE("/accounts/:id/sessions", controller.DeleteOtherSessions, securedByUsername, fillClaims)
+	echoEngine.OPTIONS("/accounts/:id/sessions/:sid", web.OptionsMethodHandler)
+	echoEngine.DELETE("/accounts/:id/sessions/:sid", controller.DeleteSession, securedByUsername)
+}
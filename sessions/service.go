@@ -0,0 +1,215 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/refreshTokens"
+	"github.com/satori/go.uuid"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrSessionNotFound       = errors.New("Session does not exist")
+	ErrCouldNotSaveSession   = errors.New("Could not save session")
+	ErrCouldNotRevokeSession = errors.New("Could not revoke session")
+)
+
+//Service keeps track of devices on which user is logged in
+type Service struct {
+	Start         func(accountId string, username string, device Device, amr []string) (Session, error)
+	Touch         func(id string) (Session, error)
+	GetByUsername func(username string) ([]Session, error)
+	Revoke        func(username string, id string) error
+	RevokeOthers  func(username string, currentId string) error
+	//RevokeAll logs account out of every device, it is used when account is deleted
+	RevokeAll func(username string) error
+	End       func(id string) error
+	//Reauthenticate records that user proved identity again, used for step-up of sensitive operations
+	Reauthenticate func(id string, amr []string) (Session, error)
+}
+
+//CreateService creates session service, session expires after lifetime since it was last seen
+func CreateService(sessionsDal Dal, tokenService jwtTokens.TokenService, refreshService refreshTokens.Service, lifetime time.Duration) Service {
+
+	var log = logging.MustGetLogger("[SessionsService]")
+
+	save := func(session Session) (Session, error) {
+		if err := sessionsDal.Save(session); err != nil {
+			log.Error("Could not save session. Details: ", err)
+			return Session{}, ErrCouldNotSaveSession
+		}
+
+		return session, nil
+	}
+
+	start := func(accountId string, username string, device Device, amr []string) (Session, error) {
+
+		now := time.Now()
+		session := Session{
+			Id:         uuid.NewV4().String(),
+			AccountId:  accountId,
+			Username:   username,
+			UserAgent:  device.UserAgent,
+			Ip:         device.Ip,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(lifetime),
+			AuthTime:   now,
+			Amr:        amr,
+		}
+
+		session.issueToken(now.Add(tokenService.AccessTokenLifetime), now)
+		return save(session)
+	}
+
+	//updated stores token issued by touch or reauthenticate, session revoked in the meantime is not brought back
+	updated := func(session Session, err error) (Session, error) {
+
+		if err == ErrSessionNotFound {
+			return Session{}, err
+		}
+
+		if err != nil {
+			log.Error("Could not update session. Details: ", err)
+			return Session{}, ErrCouldNotSaveSession
+		}
+
+		return session, nil
+	}
+
+	touch := func(id string) (Session, error) {
+
+		session, err := sessionsDal.GetById(id)
+		if err != nil {
+			return Session{}, err
+		}
+
+		if session.Revoked {
+			return Session{}, ErrSessionNotFound
+		}
+
+		now := time.Now()
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(lifetime)
+		token := session.issueToken(now.Add(tokenService.AccessTokenLifetime), now)
+		return updated(session, sessionsDal.Touch(id, session.LastSeenAt, session.ExpiresAt, token))
+	}
+
+	reauthenticate := func(id string, amr []string) (Session, error) {
+
+		session, err := sessionsDal.GetById(id)
+		if err != nil {
+			return Session{}, err
+		}
+
+		if session.Revoked {
+			return Session{}, ErrSessionNotFound
+		}
+
+		now := time.Now()
+		session.LastSeenAt = now
+		session.AuthTime = now
+		session.Amr = amr
+		token := session.issueToken(now.Add(tokenService.AccessTokenLifetime), now)
+		return updated(session, sessionsDal.Reauthenticate(id, now, amr, token))
+	}
+
+	//revokeSession marks session first, so tokens read afterwards are all tokens it will ever have
+	revokeSession := func(id string) error {
+
+		if err := sessionsDal.MarkRevoked(id); err != nil {
+			if err == ErrSessionNotFound {
+				return nil
+			}
+			log.Error("Could not mark session as revoked. Details: ", err)
+			return ErrCouldNotRevokeSession
+		}
+
+		session, err := sessionsDal.GetById(id)
+		if err == ErrSessionNotFound {
+			return nil
+		}
+
+		if err != nil {
+			log.Error("Could not fetch revoked session. Details: ", err)
+			return ErrCouldNotRevokeSession
+		}
+
+		now := time.Now()
+		for _, token := range session.Tokens {
+			if !token.ExpiresAt.After(now) {
+				continue
+			}
+
+			err := tokenService.RevokeId(token.Id, token.ExpiresAt)
+			if err != nil && err != jwtTokens.ErrTokenAlreadyUsed {
+				log.Error("Could not revoke session token. Details: ", err)
+				return ErrCouldNotRevokeSession
+			}
+		}
+
+		if err := refreshService.RevokeFamily(session.Id); err != nil {
+			log.Error("Could not revoke session refresh tokens. Details: ", err)
+			return ErrCouldNotRevokeSession
+		}
+
+		return sessionsDal.DeleteById(session.Id)
+	}
+
+	revoke := func(username string, id string) error {
+
+		session, err := sessionsDal.GetById(id)
+		if err != nil {
+			return err
+		}
+
+		//sessions of other accounts are not revealed
+		if session.Username != username {
+			return ErrSessionNotFound
+		}
+
+		return revokeSession(session.Id)
+	}
+
+	revokeOthers := func(username string, currentId string) error {
+
+		sessions, err := sessionsDal.GetByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		for _, session := range sessions {
+			if session.Id == currentId {
+				continue
+			}
+
+			if err := revokeSession(session.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	revokeAll := func(username string) error {
+		return revokeOthers(username, "")
+	}
+
+	end := func(id string) error {
+		return revokeSession(id)
+	}
+
+	return Service{
+		Start:          start,
+		Touch:          touch,
+		Reauthenticate: reauthenticate,
+		GetByUsername:  sessionsDal.GetByUsername,
+		Revoke:         revoke,
+		RevokeOthers:   revokeOthers,
+		RevokeAll:      revokeAll,
+		End:            end,
+	}
+}
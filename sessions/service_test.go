@@ -0,0 +1,219 @@
+package sessions
+
+import (
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/refreshTokens"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func createInMemoryDal() (Dal, map[string]Session) {
+
+	sessions := map[string]Session{}
+
+	return Dal{
+		GetById: func(id string) (Session, error) {
+			session, ok := sessions[id]
+			if !ok {
+				return session, ErrSessionNotFound
+			}
+			return session, nil
+		},
+		GetByUsername: func(username string) ([]Session, error) {
+			found := []Session{}
+			for _, session := range sessions {
+				if session.Username == username {
+					found = append(found, session)
+				}
+			}
+			return found, nil
+		},
+		Save: func(session Session) error {
+			sessions[session.Id] = session
+			return nil
+		},
+		Touch: func(id string, lastSeenAt time.Time, expiresAt time.Time, token IssuedToken) error {
+			session, ok := sessions[id]
+			if !ok || session.Revoked {
+				return ErrSessionNotFound
+			}
+			session.LastSeenAt, session.ExpiresAt = lastSeenAt, expiresAt
+			session.Tokens = append(session.Tokens, token)
+			sessions[id] = session
+			return nil
+		},
+		Reauthenticate: func(id string, authTime time.Time, amr []string, token IssuedToken) error {
+			session, ok := sessions[id]
+			if !ok || session.Revoked {
+				return ErrSessionNotFound
+			}
+			session.LastSeenAt, session.AuthTime, session.Amr = authTime, authTime, amr
+			session.Tokens = append(session.Tokens, token)
+			sessions[id] = session
+			return nil
+		},
+		MarkRevoked: func(id string) error {
+			session, ok := sessions[id]
+			if !ok {
+				return ErrSessionNotFound
+			}
+			session.Revoked = true
+			sessions[id] = session
+			return nil
+		},
+		DeleteById: func(id string) error {
+			delete(sessions, id)
+			return nil
+		},
+	}, sessions
+}
+
+func TestService(t *testing.T) {
+
+	device := Device{UserAgent: "curl/7.54", Ip: "10.0.0.1"}
+
+	Convey("Sessions service should", t, func() {
+
+		sessionsDal, sessions := createInMemoryDal()
+		revokedTokens := map[string]bool{}
+		revokedFamilies := map[string]bool{}
+
+		tokenService := jwtTokens.TokenService{
+			AccessTokenLifetime: time.Minute,
+			RevokeId: func(jti string, expiresAt time.Time) error {
+				revokedTokens[jti] = true
+				return nil
+			},
+		}
+
+		refreshService := refreshTokens.Service{
+			RevokeFamily: func(familyId string) error {
+				revokedFamilies[familyId] = true
+				return nil
+			},
+		}
+
+		service := CreateService(sessionsDal, tokenService, refreshService, time.Hour)
+
+		Convey("start session with device and token id", func() {
+
+			session, err := service.Start("accId", "john", device, []string{"pwd"})
+
+			So(err, ShouldBeNil)
+			So(session.Id, ShouldNotBeBlank)
+			So(session.TokenId(), ShouldNotBeBlank)
+			So(sessions[session.Id].UserAgent, ShouldEqual, device.UserAgent)
+			So(sessions[session.Id].Ip, ShouldEqual, device.Ip)
+		})
+
+		Convey("issue new token id and update last seen on touch", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+			touched, err := service.Touch(session.Id)
+
+			So(err, ShouldBeNil)
+			So(touched.TokenId(), ShouldNotEqual, session.TokenId())
+			So(touched.LastSeenAt, ShouldHappenOnOrAfter, session.LastSeenAt)
+			So(touched.Tokens, ShouldHaveLength, 2)
+		})
+
+		Convey("remember authentication and update it on reauthentication", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+			So(session.Amr, ShouldResemble, []string{"pwd"})
+			So(session.AuthTime, ShouldEqual, session.CreatedAt)
+
+			touched, _ := service.Touch(session.Id)
+			So(touched.AuthTime, ShouldEqual, session.AuthTime)
+
+			reauthenticated, err := service.Reauthenticate(session.Id, []string{"pwd", "otp", "mfa"})
+			So(err, ShouldBeNil)
+			So(reauthenticated.Amr, ShouldResemble, []string{"pwd", "otp", "mfa"})
+			So(reauthenticated.AuthTime, ShouldHappenOnOrAfter, session.AuthTime)
+			So(reauthenticated.TokenId(), ShouldNotEqual, touched.TokenId())
+		})
+
+		Convey("revoke tokens and refresh tokens of deleted session", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+			touched, _ := service.Touch(session.Id)
+
+			So(service.Revoke("john", session.Id), ShouldBeNil)
+			So(sessions, ShouldBeEmpty)
+			So(revokedTokens[session.TokenId()], ShouldBeTrue)
+			So(revokedTokens[touched.TokenId()], ShouldBeTrue)
+			So(revokedFamilies[session.Id], ShouldBeTrue)
+		})
+
+		Convey("not revoke session of other account", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+
+			So(service.Revoke("jane", session.Id), ShouldEqual, ErrSessionNotFound)
+			So(sessions, ShouldHaveLength, 1)
+		})
+
+		Convey("revoke all sessions except current one", func() {
+
+			current, _ := service.Start("accId", "john", device, []string{"pwd"})
+			service.Start("accId", "john", device, []string{"pwd"})
+			service.Start("accId", "john", device, []string{"pwd"})
+			other, _ := service.Start("otherId", "jane", device, []string{"pwd"})
+
+			So(service.RevokeOthers("john", current.Id), ShouldBeNil)
+			So(sessions, ShouldHaveLength, 2)
+			So(sessions, ShouldContainKey, current.Id)
+			So(sessions, ShouldContainKey, other.Id)
+		})
+
+		Convey("revoke all sessions of account", func() {
+
+			first, _ := service.Start("accId", "john", device, []string{"pwd"})
+			service.Start("accId", "john", device, []string{"pwd"})
+			other, _ := service.Start("otherId", "jane", device, []string{"pwd"})
+
+			So(service.RevokeAll("john"), ShouldBeNil)
+			So(sessions, ShouldHaveLength, 1)
+			So(sessions, ShouldContainKey, other.Id)
+			So(revokedFamilies, ShouldContainKey, first.Id)
+		})
+
+		Convey("not bring back session revoked while it was touched", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+
+			//session is revoked after touch read it
+			racingDal := sessionsDal
+			racingDal.GetById = func(id string) (Session, error) {
+				read, err := sessionsDal.GetById(id)
+				if err == nil && !read.Revoked {
+					So(service.Revoke("john", id), ShouldBeNil)
+				}
+				return read, err
+			}
+			racing := CreateService(racingDal, tokenService, refreshService, time.Hour)
+
+			_, err := racing.Touch(session.Id)
+			So(err, ShouldEqual, ErrSessionNotFound)
+
+			_, err = racing.Reauthenticate(session.Id, []string{"pwd"})
+			So(err, ShouldEqual, ErrSessionNotFound)
+			So(sessions, ShouldBeEmpty)
+		})
+
+		Convey("not issue tokens for session which is being revoked", func() {
+
+			session, _ := service.Start("accId", "john", device, []string{"pwd"})
+			So(sessionsDal.MarkRevoked(session.Id), ShouldBeNil)
+
+			_, err := service.Touch(session.Id)
+			So(err, ShouldEqual, ErrSessionNotFound)
+			So(sessions[session.Id].Tokens, ShouldHaveLength, 1)
+		})
+
+		Convey("ignore ending session which does not exist", func() {
+			So(service.End("missing"), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package socialLogin
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for social login endpoints
+type Controller struct {
+	Providers func(c echo.Context) error
+	Authorize func(c echo.Context) error
+	Login     func(c echo.Context) error
+	//FbLogin accepts facebook access token in payload of former facebook login endpoint
+	FbLogin func(c echo.Context) error
+}
+
+//Create social login controller
+func Create(service Service) Controller {
+
+	handleError := func(c echo.Context, msg string, err error) error {
+
+		switch err {
+		case ErrUnknownProvider:
+			return web.NotFoundResponse(c)
+		case ErrInvalidRedirectUri, ErrUnsupportedLogin:
+			return web.BadRequestResponse(c, err.Error())
+		case ErrInvalidState, ErrInvalidCode, ErrInvalidIdToken, ErrInvalidAccessToken:
+			return web.UnauthorizedResponse(c, err.Error())
+		case ErrMissingEmail, ErrEmailNotVerified:
+			return web.ForbiddenResponse(c, err.Error())
+		}
+
+		return web.LogAndReturnInternalError(c, msg, err)
+	}
+
+	providers := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, service.Providers())
+	}
+
+	authorize := func(c echo.Context) error {
+
+		redirectUri := c.QueryParam("redirectUri")
+		if len(redirectUri) == 0 {
+			return web.BadRequestResponse(c, "redirectUri is required")
+		}
+
+		authorization, err := service.Authorize(c.Param("provider"), redirectUri)
+		if err != nil {
+			return handleError(c, "Could not start social login", err)
+		}
+
+		return c.JSON(http.StatusOK, authorization)
+	}
+
+	socialLogin := func(c echo.Context) error {
+
+		dto := LoginDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if validationErrors := dto.validate(); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid payload", validationErrors)
+		}
+
+		token, err := service.Login(c.Param("provider"), dto, login.GetDevice(c))
+		if err != nil {
+			return handleError(c, "Could not login with "+c.Param("provider"), err)
+		}
+
+		return c.JSON(http.StatusOK, token)
+	}
+
+	fbLogin := func(c echo.Context) error {
+
+		dto := FbTokenDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		if validationErrors := dto.validate(); len(validationErrors) != 0 {
+			return web.BadRequestResponseWithDetails(c, "Invalid payload", validationErrors)
+		}
+
+		token, err := service.Login(Facebook, LoginDto{AccessToken: dto.Token}, login.GetDevice(c))
+		if err != nil {
+			return handleError(c, "Could not login with facebook", err)
+		}
+
+		return c.JSON(http.StatusOK, token)
+	}
+
+	return Controller{
+		Providers: providers,
+		Authorize: authorize,
+		Login:     socialLogin,
+		FbLogin:   fbLogin,
+	}
+}
@@ -0,0 +1,63 @@
+package socialLogin
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+)
+
+//keysRefreshInterval limits how often keys are fetched, tokens with unknown kid cannot force fetch on every request
+const keysRefreshInterval = time.Minute
+
+//createKeysLookup finds published key of provider by kid, keys are cached and fetched again when provider rotates them
+func createKeysLookup(jwksUri string, client *http.Client) func(kid string) (jwtTokens.Jwk, error) {
+
+	var log = logging.MustGetLogger("[SocialLoginKeys]")
+
+	var mutex sync.Mutex
+	var fetchedAt time.Time
+	keys := []jwtTokens.Jwk{}
+
+	find := func(kid string) (jwtTokens.Jwk, bool) {
+
+		for _, key := range keys {
+			//key without kid can be used only when it is the only one, encryption keys never sign tokens
+			if key.Kid == kid && (len(kid) > 0 || len(keys) == 1) && key.Use != "enc" {
+				return key, true
+			}
+		}
+
+		return jwtTokens.Jwk{}, false
+	}
+
+	return func(kid string) (jwtTokens.Jwk, error) {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if key, found := find(kid); found {
+			return key, nil
+		}
+
+		if time.Since(fetchedAt) < keysRefreshInterval {
+			return jwtTokens.Jwk{}, jwtTokens.ErrUnknownKey
+		}
+
+		jwks := jwtTokens.Jwks{}
+		if err := getJson(client, jwksUri, "", &jwks); err != nil {
+			log.Error("Could not fetch keys from ", jwksUri, ". Details: ", err)
+			return jwtTokens.Jwk{}, ErrProviderFailed
+		}
+
+		keys, fetchedAt = jwks.Keys, time.Now()
+
+		if key, found := find(kid); found {
+			return key, nil
+		}
+
+		return jwtTokens.Jwk{}, jwtTokens.ErrUnknownKey
+	}
+}
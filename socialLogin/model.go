@@ -0,0 +1,94 @@
+package socialLogin
+
+import (
+	e "github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Profile is user as described by provider, claims of provider are mapped onto it with ClaimMapping
+type Profile struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	Username      string
+}
+
+//ClaimMapping names claims of id token or user info which hold parts of profile,
+//Name is split into first and last name when provider does not return them separately
+type ClaimMapping struct {
+	Subject       string
+	Email         string
+	EmailVerified string
+	FirstName     string
+	LastName      string
+	Name          string
+	Username      string
+}
+
+//ProviderConfig describes OAuth2 provider, it is OpenID Connect provider when JwksUri and Issuers are set
+type ProviderConfig struct {
+	Name                  string
+	ClientId              string
+	ClientSecret          string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserInfoEndpoint      string
+	//TokenInfoEndpoint tells for which client access token was issued, access tokens sent by clients are accepted only with it
+	TokenInfoEndpoint string
+	//EmailsEndpoint lists emails of user with their verification, for providers which do not return it in user info
+	EmailsEndpoint string
+	JwksUri        string
+	//Issuers accepted in id tokens, {tenantid} is replaced with tid claim for multi tenant providers
+	Issuers []string
+	Scopes  []string
+	//AuthorizationParams are added to authorization url, e.g. response_mode
+	AuthorizationParams map[string]string
+	Claims              ClaimMapping
+	//TrustEmail marks emails of provider as verified when it has no claim for that
+	TrustEmail bool
+}
+
+//AuthorizationDto tells client where user has to be redirected, state comes back with code and has to be sent with it
+type AuthorizationDto struct {
+	Url   string `json:"url"`
+	State string `json:"state"`
+}
+
+//LoginDto carries authorization code with its state, id token or access token obtained by client from provider
+type LoginDto struct {
+	Code        string `json:"code" form:"code"`
+	State       string `json:"state" form:"state"`
+	RedirectUri string `json:"redirectUri" form:"redirectUri"`
+	IdToken     string `json:"idToken" form:"idToken"`
+	Nonce       string `json:"nonce" form:"nonce"`
+	AccessToken string `json:"accessToken" form:"accessToken"`
+}
+
+//FbTokenDto is payload of facebook login endpoint kept for older clients
+type FbTokenDto struct {
+	Token string `json:"token"`
+}
+
+func (dto LoginDto) validate() (errors []e.ErrorDetails) {
+
+	if len(dto.Code) == 0 && len(dto.IdToken) == 0 && len(dto.AccessToken) == 0 {
+		errors = e.AppendErrorDetails(errors, "code", "code, idToken or accessToken is required", e.MissingField)
+	}
+
+	if len(dto.Code) > 0 && (len(dto.State) == 0 || len(dto.RedirectUri) == 0) {
+		errors = e.AppendErrorDetails(errors, "state", "state and redirectUri are required with code", e.MissingField)
+	}
+
+	return
+}
+
+func (dto FbTokenDto) validate() (errors []e.ErrorDetails) {
+
+	if len(dto.Token) == 0 {
+		errors = e.AppendErrorDetails(errors, "token", "token is required", e.MissingField)
+	}
+
+	return
+}
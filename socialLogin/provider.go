@@ -0,0 +1,353 @@
+package socialLogin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/op/go-logging"
+)
+
+//Provider logs users in with single OAuth2 or OpenID Connect provider, functions which provider does not support are nil
+type Provider struct {
+	Name string
+	//AuthorizationUrl is where user logs in, nonce is sent only to OpenID Connect providers
+	AuthorizationUrl func(redirectUri string, state string, nonce string) string
+	//Exchange trades authorization code for profile of user, nonce has to match the one in id token
+	Exchange func(code string, redirectUri string, nonce string) (Profile, error)
+	//VerifyIdToken checks signature of id token obtained by client against keys of provider, then its issuer, audience, expiry and nonce
+	VerifyIdToken func(idToken string, nonce string) (Profile, error)
+	//VerifyAccessToken fetches profile with access token obtained by client, only providers which tell for which client token was issued support it
+	VerifyAccessToken func(accessToken string) (Profile, error)
+}
+
+//providerHooks are parts of login which differ between providers
+type providerHooks struct {
+	//checkAccessToken makes sure that access token sent by client was issued for this client
+	checkAccessToken func(accessToken string) error
+	//completeProfile adds what is missing in user info, e.g. email listed by separate endpoint
+	completeProfile func(profile Profile, accessToken string) (Profile, error)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IdToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+func (conf ProviderConfig) isOpenIdConnect() bool {
+	return len(conf.JwksUri) > 0 && len(conf.Issuers) > 0
+}
+
+//CreateProvider creates provider from config, settings which are not configured are taken from known provider of the same name
+func CreateProvider(conf ProviderConfig, client *http.Client) Provider {
+
+	conf = conf.withKnownDefaults()
+
+	switch conf.Name {
+	case Github:
+		return createProvider(conf, client, providerHooks{completeProfile: githubEmails(conf, client)})
+	case Facebook:
+		return createProvider(conf, client, providerHooks{checkAccessToken: facebookTokenCheck(conf, client)})
+	}
+
+	return createProvider(conf, client, providerHooks{})
+}
+
+func createProvider(conf ProviderConfig, client *http.Client, hooks providerHooks) Provider {
+
+	var log = logging.MustGetLogger("[SocialLoginProvider]")
+
+	authorizationUrl := func(redirectUri string, state string, nonce string) string {
+
+		params := url.Values{}
+		params.Set("response_type", "code")
+		params.Set("client_id", conf.ClientId)
+		params.Set("redirect_uri", redirectUri)
+		params.Set("scope", strings.Join(conf.Scopes, " "))
+		params.Set("state", state)
+		if conf.isOpenIdConnect() {
+			params.Set("nonce", nonce)
+		}
+
+		for name, value := range conf.AuthorizationParams {
+			params.Set(name, value)
+		}
+
+		return appendQuery(conf.AuthorizationEndpoint, params)
+	}
+
+	userInfo := func(accessToken string) (Profile, error) {
+
+		claims := map[string]interface{}{}
+		if err := getJson(client, conf.UserInfoEndpoint, accessToken, &claims); err != nil {
+			log.Error("Could not fetch user info from ", conf.Name, ". Details: ", err)
+			return Profile{}, ErrProviderFailed
+		}
+
+		profile := conf.profile(claims)
+		if len(profile.Subject) == 0 {
+			log.Error("User info of ", conf.Name, " has no subject")
+			return Profile{}, ErrProviderFailed
+		}
+
+		if hooks.completeProfile != nil {
+			return hooks.completeProfile(profile, accessToken)
+		}
+
+		return profile, nil
+	}
+
+	keyFor := createKeysLookup(conf.JwksUri, client)
+
+	verifyIdToken := func(idToken string, nonce string) (Profile, error) {
+
+		parsed, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+
+			//shared secrets are never published, so hmac token could be signed with public key
+			if _, isHmac := token.Method.(*jwt.SigningMethodHMAC); isHmac {
+				return nil, ErrInvalidIdToken
+			}
+
+			kid, _ := token.Header["kid"].(string)
+			jwk, err := keyFor(kid)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(jwk.Alg) > 0 && jwk.Alg != token.Method.Alg() {
+				return nil, ErrInvalidIdToken
+			}
+
+			return jwk.PublicKey()
+		})
+
+		if err != nil || !parsed.Valid {
+			log.Debugf("Invalid id token of %s. Details: %v", conf.Name, err)
+			return Profile{}, ErrInvalidIdToken
+		}
+
+		claims := parsed.Claims.(jwt.MapClaims)
+		if _, hasExpiry := claims["exp"]; !hasExpiry || !conf.acceptsIssuer(claims) || !hasAudience(claims, conf.ClientId) {
+			return Profile{}, ErrInvalidIdToken
+		}
+
+		if len(nonce) > 0 && claims["nonce"] != nonce {
+			return Profile{}, ErrInvalidIdToken
+		}
+
+		profile := conf.profile(claims)
+		if len(profile.Subject) == 0 {
+			return Profile{}, ErrInvalidIdToken
+		}
+
+		return profile, nil
+	}
+
+	exchange := func(code string, redirectUri string, nonce string) (Profile, error) {
+
+		tokens := tokenResponse{}
+		err := postForm(client, conf.TokenEndpoint, url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectUri},
+			"client_id":     {conf.ClientId},
+			"client_secret": {conf.ClientSecret},
+		}, &tokens)
+
+		if err != nil {
+			log.Error("Could not exchange code with ", conf.Name, ". Details: ", err)
+			return Profile{}, ErrInvalidCode
+		}
+
+		if len(tokens.Error) > 0 || len(tokens.AccessToken) == 0 {
+			return Profile{}, ErrInvalidCode
+		}
+
+		if !conf.isOpenIdConnect() {
+			return userInfo(tokens.AccessToken)
+		}
+
+		profile, err := verifyIdToken(tokens.IdToken, nonce)
+		if err != nil {
+			return Profile{}, err
+		}
+
+		//some providers put only subject into id token, the rest is in user info
+		if len(profile.Email) > 0 || len(conf.UserInfoEndpoint) == 0 {
+			return profile, nil
+		}
+
+		info, err := userInfo(tokens.AccessToken)
+		if err != nil {
+			return Profile{}, err
+		}
+
+		if info.Subject != profile.Subject {
+			return Profile{}, ErrInvalidIdToken
+		}
+
+		return info, nil
+	}
+
+	provider := Provider{
+		Name:             conf.Name,
+		AuthorizationUrl: authorizationUrl,
+		Exchange:         exchange,
+	}
+
+	if conf.isOpenIdConnect() {
+		provider.VerifyIdToken = verifyIdToken
+	}
+
+	if hooks.checkAccessToken != nil {
+		provider.VerifyAccessToken = func(accessToken string) (Profile, error) {
+
+			if err := hooks.checkAccessToken(accessToken); err != nil {
+				return Profile{}, err
+			}
+
+			return userInfo(accessToken)
+		}
+	}
+
+	return provider
+}
+
+//profile maps claims of provider, subject is string even when provider uses numbers
+func (conf ProviderConfig) profile(claims map[string]interface{}) Profile {
+
+	profile := Profile{
+		Provider:  conf.Name,
+		Subject:   claimString(claims, conf.Claims.Subject),
+		Email:     claimString(claims, conf.Claims.Email),
+		FirstName: claimString(claims, conf.Claims.FirstName),
+		LastName:  claimString(claims, conf.Claims.LastName),
+		Username:  claimString(claims, conf.Claims.Username),
+	}
+
+	profile.EmailVerified = len(profile.Email) > 0 && (conf.TrustEmail || claimString(claims, conf.Claims.EmailVerified) == "true")
+
+	if name := strings.Fields(claimString(claims, conf.Claims.Name)); len(profile.FirstName) == 0 && len(name) > 0 {
+		profile.FirstName = name[0]
+		if len(profile.LastName) == 0 {
+			profile.LastName = strings.Join(name[1:], " ")
+		}
+	}
+
+	return profile
+}
+
+func (conf ProviderConfig) acceptsIssuer(claims map[string]interface{}) bool {
+
+	issuer, _ := claims["iss"].(string)
+	tenant, _ := claims["tid"].(string)
+
+	for _, accepted := range conf.Issuers {
+		if strings.Contains(accepted, "{tenantid}") {
+			if len(tenant) == 0 {
+				continue
+			}
+			accepted = strings.Replace(accepted, "{tenantid}", tenant, 1)
+		}
+
+		if len(issuer) > 0 && issuer == accepted {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasAudience(claims map[string]interface{}, clientId string) bool {
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientId {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//claimString formats strings, numbers and booleans, emails are verified with "true" string by some providers
+func claimString(claims map[string]interface{}, name string) string {
+
+	if len(name) == 0 {
+		return ""
+	}
+
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64, bool:
+		return fmt.Sprint(value)
+	}
+
+	return ""
+}
+
+func appendQuery(endpoint string, params url.Values) string {
+
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+
+	return endpoint + separator + params.Encode()
+}
+
+func postForm(client *http.Client, endpoint string, values url.Values, result interface{}) error {
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doJson(client, req, result)
+}
+
+func getJson(client *http.Client, endpoint string, accessToken string, result interface{}) error {
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJson(client, req, result)
+}
+
+//doJson asks for json, some providers answer with form encoding otherwise
+func doJson(client *http.Client, req *http.Request, result interface{}) error {
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", req.URL.Host, resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
@@ -0,0 +1,322 @@
+package socialLogin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+//fakeProvider answers like OpenID Connect provider, responses are set by tests
+type fakeProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	idToken   string
+	userInfo  map[string]interface{}
+	emails    []map[string]interface{}
+	tokenInfo map[string]interface{}
+	codes     map[string]bool
+}
+
+func createFakeProvider() *fakeProvider {
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	fake := &fakeProvider{key: key}
+
+	writeJson := func(w http.ResponseWriter, value interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(value)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, jwtTokens.Jwks{Keys: []jwtTokens.Jwk{{
+			Kty: "RSA",
+			Kid: "key-1",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_secret") != "secret" || !fake.codes[r.PostFormValue("code")] {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJson(w, map[string]string{"access_token": "access-token", "id_token": fake.idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJson(w, fake.userInfo)
+	})
+	mux.HandleFunc("/emails", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, fake.emails)
+	})
+	mux.HandleFunc("/debug_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "client|secret" || r.URL.Query().Get("input_token") != "access-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeJson(w, fake.tokenInfo)
+	})
+
+	fake.server = httptest.NewServer(mux)
+	return fake
+}
+
+func (fake *fakeProvider) sign(claims jwt.MapClaims) string {
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	signed, _ := token.SignedString(fake.key)
+	return signed
+}
+
+//config points known provider to fake one
+func (fake *fakeProvider) config(name string) ProviderConfig {
+
+	conf := ProviderConfig{
+		Name:                  name,
+		ClientId:              "client",
+		ClientSecret:          "secret",
+		AuthorizationEndpoint: fake.server.URL + "/authorize",
+		TokenEndpoint:         fake.server.URL + "/token",
+		UserInfoEndpoint:      fake.server.URL + "/userinfo",
+	}
+
+	switch name {
+	case Github:
+		conf.EmailsEndpoint = fake.server.URL + "/emails"
+	case Facebook:
+		conf.TokenInfoEndpoint = fake.server.URL + "/debug_token"
+	default:
+		conf.JwksUri = fake.server.URL + "/keys"
+		conf.Issuers = []string{fake.server.URL}
+	}
+
+	return conf
+}
+
+func TestProvider(t *testing.T) {
+
+	fake := createFakeProvider()
+	defer fake.server.Close()
+
+	idClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            fake.server.URL,
+			"aud":            "client",
+			"sub":            "subject-1",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          "nonce-1",
+			"email":          "john@test.com",
+			"email_verified": true,
+			"name":           "John Maynard Smith",
+		}
+	}
+
+	Convey("OpenID Connect provider should", t, func() {
+
+		fake.codes = map[string]bool{"code-1": true}
+		fake.idToken = fake.sign(idClaims())
+		fake.userInfo = map[string]interface{}{"sub": "subject-1", "email": "info@test.com", "email_verified": "true"}
+		provider := CreateProvider(fake.config(Google), http.DefaultClient)
+
+		Convey("send user to authorization endpoint with state and nonce", func() {
+
+			authUrl, err := url.Parse(provider.AuthorizationUrl("http://frontend/callback", "state-1", "nonce-1"))
+			So(err, ShouldBeNil)
+
+			query := authUrl.Query()
+			So(authUrl.Path, ShouldEqual, "/authorize")
+			So(query.Get("client_id"), ShouldEqual, "client")
+			So(query.Get("redirect_uri"), ShouldEqual, "http://frontend/callback")
+			So(query.Get("scope"), ShouldEqual, "openid email profile")
+			So(query.Get("state"), ShouldEqual, "state-1")
+			So(query.Get("nonce"), ShouldEqual, "nonce-1")
+		})
+
+		Convey("exchange code for profile from id token", func() {
+
+			profile, err := provider.Exchange("code-1", "http://frontend/callback", "nonce-1")
+			So(err, ShouldBeNil)
+			So(profile, ShouldResemble, Profile{
+				Provider:      Google,
+				Subject:       "subject-1",
+				Email:         "john@test.com",
+				EmailVerified: true,
+				FirstName:     "John",
+				LastName:      "Maynard Smith",
+			})
+		})
+
+		Convey("take email from user info when id token has none", func() {
+
+			claims := idClaims()
+			delete(claims, "email")
+			fake.idToken = fake.sign(claims)
+
+			profile, err := provider.Exchange("code-1", "http://frontend/callback", "nonce-1")
+			So(err, ShouldBeNil)
+			So(profile.Email, ShouldEqual, "info@test.com")
+			So(profile.EmailVerified, ShouldBeTrue)
+
+			fake.userInfo["sub"] = "subject-2"
+			_, err = provider.Exchange("code-1", "http://frontend/callback", "nonce-1")
+			So(err, ShouldEqual, ErrInvalidIdToken)
+		})
+
+		Convey("reject unknown code", func() {
+
+			_, err := provider.Exchange("code-2", "http://frontend/callback", "nonce-1")
+			So(err, ShouldEqual, ErrInvalidCode)
+		})
+
+		Convey("reject id token with other nonce, audience, issuer or expired", func() {
+
+			_, err := provider.VerifyIdToken(fake.idToken, "nonce-2")
+			So(err, ShouldEqual, ErrInvalidIdToken)
+
+			for name, value := range map[string]interface{}{
+				"aud": "other-client",
+				"iss": "http://other-issuer",
+				"exp": time.Now().Add(-time.Minute).Unix(),
+			} {
+				claims := idClaims()
+				claims[name] = value
+				_, err = provider.VerifyIdToken(fake.sign(claims), "nonce-1")
+				So(err, ShouldEqual, ErrInvalidIdToken)
+			}
+		})
+
+		Convey("reject id token not signed with its keys", func() {
+
+			other, _ := rsa.GenerateKey(rand.Reader, 2048)
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, idClaims())
+			token.Header["kid"] = "key-1"
+			forged, _ := token.SignedString(other)
+
+			_, err := provider.VerifyIdToken(forged, "nonce-1")
+			So(err, ShouldEqual, ErrInvalidIdToken)
+
+			hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, idClaims()).SignedString([]byte("secret"))
+			_, err = provider.VerifyIdToken(hmac, "nonce-1")
+			So(err, ShouldEqual, ErrInvalidIdToken)
+		})
+
+		Convey("accept issuer of tenant", func() {
+
+			conf := fake.config(Microsoft)
+			conf.Issuers = []string{fake.server.URL + "/{tenantid}/v2.0"}
+			tenantProvider := CreateProvider(conf, http.DefaultClient)
+
+			claims := idClaims()
+			claims["tid"] = "tenant-1"
+			claims["iss"] = fake.server.URL + "/tenant-1/v2.0"
+
+			profile, err := tenantProvider.VerifyIdToken(fake.sign(claims), "nonce-1")
+			So(err, ShouldBeNil)
+			So(profile.Subject, ShouldEqual, "subject-1")
+
+			claims["tid"] = "tenant-2"
+			_, err = tenantProvider.VerifyIdToken(fake.sign(claims), "nonce-1")
+			So(err, ShouldEqual, ErrInvalidIdToken)
+		})
+
+		Convey("not accept access tokens", func() {
+			So(provider.VerifyAccessToken, ShouldBeNil)
+		})
+	})
+
+	Convey("Github provider should", t, func() {
+
+		fake.codes = map[string]bool{"code-1": true}
+		fake.userInfo = map[string]interface{}{"id": 42, "login": "johnny", "name": "John Smith", "email": "public@test.com"}
+		fake.emails = []map[string]interface{}{
+			{"email": "public@test.com", "primary": false, "verified": true},
+			{"email": "john@test.com", "primary": true, "verified": true},
+		}
+		provider := CreateProvider(fake.config(Github), http.DefaultClient)
+
+		Convey("take primary verified email", func() {
+
+			profile, err := provider.Exchange("code-1", "http://frontend/callback", "")
+			So(err, ShouldBeNil)
+			So(profile, ShouldResemble, Profile{
+				Provider:      Github,
+				Subject:       "42",
+				Email:         "john@test.com",
+				EmailVerified: true,
+				FirstName:     "John",
+				LastName:      "Smith",
+				Username:      "johnny",
+			})
+		})
+
+		Convey("leave email empty when primary one is not verified", func() {
+
+			fake.emails[1]["verified"] = false
+
+			profile, err := provider.Exchange("code-1", "http://frontend/callback", "")
+			So(err, ShouldBeNil)
+			So(profile.Email, ShouldBeEmpty)
+			So(profile.EmailVerified, ShouldBeFalse)
+		})
+
+		Convey("not support id tokens", func() {
+			So(provider.VerifyIdToken, ShouldBeNil)
+		})
+	})
+
+	Convey("Facebook provider should", t, func() {
+
+		fake.userInfo = map[string]interface{}{"id": "fb-1", "email": "john@test.com", "first_name": "John", "last_name": "Smith"}
+		fake.tokenInfo = map[string]interface{}{"data": map[string]interface{}{"app_id": "client", "is_valid": true}}
+		provider := CreateProvider(fake.config(Facebook), http.DefaultClient)
+
+		Convey("accept access token issued for its app", func() {
+
+			profile, err := provider.VerifyAccessToken("access-token")
+			So(err, ShouldBeNil)
+			So(profile, ShouldResemble, Profile{
+				Provider:      Facebook,
+				Subject:       "fb-1",
+				Email:         "john@test.com",
+				EmailVerified: true,
+				FirstName:     "John",
+				LastName:      "Smith",
+			})
+		})
+
+		Convey("reject access token issued for other app", func() {
+
+			fake.tokenInfo = map[string]interface{}{"data": map[string]interface{}{"app_id": "other-app", "is_valid": true}}
+
+			_, err := provider.VerifyAccessToken("access-token")
+			So(err, ShouldEqual, ErrInvalidAccessToken)
+		})
+
+		Convey("reject invalid access token", func() {
+
+			fake.tokenInfo = map[string]interface{}{"data": map[string]interface{}{"app_id": "client", "is_valid": false}}
+
+			_, err := provider.VerifyAccessToken("access-token")
+			So(err, ShouldEqual, ErrInvalidAccessToken)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package socialLogin
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/op/go-logging"
+)
+
+//Names of known providers, their endpoints and claims do not have to be configured
+const (
+	Google    = "google"
+	Github    = "github"
+	Apple     = "apple"
+	Microsoft = "microsoft"
+	Facebook  = "facebook"
+)
+
+//standardClaims are claims of OpenID Connect, providers use them unless they say otherwise
+var standardClaims = ClaimMapping{
+	Subject:       "sub",
+	Email:         "email",
+	EmailVerified: "email_verified",
+	FirstName:     "given_name",
+	LastName:      "family_name",
+	Name:          "name",
+	Username:      "preferred_username",
+}
+
+var knownProviders = map[string]ProviderConfig{
+	Google: {
+		AuthorizationEndpoint: "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenEndpoint:         "https://oauth2.googleapis.com/token",
+		UserInfoEndpoint:      "https://openidconnect.googleapis.com/v1/userinfo",
+		JwksUri:               "https://www.googleapis.com/oauth2/v3/certs",
+		Issuers:               []string{"https://accounts.google.com", "accounts.google.com"},
+		Scopes:                []string{"openid", "email", "profile"},
+	},
+	//apple sends name and email only with form_post, client secret is jwt generated with key from apple developer account
+	Apple: {
+		AuthorizationEndpoint: "https://appleid.apple.com/auth/authorize",
+		TokenEndpoint:         "https://appleid.apple.com/auth/token",
+		JwksUri:               "https://appleid.apple.com/auth/keys",
+		Issuers:               []string{"https://appleid.apple.com"},
+		Scopes:                []string{"name", "email"},
+		AuthorizationParams:   map[string]string{"response_mode": "form_post"},
+	},
+	//microsoft does not verify emails of work accounts, TrustEmail can be set for tenants which do
+	Microsoft: {
+		AuthorizationEndpoint: "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		TokenEndpoint:         "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		UserInfoEndpoint:      "https://graph.microsoft.com/oidc/userinfo",
+		JwksUri:               "https://login.microsoftonline.com/common/discovery/v2.0/keys",
+		Issuers:               []string{"https://login.microsoftonline.com/{tenantid}/v2.0"},
+		Scopes:                []string{"openid", "email", "profile"},
+	},
+	Github: {
+		AuthorizationEndpoint: "https://github.com/login/oauth/authorize",
+		TokenEndpoint:         "https://github.com/login/oauth/access_token",
+		UserInfoEndpoint:      "https://api.github.com/user",
+		EmailsEndpoint:        "https://api.github.com/user/emails",
+		Scopes:                []string{"read:user", "user:email"},
+		Claims:                ClaimMapping{Subject: "id", Name: "name", Username: "login"},
+	},
+	//facebook returns only verified emails
+	Facebook: {
+		AuthorizationEndpoint: "https://www.facebook.com/v19.0/dialog/oauth",
+		TokenEndpoint:         "https://graph.facebook.com/v19.0/oauth/access_token",
+		UserInfoEndpoint:      "https://graph.facebook.com/v19.0/me?fields=id,email,first_name,last_name",
+		TokenInfoEndpoint:     "https://graph.facebook.com/debug_token",
+		Scopes:                []string{"email", "public_profile"},
+		Claims:                ClaimMapping{Subject: "id", Email: "email", FirstName: "first_name", LastName: "last_name"},
+		TrustEmail:            true,
+	},
+}
+
+//withKnownDefaults fills settings which are not configured, providers which are not known have only standard claims
+func (conf ProviderConfig) withKnownDefaults() ProviderConfig {
+
+	known, isKnown := knownProviders[conf.Name]
+
+	defaultString := func(value *string, knownValue string) {
+		if len(*value) == 0 {
+			*value = knownValue
+		}
+	}
+
+	defaultString(&conf.AuthorizationEndpoint, known.AuthorizationEndpoint)
+	defaultString(&conf.TokenEndpoint, known.TokenEndpoint)
+	defaultString(&conf.UserInfoEndpoint, known.UserInfoEndpoint)
+	defaultString(&conf.TokenInfoEndpoint, known.TokenInfoEndpoint)
+	defaultString(&conf.EmailsEndpoint, known.EmailsEndpoint)
+	defaultString(&conf.JwksUri, known.JwksUri)
+
+	if len(conf.Issuers) == 0 {
+		conf.Issuers = known.Issuers
+	}
+
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = known.Scopes
+	}
+
+	if conf.AuthorizationParams == nil {
+		conf.AuthorizationParams = known.AuthorizationParams
+	}
+
+	conf.TrustEmail = conf.TrustEmail || known.TrustEmail
+
+	knownClaims := standardClaims
+	if isKnown && known.Claims != (ClaimMapping{}) {
+		knownClaims = known.Claims
+	}
+
+	defaultString(&conf.Claims.Subject, knownClaims.Subject)
+	defaultString(&conf.Claims.Email, knownClaims.Email)
+	defaultString(&conf.Claims.EmailVerified, knownClaims.EmailVerified)
+	defaultString(&conf.Claims.FirstName, knownClaims.FirstName)
+	defaultString(&conf.Claims.LastName, knownClaims.LastName)
+	defaultString(&conf.Claims.Name, knownClaims.Name)
+	defaultString(&conf.Claims.Username, knownClaims.Username)
+
+	return conf
+}
+
+//githubEmails takes primary verified email, user info has only email which user made public
+func githubEmails(conf ProviderConfig, client *http.Client) func(profile Profile, accessToken string) (Profile, error) {
+
+	var log = logging.MustGetLogger("[GithubLogin]")
+
+	return func(profile Profile, accessToken string) (Profile, error) {
+
+		emails := []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}{}
+
+		if err := getJson(client, conf.EmailsEndpoint, accessToken, &emails); err != nil {
+			log.Error("Could not fetch emails. Details: ", err)
+			return Profile{}, ErrProviderFailed
+		}
+
+		profile.Email, profile.EmailVerified = "", false
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				profile.Email, profile.EmailVerified = email.Email, true
+			}
+		}
+
+		return profile, nil
+	}
+}
+
+//facebookTokenCheck asks facebook if token was issued for this app, token of other app would let it log in as its users
+func facebookTokenCheck(conf ProviderConfig, client *http.Client) func(accessToken string) error {
+
+	var log = logging.MustGetLogger("[FacebookLogin]")
+
+	return func(accessToken string) error {
+
+		info := struct {
+			Data struct {
+				AppId   string `json:"app_id"`
+				IsValid bool   `json:"is_valid"`
+			} `json:"data"`
+		}{}
+
+		params := url.Values{}
+		params.Set("input_token", accessToken)
+		params.Set("access_token", conf.ClientId+"|"+conf.ClientSecret)
+
+		if err := getJson(client, appendQuery(conf.TokenInfoEndpoint, params), "", &info); err != nil {
+			log.Error("Could not check access token. Details: ", err)
+			return ErrProviderFailed
+		}
+
+		if !info.Data.IsValid || info.Data.AppId != conf.ClientId {
+			return ErrInvalidAccessToken
+		}
+
+		return nil
+	}
+}
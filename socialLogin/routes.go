@@ -0,0 +1,21 @@
+package socialLogin
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller) {
+
+	echoEngine.OPTIONS("/social/providers", web.OptionsMethodHandler)
+	echoEngine.GET("/social/providers", controller.Providers)
+	echoEngine.OPTIONS("/social/:provider/authorize", web.OptionsMethodHandler)
+	echoEngine.GET("/social/:provider/authorize", controller.Authorize)
+	echoEngine.OPTIONS("/social/:provider/login", web.OptionsMethodHandler)
+	echoEngine.POST("/social/:provider/login", controller.Login)
+
+	//facebook login of older clients
+	echoEngine.OPTIONS("/fb/login", web.OptionsMethodHandler)
+	echoEngine.POST("/fb/login", controller.FbLogin)
+}
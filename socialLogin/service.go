@@ -0,0 +1,301 @@
+package socialLogin
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/mfa"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	"github.com/satori/go.uuid"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrUnknownProvider       = errors.New("Unknown login provider")
+	ErrInvalidRedirectUri    = errors.New("Redirect uri is not allowed")
+	ErrInvalidState          = errors.New("Invalid or expired state")
+	ErrInvalidCode           = errors.New("Invalid authorization code")
+	ErrInvalidIdToken        = errors.New("Invalid id token")
+	ErrInvalidAccessToken    = errors.New("Invalid access token")
+	ErrUnsupportedLogin      = errors.New("Provider does not support this kind of login")
+	ErrProviderFailed        = errors.New("Login provider did not respond properly")
+	ErrMissingEmail          = errors.New("Login provider did not share email of user")
+	ErrEmailNotVerified      = errors.New("Email of user is not verified by login provider")
+	ErrCouldNotFetchAccount  = errors.New("Could not fetch account")
+	ErrCouldNotCreateAccount = errors.New("Could not create account")
+	ErrCouldNotUpdateAccount = errors.New("Could not update account")
+)
+
+//StateLifetime is time user has for logging in with provider
+const StateLifetime = time.Minute * 10
+
+//state is jwt without sub and username, so it cannot be used as access token
+const (
+	tokenUseClaim       = "token_use"
+	tokenUseSocialState = "social_state"
+	stateProviderClaim  = "state_provider"
+	stateNonceClaim     = "state_nonce"
+	stateRedirectClaim  = "state_redirect_uri"
+)
+
+//Service for login with accounts of social login providers
+type Service struct {
+	//Providers lists names of configured providers
+	Providers func() []string
+	//Authorize returns url of provider login page, redirectUri has to be allowed
+	Authorize func(provider string, redirectUri string) (AuthorizationDto, error)
+	//Login finds or creates account linked with provider account, it returns mfa challenge when account has second factor
+	Login func(provider string, dto LoginDto, device sessions.Device) (*login.Token, error)
+}
+
+//CreateService creates social login service, redirect uris have to be listed in redirectUris or lead to frontendUrl
+func CreateService(providers []Provider, redirectUris []string, frontendUrl string, accountsDal accounts.Dal, accountsService accounts.Service, mfaService mfa.Service, tokenService jwtTokens.TokenService, loginService login.Service) Service {
+
+	var log = logging.MustGetLogger("[SocialLoginService]")
+
+	byName := map[string]Provider{}
+	names := []string{}
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+		names = append(names, provider.Name)
+	}
+	sort.Strings(names)
+
+	isAllowedRedirect := func(redirectUri string) bool {
+
+		if len(redirectUris) == 0 {
+			return len(frontendUrl) > 0 && strings.HasPrefix(redirectUri, strings.TrimSuffix(frontendUrl, "/")+"/")
+		}
+
+		for _, allowed := range redirectUris {
+			if allowed == redirectUri {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	authorize := func(name string, redirectUri string) (AuthorizationDto, error) {
+
+		provider, found := byName[name]
+		if !found {
+			return AuthorizationDto{}, ErrUnknownProvider
+		}
+
+		if !isAllowedRedirect(redirectUri) {
+			return AuthorizationDto{}, ErrInvalidRedirectUri
+		}
+
+		nonce := uuid.NewV4().String()
+		state, err := tokenService.Sign(map[string]interface{}{
+			tokenUseClaim:      tokenUseSocialState,
+			stateProviderClaim: name,
+			stateNonceClaim:    nonce,
+			stateRedirectClaim: redirectUri,
+		}, StateLifetime)
+
+		if err != nil {
+			return AuthorizationDto{}, err
+		}
+
+		return AuthorizationDto{
+			Url:   provider.AuthorizationUrl(redirectUri, state, nonce),
+			State: state,
+		}, nil
+	}
+
+	//useState checks that code comes back to the same provider and redirect uri it was requested for, state can be used only once
+	useState := func(name string, dto LoginDto) (string, error) {
+
+		claims := tokenService.GetClaims(dto.State)
+		nonce, _ := claims[stateNonceClaim].(string)
+		if claims[tokenUseClaim] != tokenUseSocialState || claims[stateProviderClaim] != name || claims[stateRedirectClaim] != dto.RedirectUri {
+			return "", ErrInvalidState
+		}
+
+		if err := tokenService.Revoke(dto.State); err != nil {
+			log.Error("Could not revoke state. Details: ", err.Error())
+			return "", ErrInvalidState
+		}
+
+		return nonce, nil
+	}
+
+	profileOf := func(provider Provider, dto LoginDto) (Profile, error) {
+
+		switch {
+		case len(dto.Code) > 0:
+			nonce, err := useState(provider.Name, dto)
+			if err != nil {
+				return Profile{}, err
+			}
+			return provider.Exchange(dto.Code, dto.RedirectUri, nonce)
+
+		case len(dto.IdToken) > 0:
+			if provider.VerifyIdToken == nil {
+				return Profile{}, ErrUnsupportedLogin
+			}
+			return provider.VerifyIdToken(dto.IdToken, dto.Nonce)
+
+		case len(dto.AccessToken) > 0:
+			if provider.VerifyAccessToken == nil {
+				return Profile{}, ErrUnsupportedLogin
+			}
+			return provider.VerifyAccessToken(dto.AccessToken)
+		}
+
+		return Profile{}, ErrUnsupportedLogin
+	}
+
+	//usernameFor keeps username of provider when nobody has it yet
+	usernameFor := func(profile Profile) string {
+
+		if len(profile.Username) == 0 {
+			return profile.Email
+		}
+
+		if _, err := accountsDal.GetByUsername(profile.Username); err == accounts.ErrAccountNotFound {
+			return profile.Username
+		}
+
+		return profile.Email
+	}
+
+	createAccount := func(profile Profile) (accounts.PasswordlessAccount, error) {
+
+		secAccount := accounts.SecuredAccount{
+			Account: accounts.Account{
+				PasswordlessAccount: accounts.PasswordlessAccount{
+					Email:      profile.Email,
+					Username:   usernameFor(profile),
+					FirstName:  profile.FirstName,
+					LastName:   profile.LastName,
+					Status:     accounts.Confirmed,
+					Identities: []accounts.Identity{{Provider: profile.Provider, Subject: profile.Subject}},
+				},
+			},
+		}
+
+		id, err := accountsDal.CreateAccount(secAccount)
+		if err != nil {
+			log.Error("Could not create account for ", profile.Provider, " user. Details: ", err.Error())
+			return accounts.PasswordlessAccount{}, ErrCouldNotCreateAccount
+		}
+
+		account := secAccount.PasswordlessAccount
+		account.Id = id
+		return account, nil
+	}
+
+	//linkAccount adds identity to account with the same email, provider proved that user owns it,
+	//so account which waits for confirmation is confirmed, without password and second factors of whoever registered it
+	linkAccount := func(account accounts.PasswordlessAccount, profile Profile) (accounts.PasswordlessAccount, error) {
+
+		if account.Status != accounts.Confirmed {
+			if err := accountsService.ConfirmVerifiedEmail(account.Email); err != nil {
+				log.Error("Could not confirm account ", account.Id, ". Details: ", err.Error())
+				return accounts.PasswordlessAccount{}, ErrCouldNotUpdateAccount
+			}
+
+			if err := mfaService.Reset(account.Id); err != nil {
+				log.Error("Could not reset second factors of account ", account.Id, ". Details: ", err.Error())
+				return accounts.PasswordlessAccount{}, ErrCouldNotUpdateAccount
+			}
+		}
+
+		identity := accounts.Identity{Provider: profile.Provider, Subject: profile.Subject}
+		err := accountsDal.UpdateByEmail(account.Email, func(acc *accounts.SecuredAccount) error {
+
+			if !acc.HasIdentity(identity.Provider, identity.Subject) {
+				acc.Identities = append(acc.Identities, identity)
+			}
+
+			if len(acc.FirstName) == 0 {
+				acc.FirstName = profile.FirstName
+			}
+
+			if len(acc.LastName) == 0 {
+				acc.LastName = profile.LastName
+			}
+
+			account = acc.PasswordlessAccount
+			return nil
+		})
+
+		if err != nil {
+			log.Error("Could not link account ", account.Id, " with ", profile.Provider, ". Details: ", err.Error())
+			return accounts.PasswordlessAccount{}, ErrCouldNotUpdateAccount
+		}
+
+		return account, nil
+	}
+
+	//accountOf finds account linked with provider account, then account with its email,
+	//emails which provider did not verify are not linked, anyone could claim them there
+	accountOf := func(profile Profile) (accounts.PasswordlessAccount, error) {
+
+		account, err := accountsDal.GetByIdentity(profile.Provider, profile.Subject)
+		if err == nil {
+			return account, nil
+		}
+
+		if err != accounts.ErrAccountNotFound {
+			log.Error("Could not fetch account by identity. Details: ", err.Error())
+			return accounts.PasswordlessAccount{}, ErrCouldNotFetchAccount
+		}
+
+		if len(profile.Email) == 0 {
+			return accounts.PasswordlessAccount{}, ErrMissingEmail
+		}
+
+		if !profile.EmailVerified {
+			return accounts.PasswordlessAccount{}, ErrEmailNotVerified
+		}
+
+		account, err = accountsDal.GetByEmail(profile.Email)
+		if err == accounts.ErrAccountNotFound {
+			return createAccount(profile)
+		}
+
+		if err != nil {
+			log.Error("Could not fetch account by email. Details: ", err.Error())
+			return accounts.PasswordlessAccount{}, ErrCouldNotFetchAccount
+		}
+
+		return linkAccount(account, profile)
+	}
+
+	socialLogin := func(name string, dto LoginDto, device sessions.Device) (*login.Token, error) {
+
+		provider, found := byName[name]
+		if !found {
+			return nil, ErrUnknownProvider
+		}
+
+		profile, err := profileOf(provider, dto)
+		if err != nil {
+			return nil, err
+		}
+
+		account, err := accountOf(profile)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("Account %s logged in with %s", account.Id, name)
+		return loginService.Complete(account, device, []string{login.AmrFederated})
+	}
+
+	return Service{
+		Providers: func() []string { return names },
+		Authorize: authorize,
+		Login:     socialLogin,
+	}
+}
@@ -0,0 +1,248 @@
+package socialLogin
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/jwtTokens"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/mfa"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestService(t *testing.T) {
+
+	Convey("Social login service should", t, func() {
+
+		stored := []accounts.SecuredAccount{}
+		store := func(account accounts.PasswordlessAccount) {
+			stored = append(stored, accounts.SecuredAccount{Account: accounts.Account{PasswordlessAccount: account}})
+		}
+		store(accounts.PasswordlessAccount{Id: "johnId", Username: "john", Email: "john@test.com", Status: accounts.Confirmed})
+		store(accounts.PasswordlessAccount{Id: "pendingId", Username: "pending", Email: "pending@test.com", Status: accounts.Pending})
+		stored[1].Password = "hashOfSomeoneElse"
+		store(accounts.PasswordlessAccount{Id: "linkedId", Username: "linked", Email: "linked@test.com", Status: accounts.Confirmed,
+			Identities: []accounts.Identity{{Provider: Google, Subject: "linked-subject"}}})
+
+		find := func(matches func(accounts.PasswordlessAccount) bool) (accounts.PasswordlessAccount, error) {
+			for _, account := range stored {
+				if matches(account.PasswordlessAccount) {
+					return account.PasswordlessAccount, nil
+				}
+			}
+			return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+		}
+
+		accountsDal := accounts.Dal{
+			GetByEmail: func(email string) (accounts.PasswordlessAccount, error) {
+				return find(func(account accounts.PasswordlessAccount) bool { return account.Email == email })
+			},
+			GetByUsername: func(username string) (accounts.PasswordlessAccount, error) {
+				return find(func(account accounts.PasswordlessAccount) bool { return account.Username == username })
+			},
+			GetByIdentity: func(provider string, subject string) (accounts.PasswordlessAccount, error) {
+				return find(func(account accounts.PasswordlessAccount) bool { return account.HasIdentity(provider, subject) })
+			},
+			UpdateByEmail: func(email string, handleUpdate func(*accounts.SecuredAccount) error) error {
+				for i := range stored {
+					if stored[i].Email == email {
+						return handleUpdate(&stored[i])
+					}
+				}
+				return accounts.ErrAccountNotFound
+			},
+			CreateAccount: func(secAccount accounts.SecuredAccount) (string, error) {
+				secAccount.Id = "newId"
+				stored = append(stored, secAccount)
+				return secAccount.Id, nil
+			},
+		}
+
+		//pending account is confirmed without credentials of whoever registered it
+		confirmed, reset := []string{}, []string{}
+		accountsService := accounts.Service{
+			ConfirmVerifiedEmail: func(email string) error {
+				confirmed = append(confirmed, email)
+				return accountsDal.UpdateByEmail(email, func(acc *accounts.SecuredAccount) error {
+					acc.Status, acc.Password = accounts.Confirmed, ""
+					return nil
+				})
+			},
+		}
+		mfaService := mfa.Service{
+			Reset: func(accountId string) error {
+				reset = append(reset, accountId)
+				return nil
+			},
+		}
+
+		revoked := map[string]bool{}
+		tokenService := jwtTokens.Create(jwtTokens.CreateHMACKeySet("secret"), jwtTokens.RevocationStore{
+			Revoke: func(jti string, expiresAt time.Time) error {
+				revoked[jti] = true
+				return nil
+			},
+			IsRevoked: func(ids ...string) bool {
+				for _, id := range ids {
+					if revoked[id] {
+						return true
+					}
+				}
+				return false
+			},
+		}, jwtTokens.TokenConfig{})
+
+		var loggedIn accounts.PasswordlessAccount
+		loginService := login.Service{
+			Complete: func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*login.Token, error) {
+				loggedIn = account
+				return &login.Token{Token: "token-" + account.Id}, nil
+			},
+		}
+
+		//profile is returned by provider for any code, nonce has to be the one from authorization url
+		profile := Profile{Provider: Google, Subject: "subject-1", Email: "john@test.com", EmailVerified: true, FirstName: "John", LastName: "Smith"}
+		var authorizedNonce string
+		provider := Provider{
+			Name: Google,
+			AuthorizationUrl: func(redirectUri string, state string, nonce string) string {
+				authorizedNonce = nonce
+				return "http://provider/authorize?" + url.Values{"state": {state}}.Encode()
+			},
+			Exchange: func(code string, redirectUri string, nonce string) (Profile, error) {
+				if nonce != authorizedNonce {
+					return Profile{}, ErrInvalidIdToken
+				}
+				return profile, nil
+			},
+			VerifyIdToken: func(idToken string, nonce string) (Profile, error) {
+				return profile, nil
+			},
+		}
+
+		service := CreateService([]Provider{provider}, nil, "http://frontend", accountsDal, accountsService, mfaService, tokenService, loginService)
+
+		codeLogin := func(redirectUri string) LoginDto {
+			authorization, err := service.Authorize(Google, redirectUri)
+			So(err, ShouldBeNil)
+			return LoginDto{Code: "code", State: authorization.State, RedirectUri: redirectUri}
+		}
+
+		Convey("link account with the same verified email", func() {
+
+			token, err := service.Login(Google, codeLogin("http://frontend/callback"), sessions.Device{})
+			So(err, ShouldBeNil)
+			So(token.Token, ShouldEqual, "token-johnId")
+			So(stored[0].Identities, ShouldResemble, []accounts.Identity{{Provider: Google, Subject: "subject-1"}})
+			So(stored[0].FirstName, ShouldEqual, "John")
+			So(loggedIn.HasIdentity(Google, "subject-1"), ShouldBeTrue)
+		})
+
+		Convey("log in linked account even when email changed", func() {
+
+			profile.Subject, profile.Email = "linked-subject", "changed@test.com"
+
+			token, err := service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldBeNil)
+			So(token.Token, ShouldEqual, "token-linkedId")
+			So(stored, ShouldHaveLength, 3)
+		})
+
+		Convey("create confirmed account for new user", func() {
+
+			profile.Email, profile.Username = "new@test.com", "john"
+
+			token, err := service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldBeNil)
+			So(token.Token, ShouldEqual, "token-newId")
+
+			created := stored[3]
+			So(created.Email, ShouldEqual, "new@test.com")
+			So(created.Username, ShouldEqual, "new@test.com")
+			So(created.Status, ShouldEqual, accounts.Confirmed)
+			So(created.Password, ShouldBeEmpty)
+			So(created.Identities, ShouldResemble, []accounts.Identity{{Provider: Google, Subject: "subject-1"}})
+		})
+
+		Convey("confirm pending account without credentials of whoever registered it", func() {
+
+			profile.Email = "pending@test.com"
+
+			_, err := service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldBeNil)
+			So(stored[1].Status, ShouldEqual, accounts.Confirmed)
+			So(stored[1].Password, ShouldBeEmpty)
+			So(stored[1].Identities, ShouldResemble, []accounts.Identity{{Provider: Google, Subject: "subject-1"}})
+			So(confirmed, ShouldResemble, []string{"pending@test.com"})
+			So(reset, ShouldResemble, []string{"pendingId"})
+		})
+
+		Convey("keep credentials of confirmed account", func() {
+
+			_, err := service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldBeNil)
+			So(confirmed, ShouldBeEmpty)
+			So(reset, ShouldBeEmpty)
+		})
+
+		Convey("not link or create account without verified email", func() {
+
+			profile.EmailVerified = false
+			_, err := service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldEqual, ErrEmailNotVerified)
+
+			profile.Email = ""
+			_, err = service.Login(Google, LoginDto{IdToken: "id-token"}, sessions.Device{})
+			So(err, ShouldEqual, ErrMissingEmail)
+
+			So(stored, ShouldHaveLength, 3)
+			So(stored[0].Identities, ShouldBeEmpty)
+		})
+
+		Convey("accept state only once and only with its redirect uri", func() {
+
+			dto := codeLogin("http://frontend/callback")
+
+			otherRedirect := dto
+			otherRedirect.RedirectUri = "http://frontend/other"
+			_, err := service.Login(Google, otherRedirect, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidState)
+
+			_, err = service.Login(Google, dto, sessions.Device{})
+			So(err, ShouldBeNil)
+
+			_, err = service.Login(Google, dto, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidState)
+
+			accessToken, _ := tokenService.GenerateToken(jwtTokens.AccountClaims{AccountId: "johnId", Username: "john"})
+			dto.State = accessToken
+			_, err = service.Login(Google, dto, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidState)
+		})
+
+		Convey("reject redirect uri outside of frontend", func() {
+
+			_, err := service.Authorize(Google, "http://attacker/callback")
+			So(err, ShouldEqual, ErrInvalidRedirectUri)
+
+			_, err = service.Authorize(Google, "http://frontend.attacker/callback")
+			So(err, ShouldEqual, ErrInvalidRedirectUri)
+		})
+
+		Convey("reject unknown provider and unsupported login", func() {
+
+			_, err := service.Authorize(Github, "http://frontend/callback")
+			So(err, ShouldEqual, ErrUnknownProvider)
+
+			_, err = service.Login(Google, LoginDto{AccessToken: "access-token"}, sessions.Device{})
+			So(err, ShouldEqual, ErrUnsupportedLogin)
+		})
+
+		Convey("list configured providers", func() {
+			So(service.Providers(), ShouldResemble, []string{Google})
+		})
+	})
+}
@@ -19,15 +19,31 @@ func GetTestRepo(collection string) dal.Dal {
 }
 
 func CreateSecurity(validToken string) security.Security {
+	return CreateSecurityWithClaims(validToken, map[string]interface{}{"roles": []interface{}{"user"}})
+}
+
+//CreateSecurityWithClaims creates security for which validToken is access token with given claims
+func CreateSecurityWithClaims(validToken string, claims map[string]interface{}) security.Security {
+
+	accessClaims := map[string]interface{}{jwtTokens.TokenUseClaim: jwtTokens.TokenUseAccess}
+	for name, value := range claims {
+		accessClaims[name] = value
+	}
 
 	tokenService := jwtTokens.TokenService{
 		Validate: func(token string, claimName string, claimValue string) bool {
 			return token == validToken
 		},
-		GenerateToken: func(username string, userId string) (string, error) {
+		GenerateToken: func(account jwtTokens.AccountClaims) (string, error) {
 
 			return validToken, nil
 		},
+		GetClaims: func(token string) map[string]interface{} {
+			if token != validToken {
+				return map[string]interface{}{}
+			}
+			return accessClaims
+		},
 	}
 
 	return security.CreateSecurity(tokenService)
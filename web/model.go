@@ -22,6 +22,7 @@ type ErrorDetails struct {
 
 type Error struct {
         Message      string  `json:"message,omitempty"`
+        Code         string  `json:"code,omitempty"`
         ErrorDetails []ErrorDetails  `json:"details,omitempty"`
         Status       int `json:"status"`
 }
@@ -1,7 +1,11 @@
 package web
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/labstack/echo"
 	"github.com/op/go-logging"
 )
@@ -22,6 +26,28 @@ func UnauthorizedResponse(c echo.Context, msg string) error {
 	return c.JSON(http.StatusUnauthorized, resp)
 }
 
+//UnauthorizedResponseWithCode lets client tell why request was rejected without parsing message
+func UnauthorizedResponseWithCode(c echo.Context, msg string, code string) error {
+
+	resp := Error{
+		Message: msg,
+		Code:    code,
+		Status:  http.StatusUnauthorized,
+	}
+
+	return c.JSON(http.StatusUnauthorized, resp)
+}
+
+func ForbiddenResponse(c echo.Context, msg string) error {
+
+	resp := Error{
+		Message: msg,
+		Status:  http.StatusForbidden,
+	}
+
+	return c.JSON(http.StatusForbidden, resp)
+}
+
 func NotFoundResponse(c echo.Context) error {
 
 	resp := Error{
@@ -53,6 +79,38 @@ func ConflictResponse(c echo.Context, msg string) error {
 }
 
 
+func LockedResponse(c echo.Context, msg string) error {
+
+	resp := Error{
+		Message: msg,
+		Status:  http.StatusLocked,
+	}
+
+	return c.JSON(http.StatusLocked, resp)
+}
+
+func TooManyRequestsResponse(c echo.Context, msg string) error {
+
+	resp := Error{
+		Message: msg,
+		Status:  http.StatusTooManyRequests,
+	}
+
+	return c.JSON(http.StatusTooManyRequests, resp)
+}
+
+//ServiceUnavailableResponse asks client to retry after given time, when server sheds load
+func ServiceUnavailableResponse(c echo.Context, msg string, retryAfter time.Duration) error {
+
+	resp := Error{
+		Message: msg,
+		Status:  http.StatusServiceUnavailable,
+	}
+
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return c.JSON(http.StatusServiceUnavailable, resp)
+}
+
 func BadRequestResponseWithDetails(c echo.Context, msg string, details []ErrorDetails) error {
 
 	resp := Error{
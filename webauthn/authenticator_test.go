@@ -0,0 +1,205 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+//encodeCbor is minimal encoder used to build synthetic authenticator responses
+func encodeCbor(value interface{}) []byte {
+
+	header := func(major byte, argument uint64) []byte {
+		switch {
+		case argument < 24:
+			return []byte{major<<5 | byte(argument)}
+		case argument < 1<<8:
+			return []byte{major<<5 | 24, byte(argument)}
+		case argument < 1<<16:
+			return []byte{major<<5 | 25, byte(argument >> 8), byte(argument)}
+		}
+		result := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(result[1:], uint32(argument))
+		return result
+	}
+
+	switch v := value.(type) {
+	case int:
+		return encodeCbor(int64(v))
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []interface{}:
+		result := header(4, uint64(len(v)))
+		for _, item := range v {
+			result = append(result, encodeCbor(item)...)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := header(5, uint64(len(v)))
+		for key, item := range v {
+			result = append(result, encodeCbor(key)...)
+			result = append(result, encodeCbor(item)...)
+		}
+		return result
+	}
+
+	panic("unsupported cbor value")
+}
+
+//fakeAuthenticator behaves like platform authenticator with P-256 key
+type fakeAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	aaguid       []byte
+	signCount    uint32
+	flags        byte
+}
+
+func newFakeAuthenticator() *fakeAuthenticator {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credentialId := make([]byte, 16)
+	rand.Read(credentialId)
+
+	return &fakeAuthenticator{
+		key:          key,
+		credentialId: credentialId,
+		aaguid:       make([]byte, 16),
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *fakeAuthenticator) coseKey() []byte {
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	return encodeCbor(map[interface{}]interface{}{
+		coseKeyKty: coseKtyEC2,
+		coseKeyAlg: AlgES256,
+		coseKeyCrv: coseCrvP256,
+		coseKeyX:   x,
+		coseKeyY:   y,
+	})
+}
+
+func (a *fakeAuthenticator) authData(rpId string, attested bool) []byte {
+
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredentialData
+	}
+
+	data := append(hashRpId(rpId), flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+
+	if attested {
+		data = append(data, a.aaguid...)
+		data = append(data, byte(len(a.credentialId)>>8), byte(len(a.credentialId)))
+		data = append(data, a.credentialId...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+func (a *fakeAuthenticator) sign(key *ecdsa.PrivateKey, authData []byte, clientData []byte) []byte {
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	return signature
+}
+
+func clientDataJson(ceremony string, challenge string, origin string) []byte {
+
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return data
+}
+
+//create answers registration options with attestation in given format, packed uses self attestation
+func (a *fakeAuthenticator) create(options *CreationOptions, origin string, format string) RegistrationResponse {
+
+	clientData := clientDataJson(ceremonyCreate, options.Challenge, origin)
+	authData := a.authData(options.Rp.Id, true)
+
+	statement := map[interface{}]interface{}{}
+	if format == FormatPacked {
+		statement["alg"] = AlgES256
+		statement["sig"] = a.sign(a.key, authData, clientData)
+	}
+
+	return a.registrationResponse(clientData, format, statement, authData)
+}
+
+//createWithCertificate answers registration options with packed attestation signed by attestation certificate
+func (a *fakeAuthenticator) createWithCertificate(options *CreationOptions, origin string, certificateAaguid []byte) RegistrationResponse {
+
+	attestationKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	aaguidExtension, _ := asn1.Marshal(certificateAaguid)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Authenticator Attestation", OrganizationalUnit: []string{"Authenticator Attestation"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidAaguid, Value: aaguidExtension}},
+	}
+	certificate, _ := x509.CreateCertificate(rand.Reader, template, template, &attestationKey.PublicKey, attestationKey)
+
+	clientData := clientDataJson(ceremonyCreate, options.Challenge, origin)
+	authData := a.authData(options.Rp.Id, true)
+
+	return a.registrationResponse(clientData, FormatPacked, map[interface{}]interface{}{
+		"alg": AlgES256,
+		"sig": a.sign(attestationKey, authData, clientData),
+		"x5c": []interface{}{certificate},
+	}, authData)
+}
+
+func (a *fakeAuthenticator) registrationResponse(clientData []byte, format string, statement map[interface{}]interface{}, authData []byte) RegistrationResponse {
+
+	response := RegistrationResponse{Id: encodeBase64(a.credentialId), RawId: encodeBase64(a.credentialId), Type: credentialType}
+	response.Response.ClientDataJSON = encodeBase64(clientData)
+	response.Response.AttestationObject = encodeBase64(encodeCbor(map[interface{}]interface{}{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	}))
+
+	return response
+}
+
+//get answers login options, every assertion increases sign counter
+func (a *fakeAuthenticator) get(options *RequestOptions, origin string, userHandle string) AssertionResponse {
+
+	a.signCount++
+	clientData := clientDataJson(ceremonyGet, options.Challenge, origin)
+	authData := a.authData(options.RpId, false)
+
+	response := AssertionResponse{Id: encodeBase64(a.credentialId), RawId: encodeBase64(a.credentialId), Type: credentialType}
+	response.Response.ClientDataJSON = encodeBase64(clientData)
+	response.Response.AuthenticatorData = encodeBase64(authData)
+	response.Response.Signature = encodeBase64(a.sign(a.key, authData, clientData))
+	response.Response.UserHandle = encodeBase64([]byte(userHandle))
+
+	return response
+}
@@ -0,0 +1,152 @@
+package webauthn
+
+import (
+	"errors"
+	"math"
+)
+
+//ErrMalformedCbor is returned for data which is not valid or not supported cbor
+var ErrMalformedCbor = errors.New("Malformed cbor")
+
+//maxCborDepth guards against deeply nested input
+const maxCborDepth = 16
+
+//decodeCbor decodes single cbor(RFC 7049) item and returns it together with number of bytes it took,
+//only definite lengths are supported which is enough for webauthn, integers are returned as int64
+//and maps as map[interface{}]interface{}
+func decodeCbor(data []byte) (interface{}, int, error) {
+	return decodeCborItem(data, 0, 0)
+}
+
+func decodeCborItem(data []byte, pos int, depth int) (interface{}, int, error) {
+
+	if depth > maxCborDepth || pos >= len(data) {
+		return nil, 0, ErrMalformedCbor
+	}
+
+	major := data[pos] >> 5
+	info := data[pos] & 0x1f
+	start := pos
+	pos++
+
+	//simple values and floats use additional info differently
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		}
+		return nil, 0, ErrMalformedCbor
+	}
+
+	argument, size, err := readCborArgument(data[pos:], info)
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += size
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, 0, ErrMalformedCbor
+		}
+		return int64(argument), pos - start, nil
+
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, 0, ErrMalformedCbor
+		}
+		return -1 - int64(argument), pos - start, nil
+
+	case 2, 3:
+		if argument > uint64(len(data)-pos) {
+			return nil, 0, ErrMalformedCbor
+		}
+		end := pos + int(argument)
+		if major == 3 {
+			return string(data[pos:end]), end - start, nil
+		}
+		return append([]byte{}, data[pos:end]...), end - start, nil
+
+	case 4:
+		if argument > uint64(len(data)-pos) {
+			return nil, 0, ErrMalformedCbor
+		}
+		items := make([]interface{}, 0, int(argument))
+		for i := uint64(0); i < argument; i++ {
+			item, itemSize, err := decodeCborItem(data, pos, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			pos += itemSize
+		}
+		return items, pos - start, nil
+
+	case 5:
+		if argument > uint64(len(data)-pos) {
+			return nil, 0, ErrMalformedCbor
+		}
+		items := make(map[interface{}]interface{}, int(argument))
+		for i := uint64(0); i < argument; i++ {
+			key, keySize, err := decodeCborItem(data, pos, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += keySize
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, ErrMalformedCbor
+			}
+
+			value, valueSize, err := decodeCborItem(data, pos, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += valueSize
+			items[key] = value
+		}
+		return items, pos - start, nil
+	}
+
+	//tags(major 6) are not used by webauthn
+	return nil, 0, ErrMalformedCbor
+}
+
+func readCborArgument(data []byte, info byte) (uint64, int, error) {
+
+	if info < 24 {
+		return uint64(info), 0, nil
+	}
+
+	size := 0
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		//indefinite lengths and reserved values
+		return 0, 0, ErrMalformedCbor
+	}
+
+	if len(data) < size {
+		return 0, 0, ErrMalformedCbor
+	}
+
+	var argument uint64
+	for i := 0; i < size; i++ {
+		argument = argument<<8 | uint64(data[i])
+	}
+
+	return argument, size, nil
+}
@@ -0,0 +1,56 @@
+package webauthn
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCbor(t *testing.T) {
+
+	Convey("Cbor decoder should", t, func() {
+
+		Convey("decode values used by webauthn", func() {
+
+			value, size, err := decodeCbor(encodeCbor(map[interface{}]interface{}{
+				"fmt":  "none",
+				-7:     []byte{1, 2},
+				1000:   []interface{}{int64(1), "a"},
+				"none": map[interface{}]interface{}{},
+			}))
+
+			So(err, ShouldBeNil)
+			So(size, ShouldBeGreaterThan, 0)
+			So(value, ShouldResemble, map[interface{}]interface{}{
+				"fmt":       "none",
+				int64(-7):   []byte{1, 2},
+				int64(1000): []interface{}{int64(1), "a"},
+				"none":      map[interface{}]interface{}{},
+			})
+		})
+
+		Convey("return size of first item only", func() {
+
+			data := append(encodeCbor("abc"), 0x01)
+			_, size, err := decodeCbor(data)
+
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 4)
+		})
+
+		Convey("reject truncated, indefinite and too deeply nested data", func() {
+
+			_, _, err := decodeCbor([]byte{0x43, 0x01})
+			So(err, ShouldEqual, ErrMalformedCbor)
+
+			_, _, err = decodeCbor([]byte{0x5f, 0x41, 0x01, 0xff})
+			So(err, ShouldEqual, ErrMalformedCbor)
+
+			nested := []byte{}
+			for i := 0; i < maxCborDepth+2; i++ {
+				nested = append(nested, 0x81)
+			}
+			_, _, err = decodeCbor(append(nested, 0x01))
+			So(err, ShouldEqual, ErrMalformedCbor)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package webauthn
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//Controller for passkey registration, management and login
+type Controller struct {
+	BeginRegistration  func(c echo.Context) error
+	FinishRegistration func(c echo.Context) error
+	GetCredentials     func(c echo.Context) error
+	DeleteCredential   func(c echo.Context) error
+	BeginLogin         func(c echo.Context) error
+	FinishLogin        func(c echo.Context) error
+}
+
+//Create webauthn controller
+func Create(service Service) Controller {
+
+	handleError := func(c echo.Context, msg string, err error) error {
+
+		switch err {
+		case accounts.ErrAccountNotFound, ErrCredentialNotFound:
+			return web.NotFoundResponse(c)
+		case ErrMalformedResponse, ErrInvalidChallenge, ErrInvalidOrigin, ErrInvalidRpId, ErrUserNotVerified,
+			ErrUnsupportedAlgorithm, ErrUnsupportedAttestation, ErrInvalidAttestation:
+			return web.BadRequestResponse(c, err.Error())
+		case ErrCredentialExists, ErrTooManyCredentials, ErrNotConfirmedAccount:
+			return web.ConflictResponse(c, err.Error())
+		case ErrInvalidCredential, ErrInvalidSignature, ErrCredentialCloned:
+			return web.UnauthorizedResponse(c, err.Error())
+		}
+
+		return web.LogAndReturnInternalError(c, msg, err)
+	}
+
+	beginRegistration := func(c echo.Context) error {
+
+		options, err := service.BeginRegistration(c.Param("id"))
+		if err != nil {
+			return handleError(c, "Could not start passkey registration", err)
+		}
+
+		return c.JSON(http.StatusOK, options)
+	}
+
+	finishRegistration := func(c echo.Context) error {
+
+		response := RegistrationResponse{}
+		if err := c.Bind(&response); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		credential, err := service.FinishRegistration(c.Param("id"), response)
+		if err != nil {
+			return handleError(c, "Could not register passkey", err)
+		}
+
+		return web.CreatedResponse(c, credential)
+	}
+
+	getCredentials := func(c echo.Context) error {
+
+		credentials, err := service.GetCredentials(c.Param("id"))
+		if err != nil {
+			return handleError(c, "Could not fetch passkeys", err)
+		}
+
+		return c.JSON(http.StatusOK, credentials)
+	}
+
+	deleteCredential := func(c echo.Context) error {
+
+		if err := service.DeleteCredential(c.Param("id"), c.Param("credentialId")); err != nil {
+			return handleError(c, "Could not delete passkey", err)
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	beginLogin := func(c echo.Context) error {
+
+		dto := BeginLoginDto{}
+		if err := c.Bind(&dto); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		options, err := service.BeginLogin(dto.Username)
+		if err != nil {
+			return handleError(c, "Could not start passkey login", err)
+		}
+
+		return c.JSON(http.StatusOK, options)
+	}
+
+	finishLogin := func(c echo.Context) error {
+
+		response := AssertionResponse{}
+		if err := c.Bind(&response); err != nil {
+			return web.BadRequestResponse(c, "Unable to parse request body")
+		}
+
+		token, err := service.FinishLogin(response, login.GetDevice(c))
+		if err != nil {
+			return handleError(c, "Could not login with passkey", err)
+		}
+
+		return c.JSON(http.StatusOK, token)
+	}
+
+	return Controller{
+		BeginRegistration:  beginRegistration,
+		FinishRegistration: finishRegistration,
+		GetCredentials:     getCredentials,
+		DeleteCredential:   deleteCredential,
+		BeginLogin:         beginLogin,
+		FinishLogin:        finishLogin,
+	}
+}
@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+//COSE(RFC 8152) key parameters and algorithms used by authenticators
+const (
+	coseKeyKty = 1
+	coseKeyAlg = 3
+	coseKeyCrv = -1
+	coseKeyX   = -2
+	coseKeyY   = -3
+	coseKeyN   = -1
+	coseKeyE   = -2
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+//SupportedAlgorithms are offered to authenticators in order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+//publicKey is credential public key together with algorithm it signs with
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+//parseCoseKey decodes credential public key stored in cose format
+func parseCoseKey(data []byte) (publicKey, error) {
+
+	decoded, _, err := decodeCbor(data)
+	if err != nil {
+		return publicKey{}, ErrMalformedResponse
+	}
+
+	params, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return publicKey{}, ErrMalformedResponse
+	}
+
+	kty, _ := params[int64(coseKeyKty)].(int64)
+	alg, _ := params[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := params[int64(coseKeyCrv)].(int64)
+		x, _ := params[int64(coseKeyX)].([]byte)
+		y, _ := params[int64(coseKeyY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, ErrMalformedResponse
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, ErrMalformedResponse
+		}
+		return publicKey{alg: alg, key: key}, nil
+
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := params[int64(coseKeyCrv)].(int64)
+		x, _ := params[int64(coseKeyX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, ErrMalformedResponse
+		}
+		return publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := params[int64(coseKeyN)].([]byte)
+		e, _ := params[int64(coseKeyE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, ErrMalformedResponse
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return publicKey{}, ErrUnsupportedAlgorithm
+}
+
+//verifySignature checks signature created with alg by owner of key
+func verifySignature(alg int64, key crypto.PublicKey, data []byte, signature []byte) error {
+
+	digest := sha256.Sum256(data)
+
+	switch alg {
+	case AlgES256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if ok && ecdsa.VerifyASN1(ecKey, digest[:], signature) {
+			return nil
+		}
+
+	case AlgEdDSA:
+		edKey, ok := key.(ed25519.PublicKey)
+		if ok && ed25519.Verify(edKey, data, signature) {
+			return nil
+		}
+
+	case AlgRS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return ErrInvalidSignature
+}
+
+func (key publicKey) verify(data []byte, signature []byte) error {
+	return verifySignature(key.alg, key.key, data, signature)
+}
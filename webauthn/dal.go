@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/dal"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+const maxCredentialsPerAccount = 100
+
+//CredentialsDal for passkeys collection
+type CredentialsDal struct {
+	GetById        func(id string) (Credential, error)
+	GetByAccountId func(accountId string) ([]Credential, error)
+	Save           func(credential Credential) error
+	//UpdateSignCount fails with ErrCredentialCloned when counter was already moved past signCount
+	UpdateSignCount func(id string, previous uint32, signCount uint32, usedAt time.Time) error
+	DeleteById      func(id string) error
+}
+
+//ChallengesDal for pending ceremonies, expired challenges are removed by mongo
+type ChallengesDal struct {
+	Save func(challenge Challenge) error
+	//Consume returns challenge and removes it, so it can be answered only once
+	Consume func(id string) (Challenge, error)
+}
+
+//CreateCredentialsDal wraps generic dal with passkey specific operations
+func CreateCredentialsDal(credentialsRepo dal.Dal) CredentialsDal {
+
+	getById := func(id string) (Credential, error) {
+
+		credential := Credential{}
+		if err := credentialsRepo.GetById(id, &credential); err != nil {
+			return credential, err
+		}
+
+		if len(credential.Id) == 0 {
+			return credential, ErrCredentialNotFound
+		}
+
+		return credential, nil
+	}
+
+	getByAccountId := func(accountId string) ([]Credential, error) {
+
+		credentials := make([]Credential, 0)
+		query := dal.NewQueryBuilder().WithField("accountId", accountId).Build()
+		pagination := web.Pagination{PageNumber: 1, PageSize: maxCredentialsPerAccount}
+
+		if err := credentialsRepo.GetByQuery(&credentials, pagination, query); err != nil {
+			return []Credential{}, err
+		}
+
+		return credentials, nil
+	}
+
+	save := func(credential Credential) error {
+		return credentialsRepo.Upsert(credential.Id, credential)
+	}
+
+	updateSignCount := func(id string, previous uint32, signCount uint32, usedAt time.Time) error {
+
+		//concurrent assertions with the same counter cannot both succeed
+		query := dal.NewQueryBuilder().WithId(id).WithField("signCount", previous).Build()
+		err := credentialsRepo.UpdateByQuery(query, map[string]interface{}{
+			"$set": map[string]interface{}{"signCount": signCount, "lastUsedAt": usedAt},
+		})
+
+		if err == dal.ErrNotFound {
+			return ErrCredentialCloned
+		}
+
+		return err
+	}
+
+	return CredentialsDal{
+		GetById:         getById,
+		GetByAccountId:  getByAccountId,
+		Save:            save,
+		UpdateSignCount: updateSignCount,
+		DeleteById:      credentialsRepo.DeleteById,
+	}
+}
+
+//CreateChallengesDal wraps generic dal with challenge specific operations
+func CreateChallengesDal(challengesRepo dal.Dal) ChallengesDal {
+
+	var log = logging.MustGetLogger("[WebauthnDal]")
+
+	if err := challengesRepo.EnsureTTLIndex("expiresAt", time.Second); err != nil {
+		log.Error("Could not create ttl index for webauthn challenges. Details: ", err)
+	}
+
+	save := func(challenge Challenge) error {
+		_, err := challengesRepo.Save(challenge)
+		return err
+	}
+
+	consume := func(id string) (Challenge, error) {
+
+		challenge := Challenge{}
+		if err := challengesRepo.GetById(id, &challenge); err != nil {
+			return challenge, err
+		}
+
+		if len(challenge.Id) == 0 {
+			return challenge, ErrInvalidChallenge
+		}
+
+		//only request which deleted challenge may use it
+		if err := challengesRepo.DeleteById(id); err != nil {
+			if err == dal.ErrNotFound {
+				return Challenge{}, ErrInvalidChallenge
+			}
+			return Challenge{}, err
+		}
+
+		return challenge, nil
+	}
+
+	return ChallengesDal{
+		Save:    save,
+		Consume: consume,
+	}
+}
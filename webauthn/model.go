@@ -0,0 +1,120 @@
+package webauthn
+
+import "time"
+
+//Credential is passkey registered for account, public key is stored in cose format
+type Credential struct {
+	Id        string `json:"id" bson:"_id"`
+	AccountId string `json:"-" bson:"accountId"`
+	Username  string `json:"-" bson:"username"`
+	Name      string `json:"name" bson:"name"`
+	PublicKey []byte `json:"-" bson:"publicKey"`
+	//SignCount is last counter reported by authenticator, it detects cloned authenticators
+	SignCount         uint32     `json:"signCount" bson:"signCount"`
+	Aaguid            string     `json:"aaguid" bson:"aaguid"`
+	AttestationFormat string     `json:"attestationFormat" bson:"attestationFormat"`
+	CreatedAt         time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt        *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+//Challenge is kept until ceremony finishes, its id is the challenge sent to browser
+type Challenge struct {
+	Id   string `bson:"_id"`
+	Type string `bson:"type"`
+	//AccountId is empty for login without username, where any passkey can answer
+	AccountId string    `bson:"accountId"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+//RelyingParty identifies this service to authenticator
+type RelyingParty struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//User is account as seen by authenticator, id is base64url encoded account id
+type User struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+//CreationOptions are passed to navigator.credentials.create, binary values are base64url encoded
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	Rp                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+//RequestOptions are passed to navigator.credentials.get, binary values are base64url encoded
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RpId             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+}
+
+//RegistrationResponse is PublicKeyCredential returned by navigator.credentials.create, binary values are base64url encoded
+type RegistrationResponse struct {
+	Id       string `json:"id"`
+	RawId    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+	//Name is optional label chosen by user
+	Name string `json:"name"`
+}
+
+//AssertionResponse is PublicKeyCredential returned by navigator.credentials.get, binary values are base64url encoded
+type AssertionResponse struct {
+	Id       string `json:"id"`
+	RawId    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+//BeginLoginDto optionally names account, without it discoverable passkeys are used
+type BeginLoginDto struct {
+	Username string `json:"username"`
+}
+
+//credentialType is the only type defined by webauthn
+const credentialType = "public-key"
+
+func descriptors(credentials []Credential) []CredentialDescriptor {
+
+	result := make([]CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		result = append(result, CredentialDescriptor{Type: credentialType, Id: credential.Id})
+	}
+
+	return result
+}
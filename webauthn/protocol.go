@@ -0,0 +1,241 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+)
+
+//authenticator data flags
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+	flagExtensionData          = 0x80
+)
+
+//client data types
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+//attestation statement formats
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+//oidAaguid is certificate extension with aaguid of authenticator model
+var oidAaguid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+//clientData is json which browser signs together with authenticator data
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+//authenticatorData is binary structure created and signed by authenticator
+type authenticatorData struct {
+	rpIdHash  []byte
+	flags     byte
+	signCount uint32
+	//attested credential data is present only during registration
+	aaguid              []byte
+	credentialId        []byte
+	credentialPublicKey []byte
+}
+
+//decodeBase64 accepts base64url with or without padding, which is what browsers and libraries send
+func decodeBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func encodeBase64(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+func parseClientData(raw []byte) (clientData, error) {
+
+	data := clientData{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return data, ErrMalformedResponse
+	}
+
+	return data, nil
+}
+
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+
+	//rpIdHash(32) flags(1) signCount(4)
+	if len(raw) < 37 {
+		return authenticatorData{}, ErrMalformedResponse
+	}
+
+	data := authenticatorData{
+		rpIdHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rest := raw[37:]
+	if data.flags&flagAttestedCredentialData != 0 {
+
+		//aaguid(16) credentialIdLength(2) credentialId credentialPublicKey
+		if len(rest) < 18 {
+			return data, ErrMalformedResponse
+		}
+
+		data.aaguid = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLength == 0 || len(rest) < idLength {
+			return data, ErrMalformedResponse
+		}
+
+		data.credentialId = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, keyLength, err := decodeCbor(rest)
+		if err != nil {
+			return data, ErrMalformedResponse
+		}
+
+		data.credentialPublicKey = rest[:keyLength]
+		rest = rest[keyLength:]
+	}
+
+	if data.flags&flagExtensionData != 0 {
+		_, extensionsLength, err := decodeCbor(rest)
+		if err != nil {
+			return data, ErrMalformedResponse
+		}
+		rest = rest[extensionsLength:]
+	}
+
+	if len(rest) != 0 {
+		return data, ErrMalformedResponse
+	}
+
+	return data, nil
+}
+
+//attestation is decoded attestation object sent during registration
+type attestation struct {
+	format      string
+	statement   map[interface{}]interface{}
+	rawAuthData []byte
+	authData    authenticatorData
+}
+
+func parseAttestation(raw []byte) (attestation, error) {
+
+	decoded, _, err := decodeCbor(raw)
+	if err != nil {
+		return attestation{}, ErrMalformedResponse
+	}
+
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return attestation{}, ErrMalformedResponse
+	}
+
+	format, _ := object["fmt"].(string)
+	statement, okStatement := object["attStmt"].(map[interface{}]interface{})
+	rawAuthData, okAuthData := object["authData"].([]byte)
+	if !okStatement || !okAuthData {
+		return attestation{}, ErrMalformedResponse
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return attestation{}, err
+	}
+
+	return attestation{format: format, statement: statement, rawAuthData: rawAuthData, authData: authData}, nil
+}
+
+//verify checks attestation statement, clientDataHash is sha256 of client data json
+func (att attestation) verify(credentialKey publicKey, clientDataHash []byte) error {
+
+	switch att.format {
+	case FormatNone:
+		if len(att.statement) != 0 {
+			return ErrInvalidAttestation
+		}
+		return nil
+
+	case FormatPacked:
+		return att.verifyPacked(credentialKey, clientDataHash)
+	}
+
+	return ErrUnsupportedAttestation
+}
+
+//verifyPacked supports self attestation and basic attestation with certificate,
+//certificate chain is not validated against trust anchors
+func (att attestation) verifyPacked(credentialKey publicKey, clientDataHash []byte) error {
+
+	alg, okAlg := att.statement["alg"].(int64)
+	signature, okSig := att.statement["sig"].([]byte)
+	if !okAlg || !okSig {
+		return ErrInvalidAttestation
+	}
+
+	signed := append(append([]byte{}, att.rawAuthData...), clientDataHash...)
+
+	chain, hasCertificate := att.statement["x5c"].([]interface{})
+	if !hasCertificate {
+		//self attestation is signed with credential key itself
+		if alg != credentialKey.alg {
+			return ErrInvalidAttestation
+		}
+		if err := credentialKey.verify(signed, signature); err != nil {
+			return ErrInvalidAttestation
+		}
+		return nil
+	}
+
+	if len(chain) == 0 {
+		return ErrInvalidAttestation
+	}
+
+	rawCertificate, ok := chain[0].([]byte)
+	if !ok {
+		return ErrInvalidAttestation
+	}
+
+	certificate, err := x509.ParseCertificate(rawCertificate)
+	if err != nil || certificate.Version != 3 || certificate.IsCA {
+		return ErrInvalidAttestation
+	}
+
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidAaguid) {
+			continue
+		}
+
+		var aaguid []byte
+		if _, err := asn1.Unmarshal(extension.Value, &aaguid); err != nil || !bytes.Equal(aaguid, att.authData.aaguid) {
+			return ErrInvalidAttestation
+		}
+	}
+
+	if err := verifySignature(alg, certificate.PublicKey, signed, signature); err != nil {
+		return ErrInvalidAttestation
+	}
+
+	return nil
+}
+
+func hashRpId(rpId string) []byte {
+	hash := sha256.Sum256([]byte(rpId))
+	return hash[:]
+}
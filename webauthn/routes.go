@@ -0,0 +1,27 @@
+package webauthn
+
+import (
+	"github.com/labstack/echo"
+	"github.com/piotrjaromin/go-login-backend/security"
+	"github.com/piotrjaromin/go-login-backend/web"
+)
+
+//InitRoutes binds http handlers to paths
+func InitRoutes(echoEngine *echo.Echo, controller Controller, security security.Security) {
+
+	securedByUsername := security.SecuredById("username", "username", false)
+
+	//POST returns options for navigator.credentials.create, PUT stores created passkey
+	echoEngine.OPTIONS("/accounts/:id/webauthn/credentials", web.OptionsMethodHandler)
+	echoEngine.POST("/accounts/:id/webauthn/credentials", controller.BeginRegistration, securedByUsername, security.RequireRecentAuth())
+	echoEngine.PUT("/accounts/:id/webauthn/credentials", controller.FinishRegistration, securedByUsername, security.RequireRecentAuth())
+	echoEngine.GET("/accounts/:id/webauthn/credentials", controller.GetCredentials, securedByUsername)
+	echoEngine.OPTIONS("/accounts/:id/webauthn/credentials/:credentialId", web.OptionsMethodHandler)
+	echoEngine.DELETE("/accounts/:id/webauthn/credentials/:credentialId", controller.DeleteCredential, securedByUsername)
+
+	//passwordless login, begin returns options for navigator.credentials.get
+	echoEngine.OPTIONS("/login/webauthn/begin", web.OptionsMethodHandler)
+	echoEngine.POST("/login/webauthn/begin", controller.BeginLogin)
+	echoEngine.OPTIONS("/login/webauthn", web.OptionsMethodHandler)
+	echoEngine.POST("/login/webauthn", controller.FinishLogin)
+}
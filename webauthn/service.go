@@ -0,0 +1,471 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+)
+
+//Errors that can be returned by this module
+var (
+	ErrMalformedResponse      = errors.New("Malformed authenticator response")
+	ErrInvalidChallenge       = errors.New("Invalid or expired challenge")
+	ErrInvalidOrigin          = errors.New("Origin is not allowed")
+	ErrInvalidRpId            = errors.New("Credential was created for different relying party")
+	ErrUserNotVerified        = errors.New("User presence and verification are required")
+	ErrUnsupportedAlgorithm   = errors.New("Unsupported credential algorithm")
+	ErrUnsupportedAttestation = errors.New("Unsupported attestation format")
+	ErrInvalidAttestation     = errors.New("Invalid attestation")
+	ErrInvalidSignature       = errors.New("Invalid signature")
+	ErrCredentialExists       = errors.New("Credential is already registered")
+	ErrCredentialNotFound     = errors.New("Credential does not exist")
+	ErrCredentialCloned       = errors.New("Signature counter did not increase, authenticator may be cloned")
+	ErrTooManyCredentials     = errors.New("Account has too many passkeys")
+	ErrInvalidCredential      = errors.New("Invalid passkey")
+	ErrNotConfirmedAccount    = errors.New("Account is not confirmed")
+)
+
+//DefaultTimeout is time user has for answering authenticator prompt
+const DefaultTimeout = time.Minute * 5
+
+const challengeBytes = 32
+
+//userVerificationRequired since passkey replaces both password and second factor
+const userVerificationRequired = "required"
+
+//Config describes relying party, rp id is domain of frontend and origins are allowed frontend urls
+type Config struct {
+	RpId    string
+	RpName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+//Service runs registration and login ceremonies of passkeys
+type Service struct {
+	BeginRegistration  func(username string) (*CreationOptions, error)
+	FinishRegistration func(username string, response RegistrationResponse) (*Credential, error)
+	GetCredentials     func(username string) ([]Credential, error)
+	DeleteCredential   func(username string, id string) error
+	//DeleteAll removes passkeys of account, it is used when account is deleted
+	DeleteAll func(accountId string) error
+	//BeginLogin with empty username lets user pick any discoverable passkey
+	BeginLogin  func(username string) (*RequestOptions, error)
+	FinishLogin func(response AssertionResponse, device sessions.Device) (*login.Token, error)
+}
+
+//CreateConfig fills rp id and origins from frontend url when they are not configured
+func CreateConfig(rpId string, rpName string, origins []string, frontendUrl string) Config {
+
+	if len(origins) == 0 && len(frontendUrl) > 0 {
+		origins = []string{frontendUrl}
+	}
+
+	if len(rpId) == 0 && len(origins) > 0 {
+		if parsed, err := url.Parse(origins[0]); err == nil {
+			rpId = parsed.Hostname()
+		}
+	}
+
+	return Config{RpId: rpId, RpName: rpName, Origins: origins}
+}
+
+//CreateService creates webauthn service, successful login issues tokens through login service
+func CreateService(config Config, credentialsDal CredentialsDal, challengesDal ChallengesDal, accountsDal accounts.Dal, loginService login.Service) Service {
+
+	var log = logging.MustGetLogger("[WebauthnService]")
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if len(config.RpName) == 0 {
+		config.RpName = config.RpId
+	}
+
+	rpIdHash := hashRpId(config.RpId)
+
+	startCeremony := func(ceremony string, accountId string) (string, error) {
+
+		value := make([]byte, challengeBytes)
+		if _, err := rand.Read(value); err != nil {
+			return "", err
+		}
+
+		challenge := Challenge{
+			Id:        encodeBase64(value),
+			Type:      ceremony,
+			AccountId: accountId,
+			ExpiresAt: time.Now().Add(config.Timeout),
+		}
+
+		if err := challengesDal.Save(challenge); err != nil {
+			return "", err
+		}
+
+		return challenge.Id, nil
+	}
+
+	//verifyClientData checks what browser signed and returns challenge it answers
+	verifyClientData := func(ceremony string, rawClientData []byte) (Challenge, error) {
+
+		data, err := parseClientData(rawClientData)
+		if err != nil {
+			return Challenge{}, err
+		}
+
+		if data.Type != ceremony {
+			return Challenge{}, ErrMalformedResponse
+		}
+
+		if !contains(config.Origins, data.Origin) {
+			return Challenge{}, ErrInvalidOrigin
+		}
+
+		challenge, err := challengesDal.Consume(data.Challenge)
+		if err == ErrInvalidChallenge {
+			return Challenge{}, err
+		}
+
+		if err != nil {
+			log.Error("Could not fetch webauthn challenge. Details: ", err.Error())
+			return Challenge{}, err
+		}
+
+		if challenge.Type != ceremony || time.Now().After(challenge.ExpiresAt) {
+			return Challenge{}, ErrInvalidChallenge
+		}
+
+		return challenge, nil
+	}
+
+	verifyAuthenticatorData := func(data authenticatorData) error {
+
+		if !bytes.Equal(data.rpIdHash, rpIdHash) {
+			return ErrInvalidRpId
+		}
+
+		if data.flags&flagUserPresent == 0 || data.flags&flagUserVerified == 0 {
+			return ErrUserNotVerified
+		}
+
+		return nil
+	}
+
+	beginRegistration := func(username string) (*CreationOptions, error) {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, err := credentialsDal.GetByAccountId(account.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(existing) >= maxCredentialsPerAccount {
+			return nil, ErrTooManyCredentials
+		}
+
+		challenge, err := startCeremony(ceremonyCreate, account.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+		for _, alg := range SupportedAlgorithms {
+			params = append(params, CredentialParameter{Type: credentialType, Alg: alg})
+		}
+
+		return &CreationOptions{
+			Challenge: challenge,
+			Rp:        RelyingParty{Id: config.RpId, Name: config.RpName},
+			User: User{
+				Id:          encodeBase64([]byte(account.Id)),
+				Name:        account.Username,
+				DisplayName: account.Username,
+			},
+			PubKeyCredParams:   params,
+			Timeout:            int64(config.Timeout / time.Millisecond),
+			Attestation:        FormatNone,
+			ExcludeCredentials: descriptors(existing),
+			AuthenticatorSelection: AuthenticatorSelection{
+				ResidentKey:      "required",
+				UserVerification: userVerificationRequired,
+			},
+		}, nil
+	}
+
+	finishRegistration := func(username string, response RegistrationResponse) (*Credential, error) {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+
+		rawClientData, err := decodeBase64(response.Response.ClientDataJSON)
+		if err != nil || response.Type != credentialType {
+			return nil, ErrMalformedResponse
+		}
+
+		rawAttestation, err := decodeBase64(response.Response.AttestationObject)
+		if err != nil {
+			return nil, ErrMalformedResponse
+		}
+
+		challenge, err := verifyClientData(ceremonyCreate, rawClientData)
+		if err != nil {
+			return nil, err
+		}
+
+		if challenge.AccountId != account.Id {
+			return nil, ErrInvalidChallenge
+		}
+
+		att, err := parseAttestation(rawAttestation)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := verifyAuthenticatorData(att.authData); err != nil {
+			return nil, err
+		}
+
+		if att.authData.flags&flagAttestedCredentialData == 0 {
+			return nil, ErrMalformedResponse
+		}
+
+		key, err := parseCoseKey(att.authData.credentialPublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		clientDataHash := sha256.Sum256(rawClientData)
+		if err := att.verify(key, clientDataHash[:]); err != nil {
+			return nil, err
+		}
+
+		credentialId := encodeBase64(att.authData.credentialId)
+		if _, err := credentialsDal.GetById(credentialId); err != ErrCredentialNotFound {
+			if err == nil {
+				return nil, ErrCredentialExists
+			}
+			return nil, err
+		}
+
+		credential := Credential{
+			Id:                credentialId,
+			AccountId:         account.Id,
+			Username:          account.Username,
+			Name:              response.Name,
+			PublicKey:         att.authData.credentialPublicKey,
+			SignCount:         att.authData.signCount,
+			Aaguid:            hex.EncodeToString(att.authData.aaguid),
+			AttestationFormat: att.format,
+			CreatedAt:         time.Now(),
+		}
+
+		if len(credential.Name) == 0 {
+			credential.Name = "Passkey"
+		}
+
+		if err := credentialsDal.Save(credential); err != nil {
+			return nil, err
+		}
+
+		log.Infof("Registered passkey %s for account %s", credential.Id, account.Id)
+		return &credential, nil
+	}
+
+	getCredentials := func(username string) ([]Credential, error) {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+
+		return credentialsDal.GetByAccountId(account.Id)
+	}
+
+	deleteCredential := func(username string, id string) error {
+
+		account, err := accountsDal.GetByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		credential, err := credentialsDal.GetById(id)
+		if err != nil {
+			return err
+		}
+
+		//passkeys of other accounts are reported as missing
+		if credential.AccountId != account.Id {
+			return ErrCredentialNotFound
+		}
+
+		return credentialsDal.DeleteById(id)
+	}
+
+	deleteAll := func(accountId string) error {
+
+		credentials, err := credentialsDal.GetByAccountId(accountId)
+		if err != nil {
+			return err
+		}
+
+		for _, credential := range credentials {
+			if err := credentialsDal.DeleteById(credential.Id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	beginLogin := func(username string) (*RequestOptions, error) {
+
+		accountId := ""
+		allowed := []CredentialDescriptor{}
+
+		//unknown username gets the same response as discoverable login, so it does not reveal accounts
+		if len(username) > 0 {
+			account, err := accountsDal.GetByUsername(username)
+			if err != nil && err != accounts.ErrAccountNotFound {
+				return nil, err
+			}
+
+			if err == nil {
+				credentials, err := credentialsDal.GetByAccountId(account.Id)
+				if err != nil {
+					return nil, err
+				}
+
+				accountId = account.Id
+				allowed = descriptors(credentials)
+			}
+		}
+
+		challenge, err := startCeremony(ceremonyGet, accountId)
+		if err != nil {
+			return nil, err
+		}
+
+		return &RequestOptions{
+			Challenge:        challenge,
+			RpId:             config.RpId,
+			Timeout:          int64(config.Timeout / time.Millisecond),
+			UserVerification: userVerificationRequired,
+			AllowCredentials: allowed,
+		}, nil
+	}
+
+	finishLogin := func(response AssertionResponse, device sessions.Device) (*login.Token, error) {
+
+		rawClientData, errClientData := decodeBase64(response.Response.ClientDataJSON)
+		rawAuthData, errAuthData := decodeBase64(response.Response.AuthenticatorData)
+		signature, errSignature := decodeBase64(response.Response.Signature)
+		userHandle, errUserHandle := decodeBase64(response.Response.UserHandle)
+		rawId, errRawId := decodeBase64(response.RawId)
+
+		if errClientData != nil || errAuthData != nil || errSignature != nil || errUserHandle != nil || errRawId != nil || response.Type != credentialType {
+			return nil, ErrMalformedResponse
+		}
+
+		challenge, err := verifyClientData(ceremonyGet, rawClientData)
+		if err != nil {
+			return nil, err
+		}
+
+		credential, err := credentialsDal.GetById(encodeBase64(rawId))
+		if err == ErrCredentialNotFound {
+			return nil, ErrInvalidCredential
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		//challenge started for account can be answered only with its passkeys
+		if len(challenge.AccountId) > 0 && challenge.AccountId != credential.AccountId {
+			return nil, ErrInvalidCredential
+		}
+
+		if len(userHandle) > 0 && string(userHandle) != credential.AccountId {
+			return nil, ErrInvalidCredential
+		}
+
+		authData, err := parseAuthenticatorData(rawAuthData)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := verifyAuthenticatorData(authData); err != nil {
+			return nil, err
+		}
+
+		key, err := parseCoseKey(credential.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		clientDataHash := sha256.Sum256(rawClientData)
+		if err := key.verify(append(append([]byte{}, rawAuthData...), clientDataHash[:]...), signature); err != nil {
+			return nil, err
+		}
+
+		//authenticators without counter always report zero
+		if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+			log.Warningf("Sign counter of passkey %s went from %d to %d", credential.Id, credential.SignCount, authData.signCount)
+			return nil, ErrCredentialCloned
+		}
+
+		if err := credentialsDal.UpdateSignCount(credential.Id, credential.SignCount, authData.signCount, time.Now()); err != nil {
+			return nil, err
+		}
+
+		account, err := accountsDal.GetById(credential.AccountId)
+		if err == accounts.ErrAccountNotFound {
+			return nil, ErrInvalidCredential
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if account.Status != accounts.Confirmed {
+			return nil, ErrNotConfirmedAccount
+		}
+
+		return loginService.IssueToken(account, device, []string{login.AmrHardwareKey, login.AmrUserVerification})
+	}
+
+	return Service{
+		BeginRegistration:  beginRegistration,
+		FinishRegistration: finishRegistration,
+		GetCredentials:     getCredentials,
+		DeleteCredential:   deleteCredential,
+		DeleteAll:          deleteAll,
+		BeginLogin:         beginLogin,
+		FinishLogin:        finishLogin,
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package webauthn
+
+import (
+	"github.com/piotrjaromin/go-login-backend/accounts"
+	"github.com/piotrjaromin/go-login-backend/login"
+	"github.com/piotrjaromin/go-login-backend/sessions"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+const (
+	testRpId   = "login.example.com"
+	testOrigin = "https://login.example.com"
+)
+
+func createInMemoryDals() (CredentialsDal, ChallengesDal, map[string]Credential) {
+
+	credentials := map[string]Credential{}
+	challenges := map[string]Challenge{}
+
+	return CredentialsDal{
+		GetById: func(id string) (Credential, error) {
+			credential, ok := credentials[id]
+			if !ok {
+				return credential, ErrCredentialNotFound
+			}
+			return credential, nil
+		},
+		GetByAccountId: func(accountId string) ([]Credential, error) {
+			found := []Credential{}
+			for _, credential := range credentials {
+				if credential.AccountId == accountId {
+					found = append(found, credential)
+				}
+			}
+			return found, nil
+		},
+		Save: func(credential Credential) error {
+			credentials[credential.Id] = credential
+			return nil
+		},
+		UpdateSignCount: func(id string, previous uint32, signCount uint32, usedAt time.Time) error {
+			credential := credentials[id]
+			if credential.SignCount != previous {
+				return ErrCredentialCloned
+			}
+			credential.SignCount = signCount
+			credential.LastUsedAt = &usedAt
+			credentials[id] = credential
+			return nil
+		},
+		DeleteById: func(id string) error {
+			delete(credentials, id)
+			return nil
+		},
+	}, ChallengesDal{
+		Save: func(challenge Challenge) error {
+			challenges[challenge.Id] = challenge
+			return nil
+		},
+		Consume: func(id string) (Challenge, error) {
+			challenge, ok := challenges[id]
+			if !ok {
+				return challenge, ErrInvalidChallenge
+			}
+			delete(challenges, id)
+			return challenge, nil
+		},
+	}, credentials
+}
+
+func TestService(t *testing.T) {
+
+	testAccounts := map[string]accounts.PasswordlessAccount{
+		"john":  {Id: "johnId", Username: "john", Status: accounts.Confirmed},
+		"alice": {Id: "aliceId", Username: "alice", Status: accounts.Confirmed},
+	}
+
+	accountsDal := accounts.Dal{
+		GetByUsername: func(username string) (accounts.PasswordlessAccount, error) {
+			account, ok := testAccounts[username]
+			if !ok {
+				return account, accounts.ErrAccountNotFound
+			}
+			return account, nil
+		},
+		GetById: func(id string) (accounts.PasswordlessAccount, error) {
+			for _, account := range testAccounts {
+				if account.Id == id {
+					return account, nil
+				}
+			}
+			return accounts.PasswordlessAccount{}, accounts.ErrAccountNotFound
+		},
+	}
+
+	loginService := login.Service{
+		IssueToken: func(account accounts.PasswordlessAccount, device sessions.Device, amr []string) (*login.Token, error) {
+			return &login.Token{Token: "token-" + account.Id}, nil
+		},
+	}
+
+	Convey("Webauthn service should", t, func() {
+
+		credentialsDal, challengesDal, credentials := createInMemoryDals()
+		config := CreateConfig("", "Login", nil, testOrigin)
+		service := CreateService(config, credentialsDal, challengesDal, accountsDal, loginService)
+		authenticator := newFakeAuthenticator()
+
+		register := func(username string, format string) (*Credential, error) {
+			options, err := service.BeginRegistration(username)
+			So(err, ShouldBeNil)
+			return service.FinishRegistration(username, authenticator.create(options, testOrigin, format))
+		}
+
+		Convey("take rp id from frontend url", func() {
+			So(config.RpId, ShouldEqual, testRpId)
+			So(config.Origins, ShouldResemble, []string{testOrigin})
+		})
+
+		Convey("return creation options for account", func() {
+
+			options, err := service.BeginRegistration("john")
+
+			So(err, ShouldBeNil)
+			So(options.Rp.Id, ShouldEqual, testRpId)
+			So(options.User.Id, ShouldEqual, encodeBase64([]byte("johnId")))
+			So(options.Challenge, ShouldNotBeEmpty)
+			So(options.PubKeyCredParams[0].Alg, ShouldEqual, AlgES256)
+		})
+
+		Convey("register passkey with none attestation", func() {
+
+			credential, err := register("john", FormatNone)
+
+			So(err, ShouldBeNil)
+			So(credential.Id, ShouldEqual, encodeBase64(authenticator.credentialId))
+			So(credentials[credential.Id].AccountId, ShouldEqual, "johnId")
+			So(credentials[credential.Id].AttestationFormat, ShouldEqual, FormatNone)
+		})
+
+		Convey("register passkey with packed self attestation", func() {
+
+			credential, err := register("john", FormatPacked)
+
+			So(err, ShouldBeNil)
+			So(credential.AttestationFormat, ShouldEqual, FormatPacked)
+		})
+
+		Convey("register passkey with packed certificate attestation", func() {
+
+			options, _ := service.BeginRegistration("john")
+			_, err := service.FinishRegistration("john", authenticator.createWithCertificate(options, testOrigin, authenticator.aaguid))
+			So(err, ShouldBeNil)
+
+			otherAaguid := make([]byte, 16)
+			otherAaguid[0] = 1
+			options, _ = service.BeginRegistration("alice")
+			_, err = service.FinishRegistration("alice", newFakeAuthenticator().createWithCertificate(options, testOrigin, otherAaguid))
+			So(err, ShouldEqual, ErrInvalidAttestation)
+		})
+
+		Convey("reject packed attestation with invalid signature", func() {
+
+			options, _ := service.BeginRegistration("john")
+			response := authenticator.create(options, testOrigin, FormatPacked)
+			response.Response.ClientDataJSON = encodeBase64(append(clientDataJson(ceremonyCreate, options.Challenge, testOrigin), ' '))
+
+			_, err := service.FinishRegistration("john", response)
+			So(err, ShouldEqual, ErrInvalidAttestation)
+		})
+
+		Convey("reject registration from other origin, with unknown or reused challenge", func() {
+
+			options, _ := service.BeginRegistration("john")
+			_, err := service.FinishRegistration("john", authenticator.create(options, "https://evil.example.com", FormatNone))
+			So(err, ShouldEqual, ErrInvalidOrigin)
+
+			options.Challenge = encodeBase64([]byte("unknown"))
+			_, err = service.FinishRegistration("john", authenticator.create(options, testOrigin, FormatNone))
+			So(err, ShouldEqual, ErrInvalidChallenge)
+
+			options, _ = service.BeginRegistration("john")
+			response := authenticator.create(options, testOrigin, FormatNone)
+			_, err = service.FinishRegistration("john", response)
+			So(err, ShouldBeNil)
+
+			_, err = service.FinishRegistration("john", response)
+			So(err, ShouldEqual, ErrInvalidChallenge)
+		})
+
+		Convey("reject registration with challenge of other account", func() {
+
+			options, _ := service.BeginRegistration("alice")
+			_, err := service.FinishRegistration("john", authenticator.create(options, testOrigin, FormatNone))
+			So(err, ShouldEqual, ErrInvalidChallenge)
+		})
+
+		Convey("reject credentials for other relying party or without user verification", func() {
+
+			options, _ := service.BeginRegistration("john")
+			options.Rp.Id = "example.org"
+			_, err := service.FinishRegistration("john", authenticator.create(options, testOrigin, FormatNone))
+			So(err, ShouldEqual, ErrInvalidRpId)
+
+			options, _ = service.BeginRegistration("john")
+			authenticator.flags = flagUserPresent
+			_, err = service.FinishRegistration("john", authenticator.create(options, testOrigin, FormatNone))
+			So(err, ShouldEqual, ErrUserNotVerified)
+		})
+
+		Convey("not register the same credential twice", func() {
+
+			_, err := register("john", FormatNone)
+			So(err, ShouldBeNil)
+
+			_, err = register("john", FormatNone)
+			So(err, ShouldEqual, ErrCredentialExists)
+		})
+
+		Convey("login with discoverable passkey", func() {
+
+			credential, _ := register("john", FormatNone)
+
+			options, err := service.BeginLogin("")
+			So(err, ShouldBeNil)
+			So(options.AllowCredentials, ShouldBeEmpty)
+
+			token, err := service.FinishLogin(authenticator.get(options, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldBeNil)
+			So(token.Token, ShouldEqual, "token-johnId")
+			So(credentials[credential.Id].SignCount, ShouldEqual, 1)
+			So(credentials[credential.Id].LastUsedAt, ShouldNotBeNil)
+		})
+
+		Convey("list passkeys of named account in login options", func() {
+
+			credential, _ := register("john", FormatNone)
+
+			options, _ := service.BeginLogin("john")
+			So(options.AllowCredentials, ShouldResemble, []CredentialDescriptor{{Type: credentialType, Id: credential.Id}})
+
+			options, err := service.BeginLogin("unknown")
+			So(err, ShouldBeNil)
+			So(options.AllowCredentials, ShouldBeEmpty)
+		})
+
+		Convey("reject passkey of other account than login was started for", func() {
+
+			register("john", FormatNone)
+
+			options, _ := service.BeginLogin("alice")
+			_, err := service.FinishLogin(authenticator.get(options, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidCredential)
+
+			options, _ = service.BeginLogin("")
+			_, err = service.FinishLogin(authenticator.get(options, testOrigin, "aliceId"), sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidCredential)
+		})
+
+		Convey("reject assertion with invalid signature", func() {
+
+			register("john", FormatNone)
+			options, _ := service.BeginLogin("john")
+			response := authenticator.get(options, testOrigin, "johnId")
+			response.Response.Signature = encodeBase64(authenticator.sign(newFakeAuthenticator().key, []byte("data"), []byte("client")))
+
+			_, err := service.FinishLogin(response, sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidSignature)
+		})
+
+		Convey("reject assertion when sign counter does not increase", func() {
+
+			register("john", FormatNone)
+			authenticator.signCount = 10
+
+			options, _ := service.BeginLogin("john")
+			_, err := service.FinishLogin(authenticator.get(options, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldBeNil)
+
+			authenticator.signCount = 5
+			options, _ = service.BeginLogin("john")
+			_, err = service.FinishLogin(authenticator.get(options, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldEqual, ErrCredentialCloned)
+		})
+
+		Convey("reject login with unknown passkey or registration challenge", func() {
+
+			options, _ := service.BeginLogin("")
+			_, err := service.FinishLogin(authenticator.get(options, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidCredential)
+
+			register("john", FormatNone)
+			creationOptions, _ := service.BeginRegistration("john")
+			_, err = service.FinishLogin(authenticator.get(&RequestOptions{Challenge: creationOptions.Challenge, RpId: testRpId}, testOrigin, "johnId"), sessions.Device{})
+			So(err, ShouldEqual, ErrInvalidChallenge)
+		})
+
+		Convey("delete only passkeys of given account", func() {
+
+			credential, _ := register("john", FormatNone)
+
+			So(service.DeleteCredential("alice", credential.Id), ShouldEqual, ErrCredentialNotFound)
+			So(service.DeleteCredential("john", credential.Id), ShouldBeNil)
+			So(credentials, ShouldBeEmpty)
+		})
+	})
+}